@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// amqpTimeout bounds connecting and each broker round trip.
+const amqpTimeout = 15 * time.Second
+
+// AMQP 0-9-1 frame types.
+const (
+	amqpFrameMethod    = 1
+	amqpFrameHeader    = 2
+	amqpFrameBody      = 3
+	amqpFrameHeartbeat = 8
+	amqpFrameEnd       = 0xCE
+)
+
+// AMQP class and method IDs used here, packed as class<<16 | method.
+const (
+	amqpConnectionStart   = 10<<16 | 10
+	amqpConnectionStartOk = 10<<16 | 11
+	amqpConnectionTune    = 10<<16 | 30
+	amqpConnectionTuneOk  = 10<<16 | 31
+	amqpConnectionOpen    = 10<<16 | 40
+	amqpConnectionOpenOk  = 10<<16 | 41
+	amqpConnectionClose   = 10<<16 | 50
+	amqpConnectionCloseOk = 10<<16 | 51
+	amqpChannelOpen       = 20<<16 | 10
+	amqpChannelOpenOk     = 20<<16 | 11
+	amqpChannelClose      = 20<<16 | 40
+	amqpBasicPublish      = 60<<16 | 40
+	amqpBasicAck          = 60<<16 | 80
+	amqpBasicNack         = 60<<16 | 120
+	amqpConfirmSelect     = 85<<16 | 10
+	amqpConfirmSelectOk   = 85<<16 | 11
+)
+
+// AMQPConfig publishes events to a RabbitMQ (or other AMQP 0-9-1) exchange, which
+// must already exist. RoutingKey accepts the same placeholders as the MQTT topic,
+// so a topic exchange can route by county, type or severity, e.g. bind
+// "*.wake.#" for Wake County. Messages are persistent and publisher confirms are
+// used, so a publish only counts once the broker has accepted it.
+type AMQPConfig struct {
+	URL        string `yaml:"url"`
+	Exchange   string `yaml:"exchange"`
+	RoutingKey string `yaml:"routing_key"`
+}
+
+// enabled reports whether AMQP publishing is configured.
+func (a AMQPConfig) enabled() bool {
+	return a.URL != ""
+}
+
+// amqpConn is a minimal AMQP 0-9-1 publisher on a single channel.
+type amqpConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	frameMax uint32
+}
+
+// amqpWriter builds method arguments.
+type amqpWriter struct{ bytes.Buffer }
+
+func (w *amqpWriter) short(v uint16)    { binary.Write(w, binary.BigEndian, v) }
+func (w *amqpWriter) long(v uint32)     { binary.Write(w, binary.BigEndian, v) }
+func (w *amqpWriter) longlong(v uint64) { binary.Write(w, binary.BigEndian, v) }
+func (w *amqpWriter) shortstr(s string) {
+	w.WriteByte(byte(len(s)))
+	w.WriteString(s)
+}
+func (w *amqpWriter) longstr(s string) {
+	w.long(uint32(len(s)))
+	w.WriteString(s)
+}
+
+// dialAMQP connects, authenticates with PLAIN, opens channel 1 and enables
+// publisher confirms. The connection is closed once ctx is done.
+func dialAMQP(ctx context.Context, cfg AMQPConfig) (*amqpConn, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AMQP URL: %w", err)
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "amqp":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "5672")
+		}
+		conn, err = dialContext(ctx, host, nil, amqpTimeout)
+	case "amqps":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "5671")
+		}
+		conn, err = dialContext(ctx, host, &tls.Config{ServerName: u.Hostname()}, amqpTimeout)
+	default:
+		return nil, fmt.Errorf("unsupported AMQP URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &amqpConn{conn: conn, r: bufio.NewReader(conn), frameMax: 131072}
+	if err := c.handshake(u); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake runs connection setup through to a confirming channel.
+func (c *amqpConn) handshake(u *url.URL) error {
+	if err := c.writeRaw([]byte("AMQP\x00\x00\x09\x01")); err != nil {
+		return err
+	}
+	if _, err := c.expect(0, amqpConnectionStart); err != nil {
+		return err
+	}
+
+	user, pass := "guest", "guest"
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	var args amqpWriter
+	args.long(0) // empty client-properties table
+	args.shortstr("PLAIN")
+	args.longstr("\x00" + user + "\x00" + pass)
+	args.shortstr("en_US")
+	if err := c.sendMethod(0, amqpConnectionStartOk, args.Bytes()); err != nil {
+		return err
+	}
+
+	tune, err := c.expect(0, amqpConnectionTune)
+	if err != nil {
+		return err
+	}
+	if len(tune) < 6 {
+		return errors.New("malformed AMQP Connection.Tune")
+	}
+	channelMax := binary.BigEndian.Uint16(tune[0:2])
+	if frameMax := binary.BigEndian.Uint32(tune[2:6]); frameMax > 0 && frameMax < c.frameMax {
+		c.frameMax = frameMax
+	}
+	args.Reset()
+	args.short(channelMax)
+	args.long(c.frameMax)
+	args.short(0) // no heartbeats; connections only live for one cycle
+	if err := c.sendMethod(0, amqpConnectionTuneOk, args.Bytes()); err != nil {
+		return err
+	}
+
+	vhost := "/"
+	if p := strings.TrimPrefix(u.Path, "/"); p != "" {
+		vhost, _ = url.PathUnescape(p)
+	}
+	args.Reset()
+	args.shortstr(vhost)
+	args.shortstr("")
+	args.WriteByte(0)
+	if err := c.sendMethod(0, amqpConnectionOpen, args.Bytes()); err != nil {
+		return err
+	}
+	if _, err := c.expect(0, amqpConnectionOpenOk); err != nil {
+		return err
+	}
+
+	if err := c.sendMethod(1, amqpChannelOpen, []byte{0}); err != nil {
+		return err
+	}
+	if _, err := c.expect(1, amqpChannelOpenOk); err != nil {
+		return err
+	}
+	if err := c.sendMethod(1, amqpConfirmSelect, []byte{0}); err != nil {
+		return err
+	}
+	_, err = c.expect(1, amqpConfirmSelectOk)
+	return err
+}
+
+// writeRaw sends bytes within the timeout.
+func (c *amqpConn) writeRaw(data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(amqpTimeout))
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// frame encodes one frame.
+func amqpFrame(frameType byte, channel uint16, payload []byte) []byte {
+	frame := make([]byte, 7, 8+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint16(frame[1:3], channel)
+	binary.BigEndian.PutUint32(frame[3:7], uint32(len(payload)))
+	frame = append(frame, payload...)
+	return append(frame, amqpFrameEnd)
+}
+
+// sendMethod sends a method frame.
+func (c *amqpConn) sendMethod(channel uint16, method uint32, args []byte) error {
+	payload := binary.BigEndian.AppendUint32(nil, method)
+	return c.writeRaw(amqpFrame(amqpFrameMethod, channel, append(payload, args...)))
+}
+
+// readMethod reads frames until a method frame arrives, skipping heartbeats.
+func (c *amqpConn) readMethod() (uint16, uint32, []byte, error) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(amqpTimeout))
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(c.r, header); err != nil {
+			return 0, 0, nil, err
+		}
+		size := binary.BigEndian.Uint32(header[3:7])
+		payload := make([]byte, size+1)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return 0, 0, nil, err
+		}
+		if payload[size] != amqpFrameEnd {
+			return 0, 0, nil, errors.New("malformed AMQP frame")
+		}
+		if header[0] != amqpFrameMethod || size < 4 {
+			continue
+		}
+		channel := binary.BigEndian.Uint16(header[1:3])
+		return channel, binary.BigEndian.Uint32(payload[0:4]), payload[4:size], nil
+	}
+}
+
+// expect reads the next method and fails unless it is the one wanted. A close from
+// the broker is turned into an error carrying its reply text.
+func (c *amqpConn) expect(channel uint16, want uint32) ([]byte, error) {
+	ch, method, args, err := c.readMethod()
+	if err != nil {
+		return nil, err
+	}
+	if method == amqpConnectionClose || method == amqpChannelClose {
+		return nil, amqpCloseError(args)
+	}
+	if ch != channel || method != want {
+		return nil, fmt.Errorf("unexpected AMQP method %d.%d", method>>16, method&0xffff)
+	}
+	return args, nil
+}
+
+// amqpCloseError describes a Connection.Close or Channel.Close from the broker.
+func amqpCloseError(args []byte) error {
+	if len(args) < 3 {
+		return errors.New("broker closed the connection")
+	}
+	code := binary.BigEndian.Uint16(args[0:2])
+	n := int(args[2])
+	text := ""
+	if 3+n <= len(args) {
+		text = string(args[3 : 3+n])
+	}
+	return fmt.Errorf("broker closed the channel: %d %s", code, text)
+}
+
+// publish sends a persistent JSON message and waits for the broker's confirm.
+func (c *amqpConn) publish(exchange, routingKey, messageID string, body []byte) error {
+	var args amqpWriter
+	args.short(0)
+	args.shortstr(exchange)
+	args.shortstr(routingKey)
+	args.WriteByte(0) // not mandatory, not immediate
+	if err := c.sendMethod(1, amqpBasicPublish, args.Bytes()); err != nil {
+		return err
+	}
+
+	// Content header: class, weight, body size, then content-type, delivery-mode,
+	// message-id and timestamp properties.
+	var header amqpWriter
+	header.short(60)
+	header.short(0)
+	header.longlong(uint64(len(body)))
+	header.short(0x8000 | 0x1000 | 0x0080 | 0x0040)
+	header.shortstr("application/json")
+	header.WriteByte(2) // persistent
+	header.shortstr(messageID)
+	header.longlong(uint64(time.Now().Unix()))
+	if err := c.writeRaw(amqpFrame(amqpFrameHeader, 1, header.Bytes())); err != nil {
+		return err
+	}
+
+	chunk := int(c.frameMax) - 8
+	for len(body) > 0 {
+		n := min(chunk, len(body))
+		if err := c.writeRaw(amqpFrame(amqpFrameBody, 1, body[:n])); err != nil {
+			return err
+		}
+		body = body[n:]
+	}
+
+	_, method, ack, err := c.readMethod()
+	switch {
+	case err != nil:
+		return err
+	case method == amqpBasicAck:
+		return nil
+	case method == amqpBasicNack:
+		return errors.New("broker rejected the message")
+	case method == amqpChannelClose || method == amqpConnectionClose:
+		return amqpCloseError(ack)
+	default:
+		return fmt.Errorf("unexpected AMQP method %d.%d while waiting for confirm", method>>16, method&0xffff)
+	}
+}
+
+// close shuts the connection down cleanly.
+func (c *amqpConn) close() {
+	var args amqpWriter
+	args.short(200)
+	args.shortstr("bye")
+	args.short(0)
+	args.short(0)
+	if c.sendMethod(0, amqpConnectionClose, args.Bytes()) == nil {
+		c.expect(0, amqpConnectionCloseOk)
+	}
+	c.conn.Close()
+}
+
+// publishToAMQP publishes a cycle's events over one connection.
+func publishToAMQP(ctx context.Context, cfg AMQPConfig, events []IncidentEvent) error {
+	c, err := dialAMQP(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("could not connect to AMQP broker: %w", err)
+	}
+	defer c.close()
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messageID := fmt.Sprintf("ncdot-%d-%s-%d", event.Incident.ID, event.Event, event.Time.UnixNano())
+		// A closed channel can't publish anything else, so stop at the first failure.
+		if err := c.publish(cfg.Exchange, expandTopic(cfg.RoutingKey, event), messageID, data); err != nil {
+			return fmt.Errorf("incident %d: %w", event.Incident.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// annotator prepares incidents for alerts: the nearest exit or mile marker is
+// put in front of the location, a vague location is otherwise reverse
+// geocoded, the distances from the places are appended to it, and the weather
+// is added. Only alerts see the result; the stored incident keeps the feed's
+// text.
+type annotator struct {
+	exits    ExitConfig
+	geocoder *geocoder
+	weather  *weatherLookup
+	places   []Place
+}
+
+// newAnnotator sets up the cycle's enrichments for the incidents.
+func newAnnotator(ctx context.Context, cfg NotificationConfig, store Store, incidents []Incident) annotator {
+	return annotator{
+		exits:    cfg.Exits,
+		geocoder: loadGeocoder(cfg.Geocoding),
+		weather:  loadWeatherLookup(ctx, cfg.Weather, store, incidents),
+		places:   cfg.Places,
+	}
+}
+
+// annotate returns the incident as alerts show it.
+func (a annotator) annotate(ctx context.Context, incident Incident) Incident {
+	incident = a.exits.enrich(incident)
+	incident = a.geocoder.enrich(ctx, incident)
+	if text := distancesText(placeDistances(incident, a.places)); text != "" {
+		incident.Location = strings.TrimSpace(incident.Location + " (" + text + ")")
+	}
+	return a.weather.enrich(ctx, incident)
+}
+
+// save writes back what the enrichments cached.
+func (a annotator) save() error {
+	return a.geocoder.save()
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// APIConfig configures the HTTP API started by the serve subcommand.
+type APIConfig struct {
+	// Listen is the address to listen on, e.g. ":8080" or "127.0.0.1:8080".
+	Listen string `yaml:"listen"`
+	// Enabled also serves the API from run --daemon, which adds the live
+	// event stream.
+	Enabled bool `yaml:"enabled"`
+	// GRPC also serves the data over gRPC.
+	GRPC GRPCConfig `yaml:"grpc"`
+}
+
+// Incident list pages hold defaultPageSize incidents unless the limit parameter
+// asks for up to maxPageSize.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// apiShutdownTimeout is how long in-flight requests get to finish on shutdown.
+const apiShutdownTimeout = 5 * time.Second
+
+// incidentPage is the response of GET /api/incidents.
+type incidentPage struct {
+	Incidents []StoredIncident `json:"incidents"`
+	Total     int              `json:"total"`
+	Limit     int              `json:"limit"`
+	Offset    int              `json:"offset"`
+}
+
+// apiServer serves the incident data in a store over HTTP.
+type apiServer struct {
+	store Store
+	// hub is the poller's live events, and health its progress, when the
+	// daemon serves the API.
+	hub    *eventHub
+	health *pollerHealth
+	// hotspots are the defaults of /api/hotspots.geojson.
+	hotspots HotspotReportConfig
+}
+
+// handler routes the API's endpoints.
+func (a *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /dashboard/", dashboardHandler())
+	mux.Handle("GET /{$}", http.RedirectHandler("/dashboard/", http.StatusFound))
+	mux.HandleFunc("GET /healthz", a.healthz)
+	mux.HandleFunc("GET /readyz", a.readyz)
+	mux.HandleFunc("GET /api/incidents", a.listIncidents)
+	mux.HandleFunc("GET /api/incidents.geojson", a.incidentsGeoJSON)
+	mux.HandleFunc("GET /api/hotspots.geojson", a.hotspotsGeoJSON)
+	mux.HandleFunc("GET /api/incidents/{id}", a.getIncident)
+	mux.HandleFunc("GET /api/feed.rss", a.serveRSS)
+	mux.HandleFunc("GET /api/feed.atom", a.serveAtom)
+	mux.HandleFunc("GET /api/closures.ics", a.serveICal)
+	mux.HandleFunc("GET /api/incidents/{id}/history", a.getIncidentHistory)
+	mux.HandleFunc("GET /graphql", a.serveGraphQL)
+	mux.HandleFunc("POST /graphql", a.serveGraphQL)
+	mux.HandleFunc("GET /graphql/schema", a.graphQLSchemaText)
+	if a.hub != nil {
+		mux.HandleFunc("GET /events", a.streamEvents)
+		mux.HandleFunc("GET /ws", a.liveSocket)
+	}
+	return mux
+}
+
+// listIncidents serves a page of incidents filtered by the status, county,
+// type, road and min_severity parameters, paged by limit and offset.
+func (a *apiServer) listIncidents(w http.ResponseWriter, r *http.Request) {
+	q, err := parseIncidentQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	incidents, total, err := a.store.FindIncidents(r.Context(), q)
+	if err != nil {
+		slog.Error("API: error querying incidents", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not query incidents")
+		return
+	}
+	if incidents == nil {
+		incidents = []StoredIncident{}
+	}
+	writeJSON(w, http.StatusOK, incidentPage{Incidents: incidents, Total: total, Limit: q.Limit, Offset: q.Offset})
+}
+
+// geoJSONFeature is an incident as a GeoJSON Feature. Properties hold every
+// field of the incident, as in the other endpoints.
+type geoJSONFeature struct {
+	Type       string         `json:"type"`
+	ID         int            `json:"id"`
+	Geometry   *geoJSONPoint  `json:"geometry"`
+	Properties StoredIncident `json:"properties"`
+}
+
+// geoJSONPoint is a GeoJSON Point, with coordinates in [longitude, latitude] order.
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// geoJSONFeatureCollection is the response of GET /api/incidents.geojson. A
+// paged collection also has the list's total, limit and offset.
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+	Total    int              `json:"total"`
+	Limit    int              `json:"limit"`
+	Offset   int              `json:"offset"`
+}
+
+// incidentsGeoJSON serves the incidents as a GeoJSON FeatureCollection for map
+// tools. It takes the list's filters and paging, but defaults to active
+// incidents and to pages of maxPageSize; a Link header points to the next page
+// when there is one. Incidents without a location have a null geometry.
+func (a *apiServer) incidentsGeoJSON(w http.ResponseWriter, r *http.Request) {
+	q, err := parseIncidentQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	params := r.URL.Query()
+	if !params.Has("status") {
+		q.Status = "active"
+	}
+	if !params.Has("limit") {
+		q.Limit = maxPageSize
+	}
+	incidents, total, err := a.store.FindIncidents(r.Context(), q)
+	if err != nil {
+		slog.Error("API: error querying incidents", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not query incidents")
+		return
+	}
+
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{},
+		Total: total, Limit: q.Limit, Offset: q.Offset}
+	for _, incident := range incidents {
+		feature := geoJSONFeature{Type: "Feature", ID: incident.ID, Properties: incident}
+		if incident.Latitude != 0 || incident.Longitude != 0 {
+			feature.Geometry = &geoJSONPoint{Type: "Point", Coordinates: [2]float64{incident.Longitude, incident.Latitude}}
+		}
+		collection.Features = append(collection.Features, feature)
+	}
+	if next := q.Offset + len(incidents); len(incidents) > 0 && next < total {
+		page := *r.URL
+		params.Set("offset", strconv.Itoa(next))
+		page.RawQuery = params.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, page.RequestURI()))
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	if err := json.NewEncoder(w).Encode(collection); err != nil {
+		slog.Error("API: error writing response", "err", err)
+	}
+}
+
+// getIncident serves one incident.
+func (a *apiServer) getIncident(w http.ResponseWriter, r *http.Request) {
+	incident, ok := a.lookupIncident(w, r)
+	if ok {
+		writeJSON(w, http.StatusOK, incident)
+	}
+}
+
+// getIncidentHistory serves the recorded changes to an incident, oldest first.
+func (a *apiServer) getIncidentHistory(w http.ResponseWriter, r *http.Request) {
+	incident, ok := a.lookupIncident(w, r)
+	if !ok {
+		return
+	}
+	changes, err := a.store.IncidentChanges(r.Context(), incident.ID)
+	if err != nil {
+		slog.Error("API: error querying incident history", "incident_id", incident.ID, "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not query incident history")
+		return
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// lookupIncident finds the incident named by the path's {id}, writing the
+// error response and returning false if there isn't one.
+func (a *apiServer) lookupIncident(w http.ResponseWriter, r *http.Request) (StoredIncident, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid incident ID %q", r.PathValue("id")))
+		return StoredIncident{}, false
+	}
+	incidents, _, err := a.store.FindIncidents(r.Context(), incidentQuery{ID: id, Limit: 1})
+	if err != nil {
+		slog.Error("API: error querying incident", "incident_id", id, "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not query incidents")
+		return StoredIncident{}, false
+	}
+	if len(incidents) == 0 {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("incident %d not found", id))
+		return StoredIncident{}, false
+	}
+	return incidents[0], true
+}
+
+// parseIncidentQuery reads the incident list's query parameters.
+func parseIncidentQuery(r *http.Request) (incidentQuery, error) {
+	params := r.URL.Query()
+	q := incidentQuery{
+		Status:       params.Get("status"),
+		IncidentType: params.Get("type"),
+		Road:         params.Get("road"),
+		Limit:        defaultPageSize,
+	}
+	if q.Status != "" && q.Status != "all" && q.Status != "active" && q.Status != "cleared" {
+		return q, fmt.Errorf("status must be active, cleared or all, got %q", q.Status)
+	}
+	ints := []struct {
+		name     string
+		dest     *int
+		min, max int
+	}{
+		{"county", &q.CountyID, 1, ncCountyCount},
+		{"min_severity", &q.MinSeverity, 0, math.MaxInt32},
+		{"limit", &q.Limit, 1, maxPageSize},
+		{"offset", &q.Offset, 0, math.MaxInt32},
+	}
+	for _, p := range ints {
+		text := params.Get(p.name)
+		if text == "" {
+			continue
+		}
+		n, err := strconv.Atoi(text)
+		if err != nil || n < p.min || n > p.max {
+			return q, fmt.Errorf("%s must be a whole number from %d to %d, got %q", p.name, p.min, p.max, text)
+		}
+		*p.dest = n
+	}
+	return q, nil
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("API: error writing response", "err", err)
+	}
+}
+
+// writeAPIError writes an {"error": message} response.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// serveAPIs serves the REST API, and the gRPC service when configured, until
+// ctx is cancelled or either fails.
+func serveAPIs(ctx context.Context, cfg APIConfig, api *apiServer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	servers := []func() error{func() error {
+		return serveHTTP(ctx, "API", cfg.Listen, api.handler(), "", "")
+	}}
+	if cfg.GRPC.enabled() {
+		servers = append(servers, func() error {
+			return serveHTTP(ctx, "gRPC service", cfg.GRPC.Listen, api.grpcHandler(), cfg.GRPC.CertFile, cfg.GRPC.KeyFile)
+		})
+	}
+	errc := make(chan error, len(servers))
+	for _, serve := range servers {
+		go func() { errc <- serve() }()
+	}
+	var err error
+	for range servers {
+		if e := <-errc; e != nil && err == nil {
+			err = e
+			cancel()
+		}
+	}
+	return err
+}
+
+// serveHTTP serves handler on addr until ctx is cancelled, then lets in-flight
+// requests finish. Requests share ctx, so event streams end at once. With a
+// certificate it serves TLS, which also enables HTTP/2.
+func serveHTTP(ctx context.Context, name, addr string, handler http.Handler, certFile, keyFile string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errc <- srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+	slog.Info("Serving the "+name, "addr", addr)
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("serving the %s: %w", name, err)
+	case <-ctx.Done():
+	}
+	slog.Info("Shutdown requested. Stopping the " + name + " server.")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serveCommand implements the "serve" subcommand, serving the stored incidents
+// over HTTP until interrupted.
+func serveCommand(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := addConfigFlag(fs)
+	listen := fs.String("listen", "", "address to listen on (overrides api.listen)")
+	fs.Parse(args)
+
+	cfg, err := loadCommandConfig(fs, *configPath, false)
+	if err != nil {
+		return err
+	}
+	if *listen != "" {
+		cfg.API.Listen = *listen
+	}
+
+	store, err := openStore(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("Live events are only served by run --daemon with api.enabled.")
+	api := &apiServer{store: store, hotspots: cfg.Reports.Hotspots}
+	return serveAPIs(ctx, cfg.API, api)
+}
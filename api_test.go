@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestIncidentsGeoJSONPaging(t *testing.T) {
+	store := newGraphQLTestStore(t)
+	tests := []struct {
+		name string
+		url  string
+		// wantIDs are the features' incident IDs, and wantLimit and wantLink
+		// the page's limit and its Link header.
+		wantIDs   []int
+		wantLimit int
+		wantLink  string
+	}{
+		{"default page", "/api/incidents.geojson", []int{1, 2, 3}, maxPageSize, ""},
+		{"first page", "/api/incidents.geojson?status=all&limit=2", []int{1, 2}, 2,
+			`</api/incidents.geojson?limit=2&offset=2&status=all>; rel="next"`},
+		{"last page", "/api/incidents.geojson?status=all&limit=2&offset=2", []int{3}, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &apiServer{store: store}
+			rec := httptest.NewRecorder()
+			api.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var collection geoJSONFeatureCollection
+			if err := json.Unmarshal(rec.Body.Bytes(), &collection); err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, f := range collection.Features {
+				ids = append(ids, f.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("features = %v, want %v", ids, tt.wantIDs)
+			}
+			if collection.Total != 3 || collection.Limit != tt.wantLimit {
+				t.Errorf("total, limit = %d, %d; want 3, %d", collection.Total, collection.Limit, tt.wantLimit)
+			}
+			if link := rec.Header().Get("Link"); link != tt.wantLink {
+				t.Errorf("Link = %q, want %q", link, tt.wantLink)
+			}
+		})
+	}
+}
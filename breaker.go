@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerConfig stops sending to a notifier after Failures failed
+// deliveries in a row. The breaker then stays open for Cooldown, failing
+// deliveries straight away (queued ones go back to the retry queue), and lets
+// the next delivery after that through as a probe: if it succeeds the breaker
+// closes, otherwise it opens for another Cooldown.
+type CircuitBreakerConfig struct {
+	// Failures is how many failures in a row open the breaker; 0 disables it.
+	Failures int           `yaml:"failures"`
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// enabled reports whether notifiers have circuit breakers.
+func (c CircuitBreakerConfig) enabled() bool {
+	return c.Failures > 0
+}
+
+// errCircuitOpen is a delivery the breaker refused.
+var errCircuitOpen = errors.New("circuit breaker open, not sending")
+
+// Circuit breaker states.
+const (
+	breakerClosed = iota
+	breakerOpen
+	// breakerHalfOpen has one probe delivery in flight.
+	breakerHalfOpen
+)
+
+// circuitBreaker guards one notifier. A nil breaker lets everything through.
+type circuitBreaker struct {
+	name string
+	cfg  CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+// allow reports whether a delivery may go ahead, returning errCircuitOpen
+// if not. Once the cooldown has passed it lets one probe through.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cfg.Cooldown {
+			return errCircuitOpen
+		}
+		slog.Info("Circuit breaker half-open. Probing.", "channel", b.name)
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		return errCircuitOpen
+	}
+	return nil
+}
+
+// record updates the breaker with a delivery's outcome. Cancelled deliveries
+// say nothing about the endpoint and are ignored, except that a cancelled
+// probe leaves the breaker open as before, so the next delivery probes again.
+func (b *circuitBreaker) record(err error) {
+	if b == nil || errors.Is(err, errCircuitOpen) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		if b.state == breakerHalfOpen {
+			b.state = breakerOpen
+		}
+		return
+	}
+	if err == nil {
+		if b.state != breakerClosed {
+			slog.Info("Circuit breaker closed", "channel", b.name)
+		}
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.cfg.Failures {
+		if b.state != breakerOpen {
+			slog.Warn("Circuit breaker open", "channel", b.name, "failures", b.failures, "cooldown", b.cfg.Cooldown, "err", err)
+		}
+		b.state, b.openedAt = breakerOpen, time.Now()
+	}
+}
+
+// breakers holds each notifier's breaker by Name, kept across daemon cycles.
+var breakers = struct {
+	mu     sync.Mutex
+	cfg    CircuitBreakerConfig
+	byName map[string]*circuitBreaker
+}{byName: make(map[string]*circuitBreaker)}
+
+// configureBreakers sets the breaker settings. Existing breakers keep their
+// state.
+func configureBreakers(cfg CircuitBreakerConfig) {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	breakers.cfg = cfg
+	for _, b := range breakers.byName {
+		b.mu.Lock()
+		b.cfg = cfg
+		b.mu.Unlock()
+	}
+}
+
+// breakerFor returns the breaker of the notifier named name, or nil when
+// breakers are off.
+func breakerFor(name string) *circuitBreaker {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	if !breakers.cfg.enabled() {
+		return nil
+	}
+	b, ok := breakers.byName[name]
+	if !ok {
+		b = &circuitBreaker{name: name, cfg: breakers.cfg}
+		breakers.byName[name] = b
+	}
+	return b
+}
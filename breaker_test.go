@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		// probeErr is how the probe delivery ends.
+		probeErr error
+		// wantState is the breaker's state after it, and wantAllowed whether
+		// the next delivery may go ahead.
+		wantState   int
+		wantAllowed bool
+	}{
+		{"succeeded", nil, breakerClosed, true},
+		{"failed", errors.New("503 Service Unavailable"), breakerOpen, false},
+		// Cancelling says nothing about the endpoint: the cooldown has still
+		// passed, so the next delivery is the probe.
+		{"cancelled", fmt.Errorf("post: %w", context.Canceled), breakerOpen, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openedAt := time.Now().Add(-2 * time.Minute)
+			b := &circuitBreaker{name: "test", cfg: CircuitBreakerConfig{Failures: 3, Cooldown: time.Minute},
+				state: breakerOpen, failures: 3, openedAt: openedAt}
+			if err := b.allow(); err != nil {
+				t.Fatalf("allow() after the cooldown = %v, want the probe let through", err)
+			}
+			if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+				t.Fatalf("allow() during the probe = %v, want %v", err, errCircuitOpen)
+			}
+
+			b.record(tt.probeErr)
+			if b.state != tt.wantState {
+				t.Errorf("state = %d, want %d", b.state, tt.wantState)
+			}
+			if err := b.allow(); (err == nil) != tt.wantAllowed {
+				t.Errorf("allow() after the probe = %v, want allowed %v", err, tt.wantAllowed)
+			}
+		})
+	}
+}
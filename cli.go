@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// command is a CLI subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists every subcommand in the order shown by help.
+var commands = []command{
+	{"run", "fetch the feed once (or continuously with --daemon), store and notify", runCommand},
+	{"backfill", "load saved feed payloads into the database without notifying", backfillCommand},
+	{"export", "write stored incidents to stdout as JSON, CSV or Parquet", exportCommand},
+	{"stats", "show incident statistics from the database", statsCommand},
+	{"history", "show the recorded changes to an incident", historyCommand},
+	{"purge", "delete cleared incidents older than a cutoff", purgeCommand},
+	{"report", "print or send the daily summary or weekly trend report", reportCommand},
+	{"hotspots", "print, send or write as GeoJSON the roads with repeated crashes", hotspotsCommand},
+	{"serve", "serve the stored incidents over an HTTP API", serveCommand},
+}
+
+// runCLI dispatches to a subcommand. With no subcommand (or only flags) it falls
+// back to "run", so existing cron entries keep working unchanged.
+func runCLI(args []string) error {
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage()
+		return nil
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args)
+		}
+	}
+	printUsage()
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// printUsage lists the available subcommands.
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// addConfigFlag registers the --config flag shared by all subcommands.
+func addConfigFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "config.yaml", "path to the YAML config file")
+}
+
+// loadCommandConfig loads and validates the configuration for a subcommand. A
+// config file passed explicitly with --config must exist.
+func loadCommandConfig(fs *flag.FlagSet, configPath string, needFeed bool) (Config, error) {
+	required := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			required = true
+		}
+	})
+
+	cfg, err := loadConfig(configPath, required)
+	if err != nil {
+		return cfg, err
+	}
+	setupLogging(cfg.Log)
+	setupHTTPClient(cfg.HTTP)
+	setupDisplay(cfg.Display)
+	return cfg, cfg.validate(needFeed)
+}
+
+// openDatabase connects to the configured backend, applies the pool settings and
+// brings the schema up to date.
+func openDatabase(cfg DatabaseConfig) (*DB, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	configureDBPool(db, cfg)
+
+	ctx, cancel := db.timeout(context.Background())
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error connecting to database: %w", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	slog.Info("Successfully connected to the database.")
+	return db, nil
+}
@@ -0,0 +1,1213 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// countyFeedURL is the NCDOT per-county incidents endpoint, used when no explicit feed URL is set.
+const countyFeedURL = "https://eapps.ncdot.gov/services/traffic-prod/v1/counties/%d/incidents"
+
+// statewideFeedURL is the NCDOT endpoint listing every incident in the state in one call.
+const statewideFeedURL = "https://eapps.ncdot.gov/services/traffic-prod/v1/incidents"
+
+// Config holds every setting the tool needs. Values are read from an optional
+// YAML config file and then overridden by environment variables.
+type Config struct {
+	Database      DatabaseConfig     `yaml:"database"`
+	Feed          FeedConfig         `yaml:"feed"`
+	Notifications NotificationConfig `yaml:"notifications"`
+	Filters       FilterConfig       `yaml:"filters"`
+	Polling       PollingConfig      `yaml:"polling"`
+	Schedule      ScheduleConfig     `yaml:"schedule"`
+	Events        EventsConfig       `yaml:"events"`
+	Reports       ReportsConfig      `yaml:"reports"`
+	Retention     RetentionConfig    `yaml:"retention"`
+	API           APIConfig          `yaml:"api"`
+	Tracing       TracingConfig      `yaml:"tracing"`
+	HTTP          HTTPConfig         `yaml:"http"`
+	Display       DisplayConfig      `yaml:"display"`
+	Log           LogConfig          `yaml:"log"`
+
+	// DryRun is set by run --dry-run: cycles fetch and compare as usual but
+	// print the writes and notifications they would make instead.
+	DryRun bool `yaml:"-"`
+}
+
+// DatabaseConfig holds the database connection and pool settings. Driver is
+// "postgres", "mysql", "sqlite" or "memory"; SQLite only needs Path, the
+// database file, and memory nothing.
+// Port defaults to the driver's standard port.
+type DatabaseConfig struct {
+	Driver          string        `yaml:"driver"`
+	Path            string        `yaml:"path"`
+	Host            string        `yaml:"host"`
+	Port            string        `yaml:"port"`
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password"`
+	Name            string        `yaml:"name"`
+	SSLMode         string        `yaml:"sslmode"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	// QueryTimeout bounds each database operation, so a hung connection fails
+	// the operation instead of stalling the daemon. Zero means no limit.
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+	// TimescaleDB keeps incident history in a TimescaleDB hypertable with
+	// hourly and daily count aggregates. Postgres only.
+	TimescaleDB bool `yaml:"timescaledb"`
+}
+
+// FeedConfig describes where incidents are fetched from and where sent-alert state is kept.
+// An explicit URL takes precedence; otherwise each county in Counties is fetched. In
+// statewide mode the whole state is fetched in one call and Counties and Regions act
+// as an in-process filter instead.
+type FeedConfig struct {
+	URL       string     `yaml:"url"`
+	CountyID  int        `yaml:"county_id"` // Deprecated: use Counties.
+	Counties  CountyList `yaml:"counties"`
+	Statewide bool       `yaml:"statewide"`
+	// Regions selects named county groups from RegionDefinitions, e.g. "triangle".
+	Regions           []string              `yaml:"regions"`
+	RegionDefinitions map[string]CountyList `yaml:"region_definitions"`
+	StateFile         string                `yaml:"state_file"`
+	// Snapshots stores every feed response in feed_snapshots for replaying
+	// with "backfill --snapshots".
+	Snapshots bool `yaml:"snapshots"`
+	// Retries is how many times a failed fetch is retried. The first retry
+	// waits about RetryBackoff, and each one after twice as long as the last.
+	Retries      int           `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// Workers bounds how many counties are fetched, and then upserted, at
+	// once.
+	Workers int `yaml:"workers"`
+	// CacheFile keeps each feed URL's ETag and Last-Modified for conditional
+	// requests; a cycle whose feed answers 304 Not Modified skips the parse
+	// and upsert. Empty makes every fetch unconditional.
+	CacheFile string `yaml:"cache_file"`
+	// SchemaWebhook is a Discord webhook warned when the feed's fields drift
+	// from the ones parsed; empty uses notifications.discord_webhook.
+	// SchemaStateFile keeps the drift last warned about, so each change
+	// warns once.
+	SchemaWebhook   string `yaml:"schema_webhook"`
+	SchemaStateFile string `yaml:"schema_state_file"`
+}
+
+// countyFilter returns the set of counties selected by Counties and Regions, or nil
+// when neither is set, meaning every county.
+func (f FeedConfig) countyFilter() map[int]bool {
+	if len(f.Counties) == 0 && len(f.Regions) == 0 {
+		return nil
+	}
+	filter := make(map[int]bool)
+	for _, id := range f.Counties {
+		filter[id] = true
+	}
+	for _, region := range f.Regions {
+		for _, id := range f.RegionDefinitions[region] {
+			filter[id] = true
+		}
+	}
+	return filter
+}
+
+// ncCountyCount is the number of NC counties; NCDOT numbers them 1 through 100.
+const ncCountyCount = 100
+
+// CountyList is a list of NCDOT county IDs. In YAML and in the COUNTIES variable
+// it may also be given as the string "all" to select every county.
+type CountyList []int
+
+// UnmarshalYAML accepts either a list of county IDs or the string "all".
+func (c *CountyList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		list, err := parseCountyList(value.Value)
+		if err != nil {
+			return err
+		}
+		*c = list
+		return nil
+	}
+	var ids []int
+	if err := value.Decode(&ids); err != nil {
+		return fmt.Errorf("counties must be a list of county IDs or \"all\": %w", err)
+	}
+	*c = ids
+	return nil
+}
+
+// parseCountyList parses "all" or a comma-separated list of county IDs.
+func parseCountyList(s string) (CountyList, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "all") {
+		all := make(CountyList, ncCountyCount)
+		for i := range all {
+			all[i] = i + 1
+		}
+		return all, nil
+	}
+
+	var list CountyList
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid county ID %q", part)
+		}
+		list = append(list, id)
+	}
+	return list, nil
+}
+
+// NotificationConfig holds the notification targets. CountyWebhooks routes alerts
+// for specific counties to their own Discord channel; other counties use DiscordWebhook.
+// Routes add further channels with their own filters.
+type NotificationConfig struct {
+	DiscordWebhook   string         `yaml:"discord_webhook"`
+	CountyWebhooks   map[int]string `yaml:"county_webhooks"`
+	Routes           []Route        `yaml:"routes"`
+	GoogleMapsAPIKey string         `yaml:"google_maps_api_key"`
+	// StaticMap is the map image of the incident shown in Discord, Slack and
+	// email alerts.
+	StaticMap StaticMapConfig `yaml:"static_map"`
+	// EditMessages edits the original alert when an incident changes or clears,
+	// instead of posting a separate cleared message.
+	EditMessages bool `yaml:"edit_messages"`
+	// Escalations sends an "incident escalated" update when an alerted incident's
+	// severity rises or more lanes close.
+	Escalations bool `yaml:"escalations"`
+	// Updates chooses the other changes to an alerted incident that send an update.
+	Updates UpdateToggles `yaml:"updates"`
+	// MessagesFile stores the posted Discord message IDs needed for editing.
+	MessagesFile string `yaml:"messages_file"`
+	// Threads starts a thread on each alert and posts later updates there. Creating
+	// threads needs a bot token with access to the alert channels.
+	Threads         bool   `yaml:"threads"`
+	DiscordBotToken string `yaml:"discord_bot_token"`
+	// Mentions pings roles or users on critical incidents.
+	Mentions MentionConfig `yaml:"mentions"`
+	// Slack posts every alert to Slack too; routes can target other Slack channels.
+	Slack SlackConfig `yaml:"slack"`
+	// Telegram posts every alert through a Telegram bot.
+	Telegram TelegramConfig `yaml:"telegram"`
+	// Teams posts every alert to a Microsoft Teams channel as an Adaptive Card.
+	Teams TeamsConfig `yaml:"teams"`
+	// Email sends alerts by SMTP, immediately or as a periodic digest per recipient.
+	Email EmailConfig `yaml:"email"`
+	// SMS texts critical incidents through Twilio or a generic gateway.
+	SMS SMSConfig `yaml:"sms"`
+	// Pushover sends phone push notifications with priority by severity.
+	Pushover PushoverConfig `yaml:"pushover"`
+	// Ntfy publishes alerts to an ntfy topic.
+	Ntfy NtfyConfig `yaml:"ntfy"`
+	// Matrix posts alerts to a Matrix room.
+	Matrix MatrixConfig `yaml:"matrix"`
+	// Mastodon posts major incidents to a Mastodon account.
+	Mastodon MastodonConfig `yaml:"mastodon"`
+	// Twitter posts major incidents to an X account, once each.
+	Twitter TwitterConfig `yaml:"twitter"`
+	// Signal sends alerts through a signal-cli REST API.
+	Signal SignalConfig `yaml:"signal"`
+	// Gotify pushes alerts to a self-hosted Gotify server.
+	Gotify GotifyConfig `yaml:"gotify"`
+	// Geocoding fills in vague alert locations from the incident's coordinates.
+	Geocoding GeocodingConfig `yaml:"geocoding"`
+	// Dedup combines near-duplicate incidents into one alert.
+	Dedup DedupConfig `yaml:"dedup"`
+	// Exits names the nearest exit or mile marker in alerts on major routes.
+	Exits ExitConfig `yaml:"exits"`
+	// Weather adds the weather at the incident when it started to alerts.
+	Weather WeatherConfig `yaml:"weather"`
+	// Places are named points, such as home and work, whose distance each
+	// alert gives, nearest first.
+	Places []Place `yaml:"places"`
+	// Paging pages on-call through PagerDuty or Opsgenie for full closures on critical corridors.
+	Paging PagingConfig `yaml:"paging"`
+	// Webhooks POSTs every event as signed JSON to arbitrary URLs.
+	Webhooks []OutboundWebhook `yaml:"webhooks"`
+	// BatchThreshold combines the new incidents of one cycle into a single digest
+	// per channel when there are at least this many. 0 always sends them one by one.
+	BatchThreshold int `yaml:"batch_threshold"`
+	// Rules decide which notifiers and routes an incident goes to, e.g. construction
+	// to one channel and crashes to another. The first matching rule wins; incidents
+	// no rule matches go everywhere, as without rules.
+	Rules []RoutingRule `yaml:"rules"`
+	// Templates customizes message text per notifier ("discord", "slack", ...) and
+	// per event, with "default" applying to every notifier.
+	Templates map[string]MessageTemplates `yaml:"templates"`
+	// Retry queues failed notifications in the database and retries them on
+	// later cycles.
+	Retry NotificationRetryConfig `yaml:"retry"`
+	// CircuitBreaker stops sending to a notifier that keeps failing, probing it
+	// again after a cooldown.
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// Route types.
+const (
+	routeTypeDiscord = "discord"
+	routeTypeSlack   = "slack"
+)
+
+// Route sends incidents matching its filters to an additional channel, e.g. a
+// "Wake County crashes" Discord channel alongside a "statewide closures" Slack
+// channel. Type selects the service (Discord if empty). Webhook is the Discord or
+// Slack incoming webhook; a Slack route may instead name a Channel to post to with
+// the Slack bot token. Empty filters match everything.
+type Route struct {
+	Name          string   `yaml:"name"`
+	Type          string   `yaml:"type"`
+	Webhook       string   `yaml:"webhook"`
+	Channel       string   `yaml:"channel"`
+	Counties      []int    `yaml:"counties"`
+	IncidentTypes []string `yaml:"incident_types"`
+	MinSeverity   int      `yaml:"min_severity"`
+}
+
+// isType reports whether the route posts to the given service.
+func (r Route) isType(routeType string) bool {
+	if r.Type == "" {
+		return routeType == routeTypeDiscord
+	}
+	return strings.EqualFold(r.Type, routeType)
+}
+
+// matches reports whether an incident with these attributes should go to the route.
+func (r Route) matches(countyID int, incidentType string, severity int) bool {
+	if len(r.Counties) > 0 && !slices.Contains(r.Counties, countyID) {
+		return false
+	}
+	if len(r.IncidentTypes) > 0 && !(IncidentTypeFilter{Include: r.IncidentTypes}).matches(incidentType) {
+		return false
+	}
+	return severity >= r.MinSeverity
+}
+
+// UpdateToggles turns on update notifications per field. A change to lanes
+// closed or the lane count is described as e.g. "Lanes: 1 of 3 closed → 3 of 3
+// closed".
+type UpdateToggles struct {
+	LanesClosed bool `yaml:"lanes_closed"`
+	LanesTotal  bool `yaml:"lanes_total"`
+	Severity    bool `yaml:"severity"`
+}
+
+// RoutingRule sends incidents matching its predicates only to Targets, each the
+// name of a notifier ("discord", "telegram", ...) or of a route. Naming "discord"
+// or "slack" selects that service's default destinations; its routes are only
+// used when named. Empty predicates match everything, and MaxSeverity 0 means no
+// upper bound.
+type RoutingRule struct {
+	Counties      []int    `yaml:"counties"`
+	IncidentTypes []string `yaml:"incident_types"`
+	MinSeverity   int      `yaml:"min_severity"`
+	MaxSeverity   int      `yaml:"max_severity"`
+	Targets       []string `yaml:"targets"`
+}
+
+// matches reports whether an incident with these attributes falls under the rule.
+func (r RoutingRule) matches(countyID int, incidentType string, severity int) bool {
+	if r.MaxSeverity > 0 && severity > r.MaxSeverity {
+		return false
+	}
+	return Route{Counties: r.Counties, IncidentTypes: r.IncidentTypes, MinSeverity: r.MinSeverity}.matches(countyID, incidentType, severity)
+}
+
+// ruleFor returns the first rule matching an incident, or nil if none does.
+func (n NotificationConfig) ruleFor(countyID int, incidentType string, severity int) *RoutingRule {
+	for i, rule := range n.Rules {
+		if rule.matches(countyID, incidentType, severity) {
+			return &n.Rules[i]
+		}
+	}
+	return nil
+}
+
+// routesTo reports whether the rules let an incident reach the named notifier,
+// directly or through one of its routes.
+func (n NotificationConfig) routesTo(notifier string, countyID int, incidentType string, severity int) bool {
+	rule := n.ruleFor(countyID, incidentType, severity)
+	if rule == nil || slices.Contains(rule.Targets, notifier) {
+		return true
+	}
+	return slices.ContainsFunc(n.Routes, func(r Route) bool {
+		return r.Name != "" && r.isType(notifier) && slices.Contains(rule.Targets, r.Name)
+	})
+}
+
+// webhookFor returns the default Discord webhook that alerts for the given county go to.
+func (n NotificationConfig) webhookFor(countyID int) string {
+	if url, ok := n.CountyWebhooks[countyID]; ok && url != "" {
+		return url
+	}
+	return n.DiscordWebhook
+}
+
+// webhooksFor returns every Discord webhook an incident should be posted to: the
+// default (or county) webhook plus each matching route, without duplicates,
+// narrowed down by the first matching rule.
+func (n NotificationConfig) webhooksFor(countyID int, incidentType string, severity int) []string {
+	rule := n.ruleFor(countyID, incidentType, severity)
+	var webhooks []string
+	if url := n.webhookFor(countyID); url != "" && (rule == nil || slices.Contains(rule.Targets, routeTypeDiscord)) {
+		webhooks = append(webhooks, url)
+	}
+	for _, route := range n.Routes {
+		if !route.isType(routeTypeDiscord) || (rule != nil && !slices.Contains(rule.Targets, route.Name)) {
+			continue
+		}
+		if route.matches(countyID, incidentType, severity) && !slices.Contains(webhooks, route.Webhook) {
+			webhooks = append(webhooks, route.Webhook)
+		}
+	}
+	return webhooks
+}
+
+// FilterConfig decides which incidents are stored and which produce alerts.
+type FilterConfig struct {
+	// IncidentTypes selects the incident types that are stored and alerted on.
+	IncidentTypes IncidentTypeFilter `yaml:"incident_types"`
+	// Geofence limits alerts to incidents inside an area. Everything is still stored.
+	Geofence GeofenceConfig `yaml:"geofence"`
+	// Roads limits alerts to particular roads and route IDs. Everything is still stored.
+	Roads RoadFilter `yaml:"roads"`
+	// Corridors limits alerts to incidents along any of these routes, such as
+	// commutes. Everything is still stored.
+	Corridors []CorridorConfig `yaml:"corridors"`
+	// QuietHours suppresses or queues new-incident alerts during a daily window.
+	QuietHours QuietHoursConfig `yaml:"quiet_hours"`
+	// MinSeverity is the lowest severity that triggers an alert. Everything is still stored.
+	MinSeverity int `yaml:"min_severity"`
+	// MaxAge keeps cold starts quiet by not alerting on incidents that started long ago.
+	MaxAge time.Duration `yaml:"max_age"`
+	// MaxAgeUnknownAlerts decides whether incidents with an unparseable start time still alert.
+	MaxAgeUnknownAlerts bool `yaml:"max_age_unknown_alerts"`
+	// MaxDistanceMiles only alerts on incidents within this distance of one of
+	// notifications.places. 0 disables the limit. Everything is still stored.
+	MaxDistanceMiles float64 `yaml:"max_distance_miles"`
+}
+
+// PollingConfig controls daemon mode.
+type PollingConfig struct {
+	Daemon   bool          `yaml:"daemon"`
+	Interval time.Duration `yaml:"interval"`
+	// Jitter adds a random delay of up to this much to each wait between polls.
+	Jitter   time.Duration         `yaml:"jitter"`
+	Adaptive AdaptivePollingConfig `yaml:"adaptive"`
+	// LeaderElection lets redundant daemons share a database with only one
+	// of them polling and notifying.
+	LeaderElection LeaderElectionConfig `yaml:"leader_election"`
+
+	// health records the poller's progress for the API's health checks, when
+	// run serves the API.
+	health *pollerHealth
+	// pacer spaces the daemon's polls, when run is a daemon.
+	pacer *pollPacer
+}
+
+// defaultConfig returns the settings used when neither the file nor the environment sets a value.
+func defaultConfig() Config {
+	return Config{
+		Database: DatabaseConfig{
+			Driver:          driverPostgres,
+			Path:            "crash-reporting.db",
+			SSLMode:         "require",
+			MaxOpenConns:    5,
+			MaxIdleConns:    2,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			QueryTimeout:    30 * time.Second,
+		},
+		Feed: FeedConfig{
+			StateFile:       "sent_incidents_ncdot.json",
+			Retries:         3,
+			RetryBackoff:    2 * time.Second,
+			Workers:         8,
+			CacheFile:       "feed_cache_ncdot.json",
+			SchemaStateFile: "feed_schema_ncdot.json",
+		},
+		Notifications: NotificationConfig{
+			EditMessages:   true,
+			Escalations:    true,
+			Updates:        UpdateToggles{LanesClosed: true},
+			MessagesFile:   "discord_messages_ncdot.json",
+			Retry:          NotificationRetryConfig{MaxAttempts: 8, Backoff: time.Minute, MaxBackoff: time.Hour},
+			CircuitBreaker: CircuitBreakerConfig{Failures: 5, Cooldown: 5 * time.Minute},
+			StaticMap: StaticMapConfig{
+				Provider:    mapProviderGoogle,
+				MapboxStyle: "mapbox/streets-v12",
+				OSMURL:      defaultOSMStaticMapURL,
+				Zoom:        14,
+				Width:       600,
+				Height:      400,
+			},
+			Dedup:   DedupConfig{MaxDistanceMiles: 0.25, MaxTimeApart: 15 * time.Minute},
+			Exits:   ExitConfig{MaxDistanceMiles: 2},
+			Weather: WeatherConfig{URL: defaultWeatherURL},
+			Geocoding: GeocodingConfig{
+				URL:         defaultGeocodingURL,
+				UserAgent:   "ncdot-crash-reporting",
+				CacheFile:   "geocode_cache_ncdot.json",
+				MinInterval: time.Second,
+			},
+			Mentions: MentionConfig{
+				MinSeverity: 3,
+				FullClosure: true,
+			},
+			Email: EmailConfig{
+				Port:           587,
+				DigestInterval: time.Hour,
+				DigestFile:     "email_digest_ncdot.json",
+			},
+			SMS: SMSConfig{
+				Provider:    smsProviderTwilio,
+				MinSeverity: 3,
+				FullClosure: true,
+				MaxLength:   160,
+				MaxPerHour:  10,
+				StateFile:   "sms_sent_ncdot.json",
+			},
+			Pushover: PushoverConfig{
+				Priorities: map[int]int{1: -1, 2: 0, 3: 1},
+			},
+			Mastodon: MastodonConfig{
+				MinSeverity: 3,
+				FullClosure: true,
+				Hashtags:    []string{"nctraffic"},
+				Visibility:  "public",
+			},
+			Twitter: TwitterConfig{
+				MinSeverity: 3,
+				FullClosure: true,
+				Hashtags:    []string{"nctraffic"},
+				StateFile:   "tweeted_incidents_ncdot.json",
+			},
+			Gotify: GotifyConfig{
+				Priorities: map[int]int{1: 2, 2: 5, 3: 8},
+			},
+			Paging: PagingConfig{
+				OpsgenieAPIURL: defaultOpsgenieAPIURL,
+				StateFile:      "paged_incidents_ncdot.json",
+			},
+		},
+		Filters: FilterConfig{
+			IncidentTypes: IncidentTypeFilter{Include: []string{"Vehicle Crash"}},
+			QuietHours: QuietHoursConfig{
+				Mode:      quietModeQueue,
+				Timezone:  "America/New_York",
+				QueueFile: "queued_alerts_ncdot.json",
+			},
+			MaxAgeUnknownAlerts: true,
+		},
+		Polling: PollingConfig{
+			Interval:       2 * time.Minute,
+			Adaptive:       AdaptivePollingConfig{MinInterval: 30 * time.Second, MaxInterval: 10 * time.Minute},
+			LeaderElection: LeaderElectionConfig{Key: defaultLeaderKey},
+		},
+		Schedule: ScheduleConfig{Timezone: "America/New_York"},
+		Events: EventsConfig{
+			StateFile: "event_state_ncdot.json",
+			MQTT: MQTTConfig{
+				ClientID:   "ncdot-crash-reporting",
+				Topic:      "ncdot/{county}/{type}/{id}",
+				EventTopic: "ncdot/events/{event}",
+				QoS:        1,
+				Retain:     true,
+			},
+			Kafka: KafkaConfig{
+				Topic:  "ncdot-incidents",
+				Format: kafkaFormatJSON,
+			},
+			NATS: NATSConfig{
+				Subject:   "ncdot.incidents.{event}.{county}.{type}",
+				JetStream: true,
+			},
+			PubSub: PubSubConfig{
+				Endpoint: defaultPubSubEndpoint,
+			},
+			AMQP: AMQPConfig{
+				Exchange:   "ncdot",
+				RoutingKey: "{event}.{county}.{type}.{severity}",
+			},
+		},
+		Reports: ReportsConfig{
+			Timezone:  "America/New_York",
+			StateFile: "report_state_ncdot.json",
+			Weekly:    WeeklyReportConfig{Day: "monday", Top: defaultWeeklyTop},
+			Hotspots:  HotspotReportConfig{Day: "monday", Days: 90, Top: 10, CellMiles: 0.25, MinCrashes: 3},
+		},
+		API:     APIConfig{Listen: ":8080"},
+		Tracing: TracingConfig{ServiceName: "crash-reporting"},
+		HTTP:    HTTPConfig{Timeout: 30 * time.Second},
+		Display: DisplayConfig{Timezone: "America/New_York", TimeFormat: "Jan 2 3:04 PM MST"},
+		Log:     LogConfig{Format: logFormatText, Level: "info"},
+	}
+}
+
+// loadConfig builds the configuration from defaults, the YAML file at path (if it
+// exists) and environment overrides. A missing file is only an error when required
+// is true, i.e. the user asked for it explicitly. Callers validate the result.
+func loadConfig(path string, required bool) (Config, error) {
+	cfg := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("could not parse config file %s: %w", path, err)
+		}
+		slog.Info("Loaded configuration", "path", path)
+	case os.IsNotExist(err) && !required:
+		// No config file is fine; everything can come from the environment.
+	default:
+		return cfg, fmt.Errorf("could not read config file %s: %w", path, err)
+	}
+
+	if err := applyEnvOverrides(&cfg); err != nil {
+		return cfg, err
+	}
+	if len(cfg.Feed.Counties) == 0 && cfg.Feed.CountyID > 0 {
+		cfg.Feed.Counties = CountyList{cfg.Feed.CountyID}
+	}
+	if err := cfg.Filters.Geofence.load(); err != nil {
+		return cfg, err
+	}
+	for i := range cfg.Filters.Corridors {
+		if err := cfg.Filters.Corridors[i].load(); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.Notifications.Exits.load(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Filters.QuietHours.load(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Notifications.Email.load(); err != nil {
+		return cfg, err
+	}
+	for i := range cfg.Notifications.Webhooks {
+		if err := cfg.Notifications.Webhooks[i].load(); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.Notifications.loadTemplates(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Reports.load(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Schedule.load(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Display.load(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// applyEnvOverrides lets the historical environment variables take precedence over the file.
+func applyEnvOverrides(cfg *Config) error {
+	var errs []error
+
+	setString := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*dst = v
+		}
+	}
+	setInt := func(name string, dst *int) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q is not a valid integer", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	setFloat := func(name string, dst *float64) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q is not a valid number", name, v))
+				return
+			}
+			*dst = f
+		}
+	}
+	setBool := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q is not a valid boolean", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	setMinutes := func(name string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q is not a valid number of minutes", name, v))
+				return
+			}
+			*dst = time.Duration(n) * time.Minute
+		}
+	}
+	setList := func(name string, dst *[]string) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*dst = splitList(v)
+		}
+	}
+	setDuration := func(name string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q is not a valid duration (e.g. 2m, 90s)", name, v))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	setString("DATABASE_DRIVER", &cfg.Database.Driver)
+	setString("DATABASE_PATH", &cfg.Database.Path)
+	setString("DATABASE_HOST", &cfg.Database.Host)
+	setString("DATABASE_PORT", &cfg.Database.Port)
+	setString("DATABASE_USERNAME", &cfg.Database.User)
+	setString("DATABASE_PASSWORD", &cfg.Database.Password)
+	setString("DATABASE_NAME", &cfg.Database.Name)
+	setString("DATABASE_SSLMODE", &cfg.Database.SSLMode)
+	setInt("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	setInt("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	setMinutes("DB_CONN_MAX_LIFETIME_MINUTES", &cfg.Database.ConnMaxLifetime)
+	setMinutes("DB_CONN_MAX_IDLE_TIME_MINUTES", &cfg.Database.ConnMaxIdleTime)
+	setDuration("DB_QUERY_TIMEOUT", &cfg.Database.QueryTimeout)
+	setBool("DATABASE_TIMESCALEDB", &cfg.Database.TimescaleDB)
+
+	setString("DOT_URL", &cfg.Feed.URL)
+	setInt("COUNTY_ID", &cfg.Feed.CountyID)
+	if v, ok := os.LookupEnv("COUNTIES"); ok && v != "" {
+		list, err := parseCountyList(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("COUNTIES=%q: %w", v, err))
+		} else {
+			cfg.Feed.Counties = list
+		}
+	}
+	setBool("STATEWIDE", &cfg.Feed.Statewide)
+	setList("REGIONS", &cfg.Feed.Regions)
+	setString("STATE_FILE", &cfg.Feed.StateFile)
+	setBool("FEED_SNAPSHOTS", &cfg.Feed.Snapshots)
+	setInt("FEED_RETRIES", &cfg.Feed.Retries)
+	setDuration("FEED_RETRY_BACKOFF", &cfg.Feed.RetryBackoff)
+	setInt("FEED_WORKERS", &cfg.Feed.Workers)
+	setString("FEED_CACHE_FILE", &cfg.Feed.CacheFile)
+	setString("FEED_SCHEMA_WEBHOOK", &cfg.Feed.SchemaWebhook)
+	setBool("GEOCODING_ENABLED", &cfg.Notifications.Geocoding.Enabled)
+	setString("GEOCODING_URL", &cfg.Notifications.Geocoding.URL)
+	setString("GEOCODING_API_KEY", &cfg.Notifications.Geocoding.APIKey)
+	setString("GEOCODING_USER_AGENT", &cfg.Notifications.Geocoding.UserAgent)
+	setBool("DEDUP_ENABLED", &cfg.Notifications.Dedup.Enabled)
+	setString("EXITS_FILE", &cfg.Notifications.Exits.File)
+	setBool("WEATHER_ENABLED", &cfg.Notifications.Weather.Enabled)
+	setString("WEATHER_URL", &cfg.Notifications.Weather.URL)
+
+	setString("DISCORD_HOOK", &cfg.Notifications.DiscordWebhook)
+	setString("GOOGLE_MAPS_API_KEY", &cfg.Notifications.GoogleMapsAPIKey)
+	setString("STATIC_MAP_PROVIDER", &cfg.Notifications.StaticMap.Provider)
+	setString("MAPBOX_TOKEN", &cfg.Notifications.StaticMap.MapboxToken)
+	setBool("EDIT_DISCORD_MESSAGES", &cfg.Notifications.EditMessages)
+	setBool("ESCALATION_ALERTS", &cfg.Notifications.Escalations)
+	setBool("UPDATE_LANES_CLOSED", &cfg.Notifications.Updates.LanesClosed)
+	setBool("UPDATE_LANES_TOTAL", &cfg.Notifications.Updates.LanesTotal)
+	setBool("UPDATE_SEVERITY", &cfg.Notifications.Updates.Severity)
+	setString("DISCORD_MESSAGES_FILE", &cfg.Notifications.MessagesFile)
+	setBool("DISCORD_THREADS", &cfg.Notifications.Threads)
+	setString("DISCORD_BOT_TOKEN", &cfg.Notifications.DiscordBotToken)
+	setString("SLACK_WEBHOOK_URL", &cfg.Notifications.Slack.WebhookURL)
+	setString("SLACK_BOT_TOKEN", &cfg.Notifications.Slack.BotToken)
+	setString("SLACK_CHANNEL", &cfg.Notifications.Slack.Channel)
+	setString("TELEGRAM_BOT_TOKEN", &cfg.Notifications.Telegram.BotToken)
+	setList("TELEGRAM_CHAT_IDS", &cfg.Notifications.Telegram.ChatIDs)
+	setString("TEAMS_WEBHOOK_URL", &cfg.Notifications.Teams.WebhookURL)
+	setString("SMTP_HOST", &cfg.Notifications.Email.Host)
+	setInt("SMTP_PORT", &cfg.Notifications.Email.Port)
+	setString("SMTP_USERNAME", &cfg.Notifications.Email.Username)
+	setString("SMTP_PASSWORD", &cfg.Notifications.Email.Password)
+	setString("EMAIL_FROM", &cfg.Notifications.Email.From)
+	// Each variable replaces the configured recipients of its kind, alerts or
+	// digests, and leaves the other kind as configured.
+	setRecipients := func(name string, digest bool) {
+		v, ok := os.LookupEnv(name)
+		if !ok || v == "" {
+			return
+		}
+		recipients := slices.DeleteFunc(cfg.Notifications.Email.Recipients, func(r EmailRecipient) bool { return r.Digest == digest })
+		for _, addr := range splitList(v) {
+			recipients = append(recipients, EmailRecipient{Address: addr, Digest: digest})
+		}
+		cfg.Notifications.Email.Recipients = recipients
+	}
+	setRecipients("EMAIL_RECIPIENTS", false)
+	setRecipients("EMAIL_DIGEST_RECIPIENTS", true)
+	setString("EMAIL_TEMPLATE_FILE", &cfg.Notifications.Email.TemplateFile)
+	setMinutes("EMAIL_DIGEST_INTERVAL_MINUTES", &cfg.Notifications.Email.DigestInterval)
+	setString("SMS_PROVIDER", &cfg.Notifications.SMS.Provider)
+	setString("TWILIO_ACCOUNT_SID", &cfg.Notifications.SMS.AccountSID)
+	setString("TWILIO_AUTH_TOKEN", &cfg.Notifications.SMS.AuthToken)
+	setString("SMS_GATEWAY_URL", &cfg.Notifications.SMS.GatewayURL)
+	setString("SMS_FROM", &cfg.Notifications.SMS.From)
+	setList("SMS_TO", &cfg.Notifications.SMS.To)
+	setInt("SMS_MIN_SEVERITY", &cfg.Notifications.SMS.MinSeverity)
+	setBool("SMS_FULL_CLOSURE", &cfg.Notifications.SMS.FullClosure)
+	setInt("SMS_MAX_LENGTH", &cfg.Notifications.SMS.MaxLength)
+	setInt("SMS_MAX_PER_HOUR", &cfg.Notifications.SMS.MaxPerHour)
+	setString("PUSHOVER_APP_TOKEN", &cfg.Notifications.Pushover.AppToken)
+	setList("PUSHOVER_USER_KEYS", &cfg.Notifications.Pushover.UserKeys)
+	setString("NTFY_TOPIC_URL", &cfg.Notifications.Ntfy.TopicURL)
+	setString("NTFY_TOKEN", &cfg.Notifications.Ntfy.Token)
+	setString("MATRIX_HOMESERVER_URL", &cfg.Notifications.Matrix.HomeserverURL)
+	setString("MATRIX_ACCESS_TOKEN", &cfg.Notifications.Matrix.AccessToken)
+	setString("MATRIX_ROOM_ID", &cfg.Notifications.Matrix.RoomID)
+	setString("MASTODON_INSTANCE_URL", &cfg.Notifications.Mastodon.InstanceURL)
+	setString("MASTODON_ACCESS_TOKEN", &cfg.Notifications.Mastodon.AccessToken)
+	setInt("MASTODON_MIN_SEVERITY", &cfg.Notifications.Mastodon.MinSeverity)
+	setBool("MASTODON_FULL_CLOSURE", &cfg.Notifications.Mastodon.FullClosure)
+	setList("MASTODON_HASHTAGS", &cfg.Notifications.Mastodon.Hashtags)
+	setString("MASTODON_VISIBILITY", &cfg.Notifications.Mastodon.Visibility)
+	setString("TWITTER_API_KEY", &cfg.Notifications.Twitter.APIKey)
+	setString("TWITTER_API_SECRET", &cfg.Notifications.Twitter.APISecret)
+	setString("TWITTER_ACCESS_TOKEN", &cfg.Notifications.Twitter.AccessToken)
+	setString("TWITTER_ACCESS_TOKEN_SECRET", &cfg.Notifications.Twitter.AccessTokenSecret)
+	setInt("TWITTER_MIN_SEVERITY", &cfg.Notifications.Twitter.MinSeverity)
+	setBool("TWITTER_FULL_CLOSURE", &cfg.Notifications.Twitter.FullClosure)
+	setList("TWITTER_HASHTAGS", &cfg.Notifications.Twitter.Hashtags)
+	setString("SIGNAL_API_URL", &cfg.Notifications.Signal.APIURL)
+	setString("SIGNAL_NUMBER", &cfg.Notifications.Signal.Number)
+	setList("SIGNAL_RECIPIENTS", &cfg.Notifications.Signal.Recipients)
+	setString("GOTIFY_SERVER_URL", &cfg.Notifications.Gotify.ServerURL)
+	setString("GOTIFY_APP_TOKEN", &cfg.Notifications.Gotify.AppToken)
+	setString("PAGERDUTY_ROUTING_KEY", &cfg.Notifications.Paging.PagerDutyRoutingKey)
+	setString("OPSGENIE_API_KEY", &cfg.Notifications.Paging.OpsgenieAPIKey)
+	setString("OPSGENIE_API_URL", &cfg.Notifications.Paging.OpsgenieAPIURL)
+	setList("PAGING_CORRIDORS", &cfg.Notifications.Paging.Corridors.Allow)
+	if v := os.Getenv("OUTBOUND_WEBHOOK_URL"); v != "" {
+		cfg.Notifications.Webhooks = append(cfg.Notifications.Webhooks, OutboundWebhook{
+			URL:     v,
+			Secret:  os.Getenv("OUTBOUND_WEBHOOK_SECRET"),
+			Retries: 3,
+		})
+	}
+	setList("DISCORD_MENTION_ROLES", &cfg.Notifications.Mentions.RoleIDs)
+	setList("DISCORD_MENTION_USERS", &cfg.Notifications.Mentions.UserIDs)
+	setInt("DISCORD_MENTION_MIN_SEVERITY", &cfg.Notifications.Mentions.MinSeverity)
+	setBool("DISCORD_MENTION_FULL_CLOSURE", &cfg.Notifications.Mentions.FullClosure)
+	setInt("BATCH_THRESHOLD", &cfg.Notifications.BatchThreshold)
+	setInt("NOTIFY_RETRY_MAX_ATTEMPTS", &cfg.Notifications.Retry.MaxAttempts)
+	setDuration("NOTIFY_RETRY_BACKOFF", &cfg.Notifications.Retry.Backoff)
+	setDuration("NOTIFY_RETRY_MAX_BACKOFF", &cfg.Notifications.Retry.MaxBackoff)
+	setInt("NOTIFY_BREAKER_FAILURES", &cfg.Notifications.CircuitBreaker.Failures)
+	setDuration("NOTIFY_BREAKER_COOLDOWN", &cfg.Notifications.CircuitBreaker.Cooldown)
+
+	setList("INCIDENT_TYPES", &cfg.Filters.IncidentTypes.Include)
+	setList("EXCLUDE_INCIDENT_TYPES", &cfg.Filters.IncidentTypes.Exclude)
+	setFloat("GEOFENCE_LATITUDE", &cfg.Filters.Geofence.Latitude)
+	setFloat("GEOFENCE_LONGITUDE", &cfg.Filters.Geofence.Longitude)
+	setFloat("GEOFENCE_RADIUS_MILES", &cfg.Filters.Geofence.RadiusMiles)
+	setString("GEOFENCE_POLYGONS_FILE", &cfg.Filters.Geofence.PolygonsFile)
+	setList("ALLOW_ROADS", &cfg.Filters.Roads.Allow)
+	setList("DENY_ROADS", &cfg.Filters.Roads.Deny)
+	setString("QUIET_HOURS_START", &cfg.Filters.QuietHours.Start)
+	setString("QUIET_HOURS_END", &cfg.Filters.QuietHours.End)
+	setString("QUIET_HOURS_MODE", &cfg.Filters.QuietHours.Mode)
+	setString("QUIET_HOURS_TIMEZONE", &cfg.Filters.QuietHours.Timezone)
+	setInt("MIN_SEVERITY", &cfg.Filters.MinSeverity)
+	setMinutes("MAX_AGE_MINUTES", &cfg.Filters.MaxAge)
+	setBool("MAX_AGE_UNKNOWN_ALERTS", &cfg.Filters.MaxAgeUnknownAlerts)
+	setFloat("MAX_DISTANCE_MILES", &cfg.Filters.MaxDistanceMiles)
+
+	setBool("DAEMON", &cfg.Polling.Daemon)
+	setDuration("POLL_INTERVAL", &cfg.Polling.Interval)
+	setDuration("POLL_JITTER", &cfg.Polling.Jitter)
+	setBool("POLL_ADAPTIVE", &cfg.Polling.Adaptive.Enabled)
+	setDuration("POLL_MIN_INTERVAL", &cfg.Polling.Adaptive.MinInterval)
+	setDuration("POLL_MAX_INTERVAL", &cfg.Polling.Adaptive.MaxInterval)
+	setBool("LEADER_ELECTION", &cfg.Polling.LeaderElection.Enabled)
+	setInt("LEADER_ELECTION_KEY", &cfg.Polling.LeaderElection.Key)
+	setString("SCHEDULE_TIMEZONE", &cfg.Schedule.Timezone)
+	setString("SCHEDULE_POLL", &cfg.Schedule.Poll)
+	setString("SCHEDULE_DAILY_REPORT", &cfg.Schedule.DailyReport)
+	setString("SCHEDULE_WEEKLY_REPORT", &cfg.Schedule.WeeklyReport)
+	setString("SCHEDULE_HOTSPOT_REPORT", &cfg.Schedule.HotspotReport)
+	setString("SCHEDULE_RETENTION", &cfg.Schedule.Retention)
+
+	setString("MQTT_BROKER_URL", &cfg.Events.MQTT.BrokerURL)
+	setString("MQTT_CLIENT_ID", &cfg.Events.MQTT.ClientID)
+	setString("MQTT_USERNAME", &cfg.Events.MQTT.Username)
+	setString("MQTT_PASSWORD", &cfg.Events.MQTT.Password)
+	setString("MQTT_TOPIC", &cfg.Events.MQTT.Topic)
+	setString("MQTT_EVENT_TOPIC", &cfg.Events.MQTT.EventTopic)
+	setInt("MQTT_QOS", &cfg.Events.MQTT.QoS)
+	setBool("MQTT_RETAIN", &cfg.Events.MQTT.Retain)
+	setString("KAFKA_REST_PROXY_URL", &cfg.Events.Kafka.RESTProxyURL)
+	setString("KAFKA_TOPIC", &cfg.Events.Kafka.Topic)
+	setString("KAFKA_FORMAT", &cfg.Events.Kafka.Format)
+	setString("KAFKA_USERNAME", &cfg.Events.Kafka.Username)
+	setString("KAFKA_PASSWORD", &cfg.Events.Kafka.Password)
+	setString("NATS_URL", &cfg.Events.NATS.URL)
+	setString("NATS_SUBJECT", &cfg.Events.NATS.Subject)
+	setString("NATS_USERNAME", &cfg.Events.NATS.Username)
+	setString("NATS_PASSWORD", &cfg.Events.NATS.Password)
+	setString("NATS_TOKEN", &cfg.Events.NATS.Token)
+	setBool("NATS_JETSTREAM", &cfg.Events.NATS.JetStream)
+	setString("NATS_STREAM", &cfg.Events.NATS.Stream)
+	setString("SNS_TOPIC_ARN", &cfg.Events.SNS.TopicARN)
+	setString("PUBSUB_PROJECT", &cfg.Events.PubSub.Project)
+	setString("PUBSUB_TOPIC", &cfg.Events.PubSub.Topic)
+	setString("PUBSUB_ENDPOINT", &cfg.Events.PubSub.Endpoint)
+	setString("AMQP_URL", &cfg.Events.AMQP.URL)
+	setString("AMQP_EXCHANGE", &cfg.Events.AMQP.Exchange)
+	setString("AMQP_ROUTING_KEY", &cfg.Events.AMQP.RoutingKey)
+
+	setString("REPORT_TIMEZONE", &cfg.Reports.Timezone)
+	setString("REPORT_DISCORD_WEBHOOK", &cfg.Reports.DiscordWebhook)
+	setList("REPORT_EMAIL_RECIPIENTS", &cfg.Reports.EmailRecipients)
+	setString("DAILY_REPORT_TIME", &cfg.Reports.Daily.Time)
+	setString("WEEKLY_REPORT_DAY", &cfg.Reports.Weekly.Day)
+	setString("WEEKLY_REPORT_TIME", &cfg.Reports.Weekly.Time)
+	setString("HOTSPOT_REPORT_DAY", &cfg.Reports.Hotspots.Day)
+	setString("HOTSPOT_REPORT_TIME", &cfg.Reports.Hotspots.Time)
+
+	setInt("RETENTION_DAYS", &cfg.Retention.Days)
+	setBool("RETENTION_ARCHIVE", &cfg.Retention.Archive)
+	setInt("RETENTION_SNAPSHOT_DAYS", &cfg.Retention.SnapshotDays)
+
+	setString("API_LISTEN", &cfg.API.Listen)
+	setBool("API_ENABLED", &cfg.API.Enabled)
+	setString("API_GRPC_LISTEN", &cfg.API.GRPC.Listen)
+	setString("API_GRPC_CERT_FILE", &cfg.API.GRPC.CertFile)
+	setString("API_GRPC_KEY_FILE", &cfg.API.GRPC.KeyFile)
+
+	setString("OTEL_EXPORTER_OTLP_ENDPOINT", &cfg.Tracing.Endpoint)
+	setString("OTEL_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	setList("OTEL_EXPORTER_OTLP_HEADERS", &cfg.Tracing.Headers)
+
+	setDuration("HTTP_TIMEOUT", &cfg.HTTP.Timeout)
+	setString("DISPLAY_TIMEZONE", &cfg.Display.Timezone)
+	setString("DISPLAY_TIME_FORMAT", &cfg.Display.TimeFormat)
+
+	setString("LOG_FORMAT", &cfg.Log.Format)
+	setString("LOG_LEVEL", &cfg.Log.Level)
+	setList("LOG_MODULES", &cfg.Log.Modules)
+
+	return errors.Join(errs...)
+}
+
+// validate reports every problem with the configuration at once, so a user can
+// fix them all in one pass rather than one failed start at a time. Commands that
+// only touch the database pass needFeed=false to skip the feed and notification checks.
+func (c Config) validate(needFeed bool) error {
+	var errs []error
+
+	switch c.Database.Driver {
+	case driverPostgres, driverMySQL:
+		if c.Database.Host == "" {
+			errs = append(errs, errors.New("database.host (or DATABASE_HOST) is required"))
+		}
+		if c.Database.Name == "" {
+			errs = append(errs, errors.New("database.name (or DATABASE_NAME) is required"))
+		}
+	case driverSQLite:
+		if c.Database.Path == "" {
+			errs = append(errs, errors.New("database.path (or DATABASE_PATH) is required for SQLite"))
+		}
+	case driverMemory:
+	default:
+		errs = append(errs, fmt.Errorf("database.driver must be %s, %s, %s or %s, got %q", driverPostgres, driverMySQL, driverSQLite, driverMemory, c.Database.Driver))
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database.max_open_conns and database.max_idle_conns cannot be negative"))
+	}
+	if c.Database.QueryTimeout < 0 {
+		errs = append(errs, errors.New("database.query_timeout cannot be negative"))
+	}
+	if c.HTTP.Timeout < 0 {
+		errs = append(errs, errors.New("http.timeout cannot be negative"))
+	}
+	if c.Database.TimescaleDB && c.Database.Driver != driverPostgres {
+		errs = append(errs, fmt.Errorf("database.timescaledb needs the %s driver, got %q", driverPostgres, c.Database.Driver))
+	}
+	if needFeed {
+		if c.Feed.URL == "" && len(c.Feed.Counties) == 0 && !c.Feed.Statewide {
+			errs = append(errs, errors.New("feed.url (or DOT_URL) is required, or set feed.counties (or COUNTIES) or feed.statewide (or STATEWIDE) to use the NCDOT feeds"))
+		}
+		for _, id := range c.Feed.Counties {
+			if id < 1 || id > ncCountyCount {
+				errs = append(errs, fmt.Errorf("feed.counties: county ID %d is out of range 1-%d", id, ncCountyCount))
+			}
+		}
+		for _, region := range c.Feed.Regions {
+			if _, ok := c.Feed.RegionDefinitions[region]; !ok {
+				errs = append(errs, fmt.Errorf("feed.regions: region %q is not defined in feed.region_definitions", region))
+			}
+		}
+		if len(c.Feed.Regions) > 0 && !c.Feed.Statewide {
+			errs = append(errs, errors.New("feed.regions only applies in statewide mode; set feed.statewide (or STATEWIDE)"))
+		}
+		if c.Feed.StateFile == "" {
+			errs = append(errs, errors.New("feed.state_file cannot be empty"))
+		}
+		if c.Feed.Retries < 0 {
+			errs = append(errs, errors.New("feed.retries cannot be negative"))
+		}
+		if c.Feed.Retries > 0 && c.Feed.RetryBackoff <= 0 {
+			errs = append(errs, errors.New("feed.retry_backoff must be positive when feed.retries is set"))
+		}
+		if c.Feed.Workers < 1 {
+			errs = append(errs, errors.New("feed.workers must be at least 1"))
+		}
+		n := c.Notifications
+		if !n.hasTargets() {
+			errs = append(errs, errors.New("notifications.discord_webhook (or DISCORD_HOOK) is required, unless another notification target is configured"))
+		}
+		for i, route := range n.Routes {
+			switch {
+			case route.isType(routeTypeDiscord) && route.Webhook == "":
+				errs = append(errs, fmt.Errorf("notifications.routes[%d] (%s) has no webhook", i, route.Name))
+			case route.isType(routeTypeSlack) && route.Webhook == "" && (route.Channel == "" || n.Slack.BotToken == ""):
+				errs = append(errs, fmt.Errorf("notifications.routes[%d] (%s) needs a Slack webhook, or a channel and notifications.slack.bot_token", i, route.Name))
+			case !route.isType(routeTypeDiscord) && !route.isType(routeTypeSlack):
+				errs = append(errs, fmt.Errorf("notifications.routes[%d] (%s) has unknown type %q", i, route.Name, route.Type))
+			}
+		}
+		if n.Email.enabled() && n.Email.From == "" {
+			errs = append(errs, errors.New("notifications.email.from (or EMAIL_FROM) is required when email recipients are set"))
+		}
+		if len(n.SMS.To) > 0 {
+			switch n.SMS.Provider {
+			case smsProviderTwilio:
+				if n.SMS.AccountSID == "" || n.SMS.AuthToken == "" || n.SMS.From == "" {
+					errs = append(errs, errors.New("notifications.sms needs account_sid, auth_token and from for Twilio"))
+				}
+			case smsProviderGateway:
+				if n.SMS.GatewayURL == "" {
+					errs = append(errs, errors.New("notifications.sms.gateway_url (or SMS_GATEWAY_URL) is required for the gateway provider"))
+				}
+			default:
+				errs = append(errs, fmt.Errorf("notifications.sms.provider must be %q or %q, got %q", smsProviderTwilio, smsProviderGateway, n.SMS.Provider))
+			}
+		}
+		for severity, priority := range n.Pushover.Priorities {
+			if priority < -2 || priority > 1 {
+				errs = append(errs, fmt.Errorf("notifications.pushover.priorities[%d] must be between -2 and 1, got %d", severity, priority))
+			}
+		}
+		switch n.Mastodon.Visibility {
+		case "public", "unlisted", "private", "direct":
+		default:
+			errs = append(errs, fmt.Errorf("notifications.mastodon.visibility must be public, unlisted, private or direct, got %q", n.Mastodon.Visibility))
+		}
+		for i, w := range n.Webhooks {
+			if w.URL == "" {
+				errs = append(errs, fmt.Errorf("notifications.webhooks[%d] has no url", i))
+			}
+		}
+		for i, rule := range n.Rules {
+			if len(rule.Targets) == 0 {
+				errs = append(errs, fmt.Errorf("notifications.rules[%d] has no targets", i))
+			}
+			if rule.MaxSeverity > 0 && rule.MaxSeverity < rule.MinSeverity {
+				errs = append(errs, fmt.Errorf("notifications.rules[%d].max_severity is below min_severity", i))
+			}
+			for _, target := range rule.Targets {
+				if !slices.ContainsFunc(notifierRegistry, func(r notifierRegistration) bool { return r.name == target }) &&
+					!slices.ContainsFunc(n.Routes, func(r Route) bool { return r.Name == target }) {
+					errs = append(errs, fmt.Errorf("notifications.rules[%d] targets %q, which is neither a notifier nor a route name", i, target))
+				}
+			}
+		}
+		if n.Slack.BotToken != "" && n.Slack.WebhookURL == "" && n.Slack.Channel == "" && !slices.ContainsFunc(n.Routes, func(r Route) bool { return r.isType(routeTypeSlack) }) {
+			errs = append(errs, errors.New("notifications.slack.bot_token is set but no notifications.slack.channel or Slack route uses it"))
+		}
+		if c.Notifications.Threads && c.Notifications.DiscordBotToken == "" {
+			errs = append(errs, errors.New("notifications.threads needs notifications.discord_bot_token (or DISCORD_BOT_TOKEN)"))
+		}
+		if n.BatchThreshold < 0 {
+			errs = append(errs, errors.New("notifications.batch_threshold cannot be negative"))
+		}
+		if r := n.Retry; r.enabled() && (r.Backoff <= 0 || r.MaxBackoff < r.Backoff) {
+			errs = append(errs, errors.New("notifications.retry needs a positive backoff no longer than max_backoff"))
+		}
+		if b := n.CircuitBreaker; b.Failures < 0 || (b.enabled() && b.Cooldown <= 0) {
+			errs = append(errs, errors.New("notifications.circuit_breaker needs non-negative failures and a positive cooldown"))
+		}
+	}
+	if g := c.Filters.Geofence; g.RadiusMiles < 0 {
+		errs = append(errs, errors.New("filters.geofence.radius_miles cannot be negative"))
+	} else if g.RadiusMiles > 0 && (g.Latitude < -90 || g.Latitude > 90 || g.Longitude < -180 || g.Longitude > 180 || (g.Latitude == 0 && g.Longitude == 0)) {
+		errs = append(errs, errors.New("filters.geofence needs a valid latitude and longitude when radius_miles is set"))
+	}
+	for _, corridor := range c.Filters.Corridors {
+		if err := corridor.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Filters.MinSeverity < 0 {
+		errs = append(errs, errors.New("filters.min_severity cannot be negative"))
+	}
+	if c.Filters.MaxAge < 0 {
+		errs = append(errs, errors.New("filters.max_age cannot be negative"))
+	}
+	for i, p := range c.Notifications.Places {
+		if p.Name == "" || !p.point().Valid() {
+			errs = append(errs, fmt.Errorf("notifications.places[%d] needs a name and a valid latitude and longitude", i))
+		}
+	}
+	if c.Filters.MaxDistanceMiles < 0 {
+		errs = append(errs, errors.New("filters.max_distance_miles cannot be negative"))
+	} else if c.Filters.MaxDistanceMiles > 0 && len(c.Notifications.Places) == 0 {
+		errs = append(errs, errors.New("filters.max_distance_miles needs notifications.places"))
+	}
+	if c.Polling.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("polling.interval must be positive, got %s", c.Polling.Interval))
+	}
+	if err := c.Notifications.StaticMap.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if g := c.Notifications.Geocoding; g.Enabled && (g.URL == "" || g.UserAgent == "") {
+		errs = append(errs, errors.New("notifications.geocoding needs a url and a user_agent"))
+	}
+	if d := c.Notifications.Dedup; d.Enabled && (d.MaxDistanceMiles <= 0 || d.MaxTimeApart < 0) {
+		errs = append(errs, errors.New("notifications.dedup needs a positive max_distance_miles and a non-negative max_time_apart"))
+	}
+	if c.Notifications.Exits.MaxDistanceMiles < 0 {
+		errs = append(errs, errors.New("notifications.exits.max_distance_miles cannot be negative"))
+	}
+	if w := c.Notifications.Weather; w.Enabled && w.URL == "" {
+		errs = append(errs, errors.New("notifications.weather needs a url"))
+	}
+	if c.Display.TimeFormat == "" {
+		errs = append(errs, errors.New("display.time_format cannot be empty"))
+	}
+	if c.Polling.Jitter < 0 {
+		errs = append(errs, errors.New("polling.jitter cannot be negative"))
+	}
+	if a := c.Polling.Adaptive; a.Enabled {
+		if a.MinInterval <= 0 || a.MaxInterval < a.MinInterval {
+			errs = append(errs, fmt.Errorf("polling.adaptive needs a positive min_interval no greater than max_interval, got %s and %s", a.MinInterval, a.MaxInterval))
+		}
+		if c.Schedule.Poll != "" {
+			errs = append(errs, errors.New("polling.adaptive can't be combined with schedule.poll"))
+		}
+	}
+	if c.Polling.LeaderElection.Enabled && c.Database.Driver != driverPostgres {
+		errs = append(errs, fmt.Errorf("polling.leader_election needs the %s driver, got %q", driverPostgres, c.Database.Driver))
+	}
+	if q := c.Events.MQTT.QoS; q != 0 && q != 1 {
+		errs = append(errs, fmt.Errorf("events.mqtt.qos must be 0 or 1, got %d", q))
+	}
+	if len(c.Reports.EmailRecipients) > 0 && (c.Notifications.Email.Host == "" || c.Notifications.Email.From == "") {
+		errs = append(errs, errors.New("reports.email_recipients needs notifications.email.host and notifications.email.from for sending"))
+	}
+	if c.Reports.Daily.enabled() && !c.Reports.hasTargets() {
+		errs = append(errs, errors.New("reports.daily needs reports.discord_webhook or reports.email_recipients"))
+	}
+	if c.Reports.Weekly.enabled() && !c.Reports.hasTargets() {
+		errs = append(errs, errors.New("reports.weekly needs reports.discord_webhook or reports.email_recipients"))
+	}
+	if c.Reports.Hotspots.enabled() && !c.Reports.hasTargets() {
+		errs = append(errs, errors.New("reports.hotspots needs reports.discord_webhook or reports.email_recipients"))
+	}
+	if (c.Schedule.DailyReport != "" || c.Schedule.WeeklyReport != "" || c.Schedule.HotspotReport != "") && !c.Reports.hasTargets() {
+		errs = append(errs, errors.New("schedule.daily_report, schedule.weekly_report and schedule.hotspot_report need reports.discord_webhook or reports.email_recipients"))
+	}
+	if c.Schedule.Retention != "" && !c.Retention.enabled() {
+		errs = append(errs, errors.New("schedule.retention needs retention.days or retention.snapshot_days"))
+	}
+	if c.Reports.Weekly.Top < 0 {
+		errs = append(errs, errors.New("reports.weekly.top cannot be negative"))
+	}
+	if err := c.Reports.Hotspots.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Retention.Days < 0 || c.Retention.SnapshotDays < 0 {
+		errs = append(errs, errors.New("retention.days and retention.snapshot_days cannot be negative"))
+	}
+	if c.API.GRPC.enabled() && (c.API.GRPC.CertFile == "" || c.API.GRPC.KeyFile == "") {
+		errs = append(errs, errors.New("api.grpc.listen needs api.grpc.cert_file and api.grpc.key_file, as gRPC is served over TLS"))
+	}
+	if c.Events.SNS.enabled() && c.Events.SNS.region() == "" {
+		errs = append(errs, fmt.Errorf("events.sns.topic_arn %q is not a valid SNS topic ARN", c.Events.SNS.TopicARN))
+	}
+	errs = append(errs, c.Log.validate()...)
+	if f := c.Events.Kafka.Format; f != kafkaFormatJSON && f != kafkaFormatAvro {
+		errs = append(errs, fmt.Errorf("events.kafka.format must be %q or %q, got %q", kafkaFormatJSON, kafkaFormatAvro, f))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// dsn builds the connection string for the driver.
+func (d DatabaseConfig) dsn() string {
+	switch d.Driver {
+	case driverSQLite:
+		// Wait for a busy database rather than failing, and let readers such as
+		// the report command run alongside the daemon's writes.
+		return "file:" + d.Path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	case driverMySQL:
+		port := cmp.Or(d.Port, "3306")
+		params := url.Values{
+			"parseTime": {"true"},
+			"time_zone": {"'+00:00'"},
+			"sql_mode":  {"CONCAT(@@sql_mode, ',ANSI_QUOTES')"},
+		}
+		if tls, ok := mysqlTLS[d.SSLMode]; ok {
+			params.Set("tls", tls)
+		}
+		return fmt.Sprintf("%s:%s@tcp(%s)/%s?%s", d.User, d.Password, net.JoinHostPort(d.Host, port), d.Name, params.Encode())
+	}
+	port := cmp.Or(d.Port, "5432")
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		d.Host, port, d.User, d.Password, d.Name, d.SSLMode)
+}
+
+// mysqlTLS maps the Postgres sslmode names to the MySQL driver's tls values.
+var mysqlTLS = map[string]string{
+	"disable":     "false",
+	"allow":       "preferred",
+	"prefer":      "preferred",
+	"require":     "skip-verify",
+	"verify-ca":   "true",
+	"verify-full": "true",
+}
+
+// splitList splits a comma-separated environment value, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEmailRecipientOverrides(t *testing.T) {
+	configured := []EmailRecipient{
+		{Address: "commuter@example.com"},
+		{Address: "manager@example.com", Digest: true},
+	}
+	tests := []struct {
+		name            string
+		alerts, digests string
+		want            []EmailRecipient
+	}{
+		{"unset", "", "", configured},
+		{"alerts", "a@example.com, b@example.com", "", []EmailRecipient{
+			{Address: "manager@example.com", Digest: true},
+			{Address: "a@example.com"},
+			{Address: "b@example.com"},
+		}},
+		{"digests", "", "d@example.com", []EmailRecipient{
+			{Address: "commuter@example.com"},
+			{Address: "d@example.com", Digest: true},
+		}},
+		{"both", "a@example.com", "d@example.com", []EmailRecipient{
+			{Address: "a@example.com"},
+			{Address: "d@example.com", Digest: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EMAIL_RECIPIENTS", tt.alerts)
+			t.Setenv("EMAIL_DIGEST_RECIPIENTS", tt.digests)
+			var cfg Config
+			cfg.Notifications.Email.Recipients = append([]EmailRecipient(nil), configured...)
+			if err := applyEnvOverrides(&cfg); err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.Notifications.Email.Recipients; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recipients = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
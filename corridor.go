@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mtickle/crash-reporting/geo"
+)
+
+// CorridorConfig is a commute or other route to be alerted about. Roads lists
+// the roads along it, in order, matched like filters.roads; File is a GPX
+// track or route, or GeoJSON LineStrings, tracing it. With a file, incidents
+// must be within BufferMiles of the line; with both, they must also be on one
+// of the roads, which keeps out cross streets passing over or under it.
+type CorridorConfig struct {
+	Name        string   `yaml:"name"`
+	Roads       []string `yaml:"roads"`
+	File        string   `yaml:"file"`
+	BufferMiles float64  `yaml:"buffer_miles"`
+
+	// corridor is loaded from File by load.
+	corridor geo.Corridor
+}
+
+// load reads the corridor's line, if it has a file. Files ending in .gpx are
+// GPX; anything else is GeoJSON.
+func (c *CorridorConfig) load() error {
+	if c.File == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return fmt.Errorf("could not read corridor %s: %w", c.Name, err)
+	}
+	var paths [][]geo.Point
+	if strings.EqualFold(filepath.Ext(c.File), ".gpx") {
+		paths, err = geo.ParseGPX(data)
+	} else {
+		paths, err = parseGeoJSONLines(data)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse corridor %s in %s: %w", c.Name, c.File, err)
+	}
+	c.corridor = geo.Corridor{Paths: paths, BufferMiles: c.BufferMiles}
+	return nil
+}
+
+// matches reports whether the incident is along the corridor.
+func (c CorridorConfig) matches(incident Incident) bool {
+	if len(c.Roads) > 0 && !(RoadFilter{Allow: c.Roads}).matches(incident) {
+		return false
+	}
+	if c.File == "" {
+		return true
+	}
+	at := geo.Point{Lat: incident.Latitude, Lon: incident.Longitude}
+	return at.Valid() && c.corridor.Contains(at)
+}
+
+// validate checks that the corridor is defined.
+func (c CorridorConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("filters.corridors entries need a name")
+	}
+	if len(c.Roads) == 0 && c.File == "" {
+		return fmt.Errorf("filters.corridors %s needs roads or a file", c.Name)
+	}
+	if c.File != "" && c.BufferMiles <= 0 {
+		return fmt.Errorf("filters.corridors %s needs a positive buffer_miles with a file", c.Name)
+	}
+	return nil
+}
+
+// alongCorridor reports whether the incident is along any corridor. With no
+// corridors configured every incident passes.
+func (f FilterConfig) alongCorridor(incident Incident) bool {
+	if len(f.Corridors) == 0 {
+		return true
+	}
+	for _, c := range f.Corridors {
+		if c.matches(incident) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGeoJSONLines extracts every LineString and MultiLineString from a
+// GeoJSON document as paths.
+func parseGeoJSONLines(data []byte) ([][]geo.Point, error) {
+	var paths [][]geo.Point
+	toPath := func(line [][]float64) []geo.Point {
+		path := make([]geo.Point, 0, len(line))
+		for _, pos := range line {
+			if len(pos) >= 2 {
+				path = append(path, geo.Point{Lat: pos[1], Lon: pos[0]})
+			}
+		}
+		return path
+	}
+	err := walkGeoJSON(data, func(o geoJSONObject) error {
+		switch o.Type {
+		case "LineString":
+			var line [][]float64
+			if err := json.Unmarshal(o.Coordinates, &line); err != nil {
+				return fmt.Errorf("invalid LineString coordinates: %w", err)
+			}
+			paths = append(paths, toPath(line))
+		case "MultiLineString":
+			var multi [][][]float64
+			if err := json.Unmarshal(o.Coordinates, &multi); err != nil {
+				return fmt.Errorf("invalid MultiLineString coordinates: %w", err)
+			}
+			for _, line := range multi {
+				paths = append(paths, toPath(line))
+			}
+		}
+		return nil
+	})
+	if err == nil && len(paths) == 0 {
+		err = errors.New("no LineString or MultiLineString geometry")
+	}
+	return paths, err
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression: five fields for the minute, hour,
+// day of the month, month and day of the week, each a "*", a number, a range
+// like "1-5" or a list like "0,30", optionally stepped like "*/2" or "8-18/2".
+// Months and days of the week also take names ("jan", "mon"), and Sunday is 0
+// or 7. As in cron, when both day fields are restricted either one matching is
+// enough. The descriptors @hourly, @daily (@midnight), @weekly, @monthly and
+// @yearly (@annually) stand for their usual expressions.
+//
+// Times are wall-clock times in the schedule's location. As in cron, a schedule
+// whose hour field isn't "*" runs once across daylight saving changes: a time
+// skipped when the clocks spring forward runs as soon as they have, and a time
+// repeated when they fall back runs only the first time. A schedule with "*" in
+// its hour field follows the clock, so "*/5 * * * *" keeps running every five
+// minutes through the repeated hour.
+type cronSchedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+	loc                           *time.Location
+}
+
+// cronDescriptors are the expressions the @ shorthands stand for.
+var cronDescriptors = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var (
+	cronMonths   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// parseCron parses a cron expression evaluated in loc.
+func parseCron(expr string, loc *time.Location) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		if d, ok := cronDescriptors[strings.ToLower(fields[0])]; ok {
+			fields = strings.Fields(d)
+		}
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q is not a cron expression: it needs five fields", expr)
+	}
+	s := &cronSchedule{expr: expr, loc: loc}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil, 0); err != nil {
+		return nil, fmt.Errorf("%q: minute: %w", expr, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil, 0); err != nil {
+		return nil, fmt.Errorf("%q: hour: %w", expr, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil, 0); err != nil {
+		return nil, fmt.Errorf("%q: day of month: %w", expr, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonths, 1); err != nil {
+		return nil, fmt.Errorf("%q: month: %w", expr, err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronWeekdays, 0); err != nil {
+		return nil, fmt.Errorf("%q: day of week: %w", expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too.
+	}
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("%q never matches", expr)
+	}
+	return s, nil
+}
+
+// parseCronField parses one field into a bitset of the values it matches.
+// names, if given, name the values from base up.
+func parseCronField(field string, lo, hi int, names []string, base int) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return base + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("%q is not a value from %d to %d", s, lo, hi)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("%q is not a valid step", stepStr)
+			}
+		}
+		first, last := lo, hi
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if first, err = value(from); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = value(to); err != nil {
+					return 0, err
+				}
+			} else if stepped {
+				// "5/15" steps from 5 to the end of the range.
+				last = hi
+			}
+			if last < first {
+				return 0, fmt.Errorf("%q is an empty range", rng)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// String returns the expression the schedule was parsed from.
+func (s *cronSchedule) String() string {
+	return s.expr
+}
+
+// next returns the first time after t that the schedule matches, or the zero
+// time if it matches none in the next five years.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		var step time.Time
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			step = cronDate(t.Year(), t.Month()+1, 1, 0, s.loc)
+		case !s.dayMatches(t):
+			step = cronDate(t.Year(), t.Month(), t.Day()+1, 0, s.loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			step = cronDate(t.Year(), t.Month(), t.Day(), t.Hour()+1, s.loc)
+		case s.minute&(1<<uint(t.Minute())) == 0 || (!s.hourly() && repeatedWallTime(t)):
+			step = t.Add(time.Minute)
+		default:
+			return t
+		}
+		if !s.hourly() && s.matchesSkipped(t, step) {
+			return step
+		}
+		t = step
+	}
+	return time.Time{}
+}
+
+// cronDate is time.Date for the start of an hour, except that an hour the clocks
+// skipped starts at the end of the gap. time.Date gives no guarantee which side
+// of the gap it picks, and picking the earlier one would take next backwards.
+func cronDate(year int, month time.Month, day, hour int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, 0, 0, 0, loc)
+	want := time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	switch {
+	case got.Before(want):
+		_, end := t.ZoneBounds()
+		return end
+	case got.After(want):
+		start, _ := t.ZoneBounds()
+		return start
+	}
+	return t
+}
+
+// hourly reports whether the hour field is "*", or matches every hour anyway.
+func (s *cronSchedule) hourly() bool {
+	return s.hour == 1<<24-1
+}
+
+// matchesSkipped reports whether the clocks sprang forward between from and to
+// over a wall-clock time the schedule matches, taking the gap to end at to: a
+// step in next either stops at the first minute after a gap or passes one only
+// where a field it skips doesn't match.
+func (s *cronSchedule) matchesSkipped(from, to time.Time) bool {
+	_, before := from.Zone()
+	_, after := to.Zone()
+	if after <= before {
+		return false
+	}
+	end := time.Date(to.Year(), to.Month(), to.Day(), to.Hour(), to.Minute(), 0, 0, time.UTC)
+	for wall := end.Add(-time.Duration(after-before) * time.Second); wall.Before(end); wall = wall.Add(time.Minute) {
+		if s.month&(1<<uint(wall.Month())) != 0 && s.dayMatches(wall) &&
+			s.hour&(1<<uint(wall.Hour())) != 0 && s.minute&(1<<uint(wall.Minute())) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// repeatedWallTime reports whether t's wall-clock time already happened once,
+// before the clocks fell back.
+func repeatedWallTime(t time.Time) bool {
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		return false
+	}
+	_, before := start.Add(-time.Nanosecond).Zone()
+	_, after := t.Zone()
+	return before > after && t.Sub(start) < time.Duration(before-after)*time.Second
+}
+
+// dayMatches reports whether t's day matches the day fields.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"*/5 * * * *", false},
+		{"0 8-18/2 * jan-mar mon-fri", false},
+		{"@Daily", false},
+		{"0 0 * * 7", false},
+		{"* * * *", true},
+		{"60 * * * *", true},
+		{"0 24 * * *", true},
+		{"0 0 0 * *", true},
+		{"0 0 * 13 *", true},
+		{"*/0 * * * *", true},
+		{"5-1 * * * *", true},
+		{"0 0 * * fri-mon", true},
+		{"@reboot", true},
+		{"0 0 30 feb *", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseCron(tt.expr, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCron(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	at := func(loc *time.Location, value string) time.Time {
+		t.Helper()
+		tm, err := time.ParseInLocation("2006-01-02 15:04 MST", value, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		name  string
+		expr  string
+		loc   *time.Location
+		after string
+		want  []string
+	}{
+		{"every five minutes", "*/5 * * * *", time.UTC, "2024-05-01 12:03 UTC",
+			[]string{"2024-05-01 12:05 UTC", "2024-05-01 12:10 UTC"}},
+		{"stepped range", "0 8-18/4 * * *", time.UTC, "2024-05-01 12:00 UTC",
+			[]string{"2024-05-01 16:00 UTC", "2024-05-02 08:00 UTC", "2024-05-02 12:00 UTC"}},
+		// "5/15" steps from 5 to the end of the range.
+		{"stepped start", "5/15 * * * *", time.UTC, "2024-05-01 12:00 UTC",
+			[]string{"2024-05-01 12:05 UTC", "2024-05-01 12:20 UTC", "2024-05-01 12:35 UTC", "2024-05-01 12:50 UTC", "2024-05-01 13:05 UTC"}},
+		{"list", "0,30 9 * * *", time.UTC, "2024-05-01 09:00 UTC",
+			[]string{"2024-05-01 09:30 UTC", "2024-05-02 09:00 UTC"}},
+		// 2024-05-01 is a Wednesday.
+		{"weekday names", "0 7 * * mon-fri", time.UTC, "2024-05-03 08:00 UTC",
+			[]string{"2024-05-06 07:00 UTC"}},
+		{"month names", "0 0 1 JUN,dec *", time.UTC, "2024-05-01 00:00 UTC",
+			[]string{"2024-06-01 00:00 UTC", "2024-12-01 00:00 UTC", "2025-06-01 00:00 UTC"}},
+		{"sunday as 7", "0 12 * * 7", time.UTC, "2024-05-01 00:00 UTC",
+			[]string{"2024-05-05 12:00 UTC", "2024-05-12 12:00 UTC"}},
+		{"weekly", "@weekly", time.UTC, "2024-05-01 00:00 UTC",
+			[]string{"2024-05-05 00:00 UTC"}},
+		{"monthly", "@monthly", time.UTC, "2024-05-01 00:00 UTC",
+			[]string{"2024-06-01 00:00 UTC"}},
+		{"yearly", "@annually", time.UTC, "2024-05-01 00:00 UTC",
+			[]string{"2025-01-01 00:00 UTC"}},
+		// With both day fields restricted, either matching is enough: the 10th,
+		// or any Monday.
+		{"either day field", "0 0 10 * mon", time.UTC, "2024-05-07 00:00 UTC",
+			[]string{"2024-05-10 00:00 UTC", "2024-05-13 00:00 UTC", "2024-05-20 00:00 UTC"}},
+		// With one restricted, it alone decides.
+		{"day of month only", "0 0 10 * *", time.UTC, "2024-05-07 00:00 UTC",
+			[]string{"2024-05-10 00:00 UTC", "2024-06-10 00:00 UTC"}},
+		{"leap day", "0 0 29 2 *", time.UTC, "2024-03-01 00:00 UTC",
+			[]string{"2028-02-29 00:00 UTC"}},
+		{"time zone", "0 9 * * *", newYork, "2024-05-01 10:00 EDT",
+			[]string{"2024-05-02 09:00 EDT"}},
+
+		// On 2024-03-10 New York's clocks jumped from 02:00 EST to 03:00 EDT.
+		{"skipped time runs after the gap", "30 2 * * *", newYork, "2024-03-09 03:00 EST",
+			[]string{"2024-03-10 03:00 EDT", "2024-03-11 02:30 EDT"}},
+		{"skipped hour runs once", "*/15 2 * * *", newYork, "2024-03-10 01:00 EST",
+			[]string{"2024-03-10 03:00 EDT", "2024-03-11 02:00 EDT"}},
+		{"skipped after earlier match", "0 1,2 * * *", newYork, "2024-03-10 00:00 EST",
+			[]string{"2024-03-10 01:00 EST", "2024-03-10 03:00 EDT", "2024-03-11 01:00 EDT"}},
+		{"hourly follows the clock forward", "30 * * * *", newYork, "2024-03-10 01:00 EST",
+			[]string{"2024-03-10 01:30 EST", "2024-03-10 03:30 EDT"}},
+		// On 2024-11-03 they went back from 02:00 EDT to 01:00 EST.
+		{"repeated time runs once", "30 1 * * *", newYork, "2024-11-03 00:00 EDT",
+			[]string{"2024-11-03 01:30 EDT", "2024-11-04 01:30 EST"}},
+		{"hourly follows the clock back", "30 * * * *", newYork, "2024-11-03 00:00 EDT",
+			[]string{"2024-11-03 00:30 EDT", "2024-11-03 01:30 EDT", "2024-11-03 01:30 EST", "2024-11-03 02:30 EST"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr, tt.loc)
+			if err != nil {
+				t.Fatalf("parseCron(%q) error = %v", tt.expr, err)
+			}
+			after := at(tt.loc, tt.after)
+			for _, want := range tt.want {
+				got := s.next(after)
+				if w := at(tt.loc, want); !got.Equal(w) {
+					t.Fatalf("next(%s) = %s, want %s", after.Format(time.DateTime+" MST"), got.Format(time.DateTime+" MST"), want)
+				}
+				after = got
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// runDaemon runs processing cycles every interval (see pollPacer), or on
+// schedule.poll, until ctx is cancelled. A failed cycle is logged and retried on
+// the next poll instead of stopping the daemon, and cancellation is only acted on
+// between tasks so a run is never cut off mid-write. With leader election, only the leader runs cycles and
+// the other tasks; the others stand by.
+//
+// Reports and retention scheduled with cron expressions run as tasks of their
+// own; otherwise reports go out at the end of a cycle, as in one-off runs, and
+// retention runs after the first cycle and then daily.
+//
+// Under systemd (see sdnotify.go) the daemon reports ready after its first
+// cycle that fetched the feed, or its first standby check, with the database
+// answering, and pings the watchdog while it waits between tasks.
+func runDaemon(ctx context.Context, store Store, cfg Config, elector *leaderElector) {
+	sched := cfg.Schedule
+	if sched.poll != nil {
+		slog.Info("Starting daemon mode", "schedule", sched.poll)
+	} else {
+		slog.Info("Starting daemon mode", "interval", cfg.Polling.Interval, "adaptive", cfg.Polling.Adaptive.Enabled)
+	}
+	defer elector.resign()
+	if sched.dailyReport != nil {
+		cfg.Reports.Daily = DailyReportConfig{}
+	}
+	if sched.weeklyReport != nil {
+		cfg.Reports.Weekly.Time = ""
+	}
+	if sched.hotspotReport != nil {
+		cfg.Reports.Hotspots.Time = ""
+	}
+	// Tasks run on a background context so a shutdown signal lets the
+	// current one finish cleanly before the loop exits.
+	bg := context.Background()
+
+	var lastRetention time.Time
+	ready := false
+	pacer := newPollPacer(cfg.Polling)
+	cfg.Polling.pacer = pacer
+	poll := &scheduledTask{name: "poll", next: pacer.next, due: time.Now()}
+	if sched.poll != nil {
+		poll.next = func(t time.Time) time.Time { return sched.poll.next(t).Add(pacer.delay()) }
+	}
+	poll.run = func() {
+		start := time.Now()
+		var err error
+		if !elector.lead(bg) {
+			cfg.Polling.health.standingBy()
+		} else if err = runCycle(bg, store, cfg); err != nil {
+			slog.Error("Error during cycle", "duration", time.Since(start), "err", err)
+		} else {
+			slog.Info("Cycle complete", "duration", time.Since(start))
+		}
+		if !ready && err == nil && store.Ping(ctx) == nil {
+			sdNotify("READY=1")
+			ready = true
+		}
+
+		// Retention runs after the first cycle and then daily; a failure is
+		// retried after the next cycle.
+		if sched.retention == nil && cfg.Retention.enabled() && !cfg.DryRun && elector.leading() && time.Since(lastRetention) >= retentionInterval {
+			if err := applyRetention(bg, store, cfg.Retention); err != nil {
+				slog.Error("Error applying retention policy", "err", err)
+			} else {
+				lastRetention = time.Now()
+			}
+		}
+	}
+	tasks := []*scheduledTask{poll}
+
+	// A dry run sends no reports and applies no retention.
+	if !cfg.DryRun {
+		if s := sched.dailyReport; s != nil {
+			tasks = append(tasks, &scheduledTask{name: "daily_report", next: s.next, run: func() {
+				if !elector.leading() {
+					return
+				}
+				if err := sendDailyReport(bg, store, cfg, cfg.Reports.reportDay(time.Now())); err != nil {
+					slog.Error("Error sending daily report", "err", err)
+				}
+			}})
+		}
+		if s := sched.weeklyReport; s != nil {
+			tasks = append(tasks, &scheduledTask{name: "weekly_report", next: s.next, run: func() {
+				if !elector.leading() {
+					return
+				}
+				if err := sendWeeklyReport(bg, store, cfg, cfg.Reports.reportDay(time.Now())); err != nil {
+					slog.Error("Error sending weekly report", "err", err)
+				}
+			}})
+		}
+		if s := sched.hotspotReport; s != nil {
+			tasks = append(tasks, &scheduledTask{name: "hotspot_report", next: s.next, run: func() {
+				if !elector.leading() {
+					return
+				}
+				if err := sendHotspotReport(bg, store, cfg, cfg.Reports.reportDay(time.Now())); err != nil {
+					slog.Error("Error sending hotspot report", "err", err)
+				}
+			}})
+		}
+		if s := sched.retention; s != nil && cfg.Retention.enabled() {
+			tasks = append(tasks, &scheduledTask{name: "retention", next: s.next, run: func() {
+				if !elector.leading() {
+					return
+				}
+				if err := applyRetention(bg, store, cfg.Retention); err != nil {
+					slog.Error("Error applying retention policy", "err", err)
+				}
+			}})
+		}
+	}
+	for _, t := range tasks[1:] {
+		t.due = t.next(time.Now())
+		slog.Info("Scheduled task", "task", t.name, "next", t.due)
+	}
+
+	runScheduler(ctx, tasks)
+	slog.Info("Shutdown requested. Exiting daemon mode.")
+	sdNotify("STOPPING=1")
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles is the web dashboard: a Leaflet map and table of the active
+// incidents, kept current from the /events stream when the daemon serves it.
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardHandler serves the dashboard's files under /dashboard/.
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err) // The directory is embedded above, so this can't happen.
+	}
+	return http.StripPrefix("/dashboard", http.FileServerFS(files))
+}
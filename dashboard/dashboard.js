@@ -0,0 +1,159 @@
+// The dashboard loads the active incidents from /api/incidents.geojson, then
+// follows the daemon's /events stream to keep them current. When the stream
+// isn't served (ncdot serve) it polls instead. Filtering happens here, so
+// changing a filter never waits on the server.
+"use strict";
+
+const REFRESH_MS = 5 * 60 * 1000; // Full reload, in case an event was missed.
+const POLL_MS = 60 * 1000;        // Reload interval without the event stream.
+
+// Severity colors, matching the Discord alerts.
+const COLORS = { 1: "#2ecc71", 2: "#ffff00", 3: "#e74c3c" };
+const GREY = "#95a5a6";
+
+const incidents = new Map(); // Active incidents by ID.
+const map = L.map("map").setView([35.5, -79.4], 7);
+L.tileLayer("https://tile.openstreetmap.org/{z}/{x}/{y}.png", {
+  maxZoom: 19,
+  attribution: '&copy; <a href="https://www.openstreetmap.org/copyright">OpenStreetMap</a> contributors',
+}).addTo(map);
+const markers = L.layerGroup().addTo(map);
+
+const $ = (id) => document.getElementById(id);
+
+function setStatus(text) {
+  $("status").textContent = text;
+}
+
+function escapeHTML(s) {
+  return String(s ?? "").replace(/[&<>"']/g, (c) => "&#" + c.charCodeAt(0) + ";");
+}
+
+function filters() {
+  return {
+    county: parseInt($("county").value, 10) || 0,
+    type: $("type").value,
+    road: $("road").value.trim().toLowerCase(),
+    severity: parseInt($("severity").value, 10) || 0,
+  };
+}
+
+function matches(i, f) {
+  return (!f.county || i.countyId === f.county) &&
+    (!f.type || i.incidentType === f.type) &&
+    (!f.road || (i.road || "").toLowerCase().includes(f.road)) &&
+    i.severity >= f.severity;
+}
+
+function lanes(i) {
+  return i.lanesTotal > 0 ? `${i.lanesClosed} of ${i.lanesTotal} closed` : "";
+}
+
+function started(i) {
+  const t = new Date(i.start);
+  return isNaN(t) ? "" : t.toLocaleString([], { month: "short", day: "numeric", hour: "numeric", minute: "2-digit" });
+}
+
+function popup(i) {
+  return `<b>${escapeHTML(i.incidentType)}</b> (severity ${i.severity})<br>` +
+    `${escapeHTML(i.road)} at ${escapeHTML(i.location)}, ${escapeHTML(i.city)}<br>` +
+    (lanes(i) ? `Lanes: ${lanes(i)}<br>` : "") +
+    (i.reason ? `${escapeHTML(i.reason)}<br>` : "") +
+    `Started ${escapeHTML(started(i))}`;
+}
+
+// refreshTypes lists the incident types seen in the type filter.
+function refreshTypes() {
+  const select = $("type");
+  const current = select.value;
+  const types = [...new Set([...incidents.values()].map((i) => i.incidentType))].sort();
+  select.replaceChildren(new Option("any", ""), ...types.map((t) => new Option(t, t)));
+  select.value = types.includes(current) ? current : "";
+}
+
+function render() {
+  const f = filters();
+  const shown = [...incidents.values()]
+    .filter((i) => matches(i, f))
+    .sort((a, b) => b.severity - a.severity || b.id - a.id);
+
+  markers.clearLayers();
+  const rows = [];
+  for (const i of shown) {
+    const color = COLORS[i.severity] || GREY;
+    let marker = null;
+    if (i.latitude || i.longitude) {
+      marker = L.circleMarker([i.latitude, i.longitude], {
+        radius: 6 + 2 * Math.min(i.severity, 3), color: "#333", weight: 1, fillColor: color, fillOpacity: 0.85,
+      }).bindPopup(popup(i)).addTo(markers);
+    }
+    const row = document.createElement("tr");
+    row.innerHTML = `<td><span class="sev" style="background:${color}">${i.severity}</span></td>` +
+      `<td>${escapeHTML(i.incidentType)}</td><td>${escapeHTML(i.road)}</td>` +
+      `<td>${escapeHTML(i.location)}${i.city ? ", " + escapeHTML(i.city) : ""}</td>` +
+      `<td>${escapeHTML(i.countyName)}</td><td>${escapeHTML(lanes(i))}</td><td>${escapeHTML(started(i))}</td>`;
+    if (marker) {
+      row.addEventListener("click", () => {
+        map.setView(marker.getLatLng(), Math.max(map.getZoom(), 12));
+        marker.openPopup();
+      });
+    }
+    rows.push(row);
+  }
+  $("incidents").replaceChildren(...rows);
+  setStatus(`${shown.length} of ${incidents.size} active incidents`);
+}
+
+async function load() {
+  try {
+    const features = [];
+    // Pages are followed through their Link headers.
+    for (let url = "/api/incidents.geojson?status=active"; url;) {
+      const resp = await fetch(url);
+      if (!resp.ok) throw new Error(resp.statusText);
+      const collection = await resp.json();
+      features.push(...collection.features);
+      url = /<([^>]*)>;\s*rel="next"/.exec(resp.headers.get("Link") || "")?.[1];
+    }
+    incidents.clear();
+    for (const feature of features) {
+      incidents.set(feature.properties.id, feature.properties);
+    }
+    refreshTypes();
+    render();
+  } catch (err) {
+    setStatus(`Could not load incidents: ${err.message}`);
+  }
+}
+
+// follow applies the live events, falling back to polling when the server
+// has no event stream.
+function follow() {
+  const source = new EventSource("/events");
+  let connected = false;
+  source.onopen = () => { connected = true; };
+  source.onerror = () => {
+    if (!connected) {
+      source.close();
+      setInterval(load, POLL_MS);
+    }
+  };
+  const apply = (e) => {
+    const event = JSON.parse(e.data);
+    if (event.event === "cleared") {
+      incidents.delete(event.incident.id);
+    } else {
+      incidents.set(event.incident.id, { ...incidents.get(event.incident.id), ...event.incident, status: "active" });
+    }
+    refreshTypes();
+    render();
+  };
+  for (const kind of ["created", "updated", "cleared"]) {
+    source.addEventListener(kind, apply);
+  }
+}
+
+$("filters").addEventListener("input", render);
+$("filters").addEventListener("submit", (e) => e.preventDefault());
+load().then(follow);
+setInterval(load, REFRESH_MS);
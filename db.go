@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// configureDBPool applies the configured connection pool limits. Recycling
+// connections periodically keeps long-lived runs from holding on to sockets that a
+// managed database or a Postgres restart has already dropped.
+func configureDBPool(db *DB, cfg DatabaseConfig) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	slog.Info("Database pool", "max_open", cfg.MaxOpenConns, "max_idle", cfg.MaxIdleConns,
+		"max_lifetime", cfg.ConnMaxLifetime, "max_idle_time", cfg.ConnMaxIdleTime, "query_timeout", cfg.QueryTimeout)
+}
+
+// isConnError reports whether err is a broken connection rather than a problem
+// with the query itself: a connection the driver reports bad, or one reset,
+// closed mid-write or ended early by the server. Timeouts and other network
+// errors aren't, since a retry would likely hit them again.
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// Some drivers only pass on the message.
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe")
+}
+
+// withReconnect runs op and, if it fails with a connection-level error, re-pings
+// the database and retries it exactly once.
+func withReconnect(db *DB, op func() error) error {
+	err := op()
+	if !isConnError(err) {
+		return err
+	}
+
+	slog.Warn("Database connection error. Re-pinging and retrying once.", "err", err)
+	ctx, cancel := db.timeout(context.Background())
+	defer cancel()
+	if pingErr := db.PingContext(ctx); pingErr != nil {
+		return fmt.Errorf("could not reconnect to database: %w (original error: %v)", pingErr, err)
+	}
+	return op()
+}
+
+// StoredIncident is an incident as persisted in the database, including its lifecycle state.
+type StoredIncident struct {
+	Incident
+	Status      string     `json:"status" db:"status"`
+	ClearedTime *time.Time `json:"clearedTime,omitempty" db:"cleared_time"`
+	// DurationSeconds is how long the incident was active, set when it clears.
+	DurationSeconds *int `json:"durationSeconds,omitempty" db:"duration_seconds"`
+	// ReopenCount is how many times the incident came back after clearing, most
+	// recently at ReopenedAt.
+	ReopenCount int        `json:"reopenCount" db:"reopen_count"`
+	ReopenedAt  *time.Time `json:"reopenedAt,omitempty" db:"reopened_at"`
+}
+
+// incidentColumns is the column list scanned by scanStoredIncident, in order.
+const incidentColumns = `id, latitude, longitude, common_name, reason, "condition", incident_type,
+	severity, direction, location, county_id, county_name, city, start_time,
+	end_time, last_update, road, route_id, lanes_closed, lanes_total, detour,
+	cross_street_prefix, cross_street_number, cross_street_suffix,
+	cross_street_common_name, event, created_from_concurrent, movable_construction,
+	work_zone_speed_limit, status, cleared_time, duration_seconds, reopen_count, reopened_at`
+
+// scanStoredIncident reads one row selected with incidentColumns. Nullable columns
+// are scanned through sql.Null, or FeedTime for the feed's timestamps, so older
+// rows with missing values still load.
+func scanStoredIncident(rows *sql.Rows) (StoredIncident, error) {
+	var (
+		si                                                          StoredIncident
+		commonName, reason, condition, direction, location          sql.Null[string]
+		countyName, city, road                                      sql.Null[string]
+		detour, crossPrefix, crossSuffix, crossCommon, event, mcons sql.Null[string]
+		status                                                      sql.Null[string]
+		severity, countyID, routeID, lanesClosed, lanesTotal        sql.Null[int]
+		crossNumber, speedLimit                                     sql.Null[int]
+		concurrent                                                  sql.Null[bool]
+		clearedTime                                                 sql.Null[time.Time]
+		duration, reopenCount                                       sql.Null[int]
+		reopenedAt                                                  sql.Null[time.Time]
+	)
+	err := rows.Scan(
+		&si.ID, &si.Latitude, &si.Longitude, &commonName, &reason, &condition, &si.IncidentType,
+		&severity, &direction, &location, &countyID, &countyName, &city, &si.StartTime,
+		&si.EndTime, &si.LastUpdate, &road, &routeID, &lanesClosed, &lanesTotal, &detour,
+		&crossPrefix, &crossNumber, &crossSuffix,
+		&crossCommon, &event, &concurrent, &mcons,
+		&speedLimit, &status, &clearedTime, &duration, &reopenCount, &reopenedAt,
+	)
+	if err != nil {
+		return si, err
+	}
+
+	si.CommonName, si.Reason, si.Condition = commonName.V, reason.V, condition.V
+	si.Severity, si.Direction, si.Location = severity.V, direction.V, location.V
+	si.CountyID, si.CountyName, si.City = countyID.V, countyName.V, city.V
+	si.Road, si.RouteID, si.LanesClosed, si.LanesTotal = road.V, routeID.V, lanesClosed.V, lanesTotal.V
+	si.Detour, si.CrossStreetPrefix, si.CrossStreetNumber = detour.V, crossPrefix.V, crossNumber.V
+	si.CrossStreetSuffix, si.CrossStreetCommonName, si.Event = crossSuffix.V, crossCommon.V, event.V
+	si.CreatedFromConcurrent, si.MovableConstruction, si.WorkZoneSpeedLimit = concurrent.V, mcons.V, speedLimit.V
+	si.Status = status.V
+	if clearedTime.Valid {
+		t := clearedTime.V
+		si.ClearedTime = &t
+	}
+	if duration.Valid {
+		d := duration.V
+		si.DurationSeconds = &d
+	}
+	si.ReopenCount = reopenCount.V
+	if reopenedAt.Valid {
+		t := reopenedAt.V
+		si.ReopenedAt = &t
+	}
+	return si, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestIsConnError(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"bad connection", driver.ErrBadConn, true},
+		{"wrapped bad connection", fmt.Errorf("query: %w", driver.ErrBadConn), true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"broken pipe", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}, true},
+		{"EOF", io.EOF, true},
+		{"unexpected EOF", fmt.Errorf("reading reply: %w", io.ErrUnexpectedEOF), true},
+		{"reset in the message only", errors.New("read tcp 10.0.0.2:5432: connection reset by peer"), true},
+		{"network timeout", timeout, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"query error", errors.New(`pq: relation "ncdot_incidents" does not exist`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnError(tt.err); got != tt.want {
+				t.Errorf("isConnError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// newMockDB returns a Postgres DB backed by sqlmock, which expects pings too.
+func newMockDB(t *testing.T) (*DB, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return &DB{DB: sqlDB, dialect: dialects[driverPostgres]}, mock
+}
+
+func TestWithReconnect(t *testing.T) {
+	tests := []struct {
+		name string
+		// errs are what each attempt at the query fails with, nil for success.
+		errs    []error
+		pingErr error
+		// wantAttempts is how many times the query runs, and wantPing whether
+		// the database is pinged in between.
+		wantAttempts int
+		wantPing     bool
+		wantErr      bool
+	}{
+		{name: "success", errs: []error{nil}, wantAttempts: 1},
+		{name: "bad connection is retried", errs: []error{driver.ErrBadConn, nil}, wantAttempts: 2, wantPing: true},
+		{name: "reset is retried", errs: []error{syscall.ECONNRESET, nil}, wantAttempts: 2, wantPing: true},
+		{name: "retried once only", errs: []error{driver.ErrBadConn, io.ErrUnexpectedEOF}, wantAttempts: 2, wantPing: true, wantErr: true},
+		{name: "failed ping", errs: []error{driver.ErrBadConn}, pingErr: syscall.ECONNREFUSED, wantAttempts: 1, wantPing: true, wantErr: true},
+		{name: "query error is not retried", errs: []error{errors.New("syntax error")}, wantAttempts: 1, wantErr: true},
+		{name: "timeout is not retried", errs: []error{context.DeadlineExceeded}, wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			// The errors come while reading the rows, as when the connection
+			// drops mid-query: database/sql retries a bad connection itself
+			// when the query is sent, but not once it has rows.
+			for i, err := range tt.errs {
+				rows := sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2)
+				if err != nil {
+					rows.RowError(1, err)
+				}
+				mock.ExpectQuery("SELECT id FROM ncdot_incidents").WithArgs("active").WillReturnRows(rows)
+				if i == 0 && tt.wantPing {
+					ping := mock.ExpectPing()
+					if tt.pingErr != nil {
+						ping.WillReturnError(tt.pingErr)
+					}
+				}
+			}
+
+			attempts := 0
+			var ids []int
+			err := withReconnect(db, func() error {
+				attempts++
+				ids = nil
+				rows, err := db.QueryContext(context.Background(), "SELECT id FROM ncdot_incidents WHERE status = $1", "active")
+				if err != nil {
+					return err
+				}
+				defer rows.Close()
+				for rows.Next() {
+					var id int
+					if err := rows.Scan(&id); err != nil {
+						return err
+					}
+					ids = append(ids, id)
+				}
+				return rows.Err()
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("withReconnect() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && len(ids) != 2 {
+				t.Errorf("read ids %v, want 2 of them", ids)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("ran the query %d times, want %d", attempts, tt.wantAttempts)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestIncidentChangesByIDReconnect(t *testing.T) {
+	db, mock := newMockDB(t)
+	observed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{"incident_id", "observed_at", "field", "old_value", "new_value", "last_update"}
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(1, observed, "severity", "1", "2", "").
+			AddRow(1, observed, "road", "I-40", "I-440", "").
+			AddRow(2, observed, "city", "Cary", "Apex", "")
+	}
+	// The connection drops after the first row has been read.
+	mock.ExpectQuery("SELECT incident_id, observed_at").WithArgs(1, 2).WillReturnRows(rows().RowError(1, driver.ErrBadConn))
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT incident_id, observed_at").WithArgs(1, 2).WillReturnRows(rows())
+
+	changes, err := (&sqlStore{db: db}).IncidentChangesByID(context.Background(), []int{1, 2})
+	if err != nil {
+		t.Fatalf("IncidentChangesByID() error = %v", err)
+	}
+	if len(changes[1]) != 2 || len(changes[2]) != 1 {
+		t.Errorf("IncidentChangesByID() = %v, want 2 changes to incident 1 and 1 to incident 2", changes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mtickle/crash-reporting/geo"
+)
+
+// DedupConfig combines near-duplicate incidents, such as one crash reported in
+// both directions or copies the feed marks createdFromConcurrent, into one
+// alert. Incidents are duplicates when they are on the same road, within
+// MaxDistanceMiles of each other, and started within MaxTimeApart, which is
+// skipped when either start time is unknown. A new incident that duplicates
+// one already alerted sends nothing.
+type DedupConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	MaxDistanceMiles float64       `yaml:"max_distance_miles"`
+	MaxTimeApart     time.Duration `yaml:"max_time_apart"`
+}
+
+// roadKey is the road an incident is on, ignoring direction: its route, e.g.
+// "I-40", or else its normalised name.
+func roadKey(incident Incident) string {
+	if route := routeDesignation(incident.Road); route != "" {
+		return route
+	}
+	return normalizeRoad(incident.Road)
+}
+
+// duplicates reports whether two incidents look like the same event.
+func (d DedupConfig) duplicates(a, b Incident) bool {
+	if a.ID == b.ID || roadKey(a) == "" || roadKey(a) != roadKey(b) {
+		return false
+	}
+	pa := geo.Point{Lat: a.Latitude, Lon: a.Longitude}
+	pb := geo.Point{Lat: b.Latitude, Lon: b.Longitude}
+	if !pa.Valid() || !pb.Valid() || geo.HaversineMiles(pa, pb) > d.MaxDistanceMiles {
+		return false
+	}
+	if a.StartTime.IsZero() || b.StartTime.IsZero() {
+		return true
+	}
+	apart := a.StartTime.Sub(b.StartTime.Time)
+	return apart.Abs() <= d.MaxTimeApart
+}
+
+// apply combines the pending new-incident alerts. Alerts duplicating an
+// incident in alerted are dropped and their incidents marked as sent; the rest
+// are grouped, each group sending one alert whose incident lists the others in
+// Duplicates. A group's alert is for its first incident not createdFromConcurrent.
+func (d DedupConfig) apply(pending []Notification, alerted []Incident, sentIDs map[int]bool) []Notification {
+	if !d.Enabled {
+		return pending
+	}
+	var kept []Notification
+next:
+	for _, n := range pending {
+		for _, a := range alerted {
+			if d.duplicates(n.Incident, a) {
+				slog.Info("Not alerting on a duplicate of an alerted incident", "incident_id", n.Incident.ID, "duplicate_of", a.ID)
+				sentIDs[n.Incident.ID] = true
+				continue next
+			}
+		}
+		for i := range kept {
+			group := &kept[i]
+			if !d.duplicates(n.Incident, group.Incident) && !d.duplicatesAny(n.Incident, group.Incident.Duplicates) {
+				continue
+			}
+			slog.Info("Combining duplicate incidents into one alert", "incident_id", n.Incident.ID, "duplicate_of", group.Incident.ID)
+			if group.Incident.CreatedFromConcurrent && !n.Incident.CreatedFromConcurrent {
+				// The original report leads, with the copy listed.
+				previous := group.Incident
+				others := append(previous.Duplicates, previous)
+				others[len(others)-1].Duplicates = nil
+				*group = n
+				group.Incident.Duplicates = others
+			} else {
+				group.Incident.Duplicates = append(group.Incident.Duplicates, n.Incident)
+			}
+			continue next
+		}
+		kept = append(kept, n)
+	}
+	return kept
+}
+
+// duplicatesAny reports whether the incident duplicates any of the others.
+func (d DedupConfig) duplicatesAny(incident Incident, others []Incident) bool {
+	for _, o := range others {
+		if d.duplicates(incident, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicateIDs lists the incident's ID and those of its duplicates.
+func duplicateIDs(incident Incident) []int {
+	ids := []int{incident.ID}
+	for _, d := range incident.Duplicates {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+// duplicateLink is a map link to one of an incident's duplicates.
+type duplicateLink struct {
+	URL string
+	// Label names the duplicate, e.g. "#123 I-40 WB".
+	Label string
+}
+
+// duplicateLinks links to the incident's duplicates.
+func duplicateLinks(incident Incident) []duplicateLink {
+	var links []duplicateLink
+	for _, d := range incident.Duplicates {
+		label := fmt.Sprintf("#%d %s", d.ID, orNA(d.Road))
+		if dir := directionAbbreviations[strings.ToUpper(strings.TrimSpace(d.Direction))]; dir != "" && !strings.HasSuffix(strings.ToUpper(d.Road), dir) {
+			label += " " + dir
+		}
+		links = append(links, duplicateLink{URL: mapLink(d.Latitude, d.Longitude), Label: label})
+	}
+	return links
+}
+
+// duplicatesText lists the duplicates one per line, each written by link.
+func duplicatesText(incident Incident, link func(duplicateLink) string) string {
+	var lines []string
+	for _, l := range duplicateLinks(incident) {
+		lines = append(lines, link(l))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// ctxConn is a connection bound to a context: the deadlines its client sets for
+// each round trip never reach past the context's deadline, and the connection is
+// closed as soon as the context is done, which fails any read or write blocked
+// on a hung server.
+type ctxConn struct {
+	net.Conn
+	deadline time.Time
+	stop     func() bool
+}
+
+// clamp moves t back to the context's deadline if it is later.
+func (c *ctxConn) clamp(t time.Time) time.Time {
+	if !c.deadline.IsZero() && (t.IsZero() || t.After(c.deadline)) {
+		return c.deadline
+	}
+	return t
+}
+
+func (c *ctxConn) SetDeadline(t time.Time) error      { return c.Conn.SetDeadline(c.clamp(t)) }
+func (c *ctxConn) SetReadDeadline(t time.Time) error  { return c.Conn.SetReadDeadline(c.clamp(t)) }
+func (c *ctxConn) SetWriteDeadline(t time.Time) error { return c.Conn.SetWriteDeadline(c.clamp(t)) }
+
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// dialContext connects to addr over TCP, then TLS when tlsConfig is set, within
+// timeout. The connection is bound to ctx as described on ctxConn.
+func dialContext(ctx context.Context, addr string, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	raw, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &ctxConn{Conn: raw}
+	c.deadline, _ = ctx.Deadline()
+	c.stop = context.AfterFunc(ctx, func() { raw.Close() })
+	if !c.deadline.IsZero() {
+		c.SetDeadline(c.deadline)
+	}
+	if tlsConfig == nil {
+		return c, nil
+	}
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn := tls.Client(c, tlsConfig)
+	if err := conn.HandshakeContext(handshakeCtx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// hungListener accepts connections and never writes to them.
+func hungListener(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		l.Close()
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				<-done
+				conn.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func TestHungServerCancelled(t *testing.T) {
+	events := []IncidentEvent{{Event: eventCreated, Incident: Incident{ID: 1}}}
+	tests := []struct {
+		name string
+		send func(ctx context.Context, addr string) error
+	}{
+		{"email", func(ctx context.Context, addr string) error {
+			host, port, _ := net.SplitHostPort(addr)
+			p, _ := strconv.Atoi(port)
+			cfg := EmailConfig{Host: host, Port: p, From: "alerts@example.com"}
+			return cfg.sendMail(ctx, []string{"me@example.com"}, "subject", "<p>body</p>")
+		}},
+		{"mqtt", func(ctx context.Context, addr string) error {
+			return publishToMQTT(ctx, MQTTConfig{BrokerURL: "tcp://" + addr, Topic: "ncdot/{id}"}, events)
+		}},
+		{"nats", func(ctx context.Context, addr string) error {
+			return publishToNATS(ctx, NATSConfig{URL: "nats://" + addr, Subject: "ncdot.{id}"}, events)
+		}},
+		{"amqp", func(ctx context.Context, addr string) error {
+			return publishToAMQP(ctx, AMQPConfig{URL: "amqp://" + addr, Exchange: "ncdot"}, events)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := hungListener(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			start := time.Now()
+			if err := tt.send(ctx, l.Addr().String()); err == nil {
+				t.Error("send succeeded against a hung server")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("send took %s, want it to return promptly once cancelled", elapsed)
+			}
+		})
+	}
+}
+
+func TestDialContextDeadline(t *testing.T) {
+	l := hungListener(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// The 15s per-round-trip timeout is clamped to the context's deadline.
+	if err := publishToMQTT(ctx, MQTTConfig{BrokerURL: "tcp://" + l.Addr().String(), Topic: "t"}, nil); err == nil {
+		t.Error("publishToMQTT() succeeded against a hung server")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("publishToMQTT() took %s, want it to stop at the context's deadline", elapsed)
+	}
+}
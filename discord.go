@@ -0,0 +1,717 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// discordUsername is the name the webhook posts under.
+const discordUsername = "NC DOT Crash Bot"
+
+// Embed colors.
+const (
+	colorGreen  = 3066993
+	colorYellow = 16776960
+	colorRed    = 15158332
+	colorGrey   = 2105893
+)
+
+// Structs for creating a rich Discord Embed with a thumbnail
+type DiscordWebhookPayload struct {
+	Username        string           `json:"username"`
+	AvatarURL       string           `json:"avatar_url,omitempty"`
+	Content         string           `json:"content,omitempty"`
+	Embeds          []DiscordEmbed   `json:"embeds"`
+	AllowedMentions *AllowedMentions `json:"allowed_mentions,omitempty"`
+}
+
+// AllowedMentions restricts which mentions in the message content actually ping.
+type AllowedMentions struct {
+	Roles []string `json:"roles"`
+	Users []string `json:"users"`
+}
+
+type DiscordEmbed struct {
+	Title       string          `json:"title"`
+	Description string          `json:"description,omitempty"`
+	Color       int             `json:"color"`
+	Fields      []EmbedField    `json:"fields,omitempty"`
+	Footer      EmbedFooter     `json:"footer"`
+	Timestamp   string          `json:"timestamp"`
+	Thumbnail   *EmbedThumbnail `json:"thumbnail,omitempty"`
+	Image       *EmbedImage     `json:"image,omitempty"`
+}
+
+type EmbedThumbnail struct {
+	URL string `json:"url"`
+}
+
+type EmbedImage struct {
+	URL string `json:"url"`
+}
+
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type EmbedFooter struct {
+	Text string `json:"text"`
+}
+
+// severityColor maps an incident severity to its embed color.
+func severityColor(severity int) int {
+	switch severity {
+	case 1:
+		return colorGreen
+	case 2:
+		return colorYellow
+	case 3:
+		return colorRed
+	default:
+		return colorGrey
+	}
+}
+
+// orNA keeps empty feed values from producing blank embed fields, which Discord rejects.
+func orNA(value string) string {
+	if value == "" {
+		return "N/A"
+	}
+	return value
+}
+
+// lanesText describes how many lanes are closed, e.g. "2 of 3 closed".
+func lanesText(closed, total int) string {
+	if total == 0 {
+		return "Unknown"
+	}
+	return fmt.Sprintf("%d of %d closed", closed, total)
+}
+
+// buildIncidentEmbed builds the color-coded embed for a new incident.
+func buildIncidentEmbed(incident Incident, parsedTime time.Time, maps StaticMapConfig) DiscordEmbed {
+	// All fields are single-column (Inline: false) for mobile readability.
+	embed := DiscordEmbed{
+		Title: fmt.Sprintf("New %s Alert", incident.IncidentType),
+		Color: severityColor(incident.Severity),
+		Fields: []EmbedField{
+			{Name: "Reason", Value: orNA(incident.Reason), Inline: false},
+			{Name: "Road", Value: orNA(incident.Road), Inline: false},
+			{Name: "Location", Value: orNA(incident.Location), Inline: false},
+			{Name: "City", Value: orNA(incident.City), Inline: false},
+			{Name: "Lanes", Value: lanesText(incident.LanesClosed, incident.LanesTotal), Inline: false},
+			{Name: "Severity", Value: strconv.Itoa(incident.Severity), Inline: false},
+		},
+		Footer:    EmbedFooter{Text: fmt.Sprintf("Incident #%d · Fetched from NC DOT API", incident.ID)},
+		Timestamp: parsedTime.Format(time.RFC3339),
+	}
+	if incident.Weather != "" {
+		embed.Fields = append(embed.Fields, EmbedField{Name: "Weather", Value: incident.Weather, Inline: false})
+	}
+	if len(incident.Duplicates) > 0 {
+		embed.Fields = append(embed.Fields, EmbedField{Name: "Also reported as", Value: duplicatesText(incident, func(l duplicateLink) string {
+			return "[" + l.Label + "](" + l.URL + ")"
+		}), Inline: false})
+	}
+
+	if mapURL := maps.url(incident); mapURL != "" {
+		embed.Image = &EmbedImage{URL: mapURL}
+	}
+	return embed
+}
+
+// buildClearedEmbed builds the embed sent when an incident leaves the feed.
+func buildClearedEmbed(incident ClearedIncident) DiscordEmbed {
+	return DiscordEmbed{
+		Title: clearedTitle(incident),
+		Color: colorGreen,
+		Fields: []EmbedField{
+			{Name: "Road", Value: orNA(incident.Road), Inline: false},
+			{Name: "Location", Value: orNA(incident.Location), Inline: false},
+			{Name: "City", Value: orNA(incident.City), Inline: false},
+		},
+		Footer:    EmbedFooter{Text: fmt.Sprintf("Incident #%d · No longer in NC DOT feed", incident.ID)},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+}
+
+// discordAPIBase is the Discord REST API used for bot-token calls like thread creation.
+const discordAPIBase = "https://discord.com/api/v10"
+
+// DiscordMessage records an alert posted to Discord so it can be edited later, and
+// the thread its follow-up updates go to when threads are enabled.
+type DiscordMessage struct {
+	WebhookURL  string    `json:"webhookUrl"`
+	MessageID   string    `json:"messageId"`
+	ChannelID   string    `json:"channelId,omitempty"`
+	ThreadID    string    `json:"threadId,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	AlertTime   time.Time `json:"alertTime"`
+	Incident    Incident  `json:"incident"`
+}
+
+// postedMessage is the part of Discord's message object we keep.
+type postedMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
+
+// incidentFingerprint captures the incident details shown in an alert, so a change
+// in any of them can be detected and the posted message edited.
+func incidentFingerprint(incident Incident) string {
+	fingerprint := fmt.Sprintf("%s|%s|%s|%s|%s|%d/%d|%d",
+		incident.Reason, incident.Condition, incident.Road, incident.Location, incident.City,
+		incident.LanesClosed, incident.LanesTotal, incident.Severity)
+	// Only added when known, so alerts posted before weather was on still match.
+	if incident.Weather != "" {
+		fingerprint += "|" + incident.Weather
+	}
+	return fingerprint
+}
+
+// loadDiscordMessages reads the posted message records, keyed by incident ID. An
+// incident has one record per webhook it was posted to.
+func loadDiscordMessages(filename string) (map[int][]DiscordMessage, error) {
+	messages := make(map[int][]DiscordMessage)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return messages, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return messages, nil
+	}
+	if err := json.Unmarshal(data, &messages); err != nil {
+		slog.Warn("Could not parse the Discord message records. Existing alerts will not be edited.", "path", filename, "err", err)
+		return make(map[int][]DiscordMessage), nil
+	}
+	return messages, nil
+}
+
+// saveDiscordMessages writes the posted message records back to disk.
+func saveDiscordMessages(filename string, messages map[int][]DiscordMessage) error {
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// buildUpdatedEmbed rebuilds an alert after the incident's details have changed.
+func buildUpdatedEmbed(incident Incident, alertTime time.Time, maps StaticMapConfig) DiscordEmbed {
+	embed := buildIncidentEmbed(incident, alertTime, maps)
+	embed.Title = fmt.Sprintf("%s Alert (Updated)", incident.IncidentType)
+	embed.Footer.Text = fmt.Sprintf("Incident #%d · Updated %s", incident.ID, formatLocalTime(time.Now()))
+	return embed
+}
+
+// buildClearedEditEmbed turns a posted alert into a cleared one, keeping its details for context.
+func buildClearedEditEmbed(msg DiscordMessage, incident ClearedIncident, maps StaticMapConfig) DiscordEmbed {
+	embed := buildIncidentEmbed(msg.Incident, msg.AlertTime, maps)
+	embed.Title = fmt.Sprintf("Cleared: %s", msg.Incident.IncidentType)
+	embed.Color = colorGreen
+	cleared := formatLocalTime(time.Now())
+	if incident.DurationSeconds > 0 {
+		cleared += " (after " + formatDuration(incident.duration()) + ")"
+	}
+	embed.Fields = append(embed.Fields, EmbedField{Name: "Cleared", Value: cleared, Inline: false})
+	embed.Footer.Text = fmt.Sprintf("Incident #%d · No longer in NC DOT feed", msg.Incident.ID)
+	return embed
+}
+
+// describeChanges lists the alert details that differ between two versions of an incident.
+func describeChanges(before, after Incident) []string {
+	var changes []string
+	if before.Severity != after.Severity {
+		verb := "lowered"
+		if after.Severity > before.Severity {
+			verb = "raised"
+		}
+		changes = append(changes, fmt.Sprintf("Severity %s from %d to %d", verb, before.Severity, after.Severity))
+	}
+	if before.LanesClosed != after.LanesClosed || before.LanesTotal != after.LanesTotal {
+		changes = append(changes, fmt.Sprintf("Lanes: %s → %s",
+			lanesText(before.LanesClosed, before.LanesTotal), lanesText(after.LanesClosed, after.LanesTotal)))
+	}
+	if before.Reason != after.Reason {
+		changes = append(changes, fmt.Sprintf("Reason: %s → %s", orNA(before.Reason), orNA(after.Reason)))
+	}
+	if before.Condition != after.Condition {
+		changes = append(changes, fmt.Sprintf("Condition: %s → %s", orNA(before.Condition), orNA(after.Condition)))
+	}
+	if before.Road != after.Road || before.Location != after.Location || before.City != after.City {
+		changes = append(changes, fmt.Sprintf("Location: %s, %s", orNA(after.Road), orNA(after.Location)))
+	}
+	return changes
+}
+
+// updateDiscordAlert brings the posted alert for a changed incident up to date: the
+// original message is edited and, with threads enabled, the change is appended to the
+// incident's thread. It returns the record to keep for the incident.
+func updateDiscordAlert(ctx context.Context, msg DiscordMessage, incident Incident, notifications NotificationConfig) DiscordMessage {
+	if notifications.EditMessages && msg.MessageID != "" {
+		slog.Info("Incident changed. Editing its Discord alert.", "incident_id", incident.ID)
+		embed := buildUpdatedEmbed(incident, msg.AlertTime, notifications.staticMap())
+		if err := editDiscordMessage(ctx, msg.WebhookURL, msg.MessageID, embed); err != nil {
+			slog.Error("Error editing Discord alert", "incident_id", incident.ID, "err", err)
+			return msg // Keep the old fingerprint so the edit is retried next cycle.
+		}
+	}
+	if msg.ThreadID != "" {
+		changes := describeChanges(msg.Incident, incident)
+		embed := DiscordEmbed{
+			Title:       "Incident Updated",
+			Description: "• " + strings.Join(changes, "\n• "),
+			Color:       severityColor(incident.Severity),
+			Footer:      EmbedFooter{Text: fmt.Sprintf("Incident #%d", incident.ID)},
+			Timestamp:   time.Now().Format(time.RFC3339),
+		}
+		data := newTemplateData(templateUpdated, incident, msg.AlertTime)
+		data.Changes = changes
+		if text, ok := notifications.templatesFor("discord").render(data); ok {
+			embed.Description = text
+		}
+		if _, err := postToDiscord(ctx, threadWebhookURL(msg.WebhookURL, msg.ThreadID), embed); err != nil {
+			slog.Error("Error posting update to Discord thread", "incident_id", incident.ID, "err", err)
+		}
+	}
+	msg.Fingerprint, msg.Incident = incidentFingerprint(incident), incident
+	return msg
+}
+
+// clearDiscordAlert marks the posted alert for a cleared incident as cleared, by
+// editing it and/or posting to its thread. It reports false when neither applies, in
+// which case the caller should send a standalone cleared notification instead.
+func clearDiscordAlert(ctx context.Context, msg DiscordMessage, incident ClearedIncident, notifications NotificationConfig) bool {
+	canEdit := notifications.EditMessages && msg.MessageID != ""
+	if !canEdit && msg.ThreadID == "" {
+		return false
+	}
+	if canEdit {
+		slog.Info("Incident cleared. Editing its Discord alert.", "incident_id", incident.ID)
+		if err := editDiscordMessage(ctx, msg.WebhookURL, msg.MessageID, buildClearedEditEmbed(msg, incident, notifications.staticMap())); err != nil {
+			slog.Error("Error editing Discord alert for cleared incident", "incident_id", incident.ID, "err", err)
+		}
+	}
+	if msg.ThreadID != "" {
+		if _, err := postToDiscord(ctx, threadWebhookURL(msg.WebhookURL, msg.ThreadID), buildClearedEmbed(incident)); err != nil {
+			slog.Error("Error posting cleared update to Discord thread", "incident_id", incident.ID, "err", err)
+		}
+		if err := archiveDiscordThread(ctx, notifications.DiscordBotToken, msg.ThreadID); err != nil {
+			slog.Error("Error archiving Discord thread", "incident_id", incident.ID, "err", err)
+		}
+	}
+	return true
+}
+
+// threadWebhookURL targets a webhook post at a thread.
+func threadWebhookURL(webhookURL, threadID string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return webhookURL
+	}
+	q := u.Query()
+	q.Set("thread_id", threadID)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// threadName names an incident's thread, within Discord's 100 character limit.
+func threadName(incident Incident) string {
+	name := fmt.Sprintf("#%d %s: %s", incident.ID, incident.IncidentType, orNA(incident.Road))
+	if r := []rune(name); len(r) > 100 {
+		name = string(r[:100])
+	}
+	return name
+}
+
+// createDiscordThread starts a thread on a posted alert. Webhooks can post into
+// threads but not create them on a text channel message, so this uses a bot token.
+func createDiscordThread(ctx context.Context, botToken, channelID, messageID, name string) (string, error) {
+	body, err := json.Marshal(map[string]any{
+		"name":                  name,
+		"auto_archive_duration": 1440, // Minutes; threads archive after a day of inactivity.
+	})
+	if err != nil {
+		return "", err
+	}
+	var thread struct {
+		ID string `json:"id"`
+	}
+	endpoint := fmt.Sprintf("%s/channels/%s/messages/%s/threads", discordAPIBase, channelID, messageID)
+	if err := discordBotRequest(ctx, botToken, http.MethodPost, endpoint, body, &thread); err != nil {
+		return "", err
+	}
+	return thread.ID, nil
+}
+
+// archiveDiscordThread archives an incident's thread once the incident has cleared.
+func archiveDiscordThread(ctx context.Context, botToken, threadID string) error {
+	body := []byte(`{"archived":true}`)
+	return discordBotRequest(ctx, botToken, http.MethodPatch, fmt.Sprintf("%s/channels/%s", discordAPIBase, threadID), body, nil)
+}
+
+// discordBotRequest makes an authenticated Discord API call and decodes the reply into out, if given.
+func discordBotRequest(ctx context.Context, botToken, method, endpoint string, body []byte, out any) error {
+	resp, err := discordDo(ctx, method, endpoint, body, botToken)
+	if err != nil {
+		return fmt.Errorf("error calling Discord API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Discord API returned non-2xx status: %s", resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// MentionConfig pings Discord roles and users for critical incidents: those at or
+// above MinSeverity, or with every lane closed when FullClosure is set.
+type MentionConfig struct {
+	RoleIDs     []string `yaml:"role_ids"`
+	UserIDs     []string `yaml:"user_ids"`
+	MinSeverity int      `yaml:"min_severity"`
+	FullClosure bool     `yaml:"full_closure"`
+}
+
+// shouldMention reports whether an incident is critical enough to ping anyone.
+func (m MentionConfig) shouldMention(incident Incident) bool {
+	if len(m.RoleIDs) == 0 && len(m.UserIDs) == 0 {
+		return false
+	}
+	if m.MinSeverity > 0 && incident.Severity >= m.MinSeverity {
+		return true
+	}
+	return m.FullClosure && isFullClosure(incident)
+}
+
+// content returns the message text holding the mentions.
+func (m MentionConfig) content() string {
+	var mentions []string
+	for _, id := range m.RoleIDs {
+		mentions = append(mentions, "<@&"+id+">")
+	}
+	for _, id := range m.UserIDs {
+		mentions = append(mentions, "<@"+id+">")
+	}
+	return strings.Join(mentions, " ")
+}
+
+// postToDiscord sends embeds to a webhook and returns the created message.
+func postToDiscord(ctx context.Context, webhookURL string, embeds ...DiscordEmbed) (postedMessage, error) {
+	return postPayloadToDiscord(ctx, webhookURL, DiscordWebhookPayload{
+		Username: discordUsername,
+		Embeds:   embeds,
+	})
+}
+
+// postPayloadToDiscord sends a webhook payload and returns the created message.
+// The request uses ?wait=true so Discord replies with the message instead of 204.
+func postPayloadToDiscord(ctx context.Context, webhookURL string, payload DiscordWebhookPayload) (postedMessage, error) {
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return postedMessage{}, fmt.Errorf("error creating JSON payload: %w", err)
+	}
+
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return postedMessage{}, fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("wait", "true")
+	u.RawQuery = q.Encode()
+
+	resp, err := discordDo(ctx, http.MethodPost, u.String(), jsonPayload, "")
+	if err != nil {
+		return postedMessage{}, fmt.Errorf("error sending to Discord: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return postedMessage{}, fmt.Errorf("Discord returned non-2xx status: %s", resp.Status)
+	}
+
+	var message postedMessage
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		// The alert was delivered; only the ID needed for later edits is missing.
+		slog.Warn("Could not read Discord message ID", "err", err)
+	}
+	return message, nil
+}
+
+// editDiscordMessage replaces the embeds of a message previously posted by the webhook.
+func editDiscordMessage(ctx context.Context, webhookURL, messageID string, embeds ...DiscordEmbed) error {
+	jsonPayload, err := json.Marshal(struct {
+		Embeds []DiscordEmbed `json:"embeds"`
+	}{embeds})
+	if err != nil {
+		return fmt.Errorf("error creating JSON payload: %w", err)
+	}
+
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/messages/" + messageID
+
+	resp, err := discordDo(ctx, http.MethodPatch, u.String(), jsonPayload, "")
+	if err != nil {
+		return fmt.Errorf("error editing Discord message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Discord returned non-2xx status for edit: %s", resp.Status)
+	}
+	return nil
+}
+
+// sendToDiscord sends a rich, color-coded embed for a new incident and returns the
+// record of the posted message. With threads enabled a thread is started on the
+// alert for its later updates.
+func sendToDiscord(ctx context.Context, webhookURL string, incident Incident, parsedTime time.Time, notifications NotificationConfig) (DiscordMessage, error) {
+	embed := buildIncidentEmbed(incident, parsedTime, notifications.staticMap())
+	if text, ok := notifications.templatesFor("discord").render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		embed.Description, embed.Fields = text, nil
+	}
+	payload := DiscordWebhookPayload{
+		Username: discordUsername,
+		Embeds:   []DiscordEmbed{embed},
+	}
+	if m := notifications.Mentions; m.shouldMention(incident) {
+		payload.Content = m.content()
+		payload.AllowedMentions = &AllowedMentions{Roles: m.RoleIDs, Users: m.UserIDs}
+	}
+
+	posted, err := postPayloadToDiscord(ctx, webhookURL, payload)
+	if err != nil {
+		return DiscordMessage{}, err
+	}
+
+	msg := DiscordMessage{
+		WebhookURL:  webhookURL,
+		MessageID:   posted.ID,
+		ChannelID:   posted.ChannelID,
+		Fingerprint: incidentFingerprint(incident),
+		AlertTime:   parsedTime,
+		Incident:    incident,
+	}
+	if notifications.Threads && posted.ID != "" && posted.ChannelID != "" {
+		threadID, err := createDiscordThread(ctx, notifications.DiscordBotToken, posted.ChannelID, posted.ID, threadName(incident))
+		if err != nil {
+			slog.Error("Error creating Discord thread", "incident_id", incident.ID, "err", err)
+		} else {
+			msg.ThreadID = threadID
+		}
+	}
+	return msg, nil
+}
+
+// sendClearedNotificationToDiscord sends a rich embed when an incident is cleared.
+func sendClearedNotificationToDiscord(ctx context.Context, webhookURL string, incident ClearedIncident, notifications NotificationConfig) error {
+	embed := buildClearedEmbed(incident)
+	if text, ok := notifications.templatesFor("discord").render(clearedTemplateData(incident)); ok {
+		embed.Description, embed.Fields = text, nil
+	}
+	_, err := postToDiscord(ctx, webhookURL, embed)
+	return err
+}
+
+// clearDiscordRecords marks every posted alert for a cleared incident as cleared
+// and returns the webhooks that were handled that way. Updating the original
+// alerts keeps their context and doesn't ping anyone, so it happens even during
+// quiet hours.
+func clearDiscordRecords(ctx context.Context, records []DiscordMessage, incident ClearedIncident, notifications NotificationConfig) map[string]bool {
+	handled := make(map[string]bool)
+	for _, msg := range records {
+		if clearDiscordAlert(ctx, msg, incident, notifications) {
+			handled[msg.WebhookURL] = true
+		}
+	}
+	return handled
+}
+
+// discordNotifier delivers notifications to the Discord webhooks. It is tracked:
+// every posted alert is recorded in messages, so a retry after a partial failure
+// only posts to the webhooks that missed it, and later edits find the original.
+type discordNotifier struct {
+	cfg      NotificationConfig
+	messages map[int][]DiscordMessage
+}
+
+func init() {
+	registerNotifier("discord", true, func(cfg NotificationConfig, state notifierState) Notifier {
+		if cfg.DiscordWebhook == "" && len(cfg.CountyWebhooks) == 0 &&
+			!slices.ContainsFunc(cfg.Routes, func(r Route) bool { return r.isType(routeTypeDiscord) }) {
+			return nil
+		}
+		return discordNotifier{cfg: cfg, messages: state.discordMessages}
+	})
+}
+
+func (d discordNotifier) Name() string { return "Discord" }
+
+func (d discordNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return d.notifyCleared(ctx, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return d.notifyUpdate(ctx, n)
+	}
+	incident := n.Incident
+	var errs []error
+	for _, webhookURL := range d.cfg.webhooksFor(incident.CountyID, incident.IncidentType, incident.Severity) {
+		if alreadyPosted(d.messages[incident.ID], webhookURL) {
+			continue // Delivered on an earlier run that failed for another webhook.
+		}
+		msg, err := sendToDiscord(ctx, webhookURL, incident, n.StartTime, d.cfg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if d.messages != nil {
+			d.messages[incident.ID] = append(d.messages[incident.ID], msg)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NotifyBatch posts one digest embed per webhook listing every incident routed to
+// it. The incidents are recorded without a message ID, so a retry skips the
+// webhooks that got the digest, and their later changes and clearance are
+// announced with standalone messages instead of edits.
+func (d discordNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	var webhooks []string
+	byWebhook := make(map[string][]Notification)
+	for _, n := range batch {
+		incident := n.Incident
+		for _, webhookURL := range d.cfg.webhooksFor(incident.CountyID, incident.IncidentType, incident.Severity) {
+			if alreadyPosted(d.messages[incident.ID], webhookURL) {
+				continue
+			}
+			if _, ok := byWebhook[webhookURL]; !ok {
+				webhooks = append(webhooks, webhookURL)
+			}
+			byWebhook[webhookURL] = append(byWebhook[webhookURL], n)
+		}
+	}
+
+	var errs []error
+	for _, webhookURL := range webhooks {
+		group := byWebhook[webhookURL]
+		embed := DiscordEmbed{
+			Title:       digestTitle(group),
+			Description: digestText(group, 4096, discordDigestLine),
+			Color:       severityColor(maxSeverity(group)),
+			Footer:      EmbedFooter{Text: "Fetched from NC DOT API"},
+			Timestamp:   time.Now().Format(time.RFC3339),
+		}
+		if _, err := postToDiscord(ctx, webhookURL, embed); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, n := range group {
+			if d.messages != nil {
+				d.messages[n.Incident.ID] = append(d.messages[n.Incident.ID], DiscordMessage{
+					WebhookURL:  webhookURL,
+					Fingerprint: incidentFingerprint(n.Incident),
+					AlertTime:   n.StartTime,
+					Incident:    n.Incident,
+				})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// discordDigestLine is digestLine with the road linked to the map.
+func discordDigestLine(incident Incident) string {
+	return fmt.Sprintf("**%s**: [%s](%s) at %s, %s (lanes %s, severity %d)",
+		incident.IncidentType, orNA(incident.Road), mapLink(incident.Latitude, incident.Longitude),
+		orNA(incident.Location), orNA(incident.City),
+		lanesText(incident.LanesClosed, incident.LanesTotal), incident.Severity)
+}
+
+// notifyCleared updates the posted alerts and sends a standalone cleared
+// notification to every webhook that had none to update.
+func (d discordNotifier) notifyCleared(ctx context.Context, incident ClearedIncident) error {
+	handled := clearDiscordRecords(ctx, d.messages[incident.ID], incident, d.cfg)
+	var errs []error
+	delivered := 0
+	for _, webhookURL := range d.cfg.webhooksFor(incident.CountyID, incident.IncidentType, incident.Severity) {
+		if handled[webhookURL] {
+			continue
+		}
+		if err := sendClearedNotificationToDiscord(ctx, webhookURL, incident, d.cfg); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delivered++
+	}
+	return targetErrors(delivered, errs)
+}
+
+// notifyUpdate posts an escalation, reopening or update to every webhook, except where the
+// alert has a thread: updateDiscordAlert posts the change there instead.
+func (d discordNotifier) notifyUpdate(ctx context.Context, n Notification) error {
+	incident := n.Incident
+	embed := DiscordEmbed{
+		Title:       updateTitle(n),
+		Description: "• " + strings.Join(n.Changes, "\n• "),
+		Color:       severityColor(incident.Severity),
+		Fields: []EmbedField{
+			{Name: "Road", Value: orNA(incident.Road), Inline: true},
+			{Name: "Location", Value: orNA(incident.Location), Inline: true},
+			{Name: "Map", Value: fmt.Sprintf("[View on map](%s)", mapLink(incident.Latitude, incident.Longitude)), Inline: false},
+		},
+		Footer:    EmbedFooter{Text: fmt.Sprintf("Incident #%d", incident.ID)},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	data := newTemplateData(templateUpdated, incident, n.StartTime)
+	data.Changes = n.Changes
+	if text, ok := d.cfg.templatesFor("discord").render(data); ok {
+		embed.Description = text
+	}
+
+	var errs []error
+	delivered := 0
+	for _, webhookURL := range d.cfg.webhooksFor(incident.CountyID, incident.IncidentType, incident.Severity) {
+		if slices.ContainsFunc(d.messages[incident.ID], func(msg DiscordMessage) bool {
+			return msg.WebhookURL == webhookURL && msg.ThreadID != ""
+		}) {
+			continue
+		}
+		if _, err := postToDiscord(ctx, webhookURL, embed); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delivered++
+	}
+	return targetErrors(delivered, errs)
+}
+
+// alreadyPosted reports whether an alert has a message record for the given webhook.
+func alreadyPosted(records []DiscordMessage, webhookURL string) bool {
+	for _, msg := range records {
+		if msg.WebhookURL == webhookURL {
+			return true
+		}
+	}
+	return false
+}
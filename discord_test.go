@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadDiscordMessages(t *testing.T) {
+	alerted := time.Date(2024, 5, 1, 11, 5, 0, 0, time.UTC)
+	record := func(webhook, id string) DiscordMessage {
+		return DiscordMessage{WebhookURL: webhook, MessageID: id, Fingerprint: "Crash|||I-40", AlertTime: alerted,
+			Incident: Incident{ID: 7, Road: "I-40"}}
+	}
+	tests := []struct {
+		name string
+		// file is the records file's contents, which isn't written if missing.
+		file    string
+		missing bool
+		want    map[int][]DiscordMessage
+	}{
+		{"missing", "", true, map[int][]DiscordMessage{}},
+		{"empty", "", false, map[int][]DiscordMessage{}},
+		{"records per webhook", `{"7": [
+			{"webhookUrl": "https://a", "messageId": "1", "fingerprint": "Crash|||I-40", "alertTime": "2024-05-01T11:05:00Z", "incident": {"id": 7, "road": "I-40"}},
+			{"webhookUrl": "https://b", "messageId": "2", "fingerprint": "Crash|||I-40", "alertTime": "2024-05-01T11:05:00Z", "incident": {"id": 7, "road": "I-40"}}
+		]}`, false, map[int][]DiscordMessage{7: {record("https://a", "1"), record("https://b", "2")}}},
+		{"unparsable", `{"7": "posted"}`, false, map[int][]DiscordMessage{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "discord_messages.json")
+			if !tt.missing {
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := loadDiscordMessages(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadDiscordMessages() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// DisplayConfig sets how times are shown in alerts and templates. TimeFormat is
+// a Go layout; its zone abbreviation ("MST" in the layout) follows daylight
+// saving, e.g. EST in winter and EDT in summer.
+type DisplayConfig struct {
+	Timezone   string `yaml:"timezone"`
+	TimeFormat string `yaml:"time_format"`
+
+	// loc is parsed from Timezone by load.
+	loc *time.Location
+}
+
+// load parses the time zone.
+func (d *DisplayConfig) load() error {
+	var err error
+	if d.loc, err = time.LoadLocation(d.Timezone); err != nil {
+		return fmt.Errorf("display.timezone: %w", err)
+	}
+	return nil
+}
+
+// display is the configured display settings, set up by setupDisplay. The
+// location is loaded once rather than for every alert.
+var display = DisplayConfig{TimeFormat: "Jan 2 3:04 PM MST", loc: time.Local}
+
+// setupDisplay installs the display settings.
+func setupDisplay(cfg DisplayConfig) {
+	if cfg.loc != nil {
+		display = cfg
+	}
+}
+
+// localTime returns t in the display time zone.
+func localTime(t time.Time) time.Time {
+	return t.In(display.loc)
+}
+
+// formatLocalTime formats t in the display time zone and format.
+func formatLocalTime(t time.Time) string {
+	return localTime(t).Format(display.TimeFormat)
+}
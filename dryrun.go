@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dryRunStore reads from the real store but prints the writes a cycle would
+// make instead of making them, for run --dry-run.
+type dryRunStore struct {
+	Store
+}
+
+// UpsertIncidents prints which incidents would be inserted, reopened or
+// updated, and returns what is stored for each as the real upsert would.
+func (s dryRunStore) UpsertIncidents(ctx context.Context, incidents []Incident) ([]storedIncident, error) {
+	all, err := s.Incidents(ctx, "all")
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]StoredIncident, len(all))
+	for _, si := range all {
+		byID[si.ID] = si
+	}
+
+	stored := make([]storedIncident, len(incidents))
+	unchanged := 0
+	for i, incident := range incidents {
+		si, ok := byID[incident.ID]
+		if !ok {
+			fmt.Printf("Dry run: would insert incident %d, %s on %s in %s.\n",
+				incident.ID, incident.IncidentType, incident.Road, incident.CountyName)
+			continue
+		}
+		previous := storedIncident{
+			Exists:      true,
+			Cleared:     si.Status == "cleared",
+			Severity:    si.Severity,
+			LanesClosed: si.LanesClosed,
+			LanesTotal:  si.LanesTotal,
+			ReopenCount: si.ReopenCount,
+			LastUpdate:  si.LastUpdate,
+			Condition:   si.Condition,
+			Reason:      si.Reason,
+		}
+		if previous.Cleared {
+			previous.ReopenCount++
+		}
+		stored[i] = previous
+
+		switch {
+		case previous.Cleared:
+			fmt.Printf("Dry run: would reopen incident %d.\n", incident.ID)
+		case previous.unchanged(incident):
+			unchanged++
+		default:
+			var changes []string
+			for _, c := range previous.changes(incident) {
+				changes = append(changes, fmt.Sprintf("%s %q → %q", c.Field, c.OldValue, c.NewValue))
+			}
+			if len(changes) == 0 {
+				changes = append(changes, "lastUpdate only")
+			}
+			fmt.Printf("Dry run: would update incident %d (%s).\n", incident.ID, strings.Join(changes, "; "))
+		}
+	}
+	if unchanged > 0 {
+		fmt.Printf("Dry run: %d incidents are unchanged.\n", unchanged)
+	}
+	return stored, nil
+}
+
+// MarkCleared prints the incident that would be cleared.
+func (s dryRunStore) MarkCleared(ctx context.Context, id int) (int, error) {
+	fmt.Printf("Dry run: would mark incident %d cleared.\n", id)
+	return 0, nil
+}
+
+// SaveFeedSnapshots prints how many responses would be saved.
+func (s dryRunStore) SaveFeedSnapshots(ctx context.Context, snapshots []feedSnapshot) (int, error) {
+	fmt.Printf("Dry run: would save %d feed snapshots.\n", len(snapshots))
+	return len(snapshots), nil
+}
+
+// SaveIncidentWeather prints the weather that would be stored.
+func (s dryRunStore) SaveIncidentWeather(ctx context.Context, id int, w incidentWeather) error {
+	fmt.Printf("Dry run: would store the weather for incident %d (%s).\n", id, w)
+	return nil
+}
+
+// QueueNotifications prints how many notifications would be queued for retry.
+func (s dryRunStore) QueueNotifications(ctx context.Context, queued []queuedNotification) error {
+	fmt.Printf("Dry run: would queue %d notifications for retry.\n", len(queued))
+	return nil
+}
+
+// RescheduleNotification prints the queued notification that would be
+// rescheduled.
+func (s dryRunStore) RescheduleNotification(ctx context.Context, q queuedNotification) error {
+	fmt.Printf("Dry run: would reschedule queued notification %d for %s.\n", q.ID, q.NextAttempt.Format(time.RFC3339))
+	return nil
+}
+
+// DeleteQueuedNotification prints the queued notification that would be
+// removed.
+func (s dryRunStore) DeleteQueuedNotification(ctx context.Context, id int64) error {
+	fmt.Printf("Dry run: would remove queued notification %d.\n", id)
+	return nil
+}
+
+// dryRunNotifier stands in for a notifier, printing what it would be sent.
+type dryRunNotifier struct {
+	name string
+}
+
+func (n dryRunNotifier) Name() string { return n.name }
+
+func (n dryRunNotifier) Notify(_ context.Context, notification Notification) error {
+	id := notification.Incident.ID
+	if notification.Kind == notifyCleared {
+		id = notification.Cleared.ID
+	}
+	fmt.Printf("Dry run: would send the %s notification for incident %d to %s.\n", notification.Kind, id, n.name)
+	return nil
+}
+
+// dryRun returns the set with every notifier replaced by a dryRunNotifier of
+// the same name, so routing is unchanged and nothing is sent.
+func (s notifierSet) dryRun() notifierSet {
+	replace := func(notifiers []Notifier) []Notifier {
+		out := make([]Notifier, len(notifiers))
+		for i, n := range notifiers {
+			out[i] = dryRunNotifier{n.Name()}
+		}
+		return out
+	}
+	s.tracked, s.others = replace(s.tracked), replace(s.others)
+	return s
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpTimeout bounds sending one email, from connecting through QUIT.
+const smtpTimeout = 30 * time.Second
+
+// defaultEmailTemplate renders one or more alerts as a simple HTML email. A custom
+// template gets the same emailData and may use the same fields.
+const defaultEmailTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<h2>{{.Subject}}</h2>
+{{range .Alerts}}
+<table style="border-collapse: collapse; margin-bottom: 1.5em; border-left: 4px solid {{.Color}}; padding-left: 8px;">
+<tr><th colspan="2" style="text-align: left; font-size: 1.1em;">{{.Title}}</th></tr>
+{{if .Reason}}<tr><td><b>Reason</b></td><td>{{.Reason}}</td></tr>{{end}}
+<tr><td><b>Road</b></td><td>{{.Road}}</td></tr>
+<tr><td><b>Location</b></td><td>{{.Location}}</td></tr>
+<tr><td><b>City</b></td><td>{{.City}}</td></tr>
+{{if .Lanes}}<tr><td><b>Lanes</b></td><td>{{.Lanes}}</td></tr>{{end}}
+{{if .Severity}}<tr><td><b>Severity</b></td><td>{{.Severity}}</td></tr>{{end}}
+{{if .Weather}}<tr><td><b>Weather</b></td><td>{{.Weather}}</td></tr>{{end}}
+{{if .Duplicates}}<tr><td><b>Also reported as</b></td><td>{{range $i, $d := .Duplicates}}{{if $i}}<br>{{end}}<a href="{{$d.URL}}">{{$d.Label}}</a>{{end}}</td></tr>{{end}}
+<tr><td><b>Time</b></td><td>{{.LocalTime}}</td></tr>
+{{if .MapImage}}<tr><td colspan="2"><img src="{{.MapImage}}" alt="Map of the incident location" style="max-width: 100%;"></td></tr>{{end}}
+{{if .MapURL}}<tr><td colspan="2"><a href="{{.MapURL}}">View on map</a></td></tr>{{end}}
+<tr><td colspan="2" style="color: #777; font-size: 0.85em;">Incident #{{.ID}}</td></tr>
+</table>
+{{end}}
+<p style="color: #777; font-size: 0.85em;">Fetched from NC DOT API</p>
+</body>
+</html>
+`
+
+// EmailRecipient is an address to email. Digest recipients get one email per
+// digest interval listing everything since the last one instead of one per alert.
+type EmailRecipient struct {
+	Address string `yaml:"address"`
+	Digest  bool   `yaml:"digest"`
+}
+
+// EmailConfig sends alerts by SMTP. Port 465 uses implicit TLS; other ports use
+// STARTTLS when the server offers it.
+type EmailConfig struct {
+	Host           string           `yaml:"host"`
+	Port           int              `yaml:"port"`
+	Username       string           `yaml:"username"`
+	Password       string           `yaml:"password"`
+	From           string           `yaml:"from"`
+	Recipients     []EmailRecipient `yaml:"recipients"`
+	TemplateFile   string           `yaml:"template_file"`
+	DigestInterval time.Duration    `yaml:"digest_interval"`
+	DigestFile     string           `yaml:"digest_file"`
+
+	// template is parsed from TemplateFile, or the default, by load.
+	template *template.Template
+	// maps draws the alerts' map images, set when the notifier is created.
+	maps StaticMapConfig
+}
+
+// enabled reports whether email alerts are configured.
+func (e EmailConfig) enabled() bool {
+	return e.Host != "" && len(e.Recipients) > 0
+}
+
+// addresses returns the recipients with the given digest setting.
+func (e EmailConfig) addresses(digest bool) []string {
+	var addrs []string
+	for _, r := range e.Recipients {
+		if r.Digest == digest {
+			addrs = append(addrs, r.Address)
+		}
+	}
+	return addrs
+}
+
+// load parses the HTML template.
+func (e *EmailConfig) load() error {
+	if !e.enabled() {
+		return nil
+	}
+	text := defaultEmailTemplate
+	if e.TemplateFile != "" {
+		data, err := os.ReadFile(e.TemplateFile)
+		if err != nil {
+			return fmt.Errorf("could not read email template: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("email").Parse(text)
+	if err != nil {
+		return fmt.Errorf("could not parse email template: %w", err)
+	}
+	e.template = tmpl
+	return nil
+}
+
+// emailAlert is one incident as rendered in an email, new or cleared.
+type emailAlert struct {
+	ID       int
+	Title    string
+	Color    string
+	Reason   string
+	Road     string
+	Location string
+	City     string
+	Lanes    string
+	Severity int
+	Weather  string
+	// Duplicates link to the incidents combined into this alert.
+	Duplicates []duplicateLink
+	Time       time.Time
+	MapURL     string
+	// MapImage is a static map of the location, when one is configured.
+	MapImage string
+}
+
+// LocalTime is the alert's time in the display time zone and format.
+func (a emailAlert) LocalTime() string {
+	return formatLocalTime(a.Time)
+}
+
+// emailData is what the template is executed with.
+type emailData struct {
+	Subject string
+	Alerts  []emailAlert
+}
+
+// emailDigest holds alerts waiting for the next digest email.
+type emailDigest struct {
+	LastSent time.Time    `json:"last_sent"`
+	Alerts   []emailAlert `json:"alerts"`
+}
+
+// newEmailAlert converts a new incident for the template.
+func newEmailAlert(incident Incident, parsedTime time.Time, maps StaticMapConfig) emailAlert {
+	return emailAlert{
+		ID:         incident.ID,
+		Title:      fmt.Sprintf("New %s Alert", incident.IncidentType),
+		Color:      fmt.Sprintf("#%06x", severityColor(incident.Severity)),
+		Reason:     orNA(incident.Reason),
+		Road:       orNA(incident.Road),
+		Location:   orNA(incident.Location),
+		City:       orNA(incident.City),
+		Lanes:      lanesText(incident.LanesClosed, incident.LanesTotal),
+		Severity:   incident.Severity,
+		Weather:    incident.Weather,
+		Duplicates: duplicateLinks(incident),
+		Time:       parsedTime,
+		MapURL:     mapLink(incident.Latitude, incident.Longitude),
+		MapImage:   maps.url(incident),
+	}
+}
+
+// newClearedEmailAlert converts a cleared incident for the template.
+func newClearedEmailAlert(incident ClearedIncident) emailAlert {
+	return emailAlert{
+		ID:       incident.ID,
+		Title:    clearedTitle(incident),
+		Color:    fmt.Sprintf("#%06x", colorGreen),
+		Road:     orNA(incident.Road),
+		Location: orNA(incident.Location),
+		City:     orNA(incident.City),
+		Time:     time.Now(),
+	}
+}
+
+// renderEmail executes the template for the alerts.
+func (e EmailConfig) renderEmail(subject string, alerts []emailAlert) (string, error) {
+	var buf bytes.Buffer
+	if err := e.template.Execute(&buf, emailData{Subject: subject, Alerts: alerts}); err != nil {
+		return "", fmt.Errorf("could not render email template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// buildEmailMessage assembles an RFC 5322 message with a quoted-printable HTML body.
+func buildEmailMessage(from string, to []string, subject, html string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(html))
+	qp.Close()
+	return buf.Bytes()
+}
+
+// sendMail delivers one message to the recipients within smtpTimeout, or
+// sooner if ctx is done, so a hung server can't stall the cycle.
+func (e EmailConfig) sendMail(ctx context.Context, to []string, subject, html string) error {
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	msg := buildEmailMessage(e.From, to, subject, html)
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+
+	var tlsConfig *tls.Config
+	if e.Port == 465 {
+		tlsConfig = &tls.Config{ServerName: e.Host}
+	}
+	conn, err := dialContext(ctx, addr, tlsConfig, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if tlsConfig == nil {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: e.Host}); err != nil {
+				return err
+			}
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailAlertNow sends the alerts, in one email, to the immediate recipients and
+// adds them to the digest for the rest.
+func (e EmailConfig) emailAlertNow(ctx context.Context, subject string, alerts ...emailAlert) error {
+	var errs []error
+	delivered := 0
+	if to := e.addresses(false); len(to) > 0 {
+		html, err := e.renderEmail(subject, alerts)
+		if err == nil {
+			err = e.sendMail(ctx, to, subject, html)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			delivered++
+		}
+	}
+	if len(e.addresses(true)) > 0 {
+		digest, err := loadEmailDigest(e.DigestFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not load digest: %w", err))
+		}
+		digest.Alerts = append(digest.Alerts, alerts...)
+		if err := saveEmailDigest(e.DigestFile, digest); err != nil {
+			errs = append(errs, fmt.Errorf("could not save digest: %w", err))
+		} else {
+			delivered++
+		}
+	}
+	return targetErrors(delivered, errs)
+}
+
+// sendToEmail emails a new-incident alert.
+func sendToEmail(ctx context.Context, cfg EmailConfig, incident Incident, parsedTime time.Time) error {
+	subject := fmt.Sprintf("New %s: %s, %s", incident.IncidentType, orNA(incident.Road), orNA(incident.City))
+	return cfg.emailAlertNow(ctx, subject, newEmailAlert(incident, parsedTime, cfg.maps))
+}
+
+// sendClearedNotificationToEmail emails a cleared notification.
+func sendClearedNotificationToEmail(ctx context.Context, cfg EmailConfig, incident ClearedIncident) error {
+	subject := fmt.Sprintf("Cleared: %s, %s", orNA(incident.Road), orNA(incident.City))
+	return cfg.emailAlertNow(ctx, subject, newClearedEmailAlert(incident))
+}
+
+// sendUpdateToEmail emails an escalation, reopening or update, with what changed in the title.
+func sendUpdateToEmail(ctx context.Context, cfg EmailConfig, n Notification) error {
+	incident := n.Incident
+	alert := newEmailAlert(incident, n.StartTime, cfg.maps)
+	alert.Title = updateTitle(n) + ": " + strings.Join(n.Changes, "; ")
+	subject := fmt.Sprintf("%s: %s, %s", updateTitle(n), orNA(incident.Road), orNA(incident.City))
+	return cfg.emailAlertNow(ctx, subject, alert)
+}
+
+// flushEmailDigest sends the pending digest once the interval has passed since
+// the last one. The digest is kept if sending fails so nothing is lost.
+func flushEmailDigest(ctx context.Context, cfg EmailConfig) error {
+	to := cfg.addresses(true)
+	if len(to) == 0 {
+		return nil
+	}
+	digest, err := loadEmailDigest(cfg.DigestFile)
+	if err != nil {
+		return fmt.Errorf("could not load digest: %w", err)
+	}
+	if len(digest.Alerts) == 0 || time.Since(digest.LastSent) < cfg.DigestInterval {
+		return nil
+	}
+
+	subject := fmt.Sprintf("NC DOT digest: %d alerts", len(digest.Alerts))
+	html, err := cfg.renderEmail(subject, digest.Alerts)
+	if err == nil {
+		err = cfg.sendMail(ctx, to, subject, html)
+	}
+	if err != nil {
+		return fmt.Errorf("could not send digest: %w", err)
+	}
+	slog.Info("Sent email digest", "alerts", len(digest.Alerts), "recipients", len(to))
+	if err := saveEmailDigest(cfg.DigestFile, emailDigest{LastSent: time.Now()}); err != nil {
+		return fmt.Errorf("could not save digest: %w", err)
+	}
+	return nil
+}
+
+// emailNotifier delivers notifications by email.
+type emailNotifier struct {
+	cfg EmailConfig
+}
+
+func init() {
+	registerNotifier("email", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Email.enabled() {
+			return nil
+		}
+		email := cfg.Email
+		email.maps = cfg.staticMap()
+		return emailNotifier{cfg: email}
+	})
+}
+
+func (e emailNotifier) Name() string { return "email" }
+
+// NotifyBatch emails every alert of the batch in one message.
+func (e emailNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	alerts := make([]emailAlert, len(batch))
+	for i, n := range batch {
+		alerts[i] = newEmailAlert(n.Incident, n.StartTime, e.cfg.maps)
+	}
+	return e.cfg.emailAlertNow(ctx, "NC DOT: "+digestTitle(batch), alerts...)
+}
+
+func (e emailNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedNotificationToEmail(ctx, e.cfg, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return sendUpdateToEmail(ctx, e.cfg, n)
+	}
+	return sendToEmail(ctx, e.cfg, n.Incident, n.StartTime)
+}
+
+// Flush sends the digest when it is due.
+func (e emailNotifier) Flush(ctx context.Context) error {
+	return flushEmailDigest(ctx, e.cfg)
+}
+
+// loadEmailDigest reads the pending digest; a missing file is an empty digest.
+func loadEmailDigest(filename string) (emailDigest, error) {
+	var digest emailDigest
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return digest, nil
+	} else if err != nil {
+		return digest, err
+	}
+	if err := json.Unmarshal(data, &digest); err != nil {
+		return emailDigest{}, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	return digest, nil
+}
+
+// saveEmailDigest writes the pending digest back to disk.
+func saveEmailDigest(filename string, digest emailDigest) error {
+	data, err := json.MarshalIndent(digest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
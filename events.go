@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Incident lifecycle events published to event sinks.
+const (
+	eventCreated = "created"
+	eventUpdated = "updated"
+	eventCleared = "cleared"
+)
+
+// EventsConfig publishes incident lifecycle events to machine consumers such as
+// message brokers. Unlike notifications, events cover every incident that passes
+// the incident type filter, regardless of the alert filters or quiet hours.
+// StateFile remembers the last version of each active incident, to tell created
+// from updated and to include the full incident in cleared events.
+type EventsConfig struct {
+	StateFile string       `yaml:"state_file"`
+	MQTT      MQTTConfig   `yaml:"mqtt"`
+	Kafka     KafkaConfig  `yaml:"kafka"`
+	NATS      NATSConfig   `yaml:"nats"`
+	SNS       SNSConfig    `yaml:"sns"`
+	PubSub    PubSubConfig `yaml:"pubsub"`
+	AMQP      AMQPConfig   `yaml:"amqp"`
+
+	// hub passes events to the API's live clients, when run serves the API.
+	hub *eventHub
+}
+
+// enabled reports whether any event sink is configured.
+func (e EventsConfig) enabled() bool {
+	return e.MQTT.enabled() || e.Kafka.enabled() || e.NATS.enabled() || e.SNS.enabled() ||
+		e.PubSub.enabled() || e.AMQP.enabled() || e.hub != nil
+}
+
+// IncidentEvent is one change to an incident.
+type IncidentEvent struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Incident Incident  `json:"incident"`
+
+	// Previous is the version an update replaces, so sinks keyed on the
+	// incident's fields can tell when its key changed.
+	Previous *Incident `json:"-"`
+}
+
+// eventTracker holds the last seen version of every active incident.
+type eventTracker struct {
+	filename string
+	seen     map[int]Incident
+}
+
+// loadEventTracker reads the tracker state; a missing file starts empty, so the
+// first run reports every active incident as created.
+func loadEventTracker(filename string) (*eventTracker, error) {
+	t := &eventTracker{filename: filename, seen: make(map[int]Incident)}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return t, nil
+	} else if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(data, &t.seen); err != nil {
+		return &eventTracker{filename: filename, seen: make(map[int]Incident)}, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	return t, nil
+}
+
+// observe records an incident from the feed and returns the event it represents,
+// if it is new or has changed since the last cycle.
+func (t *eventTracker) observe(incident Incident) (IncidentEvent, bool) {
+	previous, ok := t.seen[incident.ID]
+	t.seen[incident.ID] = incident
+	switch {
+	case !ok:
+		return IncidentEvent{Event: eventCreated, Time: time.Now().UTC(), Incident: incident}, true
+	case !reflect.DeepEqual(previous, incident):
+		return IncidentEvent{Event: eventUpdated, Time: time.Now().UTC(), Incident: incident, Previous: &previous}, true
+	default:
+		return IncidentEvent{}, false
+	}
+}
+
+// clear forgets a cleared incident and returns its event, built from the last
+// version seen in the feed when there is one.
+func (t *eventTracker) clear(cleared ClearedIncident) IncidentEvent {
+	incident, ok := t.seen[cleared.ID]
+	if !ok {
+		incident = Incident{
+			ID:           cleared.ID,
+			CountyID:     cleared.CountyID,
+			IncidentType: cleared.IncidentType,
+			Severity:     cleared.Severity,
+			Road:         cleared.Road,
+			Location:     cleared.Location,
+			City:         cleared.City,
+		}
+	}
+	delete(t.seen, cleared.ID)
+	return IncidentEvent{Event: eventCleared, Time: time.Now().UTC(), Incident: incident}
+}
+
+// save writes the tracker state back to disk.
+func (t *eventTracker) save() error {
+	data, err := json.MarshalIndent(t.seen, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(t.filename, data)
+}
+
+// publishEvents sends a cycle's events to every configured sink.
+func publishEvents(ctx context.Context, cfg EventsConfig, events []IncidentEvent) {
+	if len(events) == 0 {
+		return
+	}
+	if cfg.hub != nil {
+		cfg.hub.publish(events)
+	}
+	if cfg.MQTT.enabled() {
+		if err := publishToMQTT(ctx, cfg.MQTT, events); err != nil {
+			slog.Error("Error publishing events", "channel", "mqtt", "err", err)
+		}
+	}
+	if cfg.Kafka.enabled() {
+		if err := publishToKafka(ctx, cfg.Kafka, events); err != nil {
+			slog.Error("Error publishing events", "channel", "kafka", "err", err)
+		}
+	}
+	if cfg.NATS.enabled() {
+		if err := publishToNATS(ctx, cfg.NATS, events); err != nil {
+			slog.Error("Error publishing events", "channel", "nats", "err", err)
+		}
+	}
+	if cfg.SNS.enabled() {
+		if err := publishToSNS(ctx, cfg.SNS, events); err != nil {
+			slog.Error("Error publishing events", "channel", "sns", "err", err)
+		}
+	}
+	if cfg.PubSub.enabled() {
+		if err := publishToPubSub(ctx, cfg.PubSub, events); err != nil {
+			slog.Error("Error publishing events", "channel", "pubsub", "err", err)
+		}
+	}
+	if cfg.AMQP.enabled() {
+		if err := publishToAMQP(ctx, cfg.AMQP, events); err != nil {
+			slog.Error("Error publishing events", "channel", "amqp", "err", err)
+		}
+	}
+}
+
+// slug lower-cases a name for use in topics and routing keys, e.g. "Vehicle Crash"
+// becomes "vehicle-crash".
+func slug(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// expandTopic fills an event's placeholders into a topic or subject pattern:
+// {event}, {id}, {county} (name), {county_id}, {type} and {severity}.
+func expandTopic(pattern string, event IncidentEvent) string {
+	county := slug(event.Incident.CountyName)
+	if county == "" {
+		county = fmt.Sprint(event.Incident.CountyID)
+	}
+	return strings.NewReplacer(
+		"{event}", event.Event,
+		"{id}", fmt.Sprint(event.Incident.ID),
+		"{county}", county,
+		"{county_id}", fmt.Sprint(event.Incident.CountyID),
+		"{type}", slug(event.Incident.IncidentType),
+		"{severity}", fmt.Sprint(event.Incident.Severity),
+	).Replace(pattern)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mtickle/crash-reporting/geo"
+)
+
+// ExitConfig resolves incidents on major routes to the nearest exit or mile
+// marker, e.g. "I-40 EB near Exit 293", from a reference file of them. File is
+// a CSV with the header route,kind,label,latitude,longitude, where kind is
+// "exit" or "mile" and label the exit number or mile. Only points on the
+// incident's route within MaxDistanceMiles of it are used.
+type ExitConfig struct {
+	File             string  `yaml:"file"`
+	MaxDistanceMiles float64 `yaml:"max_distance_miles"`
+
+	// routes are the reference points by route, e.g. "I-40", loaded by load.
+	routes map[string][]routeMarker
+}
+
+// routeMarker is an exit or mile marker on a route.
+type routeMarker struct {
+	// Kind is "exit" or "mile".
+	Kind  string
+	Label string
+	At    geo.Point
+}
+
+// String names the marker, e.g. "Exit 293" or "mile marker 291".
+func (m routeMarker) String() string {
+	if m.Kind == "mile" {
+		return "mile marker " + m.Label
+	}
+	return "Exit " + m.Label
+}
+
+// load reads the reference file, if one is configured.
+func (e *ExitConfig) load() error {
+	if e.File == "" {
+		return nil
+	}
+	f, err := os.Open(e.File)
+	if err != nil {
+		return fmt.Errorf("could not read exits file: %w", err)
+	}
+	defer f.Close()
+	routes, err := parseRouteMarkers(f)
+	if err != nil {
+		return fmt.Errorf("could not parse exits file %s: %w", e.File, err)
+	}
+	e.routes = routes
+	return nil
+}
+
+// parseRouteMarkers reads the reference CSV. Lines starting with # are comments.
+func parseRouteMarkers(r io.Reader) (map[string][]routeMarker, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 5
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(strings.Join(header, ",")) != "route,kind,label,latitude,longitude" {
+		return nil, errors.New("the header must be route,kind,label,latitude,longitude")
+	}
+	routes := make(map[string][]routeMarker)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		route := routeDesignation(record[0])
+		if route == "" {
+			return nil, fmt.Errorf("line %d: %q is not a route such as I-40 or US-70", line, record[0])
+		}
+		kind := strings.ToLower(record[1])
+		if kind != "exit" && kind != "mile" {
+			return nil, fmt.Errorf("line %d: kind must be exit or mile, got %q", line, record[1])
+		}
+		lat, latErr := strconv.ParseFloat(record[3], 64)
+		lon, lonErr := strconv.ParseFloat(record[4], 64)
+		at := geo.Point{Lat: lat, Lon: lon}
+		if latErr != nil || lonErr != nil || !at.Valid() {
+			return nil, fmt.Errorf("line %d: invalid latitude or longitude", line)
+		}
+		routes[route] = append(routes[route], routeMarker{Kind: kind, Label: record[2], At: at})
+	}
+	if len(routes) == 0 {
+		return nil, errors.New("no exits or mile markers")
+	}
+	return routes, nil
+}
+
+// routeDesignation returns the route in a road name, normalised as "I-40",
+// or "" if it names none.
+func routeDesignation(road string) string {
+	return routePrefixPattern.FindString(normalizeRoad(road))
+}
+
+// nearest returns the incident's route and the marker on it closest to the
+// incident, if one is within MaxDistanceMiles.
+func (e ExitConfig) nearest(incident Incident) (string, routeMarker, bool) {
+	at := geo.Point{Lat: incident.Latitude, Lon: incident.Longitude}
+	if len(e.routes) == 0 || !at.Valid() {
+		return "", routeMarker{}, false
+	}
+	route := routeDesignation(incident.Road)
+	if route == "" {
+		route = routeDesignation(incident.CommonName)
+	}
+	var best routeMarker
+	bestMiles := e.MaxDistanceMiles
+	found := false
+	for _, m := range e.routes[route] {
+		if miles := geo.HaversineMiles(at, m.At); miles <= bestMiles {
+			best, bestMiles, found = m, miles, true
+		}
+	}
+	return route, best, found
+}
+
+// directionAbbreviations writes the feed's directions as route directions.
+var directionAbbreviations = map[string]string{
+	"N": "NB", "S": "SB", "E": "EB", "W": "WB",
+	"NORTH": "NB", "SOUTH": "SB", "EAST": "EB", "WEST": "WB",
+	"NB": "NB", "SB": "SB", "EB": "EB", "WB": "WB",
+}
+
+// describe returns where on its route the incident is, e.g. "I-40 EB near
+// Exit 293", or "" when no marker is near.
+func (e ExitConfig) describe(incident Incident) string {
+	route, m, ok := e.nearest(incident)
+	if !ok {
+		return ""
+	}
+	if dir := directionAbbreviations[strings.ToUpper(strings.TrimSpace(incident.Direction))]; dir != "" {
+		route += " " + dir
+	}
+	return route + " near " + m.String()
+}
+
+// enrich puts the nearest exit or mile marker in front of the incident's
+// location, or in place of a vague one.
+func (e ExitConfig) enrich(incident Incident) Incident {
+	where := e.describe(incident)
+	switch {
+	case where == "":
+	case vagueLocation(incident.Location):
+		incident.Location = where
+	default:
+		incident.Location = where + " · " + incident.Location
+	}
+	return incident
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportColumn is a column of a tabular export: a field of StoredIncident,
+// named by its database column, or of an incident's recorded changes.
+type exportColumn struct {
+	Name string
+	// Type is the type of the column's values: string, int, float64, bool or
+	// time.Time. Nullable columns may also be nil.
+	Type     reflect.Type
+	Nullable bool
+	// index is the field's index in StoredIncident; nil for change columns.
+	index []int
+}
+
+var (
+	stringType = reflect.TypeOf("")
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+// incidentExportColumns are the StoredIncident fields in the order they are
+// declared, which keeps exports' columns stable as fields are added at the end.
+var incidentExportColumns = func() []exportColumn {
+	var columns []exportColumn
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			at := append(append([]int{}, index...), i)
+			if f.Anonymous {
+				walk(f.Type, at)
+				continue
+			}
+			name := f.Tag.Get("db")
+			if name == "" || name == "-" {
+				continue
+			}
+			c := exportColumn{Name: name, Type: f.Type, index: at}
+			if c.Type.Kind() == reflect.Pointer {
+				c.Type, c.Nullable = c.Type.Elem(), true
+			}
+			if c.Type == reflect.TypeOf(FeedTime{}) {
+				c.Type, c.Nullable = timeType, true
+			}
+			columns = append(columns, c)
+		}
+	}
+	walk(reflect.TypeOf(StoredIncident{}), nil)
+	return columns
+}()
+
+// changeExportColumns are the columns added for an incident's recorded
+// changes, empty for an incident without any.
+var changeExportColumns = []exportColumn{
+	{Name: "change_observed_at", Type: timeType, Nullable: true},
+	{Name: "change_field", Type: stringType, Nullable: true},
+	{Name: "change_old_value", Type: stringType, Nullable: true},
+	{Name: "change_new_value", Type: stringType, Nullable: true},
+	{Name: "change_last_update", Type: timeType, Nullable: true},
+}
+
+// exportColumns returns the columns of an export, with the change columns
+// when it includes the incidents' history.
+func exportColumns(withChanges bool) []exportColumn {
+	columns := incidentExportColumns
+	if withChanges {
+		columns = append(columns[:len(columns):len(columns)], changeExportColumns...)
+	}
+	return columns
+}
+
+// eachExportRow calls fn with each row of an export, the values in the order
+// of exportColumns. With changes, each recorded change gets a row of its own
+// repeating its incident, and incidents without any get one row with the
+// change columns nil. fn must not keep the row.
+func eachExportRow(incidents []StoredIncident, changes map[int][]IncidentChange, fn func(row []any) error) error {
+	n := len(incidentExportColumns)
+	row := make([]any, n, n+len(changeExportColumns))
+	for _, si := range incidents {
+		for i, c := range incidentExportColumns {
+			row[i] = c.value(si)
+		}
+		if changes == nil {
+			if err := fn(row); err != nil {
+				return err
+			}
+			continue
+		}
+		history := changes[si.ID]
+		if len(history) == 0 {
+			if err := fn(append(row[:n], nil, nil, nil, nil, nil)); err != nil {
+				return err
+			}
+		}
+		for _, ch := range history {
+			var lastUpdate any
+			if !ch.LastUpdate.IsZero() {
+				lastUpdate = ch.LastUpdate.Time
+			}
+			if err := fn(append(row[:n], ch.ObservedAt, ch.Field, ch.OldValue, ch.NewValue, lastUpdate)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// value returns the column's value for the incident: a string, int, float64,
+// bool or time.Time, or nil when it is unset.
+func (c exportColumn) value(si StoredIncident) any {
+	v := reflect.ValueOf(si).FieldByIndex(c.index)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch x := v.Interface().(type) {
+	case FeedTime:
+		if x.IsZero() {
+			return nil
+		}
+		return x.Time
+	default:
+		return x
+	}
+}
+
+// csvValue formats a column value for CSV, with times in UTC as RFC 3339.
+// Text that a spreadsheet would take for a formula, starting with =, +, -, @,
+// a tab or a carriage return, gets a leading apostrophe so it opens as text.
+func csvValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if x != "" && strings.ContainsRune("=+-@\t\r", rune(x[0])) {
+			return "'" + x
+		}
+		return x
+	case time.Time:
+		return x.UTC().Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// writeIncidentsCSV writes the incidents as CSV with a header row, with their
+// changes as eachExportRow lays them out unless changes is nil.
+func writeIncidentsCSV(out io.Writer, incidents []StoredIncident, changes map[int][]IncidentChange) error {
+	w := csv.NewWriter(out)
+	columns := exportColumns(changes != nil)
+	record := make([]string, len(columns))
+	for i, c := range columns {
+		record[i] = c.Name
+	}
+	if err := w.Write(record); err != nil {
+		return err
+	}
+	err := eachExportRow(incidents, changes, func(row []any) error {
+		for i, v := range row {
+			record[i] = csvValue(v)
+		}
+		return w.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// exportedIncident is an incident in a JSON export with its recorded changes.
+type exportedIncident struct {
+	StoredIncident
+	History []IncidentChange `json:"history"`
+}
+
+// exportCommand implements the "export" subcommand, writing stored incidents to stdout.
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := addConfigFlag(fs)
+	status := fs.String("status", "all", "only export incidents with this status: active, cleared or all")
+	format := fs.String("format", "json", "output format: json, csv, or parquet for analytics tools such as DuckDB, Spark and pandas")
+	since := fs.String("since", "", "only export incidents starting since this many days (30d), this long ago (12h) or this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only export incidents starting before this many days (30d), this long ago (12h) or this date (YYYY-MM-DD)")
+	withHistory := fs.Bool("history", false, "include each incident's recorded changes; in CSV and Parquet, one row per change")
+	fs.Parse(args)
+
+	if *status != "all" && *status != "active" && *status != "cleared" {
+		return fmt.Errorf("--status must be active, cleared or all, got %q", *status)
+	}
+	switch *format {
+	case "json", "csv":
+	case "parquet":
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return errors.New("--format parquet writes a binary file; redirect the output to one")
+		}
+	default:
+		return fmt.Errorf("--format must be json, csv or parquet, got %q", *format)
+	}
+
+	cfg, err := loadCommandConfig(fs, *configPath, false)
+	if err != nil {
+		return err
+	}
+	loc := cfg.Reports.loc
+	now := time.Now().In(loc)
+	var from, to time.Time
+	if *since != "" {
+		if from, err = parseTimeFlag("since", *since, now, loc); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if to, err = parseTimeFlag("until", *until, now, loc); err != nil {
+			return err
+		}
+	}
+
+	store, err := openStore(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	q := incidentQuery{Status: *status, StartedSince: from, StartedBefore: to, Limit: math.MaxInt32}
+	incidents, _, err := store.FindIncidents(ctx, q)
+	if err != nil {
+		return fmt.Errorf("could not query incidents: %w", err)
+	}
+	var changes map[int][]IncidentChange
+	if *withHistory {
+		ids := make([]int, len(incidents))
+		for i, si := range incidents {
+			ids[i] = si.ID
+		}
+		if changes, err = store.IncidentChangesByID(ctx, ids); err != nil {
+			return fmt.Errorf("could not query incident history: %w", err)
+		}
+	}
+
+	switch *format {
+	case "csv":
+		return writeIncidentsCSV(os.Stdout, incidents, changes)
+	case "parquet":
+		out := bufio.NewWriter(os.Stdout)
+		if err := writeIncidentsParquet(out, incidents, changes); err != nil {
+			return err
+		}
+		return out.Flush()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if changes == nil {
+		return enc.Encode(incidents)
+	}
+	exported := make([]exportedIncident, len(incidents))
+	for i, si := range incidents {
+		exported[i] = exportedIncident{StoredIncident: si, History: changes[si.ID]}
+		if exported[i].History == nil {
+			exported[i].History = []IncidentChange{}
+		}
+	}
+	return enc.Encode(exported)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCSVValue(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"null", nil, ""},
+		{"text", "I-40 West", "I-40 West"},
+		{"empty", "", ""},
+		{"formula", "=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"plus", "+1 lane", "'+1 lane"},
+		{"minus", "-2+3", "'-2+3"},
+		{"at", "@SUM(A1)", "'@SUM(A1)"},
+		{"tab", "\t=1", "'\t=1"},
+		{"carriage return", "\r=1", "'\r=1"},
+		{"formula later on", "a=b", "a=b"},
+		// Numbers are written as numbers, negative or not.
+		{"negative float", -78.64, "-78.64"},
+		{"negative int", -1, "-1"},
+		{"bool", true, "true"},
+		{"time", time.Date(2024, 5, 1, 7, 0, 0, 0, time.FixedZone("EDT", -4*3600)), "2024-05-01T11:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := csvValue(tt.v); got != tt.want {
+				t.Errorf("csvValue(%#v) = %q, want %q", tt.v, got, tt.want)
+			}
+		})
+	}
+}
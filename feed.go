@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// fetchFeed fetches every configured feed. With an explicit feed URL it makes a
+// single request and returns a nil county set, meaning the result covers everything.
+// Statewide mode also makes one request, see fetchStatewide. Otherwise the counties
+// are fetched by cfg.Workers at a time; a failing county is logged and left
+// out of the returned set so callers don't mistake its crashes for cleared ones.
+// Every response is passed to snapshots, and every payload to schema for its
+// drift check; either may be nil.
+//
+// Requests are conditional on the validators in cache, which may also be nil.
+// A county that answers 304 is left out of the returned set like a failed one,
+// and when nothing changed at all fetchFeed returns errFeedNotModified.
+func fetchFeed(ctx context.Context, cfg FeedConfig, snapshots *snapshotRecorder, schema *schemaMonitor, cache *feedCache) ([]Incident, map[int]bool, error) {
+	if cfg.URL != "" {
+		incidents, err := fetchWithRetry(ctx, cfg, cfg.URL, 0, snapshots, schema, cache)
+		return incidents, nil, err
+	}
+	if cfg.Statewide {
+		return fetchStatewide(ctx, cfg, snapshots, schema, cache)
+	}
+
+	type result struct {
+		countyID  int
+		incidents []Incident
+		err       error
+	}
+	results := make(chan result, len(cfg.Counties))
+	forEachConcurrently(cfg.Counties, cfg.Workers, func(countyID int) {
+		incidents, err := fetchWithRetry(ctx, cfg, fmt.Sprintf(countyFeedURL, countyID), countyID, snapshots, schema, cache)
+		results <- result{countyID, incidents, err}
+	})
+	close(results)
+
+	var all []Incident
+	fetched := make(map[int]bool)
+	unchanged := 0
+	var lastErr error
+	for r := range results {
+		if errors.Is(r.err, errFeedNotModified) {
+			unchanged++
+			continue
+		}
+		if r.err != nil {
+			slog.Error("Error fetching county", "county", r.countyID, "err", r.err)
+			lastErr = r.err
+			continue
+		}
+		fetched[r.countyID] = true
+		for _, incident := range r.incidents {
+			// Tag incidents with the county they were fetched for if the feed left it blank.
+			if incident.CountyID == 0 {
+				incident.CountyID = r.countyID
+			}
+			all = append(all, incident)
+		}
+	}
+	if len(fetched) == 0 {
+		if unchanged > 0 {
+			return nil, nil, errFeedNotModified
+		}
+		return nil, nil, fmt.Errorf("every county fetch failed, last error: %w", lastErr)
+	}
+	return all, fetched, nil
+}
+
+// fetchIncidents downloads and decodes the incident list from an NCDOT feed URL,
+// fetched for countyID (0 for none), and records the response in snapshots.
+// The request is conditional on cache; a 304 returns errFeedNotModified and
+// isn't recorded, as it has nothing to replay.
+func fetchIncidents(ctx context.Context, url string, countyID int, snapshots *snapshotRecorder, schema *schemaMonitor, cache *feedCache) (incidents []Incident, err error) {
+	ctx, span := startSpan(ctx, "feed.fetch", spanKindClient)
+	defer func() {
+		if errors.Is(err, errFeedNotModified) {
+			span.finish(nil)
+		} else {
+			span.finish(err)
+		}
+	}()
+	span.set("url.full", url)
+	span.set("ncdot.county_id", countyID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building feed request: %w", err)
+	}
+	if span != nil {
+		req.Header.Set("Traceparent", span.traceparent())
+	}
+	cache.prepare(req)
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching data: %w", err)
+	}
+	defer resp.Body.Close()
+
+	span.set("http.response.status_code", resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	span.set("http.response.body.size", len(body))
+	moduleLogger(logModuleFeed).Debug("Fetched feed", "url", url, "county", countyID,
+		"status", resp.StatusCode, "bytes", len(body), "duration", time.Since(start))
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errFeedNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = &feedStatusError{code: resp.StatusCode, status: resp.Status}
+	} else {
+		_, decodeSpan := startSpan(ctx, "feed.decode", spanKindInternal)
+		incidents, err = decodeIncidents(body)
+		decodeSpan.set("ncdot.incidents", len(incidents))
+		decodeSpan.finish(err)
+		if err == nil {
+			schema.record(url, body)
+			cache.record(url, resp.Header)
+		}
+	}
+	snapshot := feedSnapshot{
+		FetchedAt:  start,
+		URL:        url,
+		CountyID:   countyID,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Duration:   time.Since(start),
+		Payload:    body,
+	}
+	if err != nil {
+		snapshot.Error = err.Error()
+	}
+	snapshots.record(snapshot)
+	return incidents, err
+}
+
+// feedStatusError is a feed response with a status other than 200 OK.
+type feedStatusError struct {
+	code   int
+	status string
+}
+
+func (e *feedStatusError) Error() string {
+	return "feed returned " + e.status
+}
+
+// fetchWithRetry fetches a feed URL with fetchIncidents, retrying failures
+// cfg.Retries times with exponential backoff. Each wait is jittered between
+// half and all of the backoff, so the county fetches don't retry in lockstep.
+// Client errors (4xx other than 429) aren't retried, since they won't fix
+// themselves.
+func fetchWithRetry(ctx context.Context, cfg FeedConfig, url string, countyID int, snapshots *snapshotRecorder, schema *schemaMonitor, cache *feedCache) ([]Incident, error) {
+	backoff := cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		incidents, err := fetchIncidents(ctx, url, countyID, snapshots, schema, cache)
+		if err == nil || attempt >= cfg.Retries || !retryableFeedError(err) {
+			return incidents, err
+		}
+		wait := backoff/2 + rand.N(backoff/2+1)
+		slog.Warn("Feed fetch failed. Retrying.", "url", url, "county", countyID,
+			"attempt", attempt+1, "max_retries", cfg.Retries, "wait", wait, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// retryableFeedError reports whether a failed fetch may succeed if retried.
+func retryableFeedError(err error) bool {
+	var statusErr *feedStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code == http.StatusTooManyRequests || statusErr.code >= 500
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, errFeedNotModified)
+}
+
+// decodeIncidents parses a raw feed payload, as served by NCDOT or saved to disk.
+// A field of an unexpected type is left at its zero value rather than losing the
+// whole payload; schemaMonitor reports it.
+func decodeIncidents(data []byte) ([]Incident, error) {
+	var incidents []Incident
+	err := json.Unmarshal(data, &incidents)
+	var typeErr *json.UnmarshalTypeError
+	if err != nil && !errors.As(err, &typeErr) {
+		return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
+	}
+	return incidents, nil
+}
+
+// fetchStatewide pulls every incident in the state with a single request and keeps
+// only those in the configured counties and regions. The returned county set is the
+// filter itself (nil when unfiltered), since the one call covers all of those counties.
+func fetchStatewide(ctx context.Context, cfg FeedConfig, snapshots *snapshotRecorder, schema *schemaMonitor, cache *feedCache) ([]Incident, map[int]bool, error) {
+	incidents, err := fetchWithRetry(ctx, cfg, statewideFeedURL, 0, snapshots, schema, cache)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := cfg.countyFilter()
+	if filter == nil {
+		return incidents, nil, nil
+	}
+
+	var kept []Incident
+	for _, incident := range incidents {
+		if filter[incident.CountyID] {
+			kept = append(kept, incident)
+		}
+	}
+	slog.Info("Fetched the statewide feed", "count", len(incidents), "in_counties", len(kept))
+	return kept, filter, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// errFeedNotModified is a fetch the feed answered with 304 Not Modified: the
+// incidents are the same as on the last full fetch.
+var errFeedNotModified = errors.New("feed not modified")
+
+// feedValidators are the validators a feed URL last answered with, sent back
+// in If-None-Match and If-Modified-Since so an unchanged feed answers 304.
+type feedValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// feedCache keeps each feed URL's validators in feed.cache_file between runs.
+// A cycle's new validators are only kept once the cycle has stored its
+// incidents and delivered every alert, so a 304 never hides work a failed
+// cycle left undone. A nil cache makes every fetch unconditional.
+type feedCache struct {
+	filename string
+
+	mu         sync.Mutex
+	validators map[string]feedValidators
+	// fetched holds the validators of this cycle's full responses, by URL.
+	fetched map[string]feedValidators
+}
+
+// loadFeedCache reads the cache file. A missing file is an empty cache.
+func loadFeedCache(filename string) (*feedCache, error) {
+	c := &feedCache{
+		filename:   filename,
+		validators: make(map[string]feedValidators),
+		fetched:    make(map[string]feedValidators),
+	}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return c, nil
+	} else if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c.validators); err != nil {
+		c.validators = make(map[string]feedValidators)
+		return c, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	return c, nil
+}
+
+// prepare makes req conditional on the validators cached for its URL.
+func (c *feedCache) prepare(req *http.Request) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	v := c.validators[req.URL.String()]
+	c.mu.Unlock()
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// record notes the validators of a full response from url.
+func (c *feedCache) record(url string, header http.Header) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetched[url] = feedValidators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+}
+
+// save writes the cache back to disk. After a complete cycle the validators
+// of this cycle's responses replace the old ones; otherwise every validator
+// is dropped, so the next cycle fetches the whole feed again.
+func (c *feedCache) save(complete bool) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !complete {
+		c.validators = make(map[string]feedValidators)
+	}
+	for url, v := range c.fetched {
+		if complete && (v.ETag != "" || v.LastModified != "") {
+			c.validators[url] = v
+		} else {
+			delete(c.validators, url)
+		}
+	}
+	c.fetched = make(map[string]feedValidators)
+	data, err := json.MarshalIndent(c.validators, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.filename, data)
+}
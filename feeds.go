@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// feedIncidents is how many of the newest matching incidents a feed covers
+// unless the limit parameter asks for another number.
+const feedIncidents = 50
+
+// feedItem is one entry of the RSS and Atom feeds: an incident appearing or
+// clearing.
+type feedItem struct {
+	guid    string
+	title   string
+	summary string
+	link    string
+	date    time.Time
+}
+
+// incidentFeedItems lists the new and cleared entries for incidents, newest
+// first. Entries for incidents without a parseable start time sort last. IDs
+// are the incidents' API URLs under base, with a fragment for the entry.
+func incidentFeedItems(base string, incidents []StoredIncident) []feedItem {
+	var items []feedItem
+	for _, si := range incidents {
+		where := strings.TrimSuffix(fmt.Sprintf("%s at %s, %s", orNA(si.Road), orNA(si.Location), si.City), ", ")
+		link := ""
+		if si.Latitude != 0 || si.Longitude != 0 {
+			link = mapLink(si.Latitude, si.Longitude)
+		}
+		summary := digestLine(si.Incident)
+		if si.Reason != "" {
+			summary += "\n" + si.Reason
+		}
+		items = append(items, feedItem{
+			guid:    fmt.Sprintf("%s/api/incidents/%d#new", base, si.ID),
+			title:   fmt.Sprintf("%s: %s", si.IncidentType, where),
+			summary: summary,
+			link:    link,
+			date:    si.StartTime.Time,
+		})
+		if si.Status == "cleared" && si.ClearedTime != nil {
+			title := fmt.Sprintf("Cleared %s: %s", si.IncidentType, where)
+			if si.DurationSeconds != nil && *si.DurationSeconds > 0 {
+				title += " after " + formatDuration(time.Duration(*si.DurationSeconds)*time.Second)
+			}
+			items = append(items, feedItem{
+				// A reopened incident can clear again, so the time is part of the ID.
+				guid:    fmt.Sprintf("%s/api/incidents/%d#cleared-%d", base, si.ID, si.ClearedTime.Unix()),
+				title:   title,
+				summary: digestLine(si.Incident),
+				link:    link,
+				date:    *si.ClearedTime,
+			})
+		}
+	}
+	slices.SortStableFunc(items, func(a, b feedItem) int { return b.date.Compare(a.date) })
+	return items
+}
+
+// loadFeedItems reads a feed's entries, filtered by the incident list's
+// parameters and covering the newest feedIncidents incidents unless limit is
+// given. It writes the error response and returns false on failure.
+func (a *apiServer) loadFeedItems(w http.ResponseWriter, r *http.Request) ([]feedItem, bool) {
+	q, err := parseIncidentQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if !r.URL.Query().Has("limit") {
+		q.Limit = feedIncidents
+	}
+	q.Newest = true
+	incidents, _, err := a.store.FindIncidents(r.Context(), q)
+	if err != nil {
+		slog.Error("API: error querying incidents", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not query incidents")
+		return nil, false
+	}
+	return incidentFeedItems(baseURL(r), incidents), true
+}
+
+// feedTitle names a feed after its filters.
+func feedTitle(r *http.Request) string {
+	params := r.URL.Query()
+	var filters []string
+	if road := params.Get("road"); road != "" {
+		filters = append(filters, road)
+	}
+	if t := params.Get("type"); t != "" {
+		filters = append(filters, t)
+	}
+	if county := params.Get("county"); county != "" {
+		filters = append(filters, "county "+county)
+	}
+	if len(filters) == 0 {
+		return "NCDOT traffic incidents"
+	}
+	return "NCDOT traffic incidents: " + strings.Join(filters, ", ")
+}
+
+// baseURL is the scheme and host a request was made to.
+func baseURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// rssFeed is an RSS 2.0 document.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Self        atomLink  `xml:"atom:link"`
+	Description string    `xml:"description"`
+	BuildDate   string    `xml:"lastBuildDate"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link,omitempty"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// serveRSS serves the incidents as an RSS 2.0 feed, filtered like the list.
+func (a *apiServer) serveRSS(w http.ResponseWriter, r *http.Request) {
+	items, ok := a.loadFeedItems(w, r)
+	if !ok {
+		return
+	}
+	self := baseURL(r) + r.URL.RequestURI()
+	feed := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       feedTitle(r),
+			Link:        self,
+			Self:        atomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+			Description: "New and cleared incidents from the NCDOT traveler information feed.",
+			BuildDate:   time.Now().UTC().Format(time.RFC1123Z),
+			Items:       []rssItem{},
+		},
+	}
+	for _, item := range items {
+		ri := rssItem{
+			Title:       item.title,
+			Link:        item.link,
+			Description: item.summary,
+			GUID:        rssGUID{Value: item.guid},
+		}
+		if !item.date.IsZero() {
+			ri.PubDate = item.date.UTC().Format(time.RFC1123Z)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, ri)
+	}
+	writeXML(w, "application/rss+xml; charset=utf-8", feed)
+}
+
+// atomFeed is an Atom 1.0 document.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string    `xml:"id"`
+	Title   string    `xml:"title"`
+	Updated string    `xml:"updated"`
+	Link    *atomLink `xml:"link"`
+	Summary string    `xml:"summary"`
+}
+
+// serveAtom serves the incidents as an Atom feed, filtered like the list.
+// Atom requires an updated time, so entries without one use the feed's.
+func (a *apiServer) serveAtom(w http.ResponseWriter, r *http.Request) {
+	items, ok := a.loadFeedItems(w, r)
+	if !ok {
+		return
+	}
+	self := baseURL(r) + r.URL.RequestURI()
+	now := time.Now().UTC().Format(time.RFC3339)
+	feed := atomFeed{
+		ID:      self,
+		Title:   feedTitle(r),
+		Updated: now,
+		Author:  atomAuthor{Name: "NCDOT"},
+		Link:    atomLink{Href: self, Rel: "self", Type: "application/atom+xml"},
+	}
+	if len(items) > 0 && !items[0].date.IsZero() {
+		feed.Updated = items[0].date.UTC().Format(time.RFC3339)
+	}
+	for _, item := range items {
+		entry := atomEntry{
+			ID:      item.guid,
+			Title:   item.title,
+			Updated: feed.Updated,
+			Summary: item.summary,
+		}
+		if !item.date.IsZero() {
+			entry.Updated = item.date.UTC().Format(time.RFC3339)
+		}
+		if item.link != "" {
+			entry.Link = &atomLink{Href: item.link, Rel: "alternate"}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	writeXML(w, "application/atom+xml; charset=utf-8", feed)
+}
+
+// writeXML writes v as an XML response body.
+func writeXML(w http.ResponseWriter, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Error("API: error writing response", "err", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// FeedTime is one of the feed's timestamps. The feed sends RFC 3339 text, at
+// times empty or malformed; those are the zero time, which is stored as NULL
+// and marshalled as null, so nothing downstream has to parse text again.
+type FeedTime struct {
+	time.Time
+}
+
+// feedTimeLayouts are tried in order when parsing a timestamp: the feed's
+// RFC 3339, the same without a zone, taken as UTC, and the UTC text the
+// SQLite and MySQL backends store.
+var feedTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// parseFeedTime parses a timestamp, with empty text as the zero time.
+func parseFeedTime(s string) (FeedTime, error) {
+	if s == "" {
+		return FeedTime{}, nil
+	}
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return FeedTime{t}, nil
+		}
+	}
+	return FeedTime{}, fmt.Errorf("%q is not a timestamp", s)
+}
+
+// UnmarshalJSON parses the feed's text. A malformed timestamp is logged and
+// left zero rather than failing the whole payload; a value that isn't text
+// is left to the schema check to report.
+func (t *FeedTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		*t = FeedTime{}
+		return nil
+	}
+	parsed, err := parseFeedTime(s)
+	if err != nil {
+		slog.Warn("Ignoring a malformed feed timestamp", "err", err)
+	}
+	*t = parsed
+	return nil
+}
+
+// MarshalJSON writes the time as RFC 3339 text, or null when unknown.
+func (t FeedTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Format(time.RFC3339))
+}
+
+// String is the time as RFC 3339 text, or empty when unknown.
+func (t FeedTime) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// Value stores an unknown time as NULL.
+func (t FeedTime) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return t.Time, nil
+}
+
+// Scan reads a stored timestamp: NULL, a time, or text from a backend that
+// keeps timestamps as text. Text that doesn't parse reads as unknown.
+func (t *FeedTime) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*t = FeedTime{}
+	case time.Time:
+		*t = FeedTime{v}
+	case string:
+		*t, _ = parseFeedTime(v)
+	case []byte:
+		*t, _ = parseFeedTime(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into a timestamp", src)
+	}
+	return nil
+}
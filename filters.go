@@ -0,0 +1,115 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// IncidentTypeFilter selects incidents by their incidentType. An empty Include list
+// selects every type; Exclude always wins over Include. Matching ignores case.
+type IncidentTypeFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// matches reports whether incidents of the given type pass the filter.
+func (f IncidentTypeFilter) matches(incidentType string) bool {
+	for _, t := range f.Exclude {
+		if strings.EqualFold(t, incidentType) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, t := range f.Include {
+		if strings.EqualFold(t, incidentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the incidents that pass the filter.
+func (f IncidentTypeFilter) apply(incidents []Incident) []Incident {
+	var kept []Incident
+	for _, incident := range incidents {
+		if f.matches(incident.IncidentType) {
+			kept = append(kept, incident)
+		}
+	}
+	return kept
+}
+
+// RoadFilter limits alerts to incidents on particular roads or NCDOT route IDs.
+// Road patterns are matched against the incident's road and common name after
+// normalising route prefixes, so "I-40" also matches "I40", "Interstate 40" and
+// directional variants like "I-40 WB", but not "I-440". A "*" in a pattern matches
+// any run of characters. When any allow list is set an incident must match one of
+// them; a deny match always wins.
+type RoadFilter struct {
+	Allow         []string `yaml:"allow"`
+	Deny          []string `yaml:"deny"`
+	AllowRouteIDs []int    `yaml:"allow_route_ids"`
+	DenyRouteIDs  []int    `yaml:"deny_route_ids"`
+}
+
+// routePrefixPattern finds route designations like "I 40", "US70" or "Interstate 40".
+var routePrefixPattern = regexp.MustCompile(`\b(INTERSTATE|I|US|NC|SR)[\s-]*(\d+)`)
+
+// normalizeRoad upper-cases a road name and writes route designations as "I-40".
+func normalizeRoad(road string) string {
+	road = strings.ToUpper(strings.Join(strings.Fields(road), " "))
+	return routePrefixPattern.ReplaceAllStringFunc(road, func(m string) string {
+		parts := routePrefixPattern.FindStringSubmatch(m)
+		prefix := parts[1]
+		if prefix == "INTERSTATE" {
+			prefix = "I"
+		}
+		return prefix + "-" + parts[2]
+	})
+}
+
+// roadMatches reports whether a normalised road name matches a pattern.
+func roadMatches(pattern, road string) bool {
+	pattern = normalizeRoad(pattern)
+	if strings.Contains(pattern, "*") {
+		re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+		ok, _ := regexp.MatchString(re, road)
+		return ok
+	}
+	if !strings.HasPrefix(road, pattern) {
+		return false
+	}
+	// Only match on a word boundary, so "I-40" doesn't match "I-405".
+	rest := road[len(pattern):]
+	if rest == "" {
+		return true
+	}
+	c := rest[0]
+	return !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+}
+
+// matches reports whether an incident passes the road and route lists.
+func (f RoadFilter) matches(incident Incident) bool {
+	names := []string{normalizeRoad(incident.Road), normalizeRoad(incident.CommonName)}
+	matchesAny := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			for _, name := range names {
+				if name != "" && roadMatches(pattern, name) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if matchesAny(f.Deny) || slices.Contains(f.DenyRouteIDs, incident.RouteID) {
+		return false
+	}
+	if len(f.Allow) == 0 && len(f.AllowRouteIDs) == 0 {
+		return true
+	}
+	return matchesAny(f.Allow) || slices.Contains(f.AllowRouteIDs, incident.RouteID)
+}
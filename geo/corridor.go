@@ -0,0 +1,47 @@
+package geo
+
+import "math"
+
+// Corridor is a buffer around one or more paths, such as a commute traced as
+// a GPX track.
+type Corridor struct {
+	Paths       [][]Point
+	BufferMiles float64
+}
+
+// Contains reports whether p is within the buffer of any path.
+func (c Corridor) Contains(p Point) bool {
+	return c.DistanceMiles(p) <= c.BufferMiles
+}
+
+// DistanceMiles returns how far p is from the nearest path, or +Inf with no
+// paths. A single-point path is a point.
+func (c Corridor) DistanceMiles(p Point) float64 {
+	nearest := math.Inf(1)
+	for _, path := range c.Paths {
+		if len(path) == 1 {
+			nearest = min(nearest, HaversineMiles(p, path[0]))
+		}
+		for i := 1; i < len(path); i++ {
+			nearest = min(nearest, segmentMiles(p, path[i-1], path[i]))
+		}
+	}
+	return nearest
+}
+
+// segmentMiles returns the distance from p to the segment from a to b. The
+// segment is projected onto a plane around p, which is accurate to well under
+// 1% for segments and buffers of a few miles.
+func segmentMiles(p, a, b Point) float64 {
+	milesPerDegree := EarthRadiusMiles * math.Pi / 180
+	scale := math.Cos(p.Lat * math.Pi / 180)
+	ax, ay := (a.Lon-p.Lon)*scale*milesPerDegree, (a.Lat-p.Lat)*milesPerDegree
+	bx, by := (b.Lon-p.Lon)*scale*milesPerDegree, (b.Lat-p.Lat)*milesPerDegree
+	dx, dy := bx-ax, by-ay
+	// t is how far along the segment the closest point is, from 0 at a to 1 at b.
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
@@ -0,0 +1,29 @@
+// Package geo has the distance and grid calculations shared by the
+// geofences, corridors, alert annotations and hotspot analysis.
+package geo
+
+import "math"
+
+// EarthRadiusMiles is the mean radius of the Earth used for distance calculations.
+const EarthRadiusMiles = 3958.8
+
+// Point is a latitude and longitude in degrees.
+type Point struct {
+	Lat, Lon float64
+}
+
+// Valid reports whether p is a real coordinate. The feed sends 0,0 for
+// incidents without a location.
+func (p Point) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lon >= -180 && p.Lon <= 180 && (p.Lat != 0 || p.Lon != 0)
+}
+
+// HaversineMiles returns the great-circle distance between two points in miles.
+func HaversineMiles(a, b Point) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(b.Lat - a.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Lat))*math.Cos(toRad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadiusMiles * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,56 @@
+package geo
+
+import (
+	"encoding/xml"
+	"errors"
+)
+
+// gpxPoint is a GPX track, route or waypoint point.
+type gpxPoint struct {
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+// gpxDocument is the part of a GPX file read: tracks, made of segments, and
+// routes.
+type gpxDocument struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+// ParseGPX returns the paths in a GPX file: each track segment and each route.
+func ParseGPX(data []byte) ([][]Point, error) {
+	var doc gpxDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var paths [][]Point
+	add := func(points []gpxPoint) {
+		if len(points) == 0 {
+			return
+		}
+		path := make([]Point, len(points))
+		for i, pt := range points {
+			path[i] = Point{Lat: pt.Lat, Lon: pt.Lon}
+		}
+		paths = append(paths, path)
+	}
+	for _, trk := range doc.Tracks {
+		for _, seg := range trk.Segments {
+			add(seg.Points)
+		}
+	}
+	for _, rte := range doc.Routes {
+		add(rte.Points)
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("no tracks or routes")
+	}
+	return paths, nil
+}
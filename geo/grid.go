@@ -0,0 +1,20 @@
+package geo
+
+import "math"
+
+// Cell is a square of a grid laid over the map, for counting points near each
+// other. Rows are bands of latitude; columns are as wide in miles as rows are
+// tall, measured at the row's middle.
+type Cell struct {
+	Row, Col int
+}
+
+// CellOf returns the cell of a grid with sides of sizeMiles that p falls in.
+func CellOf(p Point, sizeMiles float64) Cell {
+	milesPerDegree := EarthRadiusMiles * math.Pi / 180
+	rowDegrees := sizeMiles / milesPerDegree
+	row := int(math.Floor(p.Lat / rowDegrees))
+	middle := (float64(row) + 0.5) * rowDegrees
+	colDegrees := rowDegrees / math.Max(math.Cos(middle*math.Pi/180), 0.01)
+	return Cell{Row: row, Col: int(math.Floor(p.Lon / colDegrees))}
+}
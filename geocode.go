@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultGeocodingURL is the public Nominatim reverse geocoding endpoint.
+const defaultGeocodingURL = "https://nominatim.openstreetmap.org/reverse"
+
+// GeocodingConfig fills in the location of alerts whose feed Location or City
+// is blank or vague ("N/A", "Unknown", ...) by reverse geocoding the
+// incident's coordinates. URL is a Nominatim-compatible reverse endpoint,
+// such as a self-hosted Nominatim or LocationIQ with APIKey. Results are
+// cached by coordinates, rounded to about 10 m, in CacheFile, and requests are
+// spaced at least MinInterval apart, as the public Nominatim's usage policy
+// asks for one a second.
+type GeocodingConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	URL         string        `yaml:"url"`
+	APIKey      string        `yaml:"api_key"`
+	UserAgent   string        `yaml:"user_agent"`
+	CacheFile   string        `yaml:"cache_file"`
+	MinInterval time.Duration `yaml:"min_interval"`
+}
+
+// vagueLocations are feed Location and City values that say nothing useful.
+var vagueLocations = map[string]bool{"": true, "n/a": true, "na": true, "unknown": true, "various": true, "various locations": true, "multiple locations": true}
+
+// vagueLocation reports whether a Location or City needs filling in.
+func vagueLocation(s string) bool {
+	return vagueLocations[strings.ToLower(strings.TrimSpace(s))]
+}
+
+// geocodedPlace is what reverse geocoding found near a point.
+type geocodedPlace struct {
+	// Address is the nearest address, or the road or place name, e.g.
+	// "1200 Wade Avenue".
+	Address string `json:"address"`
+	City    string `json:"city"`
+}
+
+// geocoder reverse geocodes coordinates through a cache, spacing its requests.
+type geocoder struct {
+	cfg GeocodingConfig
+
+	mu      sync.Mutex
+	cache   map[string]geocodedPlace
+	changed bool
+}
+
+// geocodeLimiter spaces requests across the geocoders of successive cycles.
+var geocodeLimiter struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// loadGeocoder reads the cache. A nil geocoder, returned when geocoding is
+// off, fills nothing in.
+func loadGeocoder(cfg GeocodingConfig) *geocoder {
+	if !cfg.Enabled {
+		return nil
+	}
+	g := &geocoder{cfg: cfg, cache: make(map[string]geocodedPlace)}
+	if cfg.CacheFile == "" {
+		return g
+	}
+	data, err := os.ReadFile(cfg.CacheFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Error loading the geocoding cache", "err", err)
+		}
+		return g
+	}
+	if err := json.Unmarshal(data, &g.cache); err != nil {
+		slog.Warn("Could not parse the geocoding cache. Starting fresh.", "file", cfg.CacheFile, "err", err)
+		g.cache = make(map[string]geocodedPlace)
+	}
+	return g
+}
+
+// save writes the cache back if a lookup added to it.
+func (g *geocoder) save() error {
+	if g == nil || !g.changed || g.cfg.CacheFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(g.cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(g.cfg.CacheFile, data)
+}
+
+// geocodeKey rounds coordinates to four decimal places, about 10 m, so an
+// incident's nearby updates share a cache entry.
+func geocodeKey(lat, lon float64) string {
+	return strconv.FormatFloat(lat, 'f', 4, 64) + "," + strconv.FormatFloat(lon, 'f', 4, 64)
+}
+
+// enrich fills in a vague Location or City from the incident's coordinates.
+// A failed lookup is logged and leaves the incident as it was.
+func (g *geocoder) enrich(ctx context.Context, incident Incident) Incident {
+	if g == nil || (!vagueLocation(incident.Location) && !vagueLocation(incident.City)) {
+		return incident
+	}
+	if incident.Latitude == 0 && incident.Longitude == 0 {
+		return incident
+	}
+	place, err := g.lookup(ctx, incident.Latitude, incident.Longitude)
+	if err != nil {
+		slog.Warn("Could not reverse geocode incident", "incident_id", incident.ID, "err", err)
+		return incident
+	}
+	if vagueLocation(incident.Location) && place.Address != "" {
+		incident.Location = "Near " + place.Address
+	}
+	if vagueLocation(incident.City) && place.City != "" {
+		incident.City = place.City
+	}
+	return incident
+}
+
+// lookup returns the place at a point, from the cache or the provider.
+func (g *geocoder) lookup(ctx context.Context, lat, lon float64) (geocodedPlace, error) {
+	key := geocodeKey(lat, lon)
+	g.mu.Lock()
+	place, ok := g.cache[key]
+	g.mu.Unlock()
+	if ok {
+		return place, nil
+	}
+
+	place, err := g.fetch(ctx, lat, lon)
+	if err != nil {
+		return place, err
+	}
+	g.mu.Lock()
+	g.cache[key] = place
+	g.changed = true
+	g.mu.Unlock()
+	return place, nil
+}
+
+// nominatimReverse is the part of a Nominatim jsonv2 reverse response used.
+type nominatimReverse struct {
+	Error   string `json:"error"`
+	Name    string `json:"name"`
+	Address struct {
+		HouseNumber string `json:"house_number"`
+		Road        string `json:"road"`
+		City        string `json:"city"`
+		Town        string `json:"town"`
+		Village     string `json:"village"`
+		Hamlet      string `json:"hamlet"`
+		County      string `json:"county"`
+	} `json:"address"`
+}
+
+// fetch asks the provider for the place at a point, waiting out MinInterval
+// since the last request.
+func (g *geocoder) fetch(ctx context.Context, lat, lon float64) (geocodedPlace, error) {
+	geocodeLimiter.mu.Lock()
+	wait := g.cfg.MinInterval - time.Since(geocodeLimiter.last)
+	if wait > 0 {
+		select {
+		case <-ctx.Done():
+			geocodeLimiter.mu.Unlock()
+			return geocodedPlace{}, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	geocodeLimiter.last = time.Now()
+	geocodeLimiter.mu.Unlock()
+
+	params := url.Values{
+		"format": {"jsonv2"},
+		"lat":    {strconv.FormatFloat(lat, 'f', 6, 64)},
+		"lon":    {strconv.FormatFloat(lon, 'f', 6, 64)},
+		"zoom":   {"17"}, // Major and minor streets.
+	}
+	if g.cfg.APIKey != "" {
+		params.Set("key", g.cfg.APIKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.cfg.URL+"?"+params.Encode(), nil)
+	if err != nil {
+		return geocodedPlace{}, err
+	}
+	req.Header.Set("User-Agent", g.cfg.UserAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return geocodedPlace{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return geocodedPlace{}, fmt.Errorf("geocoder returned %s", resp.Status)
+	}
+	var body nominatimReverse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return geocodedPlace{}, fmt.Errorf("could not decode geocoder response: %w", err)
+	}
+	if body.Error != "" {
+		// Nothing there, such as a point offshore. Cached like a find, so it
+		// isn't asked again.
+		return geocodedPlace{}, nil
+	}
+
+	a := body.Address
+	place := geocodedPlace{Address: strings.TrimSpace(a.HouseNumber + " " + a.Road)}
+	if place.Address == "" {
+		place.Address = body.Name
+	}
+	for _, city := range []string{a.City, a.Town, a.Village, a.Hamlet, a.County} {
+		if city != "" {
+			place.City = city
+			break
+		}
+	}
+	return place, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/mtickle/crash-reporting/geo"
+)
+
+// GeofenceConfig limits alerts to incidents inside a circle around a point and/or
+// inside GeoJSON polygons. An incident inside any of the configured areas passes.
+// With nothing configured the geofence lets everything through.
+type GeofenceConfig struct {
+	Latitude     float64 `yaml:"latitude"`
+	Longitude    float64 `yaml:"longitude"`
+	RadiusMiles  float64 `yaml:"radius_miles"`
+	PolygonsFile string  `yaml:"polygons_file"`
+
+	// polygons is loaded from PolygonsFile by load. Each polygon is a list of
+	// rings, the first being the outer boundary and the rest holes.
+	polygons [][][][2]float64
+}
+
+// enabled reports whether any area has been configured.
+func (g GeofenceConfig) enabled() bool {
+	return g.RadiusMiles > 0 || len(g.polygons) > 0
+}
+
+// contains reports whether the point lies inside the geofence.
+func (g GeofenceConfig) contains(lat, lon float64) bool {
+	if !g.enabled() {
+		return true
+	}
+	if g.RadiusMiles > 0 && geo.HaversineMiles(geo.Point{Lat: g.Latitude, Lon: g.Longitude}, geo.Point{Lat: lat, Lon: lon}) <= g.RadiusMiles {
+		return true
+	}
+	for _, polygon := range g.polygons {
+		if polygonContains(polygon, lon, lat) {
+			return true
+		}
+	}
+	return false
+}
+
+// geofenceCheck returns the test runCycle filters incidents with. When store is
+// a SpatialStore the circle is checked by the database against the incidents it
+// just stored, and only polygons are checked here; a nil store, or a failed
+// query, falls back to contains.
+func geofenceCheck(ctx context.Context, store Store, g GeofenceConfig) func(Incident) bool {
+	contains := func(incident Incident) bool { return g.contains(incident.Latitude, incident.Longitude) }
+	spatial, ok := store.(SpatialStore)
+	if !ok || g.RadiusMiles <= 0 {
+		return contains
+	}
+	within, err := spatial.IncidentsWithin(ctx, "active", g.Latitude, g.Longitude, g.RadiusMiles*metersPerMile)
+	if err != nil {
+		slog.Warn("Error checking the geofence in the database, checking it locally instead", "err", err)
+		return contains
+	}
+	inside := make(map[int]bool, len(within))
+	for _, si := range within {
+		inside[si.ID] = true
+	}
+	return func(incident Incident) bool {
+		if inside[incident.ID] {
+			return true
+		}
+		for _, polygon := range g.polygons {
+			if polygonContains(polygon, incident.Longitude, incident.Latitude) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// load reads the GeoJSON polygons file, if one is configured.
+func (g *GeofenceConfig) load() error {
+	if g.PolygonsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(g.PolygonsFile)
+	if err != nil {
+		return fmt.Errorf("could not read geofence polygons: %w", err)
+	}
+	polygons, err := parseGeoJSONPolygons(data)
+	if err != nil {
+		return fmt.Errorf("could not parse geofence polygons in %s: %w", g.PolygonsFile, err)
+	}
+	if len(polygons) == 0 {
+		return fmt.Errorf("geofence polygons file %s contains no Polygon or MultiPolygon geometry", g.PolygonsFile)
+	}
+	g.polygons = polygons
+	return nil
+}
+
+// polygonContains reports whether (x, y) lies inside the outer ring and outside every hole.
+func polygonContains(rings [][][2]float64, x, y float64) bool {
+	if len(rings) == 0 || !ringContains(rings[0], x, y) {
+		return false
+	}
+	for _, hole := range rings[1:] {
+		if ringContains(hole, x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+// ringContains is the standard even-odd ray casting test.
+func ringContains(ring [][2]float64, x, y float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// geoJSONObject covers the GeoJSON object types we need to walk to find polygons.
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Features    []geoJSONObject `json:"features"`
+	Geometries  []geoJSONObject `json:"geometries"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// walkGeoJSON calls fn with every geometry in a GeoJSON document, whether it is
+// a bare geometry, a Feature or a FeatureCollection, stopping at fn's first error.
+func walkGeoJSON(data []byte, fn func(geometry geoJSONObject) error) error {
+	var obj geoJSONObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	var walk func(o geoJSONObject) error
+	walk = func(o geoJSONObject) error {
+		switch o.Type {
+		case "FeatureCollection":
+			for _, f := range o.Features {
+				if err := walk(f); err != nil {
+					return err
+				}
+			}
+		case "Feature":
+			if o.Geometry != nil {
+				return walk(*o.Geometry)
+			}
+		case "GeometryCollection":
+			for _, g := range o.Geometries {
+				if err := walk(g); err != nil {
+					return err
+				}
+			}
+		default:
+			return fn(o)
+		}
+		return nil
+	}
+	return walk(obj)
+}
+
+// parseGeoJSONPolygons extracts every Polygon and MultiPolygon from a GeoJSON document.
+func parseGeoJSONPolygons(data []byte) ([][][][2]float64, error) {
+	var polygons [][][][2]float64
+	err := walkGeoJSON(data, func(o geoJSONObject) error {
+		switch o.Type {
+		case "Polygon":
+			var polygon [][][2]float64
+			if err := json.Unmarshal(o.Coordinates, &polygon); err != nil {
+				return fmt.Errorf("invalid Polygon coordinates: %w", err)
+			}
+			polygons = append(polygons, polygon)
+		case "MultiPolygon":
+			var multi [][][][2]float64
+			if err := json.Unmarshal(o.Coordinates, &multi); err != nil {
+				return fmt.Errorf("invalid MultiPolygon coordinates: %w", err)
+			}
+			polygons = append(polygons, multi...)
+		}
+		return nil
+	})
+	return polygons, err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GotifyConfig pushes alerts to a self-hosted Gotify server using an application
+// token. Priorities maps incident severity to a Gotify priority (0-10); unmapped
+// severities use 5.
+type GotifyConfig struct {
+	ServerURL  string      `yaml:"server_url"`
+	AppToken   string      `yaml:"app_token"`
+	Priorities map[int]int `yaml:"priorities"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether Gotify alerts are configured.
+func (g GotifyConfig) enabled() bool {
+	return g.ServerURL != "" && g.AppToken != ""
+}
+
+// priority returns the Gotify priority for a severity.
+func (g GotifyConfig) priority(severity int) int {
+	if p, ok := g.Priorities[severity]; ok {
+		return p
+	}
+	return 5
+}
+
+// gotifyMessage is the /message request body. The extras render the message as
+// Markdown and open the map when the notification is tapped.
+type gotifyMessage struct {
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	Priority int            `json:"priority"`
+	Extras   map[string]any `json:"extras,omitempty"`
+}
+
+// postToGotify sends one message.
+func postToGotify(ctx context.Context, cfg GotifyConfig, msg gotifyMessage) error {
+	headers := map[string]string{"X-Gotify-Key": cfg.AppToken}
+	return postJSON(ctx, strings.TrimRight(cfg.ServerURL, "/")+"/message", msg, headers, nil)
+}
+
+// sendToGotify pushes a new-incident alert.
+func sendToGotify(ctx context.Context, cfg GotifyConfig, incident Incident, parsedTime time.Time) error {
+	link := mapLink(incident.Latitude, incident.Longitude)
+	message := fmt.Sprintf("**%s** at %s, %s  \nLanes: %s  \nSeverity: %d  \nStarted %s  \n[View on map](%s)",
+		orNA(incident.Road), orNA(incident.Location), orNA(incident.City),
+		lanesText(incident.LanesClosed, incident.LanesTotal), incident.Severity, localTime(parsedTime).Format("3:04 PM"), link)
+	if custom, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		message = custom
+	}
+	msg := gotifyMessage{
+		Title:    fmt.Sprintf("New %s Alert", incident.IncidentType),
+		Message:  message,
+		Priority: cfg.priority(incident.Severity),
+		Extras: map[string]any{
+			"client::display":      map[string]string{"contentType": "text/markdown"},
+			"client::notification": map[string]any{"click": map[string]string{"url": link}},
+		},
+	}
+	return postToGotify(ctx, cfg, msg)
+}
+
+// sendClearedNotificationToGotify pushes a low-priority cleared notification.
+func sendClearedNotificationToGotify(ctx context.Context, cfg GotifyConfig, incident ClearedIncident) error {
+	message := fmt.Sprintf("%s at %s, %s", orNA(incident.Road), orNA(incident.Location), orNA(incident.City))
+	if custom, ok := cfg.templates.render(clearedTemplateData(incident)); ok {
+		message = custom
+	}
+	msg := gotifyMessage{
+		Title:    clearedTitle(incident),
+		Message:  message,
+		Priority: 1,
+	}
+	return postToGotify(ctx, cfg, msg)
+}
+
+// gotifyNotifier delivers notifications to a Gotify server.
+type gotifyNotifier struct {
+	cfg GotifyConfig
+}
+
+func init() {
+	registerNotifier("gotify", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Gotify.enabled() {
+			return nil
+		}
+		c := cfg.Gotify
+		c.templates = cfg.templatesFor("gotify")
+		return gotifyNotifier{cfg: c}
+	})
+}
+
+func (g gotifyNotifier) Name() string { return "Gotify" }
+
+// NotifyBatch pushes one digest at the priority of the most severe incident.
+func (g gotifyNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	return postToGotify(ctx, g.cfg, gotifyMessage{
+		Title:    digestTitle(batch),
+		Message:  digestText(batch, 0, nil),
+		Priority: g.cfg.priority(maxSeverity(batch)),
+	})
+}
+
+func (g gotifyNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedNotificationToGotify(ctx, g.cfg, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return postToGotify(ctx, g.cfg, gotifyMessage{
+			Title:    updateTitle(n),
+			Message:  updateText(g.cfg.templates, n),
+			Priority: g.cfg.priority(n.Incident.Severity),
+		})
+	}
+	return sendToGotify(ctx, g.cfg, n.Incident, n.StartTime)
+}
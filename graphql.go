@@ -0,0 +1,832 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file is a small GraphQL executor for the API's read-only schema. It
+// handles queries with arguments, variables, aliases, fragments and the @skip
+// and @include directives. Mutations, subscriptions and introspection are not
+// supported; the schema is published as SDL instead.
+
+// Limits on the work one query can ask for. maxGraphQLDepth bounds how deeply
+// fields nest and maxGraphQLFields how many are selected, counting each alias
+// and every field a fragment brings in, both checked before anything runs.
+// maxGraphQLResolves bounds the fields resolved in all, which also grows with
+// the lists returned.
+const (
+	maxGraphQLDepth    = 8
+	maxGraphQLFields   = 200
+	maxGraphQLResolves = 100000
+)
+
+// gqlToken is a lexical token. kind is 'n' for names, 'i' and 'f' for int and
+// float literals, 's' for strings, 'p' for punctuators and 0 at the end.
+type gqlToken struct {
+	kind  byte
+	value string
+}
+
+// gqlLex splits a GraphQL document into tokens, dropping whitespace, commas
+// and comments, which are insignificant.
+func gqlLex(src string) ([]gqlToken, error) {
+	var tokens []gqlToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "\uFEFF"):
+			i += len("\uFEFF")
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, gqlToken{'p', "..."})
+			i += 3
+		case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+			tokens = append(tokens, gqlToken{'p', string(c)})
+			i++
+		case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, gqlToken{'n', src[start:i]})
+		case c == '-' || c >= '0' && c <= '9':
+			start, kind := i, byte('i')
+			i++
+			digits := func() {
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			digits()
+			if i < len(src) && src[i] == '.' {
+				kind = 'f'
+				i++
+				digits()
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				kind = 'f'
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				digits()
+			}
+			tokens = append(tokens, gqlToken{kind, src[start:i]})
+		case strings.HasPrefix(src[i:], `"""`):
+			// The block ends at the first """ that isn't escaped as \""".
+			end := i + 3
+			for {
+				n := strings.Index(src[end:], `"""`)
+				if n < 0 {
+					return nil, fmt.Errorf("unterminated block string")
+				}
+				end += n
+				if src[end-1] != '\\' {
+					break
+				}
+				end += 3
+			}
+			tokens = append(tokens, gqlToken{'s', strings.ReplaceAll(src[i+3:end], `\"""`, `"""`)})
+			i = end + 3
+		case c == '"':
+			s, n, err := gqlString(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, gqlToken{'s', s})
+			i += n
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	return append(tokens, gqlToken{}), nil
+}
+
+// gqlString reads the quoted string at the start of src, returning its value
+// and length in src.
+func gqlString(src string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\n', '\r':
+			return "", 0, fmt.Errorf("unterminated string")
+		case '\\':
+			if i+1 >= len(src) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			i++
+			switch e := src[i]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 >= len(src) {
+					return "", 0, fmt.Errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(src[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid unicode escape %q", src[i-1:i+5])
+				}
+				b.WriteRune(rune(r))
+				i += 4
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// gqlVariable is a $name reference in an argument value, and gqlEnum a bare
+// enum value.
+type (
+	gqlVariable string
+	gqlEnum     string
+)
+
+// gqlDirective is a directive such as @skip(if: $flag).
+type gqlDirective struct {
+	name string
+	args map[string]any
+}
+
+// gqlSelection is a field, a fragment spread (fragment set) or an inline
+// fragment (inline set).
+type gqlSelection struct {
+	alias, name string
+	args        map[string]any
+	selections  []gqlSelection
+	fragment    string
+	inline      bool
+	directives  []gqlDirective
+}
+
+// key is the field's name in the response.
+func (s gqlSelection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// gqlVariableDef declares an operation's variable.
+type gqlVariableDef struct {
+	name       string
+	nonNull    bool
+	defaultVal any
+	hasDefault bool
+}
+
+// gqlOperation is a query, mutation or subscription.
+type gqlOperation struct {
+	kind, name string
+	variables  []gqlVariableDef
+	selections []gqlSelection
+}
+
+// gqlDocument is a parsed request document.
+type gqlDocument struct {
+	operations []gqlOperation
+	fragments  map[string][]gqlSelection
+}
+
+// gqlParser is a recursive descent parser over a token list.
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+}
+
+// parseGraphQL parses a request document.
+func parseGraphQL(src string) (*gqlDocument, error) {
+	tokens, err := gqlLex(src)
+	if err != nil {
+		return nil, fmt.Errorf("syntax error: %w", err)
+	}
+	p := &gqlParser{tokens: tokens}
+	doc, err := p.document()
+	if err != nil {
+		return nil, fmt.Errorf("syntax error: %w", err)
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *gqlParser) next() gqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != 0 {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the punctuator or name value.
+func (p *gqlParser) is(value string) bool {
+	t := p.peek()
+	return (t.kind == 'p' || t.kind == 'n') && t.value == value
+}
+
+// skip consumes the next token if it is value.
+func (p *gqlParser) skip(value string) bool {
+	if p.is(value) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes value or fails.
+func (p *gqlParser) expect(value string) error {
+	if !p.skip(value) {
+		return p.unexpected("expected " + strconv.Quote(value))
+	}
+	return nil
+}
+
+// name consumes a name.
+func (p *gqlParser) name() (string, error) {
+	if t := p.peek(); t.kind == 'n' {
+		p.pos++
+		return t.value, nil
+	}
+	return "", p.unexpected("expected a name")
+}
+
+// unexpected describes the next token for an error.
+func (p *gqlParser) unexpected(want string) error {
+	t := p.peek()
+	if t.kind == 0 {
+		return fmt.Errorf("%s, got end of document", want)
+	}
+	return fmt.Errorf("%s, got %q", want, t.value)
+}
+
+func (p *gqlParser) document() (*gqlDocument, error) {
+	doc := &gqlDocument{fragments: make(map[string][]gqlSelection)}
+	for p.peek().kind != 0 {
+		switch {
+		case p.is("{"):
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, gqlOperation{kind: "query", selections: sels})
+		case p.is("query") || p.is("mutation") || p.is("subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.skip("fragment"):
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("on"); err != nil {
+				return nil, err
+			}
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+			if _, err := p.directives(); err != nil {
+				return nil, err
+			}
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.fragments[name] = sels
+		default:
+			return nil, p.unexpected("expected an operation or fragment")
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document has no operations")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) operation() (gqlOperation, error) {
+	op := gqlOperation{kind: p.next().value}
+	if p.peek().kind == 'n' {
+		op.name = p.next().value
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			if err := p.expect("$"); err != nil {
+				return op, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return op, err
+			}
+			if err := p.expect(":"); err != nil {
+				return op, err
+			}
+			v := gqlVariableDef{name: name}
+			if v.nonNull, err = p.typeRef(); err != nil {
+				return op, err
+			}
+			if p.skip("=") {
+				if v.defaultVal, err = p.value(); err != nil {
+					return op, err
+				}
+				v.hasDefault = true
+			}
+			op.variables = append(op.variables, v)
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return op, err
+	}
+	var err error
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+// typeRef skips a type such as [Int!]!, reporting whether it is non-null.
+func (p *gqlParser) typeRef() (bool, error) {
+	if p.skip("[") {
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	return p.skip("!"), nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []gqlSelection
+	for !p.skip("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, fmt.Errorf("empty selection set")
+	}
+	return sels, nil
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	var sel gqlSelection
+	var err error
+	if p.skip("...") {
+		if p.peek().kind == 'n' && !p.is("on") {
+			sel.fragment = p.next().value
+			sel.directives, err = p.directives()
+			return sel, err
+		}
+		sel.inline = true
+		if p.skip("on") {
+			if _, err := p.name(); err != nil {
+				return sel, err
+			}
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return sel, err
+		}
+		sel.selections, err = p.selectionSet()
+		return sel, err
+	}
+
+	if sel.name, err = p.name(); err != nil {
+		return sel, err
+	}
+	if p.skip(":") {
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return sel, err
+		}
+	}
+	if p.is("(") {
+		if sel.args, err = p.arguments(); err != nil {
+			return sel, err
+		}
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return sel, err
+	}
+	if p.is("{") {
+		sel.selections, err = p.selectionSet()
+	}
+	return sel, err
+}
+
+func (p *gqlParser) arguments() (map[string]any, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := make(map[string]any)
+	for !p.skip(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+func (p *gqlParser) directives() ([]gqlDirective, error) {
+	var directives []gqlDirective
+	for p.skip("@") {
+		d := gqlDirective{}
+		var err error
+		if d.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if p.is("(") {
+			if d.args, err = p.arguments(); err != nil {
+				return nil, err
+			}
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+func (p *gqlParser) value() (any, error) {
+	t := p.next()
+	switch t.kind {
+	case 'i':
+		n, err := strconv.Atoi(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int %q", t.value)
+		}
+		return n, nil
+	case 'f':
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", t.value)
+		}
+		return f, nil
+	case 's':
+		return t.value, nil
+	case 'n':
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(t.value), nil
+	case 'p':
+		switch t.value {
+		case "$":
+			name, err := p.name()
+			return gqlVariable(name), err
+		case "[":
+			list := []any{}
+			for !p.skip("]") {
+				v, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, nil
+		case "{":
+			obj := make(map[string]any)
+			for !p.skip("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(); err != nil {
+					return nil, err
+				}
+			}
+			return obj, nil
+		}
+	}
+	p.pos--
+	return nil, p.unexpected("expected a value")
+}
+
+// gqlObject is a value with fields, resolved when selected.
+type gqlObject struct {
+	typeName string
+	field    func(name string, args gqlArgs) (any, error)
+}
+
+// gqlArgs are a field's arguments with variables substituted.
+type gqlArgs map[string]any
+
+// int returns an integer argument, or def when it is absent or null.
+func (a gqlArgs) int(name string, def int) (int, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return v, nil
+	case float64:
+		// Variables arrive as JSON numbers.
+		if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %q must be an Int", name)
+}
+
+// string returns a string argument, or def when it is absent or null.
+func (a gqlArgs) string(name string, def string) (string, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return def, nil
+	case string:
+		return v, nil
+	case gqlEnum:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("argument %q must be a String", name)
+}
+
+// gqlError is an entry of a response's errors.
+type gqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// gqlResponse is a GraphQL response. Data is absent when the request failed
+// before execution.
+type gqlResponse struct {
+	Data   *gqlOrderedMap `json:"data,omitempty"`
+	Errors []gqlError     `json:"errors,omitempty"`
+}
+
+// gqlOrderedMap is a response object, keeping its fields in selection order.
+type gqlOrderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+// set adds or replaces a field.
+func (m *gqlOrderedMap) set(key string, value any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// MarshalJSON writes the fields in order.
+func (m *gqlOrderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// gqlExecutor runs one operation.
+type gqlExecutor struct {
+	variables map[string]any
+	fragments map[string][]gqlSelection
+	errors    []gqlError
+	// resolves counts the fields resolved, against maxGraphQLResolves.
+	resolves int
+}
+
+// executeGraphQL runs the request's query operation against root.
+func executeGraphQL(query string, variables map[string]any, operationName string, root gqlObject) gqlResponse {
+	doc, err := parseGraphQL(query)
+	if err != nil {
+		return gqlResponse{Errors: []gqlError{{Message: err.Error()}}}
+	}
+	var op *gqlOperation
+	for i := range doc.operations {
+		if doc.operations[i].name == operationName || (operationName == "" && len(doc.operations) == 1) {
+			op = &doc.operations[i]
+			break
+		}
+	}
+	switch {
+	case op == nil && operationName == "":
+		return gqlResponse{Errors: []gqlError{{Message: "operationName is required for a document with several operations"}}}
+	case op == nil:
+		return gqlResponse{Errors: []gqlError{{Message: fmt.Sprintf("unknown operation %q", operationName)}}}
+	case op.kind != "query":
+		return gqlResponse{Errors: []gqlError{{Message: fmt.Sprintf("only queries are supported, not %ss", op.kind)}}}
+	}
+
+	e := &gqlExecutor{variables: make(map[string]any), fragments: doc.fragments}
+	for _, v := range op.variables {
+		value, ok := variables[v.name]
+		if !ok && v.hasDefault {
+			value, ok = v.defaultVal, true
+		}
+		if v.nonNull && (!ok || value == nil) {
+			return gqlResponse{Errors: []gqlError{{Message: fmt.Sprintf("variable $%s is required", v.name)}}}
+		}
+		e.variables[v.name] = value
+	}
+	fields := 0
+	if err := e.measure(op.selections, 1, &fields); err != nil {
+		return gqlResponse{Errors: []gqlError{{Message: err.Error()}}}
+	}
+	data := e.selectFields(root, op.selections, nil)
+	return gqlResponse{Data: data, Errors: e.errors}
+}
+
+// resolve substitutes variables and enums in an argument value.
+func (e *gqlExecutor) resolve(v any) any {
+	switch v := v.(type) {
+	case gqlVariable:
+		return e.variables[string(v)]
+	case gqlEnum:
+		return string(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = e.resolve(item)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = e.resolve(item)
+		}
+		return out
+	}
+	return v
+}
+
+// included applies @skip and @include.
+func (e *gqlExecutor) included(directives []gqlDirective) bool {
+	for _, d := range directives {
+		cond, _ := e.resolve(d.args["if"]).(bool)
+		if (d.name == "skip" && cond) || (d.name == "include" && !cond) {
+			return false
+		}
+	}
+	return true
+}
+
+// collect flattens fragments into the fields to resolve, merging the
+// sub-selections of fields with the same response key.
+func (e *gqlExecutor) collect(sels []gqlSelection, fields *[]gqlSelection, seen map[string]bool) {
+	for _, sel := range sels {
+		if !e.included(sel.directives) {
+			continue
+		}
+		switch {
+		case sel.fragment != "":
+			if !seen["..."+sel.fragment] {
+				seen["..."+sel.fragment] = true
+				e.collect(e.fragments[sel.fragment], fields, seen)
+			}
+		case sel.inline:
+			e.collect(sel.selections, fields, seen)
+		default:
+			merged := false
+			for i := range *fields {
+				if (*fields)[i].key() == sel.key() {
+					(*fields)[i].selections = append(append([]gqlSelection{}, (*fields)[i].selections...), sel.selections...)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				*fields = append(*fields, sel)
+			}
+		}
+	}
+}
+
+// measure checks the selections, at depth, against maxGraphQLDepth and
+// maxGraphQLFields, adding the fields it finds to fields.
+func (e *gqlExecutor) measure(sels []gqlSelection, depth int, fields *int) error {
+	if depth > maxGraphQLDepth {
+		return fmt.Errorf("the query nests fields more than %d deep", maxGraphQLDepth)
+	}
+	var collected []gqlSelection
+	e.collect(sels, &collected, make(map[string]bool))
+	for _, f := range collected {
+		if *fields++; *fields > maxGraphQLFields {
+			return fmt.Errorf("the query selects more than %d fields", maxGraphQLFields)
+		}
+		if len(f.selections) > 0 {
+			if err := e.measure(f.selections, depth+1, fields); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectFields resolves the selected fields of obj.
+func (e *gqlExecutor) selectFields(obj gqlObject, sels []gqlSelection, path []any) *gqlOrderedMap {
+	var fields []gqlSelection
+	e.collect(sels, &fields, make(map[string]bool))
+	out := &gqlOrderedMap{values: make(map[string]any)}
+	for _, f := range fields {
+		fieldPath := append(append([]any{}, path...), f.key())
+		if f.name == "__typename" {
+			out.set(f.key(), obj.typeName)
+			continue
+		}
+		if e.resolves++; e.resolves > maxGraphQLResolves {
+			if e.resolves == maxGraphQLResolves+1 {
+				e.errors = append(e.errors, gqlError{Message: fmt.Sprintf("the query resolves more than %d fields; ask for fewer items", maxGraphQLResolves), Path: fieldPath})
+			}
+			out.set(f.key(), nil)
+			continue
+		}
+		args := make(gqlArgs, len(f.args))
+		for name, v := range f.args {
+			args[name] = e.resolve(v)
+		}
+		value, err := obj.field(f.name, args)
+		if err != nil {
+			e.errors = append(e.errors, gqlError{Message: err.Error(), Path: fieldPath})
+			out.set(f.key(), nil)
+			continue
+		}
+		out.set(f.key(), e.complete(value, f, fieldPath))
+	}
+	return out
+}
+
+// complete turns a resolved value into response data, selecting the fields of
+// objects.
+func (e *gqlExecutor) complete(value any, f gqlSelection, path []any) any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case gqlObject:
+		if len(f.selections) == 0 {
+			e.errors = append(e.errors, gqlError{Message: fmt.Sprintf("field %q of type %s needs a selection of subfields", f.name, v.typeName), Path: path})
+			return nil
+		}
+		return e.selectFields(v, f.selections, path)
+	case []gqlObject:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = e.complete(item, f, append(append([]any{}, path...), i))
+		}
+		return out
+	}
+	if len(f.selections) > 0 {
+		e.errors = append(e.errors, gqlError{Message: fmt.Sprintf("field %q is a scalar and has no subfields", f.name), Path: path})
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGQLLex(t *testing.T) {
+	tokens, err := gqlLex("\uFEFFquery Q($n: Int = -1.5e3) { # comment\n a: f(s: \"x\\n\\u00e9\", b: \"\"\"blk\\\"\"\"\"\"\") ...F }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []gqlToken{
+		{'n', "query"}, {'n', "Q"}, {'p', "("}, {'p', "$"}, {'n', "n"}, {'p', ":"}, {'n', "Int"},
+		{'p', "="}, {'f', "-1.5e3"}, {'p', ")"}, {'p', "{"}, {'n', "a"}, {'p', ":"}, {'n', "f"},
+		{'p', "("}, {'n', "s"}, {'p', ":"}, {'s', "x\né"}, {'n', "b"}, {'p', ":"}, {'s', `blk"""`},
+		{'p', ")"}, {'p', "..."}, {'n', "F"}, {'p', "}"}, {},
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("gqlLex() =\n%v\nwant\n%v", tokens, want)
+	}
+}
+
+func TestParseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+		query Incidents($status: String! = "active", $skip: Boolean) {
+			list: incidents(status: $status, limit: 5, where: {roads: ["I-40", US_1]}) @skip(if: $skip) {
+				id
+				...Names
+				... on Incident @include(if: true) { severity }
+			}
+		}
+		fragment Names on Incident { road city }
+		{ incidentCount }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.operations) != 2 {
+		t.Fatalf("parsed %d operations, want 2", len(doc.operations))
+	}
+	op := doc.operations[0]
+	wantVars := []gqlVariableDef{
+		{name: "status", nonNull: true, defaultVal: "active", hasDefault: true},
+		{name: "skip"},
+	}
+	if op.kind != "query" || op.name != "Incidents" || !reflect.DeepEqual(op.variables, wantVars) {
+		t.Errorf("operation = %s %s %+v, want query Incidents %+v", op.kind, op.name, op.variables, wantVars)
+	}
+	wantSels := []gqlSelection{{
+		alias: "list",
+		name:  "incidents",
+		args: map[string]any{
+			"status": gqlVariable("status"),
+			"limit":  5,
+			"where":  map[string]any{"roads": []any{"I-40", gqlEnum("US_1")}},
+		},
+		directives: []gqlDirective{{name: "skip", args: map[string]any{"if": gqlVariable("skip")}}},
+		selections: []gqlSelection{
+			{name: "id"},
+			{fragment: "Names"},
+			{
+				inline:     true,
+				directives: []gqlDirective{{name: "include", args: map[string]any{"if": true}}},
+				selections: []gqlSelection{{name: "severity"}},
+			},
+		},
+	}}
+	if !reflect.DeepEqual(op.selections, wantSels) {
+		t.Errorf("selections =\n%+v\nwant\n%+v", op.selections, wantSels)
+	}
+	if want := []gqlSelection{{name: "road"}, {name: "city"}}; !reflect.DeepEqual(doc.fragments["Names"], want) {
+		t.Errorf("fragment Names = %+v, want %+v", doc.fragments["Names"], want)
+	}
+	if anon := doc.operations[1]; anon.kind != "query" || anon.name != "" || len(anon.selections) != 1 {
+		t.Errorf("shorthand operation = %+v, want an anonymous query of one field", anon)
+	}
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"", "the document has no operations"},
+		{"{ }", "empty selection set"},
+		{"{ a", "expected a name, got end of document"},
+		{"{ a(b: ) }", "expected a value, got \")\""},
+		{"{ a(b 1) }", `expected ":"`},
+		{"query ($a Int) { b }", `expected ":"`},
+		{"fragment F { a }", `expected "on"`},
+		{`{ a(s: "open) }`, "unterminated string"},
+		{`{ a(s: "\q") }`, `invalid escape \q`},
+		{"{ a(s: \"\"\"open) }", "unterminated block string"},
+		{"{ a ; }", `unexpected character ';'`},
+		{"type Query { a: Int }", "expected an operation or fragment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := parseGraphQL(tt.query)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseGraphQL(%q) error = %v, want one containing %q", tt.query, err, tt.want)
+			}
+		})
+	}
+}
+
+// testGraphQLRoot is a Query type for executor tests: greet(name) and fail
+// resolve to scalars, node to a Node whose child is another Node, and items(n)
+// to a list of n Items.
+func testGraphQLRoot() gqlObject {
+	var node func(depth int) gqlObject
+	node = func(depth int) gqlObject {
+		return gqlObject{typeName: "Node", field: func(name string, args gqlArgs) (any, error) {
+			switch name {
+			case "depth":
+				return depth, nil
+			case "child":
+				return node(depth + 1), nil
+			}
+			return nil, unknownGraphQLField("Node", name)
+		}}
+	}
+	return gqlObject{typeName: "Query", field: func(name string, args gqlArgs) (any, error) {
+		switch name {
+		case "greet":
+			who, err := args.string("name", "world")
+			if err != nil {
+				return nil, err
+			}
+			return "hello " + who, nil
+		case "fail":
+			return nil, fmt.Errorf("resolver failed")
+		case "node":
+			return node(1), nil
+		case "items":
+			n, err := args.int("n", 1)
+			if err != nil {
+				return nil, err
+			}
+			items := make([]gqlObject, n)
+			for i := range items {
+				items[i] = gqlValues("Item", map[string]any{"i": i})
+			}
+			return items, nil
+		}
+		return nil, unknownGraphQLField("Query", name)
+	}}
+}
+
+func TestExecuteGraphQL(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		variables     map[string]any
+		operationName string
+		// want is the response as JSON.
+		want string
+	}{{
+		name:  "fields and arguments",
+		query: `{ greet a: greet(name: "Wake") __typename }`,
+		want:  `{"data":{"greet":"hello world","a":"hello Wake","__typename":"Query"}}`,
+	}, {
+		name:      "variables and defaults",
+		query:     `query ($who: String, $other: String = "Durham") { a: greet(name: $who) b: greet(name: $other) }`,
+		variables: map[string]any{"who": "Wake"},
+		want:      `{"data":{"a":"hello Wake","b":"hello Durham"}}`,
+	}, {
+		name:  "required variable",
+		query: `query ($who: String!) { greet(name: $who) }`,
+		want:  `{"errors":[{"message":"variable $who is required"}]}`,
+	}, {
+		name:      "JSON number variable",
+		query:     `query ($n: Int) { items(n: $n) { i } }`,
+		variables: map[string]any{"n": 2.0},
+		want:      `{"data":{"items":[{"i":0},{"i":1}]}}`,
+	}, {
+		name:  "fragments merge",
+		query: `{ node { ...D ... on Node { child { depth } } child { __typename } } } fragment D on Node { depth }`,
+		want:  `{"data":{"node":{"depth":1,"child":{"depth":2,"__typename":"Node"}}}}`,
+	}, {
+		name:      "skip and include",
+		query:     `query ($yes: Boolean = true) { a: greet @skip(if: $yes) b: greet @include(if: $yes) c: greet @include(if: false) }`,
+		variables: map[string]any{},
+		want:      `{"data":{"b":"hello world"}}`,
+	}, {
+		name:  "field errors",
+		query: `{ greet fail node { nope } }`,
+		want: `{"data":{"greet":"hello world","fail":null,"node":{"nope":null}},"errors":[` +
+			`{"message":"resolver failed","path":["fail"]},` +
+			`{"message":"cannot query field \"nope\" on type Node","path":["node","nope"]}]}`,
+	}, {
+		name:  "bad argument",
+		query: `{ items(n: "two") { i } }`,
+		want:  `{"data":{"items":null},"errors":[{"message":"argument \"n\" must be an Int","path":["items"]}]}`,
+	}, {
+		name:  "missing subfields",
+		query: `{ node greet { x } }`,
+		want: `{"data":{"node":null,"greet":null},"errors":[` +
+			`{"message":"field \"node\" of type Node needs a selection of subfields","path":["node"]},` +
+			`{"message":"field \"greet\" is a scalar and has no subfields","path":["greet"]}]}`,
+	}, {
+		name:          "operation by name",
+		query:         `query A { a: greet } query B { b: greet }`,
+		operationName: "B",
+		want:          `{"data":{"b":"hello world"}}`,
+	}, {
+		name:  "operation name required",
+		query: `query A { a: greet } query B { b: greet }`,
+		want:  `{"errors":[{"message":"operationName is required for a document with several operations"}]}`,
+	}, {
+		name:  "mutation",
+		query: `mutation { greet }`,
+		want:  `{"errors":[{"message":"only queries are supported, not mutations"}]}`,
+	}, {
+		name:  "syntax error",
+		query: `{ greet(`,
+		want:  `{"errors":[{"message":"syntax error: expected a name, got end of document"}]}`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := executeGraphQL(tt.query, tt.variables, tt.operationName, testGraphQLRoot())
+			got, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("response =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteGraphQLLimits(t *testing.T) {
+	// node, then children down to depth at the given depth.
+	nested := func(depth int) string {
+		return "{ node { " + strings.Repeat("child { ", depth-2) + "depth" + strings.Repeat(" }", depth)
+	}
+	aliases := func(n int) string {
+		var b strings.Builder
+		b.WriteString("{ ")
+		for i := range n {
+			fmt.Fprintf(&b, "a%d: greet ", i)
+		}
+		b.WriteString("}")
+		return b.String()
+	}
+	// Each fragment spreads the next twice under different keys, so the
+	// document is small but selects 2^10 fields.
+	var fanOut strings.Builder
+	fanOut.WriteString("{ node { ...F0 } }")
+	for i := range 10 {
+		fmt.Fprintf(&fanOut, " fragment F%d on Node { x%d: child { ...F%d } y%d: child { ...F%d } }", i, i, i+1, i, i+1)
+	}
+	fanOut.WriteString(" fragment F10 on Node { depth }")
+
+	tests := []struct {
+		name  string
+		query string
+		// wantErr is the error expected, or "" for none.
+		wantErr string
+		// wantData is whether the query runs.
+		wantData bool
+	}{
+		{"deepest allowed", nested(maxGraphQLDepth), "", true},
+		{"too deep", nested(maxGraphQLDepth + 1), fmt.Sprintf("the query nests fields more than %d deep", maxGraphQLDepth), false},
+		{"most fields allowed", aliases(maxGraphQLFields), "", true},
+		{"too many aliases", aliases(maxGraphQLFields + 1), fmt.Sprintf("the query selects more than %d fields", maxGraphQLFields), false},
+		{"fragment fan-out", fanOut.String(), "the query", false},
+		{"too many resolves", fmt.Sprintf("{ items(n: %d) { i } }", maxGraphQLResolves), fmt.Sprintf("the query resolves more than %d fields", maxGraphQLResolves), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := executeGraphQL(tt.query, nil, "", testGraphQLRoot())
+			if (resp.Data != nil) != tt.wantData {
+				t.Errorf("data = %v, want data %v", resp.Data, tt.wantData)
+			}
+			switch {
+			case tt.wantErr == "" && len(resp.Errors) > 0:
+				t.Errorf("errors = %v, want none", resp.Errors)
+			case tt.wantErr != "" && (len(resp.Errors) != 1 || !strings.HasPrefix(resp.Errors[0].Message, tt.wantErr)):
+				t.Errorf("errors = %v, want one starting %q", resp.Errors, tt.wantErr)
+			}
+		})
+	}
+}
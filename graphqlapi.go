@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+)
+
+// graphQLSchema describes the /graphql endpoint, and is served at
+// /graphql/schema in place of introspection.
+const graphQLSchema = `"Times are RFC 3339 text; from and to also take YYYY-MM-DD dates."
+type Query {
+  "Incidents matching every given filter, by ID. status is active, cleared or all (the default)."
+  incidents(status: String, county: Int, type: String, road: String, minSeverity: Int, limit: Int = 100, offset: Int = 0): [Incident!]!
+  "How many incidents match the filters, ignoring paging."
+  incidentCount(status: String, county: Int, type: String, road: String, minSeverity: Int): Int!
+  incident(id: Int!): Incident
+  "The recorded changes to an incident, oldest first."
+  history(id: Int!): [IncidentChange!]!
+  "Incidents by type and status."
+  countsByType: [TypeCount!]!
+  "The roads with the most incidents starting in [from, to), 7 days to now by default."
+  countsByRoad(from: String, to: String, limit: Int = 10): [RoadCount!]!
+  "Incidents starting in [from, to) by UTC hour, 24 hours to now by default. Hours without any are left out."
+  countsByHour(from: String, to: String): [HourCount!]!
+}
+
+type Incident {
+  id: Int!
+  status: String!
+  latitude: Float!
+  longitude: Float!
+  commonName: String!
+  reason: String!
+  condition: String!
+  incidentType: String!
+  severity: Int!
+  direction: String!
+  location: String!
+  countyId: Int!
+  countyName: String!
+  city: String!
+  "The feed's times, null when it sent none or one that doesn't parse."
+  start: String
+  end: String
+  lastUpdate: String
+  road: String!
+  routeId: Int!
+  lanesClosed: Int!
+  lanesTotal: Int!
+  detour: String!
+  crossStreetPrefix: String!
+  crossStreetNumber: Int!
+  crossStreetSuffix: String!
+  crossStreetCommonName: String!
+  event: String!
+  createdFromConcurrent: Boolean!
+  movableConstruction: String!
+  workZoneSpeedLimit: Int!
+  clearedTime: String
+  durationSeconds: Int
+  reopenCount: Int!
+  reopenedAt: String
+  history: [IncidentChange!]!
+}
+
+type IncidentChange {
+  incidentId: Int!
+  observedAt: String!
+  field: String!
+  oldValue: String!
+  newValue: String!
+  lastUpdate: String!
+}
+
+type TypeCount {
+  incidentType: String!
+  status: String!
+  count: Int!
+}
+
+type RoadCount {
+  road: String!
+  count: Int!
+}
+
+type HourCount {
+  hour: String!
+  count: Int!
+}
+`
+
+// maxGraphQLRequest caps the size of a /graphql request body.
+const maxGraphQLRequest = 1 << 20
+
+// graphQLRequest is a /graphql request.
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// serveGraphQL runs a GraphQL query, sent as a JSON POST body or in GET's
+// query, variables and operationName parameters. Field errors come back in
+// the response's errors with a 200, as GraphQL clients expect.
+func (a *apiServer) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	if r.Method == http.MethodGet {
+		params := r.URL.Query()
+		req.Query = params.Get("query")
+		req.OperationName = params.Get("operationName")
+		if v := params.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeJSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: "variables must be a JSON object"}}})
+				return
+			}
+		}
+	} else {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGraphQLRequest))
+		if err != nil {
+			writeJSON(w, http.StatusRequestEntityTooLarge, gqlResponse{Errors: []gqlError{{Message: "the request is too large"}}})
+			return
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeJSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: "the body must be a JSON object with a query"}}})
+			return
+		}
+	}
+	if req.Query == "" {
+		writeJSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: "no query"}}})
+		return
+	}
+
+	resp := executeGraphQL(req.Query, req.Variables, req.OperationName, a.graphQLRoot(r.Context()))
+	status := http.StatusOK
+	if resp.Data == nil {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, resp)
+}
+
+// graphQLSchemaText serves the schema as SDL.
+func (a *apiServer) graphQLSchemaText(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, graphQLSchema)
+}
+
+// graphQLRoot is the Query type, resolving against the store with ctx.
+func (a *apiServer) graphQLRoot(ctx context.Context) gqlObject {
+	return gqlObject{typeName: "Query", field: func(name string, args gqlArgs) (any, error) {
+		switch name {
+		case "incidents":
+			q, err := graphQLIncidentQuery(args, true)
+			if err != nil {
+				return nil, err
+			}
+			incidents, _, err := a.store.FindIncidents(ctx, q)
+			if err != nil {
+				return nil, a.graphQLStoreError("querying incidents", err)
+			}
+			history := &graphQLHistoryLoader{}
+			for _, incident := range incidents {
+				history.ids = append(history.ids, incident.ID)
+			}
+			objects := make([]gqlObject, 0, len(incidents))
+			for _, incident := range incidents {
+				obj, err := a.graphQLIncident(ctx, incident, history)
+				if err != nil {
+					return nil, err
+				}
+				objects = append(objects, obj)
+			}
+			return objects, nil
+
+		case "incidentCount":
+			q, err := graphQLIncidentQuery(args, false)
+			if err != nil {
+				return nil, err
+			}
+			q.Limit = 1
+			_, total, err := a.store.FindIncidents(ctx, q)
+			if err != nil {
+				return nil, a.graphQLStoreError("counting incidents", err)
+			}
+			return total, nil
+
+		case "incident":
+			id, err := graphQLID(args)
+			if err != nil {
+				return nil, err
+			}
+			incidents, _, err := a.store.FindIncidents(ctx, incidentQuery{ID: id, Limit: 1})
+			if err != nil {
+				return nil, a.graphQLStoreError("querying incidents", err)
+			}
+			if len(incidents) == 0 {
+				return nil, nil
+			}
+			return a.graphQLIncident(ctx, incidents[0], &graphQLHistoryLoader{ids: []int{id}})
+
+		case "history":
+			id, err := graphQLID(args)
+			if err != nil {
+				return nil, err
+			}
+			return a.graphQLHistory(ctx, id)
+
+		case "countsByType":
+			counts, err := a.store.IncidentCounts(ctx)
+			if err != nil {
+				return nil, a.graphQLStoreError("counting incidents", err)
+			}
+			objects := make([]gqlObject, len(counts))
+			for i, c := range counts {
+				objects[i] = gqlValues("TypeCount", map[string]any{"incidentType": c.IncidentType, "status": c.Status, "count": c.Count})
+			}
+			return objects, nil
+
+		case "countsByRoad":
+			from, to, err := graphQLWindow(args, 7*24*time.Hour)
+			if err != nil {
+				return nil, err
+			}
+			limit, err := args.int("limit", 10)
+			if err != nil || limit < 1 || limit > maxPageSize {
+				return nil, fmt.Errorf("limit must be from 1 to %d", maxPageSize)
+			}
+			counts, err := a.store.RoadCounts(ctx, from, to, limit)
+			if err != nil {
+				return nil, a.graphQLStoreError("counting incidents by road", err)
+			}
+			objects := make([]gqlObject, len(counts))
+			for i, c := range counts {
+				objects[i] = gqlValues("RoadCount", map[string]any{"road": c.Road, "count": c.Count})
+			}
+			return objects, nil
+
+		case "countsByHour":
+			from, to, err := graphQLWindow(args, 24*time.Hour)
+			if err != nil {
+				return nil, err
+			}
+			counts, err := a.store.HourlyCounts(ctx, from, to)
+			if err != nil {
+				return nil, a.graphQLStoreError("counting incidents by hour", err)
+			}
+			objects := make([]gqlObject, len(counts))
+			for i, c := range counts {
+				objects[i] = gqlValues("HourCount", map[string]any{"hour": c.Hour.UTC().Format(time.RFC3339), "count": c.Count})
+			}
+			return objects, nil
+		}
+		return nil, unknownGraphQLField("Query", name)
+	}}
+}
+
+// graphQLIncident is an Incident object. Its fields are those of the REST
+// API's JSON, plus the incident's history, loaded with the rest of the list's.
+func (a *apiServer) graphQLIncident(ctx context.Context, incident StoredIncident, history *graphQLHistoryLoader) (gqlObject, error) {
+	data, err := json.Marshal(incident)
+	if err != nil {
+		return gqlObject{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return gqlObject{}, err
+	}
+	return gqlObject{typeName: "Incident", field: func(name string, args gqlArgs) (any, error) {
+		if name == "history" {
+			return history.load(ctx, a, incident.ID)
+		}
+		v, ok := values[name]
+		if !ok {
+			if graphQLOptionalIncidentFields[name] {
+				return nil, nil
+			}
+			return nil, unknownGraphQLField("Incident", name)
+		}
+		return v, nil
+	}}, nil
+}
+
+// graphQLOptionalIncidentFields are the Incident fields left out of the JSON
+// when unset.
+var graphQLOptionalIncidentFields = map[string]bool{"clearedTime": true, "durationSeconds": true, "reopenedAt": true}
+
+// graphQLHistoryLoader loads the history of a list of incidents in one query,
+// the first time one of them is asked for it, rather than a query per
+// incident. Queries run one field at a time, so it needs no lock.
+type graphQLHistoryLoader struct {
+	ids     []int
+	loaded  bool
+	changes map[int][]IncidentChange
+	err     error
+}
+
+// load returns the incident's changes as IncidentChange objects.
+func (l *graphQLHistoryLoader) load(ctx context.Context, a *apiServer, id int) ([]gqlObject, error) {
+	if !l.loaded {
+		l.loaded = true
+		if l.changes, l.err = a.store.IncidentChangesByID(ctx, l.ids); l.err != nil {
+			l.err = a.graphQLStoreError("querying incident history", l.err)
+		}
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	return graphQLChanges(l.changes[id]), nil
+}
+
+// graphQLHistory lists an incident's changes as IncidentChange objects.
+func (a *apiServer) graphQLHistory(ctx context.Context, id int) ([]gqlObject, error) {
+	changes, err := a.store.IncidentChanges(ctx, id)
+	if err != nil {
+		return nil, a.graphQLStoreError(fmt.Sprintf("querying the history of incident %d", id), err)
+	}
+	return graphQLChanges(changes), nil
+}
+
+// graphQLChanges returns changes as IncidentChange objects.
+func graphQLChanges(changes []IncidentChange) []gqlObject {
+	objects := make([]gqlObject, len(changes))
+	for i, c := range changes {
+		objects[i] = gqlValues("IncidentChange", map[string]any{
+			"incidentId": c.IncidentID,
+			"observedAt": c.ObservedAt.UTC().Format(time.RFC3339),
+			"field":      c.Field,
+			"oldValue":   c.OldValue,
+			"newValue":   c.NewValue,
+			"lastUpdate": c.LastUpdate.String(),
+		})
+	}
+	return objects
+}
+
+// graphQLStoreError logs a store error and returns the one shown to clients.
+func (a *apiServer) graphQLStoreError(doing string, err error) error {
+	slog.Error("API: error "+doing, "err", err)
+	return fmt.Errorf("could not query the database")
+}
+
+// gqlValues is an object whose fields are values.
+func gqlValues(typeName string, values map[string]any) gqlObject {
+	return gqlObject{typeName: typeName, field: func(name string, args gqlArgs) (any, error) {
+		v, ok := values[name]
+		if !ok {
+			return nil, unknownGraphQLField(typeName, name)
+		}
+		return v, nil
+	}}
+}
+
+// unknownGraphQLField is the error for selecting a field a type doesn't have.
+func unknownGraphQLField(typeName, name string) error {
+	return fmt.Errorf("cannot query field %q on type %s", name, typeName)
+}
+
+// graphQLID reads a required id argument.
+func graphQLID(args gqlArgs) (int, error) {
+	id, err := args.int("id", 0)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("id must be a positive Int")
+	}
+	return id, nil
+}
+
+// graphQLIncidentQuery reads the incident filters, and with paged the limit
+// and offset, checked as parseIncidentQuery checks the REST parameters.
+func graphQLIncidentQuery(args gqlArgs, paged bool) (incidentQuery, error) {
+	var q incidentQuery
+	var err error
+	if q.Status, err = args.string("status", ""); err != nil {
+		return q, err
+	}
+	if q.Status != "" && q.Status != "all" && q.Status != "active" && q.Status != "cleared" {
+		return q, fmt.Errorf("status must be active, cleared or all, got %q", q.Status)
+	}
+	if q.IncidentType, err = args.string("type", ""); err != nil {
+		return q, err
+	}
+	if q.Road, err = args.string("road", ""); err != nil {
+		return q, err
+	}
+	ints := []struct {
+		name     string
+		dest     *int
+		def      int
+		min, max int
+	}{
+		{"county", &q.CountyID, 0, 1, ncCountyCount},
+		{"minSeverity", &q.MinSeverity, 0, 0, math.MaxInt32},
+		{"limit", &q.Limit, defaultPageSize, 1, maxPageSize},
+		{"offset", &q.Offset, 0, 0, math.MaxInt32},
+	}
+	if !paged {
+		ints = ints[:2]
+	}
+	for _, p := range ints {
+		if args[p.name] == nil {
+			*p.dest = p.def
+			continue
+		}
+		n, err := args.int(p.name, p.def)
+		if err != nil || n < p.min || n > p.max {
+			return q, fmt.Errorf("%s must be a whole number from %d to %d", p.name, p.min, p.max)
+		}
+		*p.dest = n
+	}
+	return q, nil
+}
+
+// graphQLWindow reads the from and to arguments, defaulting to the span
+// before now.
+func graphQLWindow(args gqlArgs, span time.Duration) (time.Time, time.Time, error) {
+	to := time.Now()
+	from := to.Add(-span)
+	for _, p := range []struct {
+		name string
+		dest *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		text, err := args.string(p.name, "")
+		if err != nil {
+			return from, to, err
+		}
+		if text == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			if t, err = time.ParseInLocation(time.DateOnly, text, time.UTC); err != nil {
+				return from, to, fmt.Errorf("%s must be an RFC 3339 time or YYYY-MM-DD date, got %q", p.name, text)
+			}
+		}
+		*p.dest = t
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
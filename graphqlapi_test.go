@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyCountingStore counts the history queries made of a store.
+type historyCountingStore struct {
+	Store
+	changes, changesByID int
+}
+
+func (s *historyCountingStore) IncidentChanges(ctx context.Context, id int) ([]IncidentChange, error) {
+	s.changes++
+	return s.Store.IncidentChanges(ctx, id)
+}
+
+func (s *historyCountingStore) IncidentChangesByID(ctx context.Context, ids []int) (map[int][]IncidentChange, error) {
+	s.changesByID++
+	return s.Store.IncidentChangesByID(ctx, ids)
+}
+
+// newGraphQLTestStore returns a store of three incidents, the first two with
+// a change of severity recorded.
+func newGraphQLTestStore(t *testing.T) *historyCountingStore {
+	t.Helper()
+	mem := newMemStore()
+	mem.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	ctx := context.Background()
+	incidents := []Incident{
+		{ID: 1, IncidentType: "Vehicle Crash", Road: "I-40", CountyID: 92, Severity: 1},
+		{ID: 2, IncidentType: "Vehicle Crash", Road: "US-1", CountyID: 92, Severity: 2},
+		{ID: 3, IncidentType: "Construction", Road: "I-40", CountyID: 32, Severity: 1},
+	}
+	if _, err := mem.UpsertIncidents(ctx, incidents); err != nil {
+		t.Fatal(err)
+	}
+	incidents[0].Severity, incidents[1].Severity = 3, 3
+	if _, err := mem.UpsertIncidents(ctx, incidents[:2]); err != nil {
+		t.Fatal(err)
+	}
+	return &historyCountingStore{Store: mem}
+}
+
+// postGraphQL sends a query to the API and returns the response's status and
+// body.
+func postGraphQL(t *testing.T, store Store, query string) (int, string) {
+	t.Helper()
+	body, err := json.Marshal(graphQLRequest{Query: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &apiServer{store: store}
+	rec := httptest.NewRecorder()
+	api.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body))))
+	return rec.Code, strings.TrimSpace(rec.Body.String())
+}
+
+func TestGraphQLIncidentHistoryIsBatched(t *testing.T) {
+	store := newGraphQLTestStore(t)
+	status, body := postGraphQL(t, store, `{ incidents(road: "I-40") { id history { field oldValue newValue } } all: incidents { id history { field } } }`)
+	want := `{"data":{` +
+		`"incidents":[{"id":1,"history":[{"field":"severity","oldValue":"1","newValue":"3"}]},{"id":3,"history":[]}],` +
+		`"all":[{"id":1,"history":[{"field":"severity"}]},{"id":2,"history":[{"field":"severity"}]},{"id":3,"history":[]}]}}`
+	if status != http.StatusOK || body != want {
+		t.Errorf("response = %d %s\nwant 200 %s", status, body, want)
+	}
+	// One query per list, however many incidents it has.
+	if store.changesByID != 2 || store.changes != 0 {
+		t.Errorf("made %d batched and %d single history queries, want 2 and 0", store.changesByID, store.changes)
+	}
+}
+
+func TestGraphQLIncidentWithoutHistory(t *testing.T) {
+	store := newGraphQLTestStore(t)
+	status, body := postGraphQL(t, store, `{ incident(id: 2) { id road severity clearedTime } incidentCount(type: "Vehicle Crash") }`)
+	want := `{"data":{"incident":{"id":2,"road":"US-1","severity":3,"clearedTime":null},"incidentCount":2}}`
+	if status != http.StatusOK || body != want {
+		t.Errorf("response = %d %s\nwant 200 %s", status, body, want)
+	}
+	if store.changesByID != 0 || store.changes != 0 {
+		t.Errorf("made %d batched and %d single history queries, want none", store.changesByID, store.changes)
+	}
+}
+
+func TestGraphQLIncidentUnknownTimes(t *testing.T) {
+	mem := newMemStore()
+	started := FeedTime{Time: time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)}
+	// The feed sent no end or last update time.
+	if _, err := mem.UpsertIncidents(context.Background(), []Incident{{ID: 1, Road: "I-40", StartTime: started}}); err != nil {
+		t.Fatal(err)
+	}
+	status, body := postGraphQL(t, mem, `{ incident(id: 1) { id start end lastUpdate } }`)
+	want := `{"data":{"incident":{"id":1,"start":"2024-05-01T11:00:00Z","end":null,"lastUpdate":null}}}`
+	if status != http.StatusOK || body != want {
+		t.Errorf("response = %d %s\nwant 200 %s", status, body, want)
+	}
+	for _, field := range []string{"start", "end", "lastUpdate"} {
+		if !strings.Contains(graphQLSchema, "\n  "+field+": String\n") {
+			t.Errorf("schema doesn't declare Incident.%s a nullable String", field)
+		}
+	}
+}
+
+func TestGraphQLRequestErrors(t *testing.T) {
+	store := newGraphQLTestStore(t)
+	tests := []struct {
+		name, query string
+		wantStatus  int
+		want        string
+	}{
+		{"bad argument", `{ incidents(status: "open") { id } }`, http.StatusOK,
+			`{"data":{"incidents":null},"errors":[{"message":"status must be active, cleared or all, got \"open\"","path":["incidents"]}]}`},
+		{"unknown field", `{ incident(id: 1) { id colour } }`, http.StatusOK,
+			`{"data":{"incident":{"id":1,"colour":null}},"errors":[{"message":"cannot query field \"colour\" on type Incident","path":["incident","colour"]}]}`},
+		{"syntax error", `{ incidents { id }`, http.StatusBadRequest,
+			`{"errors":[{"message":"syntax error: expected a name, got end of document"}]}`},
+		{"too deep", `{ incidents { history { ...C } } } fragment C on IncidentChange { a: field ` + strings.Repeat("b { ", maxGraphQLDepth) + "c" + strings.Repeat(" }", maxGraphQLDepth) + ` }`,
+			http.StatusBadRequest, `{"errors":[{"message":"the query nests fields more than 8 deep"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := postGraphQL(t, store, tt.query)
+			if status != tt.wantStatus || body != tt.want {
+				t.Errorf("response = %d %s\nwant %d %s", status, body, tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GRPCConfig serves the Incidents service in proto/incidents.proto alongside
+// the REST API. gRPC runs over HTTP/2, which net/http only serves over TLS, so
+// Listen needs CertFile and KeyFile.
+type GRPCConfig struct {
+	Listen   string `yaml:"listen"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// enabled reports whether the gRPC service is configured.
+func (g GRPCConfig) enabled() bool {
+	return g.Listen != ""
+}
+
+// gRPC status codes (google.golang.org/grpc/codes).
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+)
+
+// grpcMaxMessage is the largest request message accepted, gRPC's default.
+const grpcMaxMessage = 4 << 20
+
+// grpcError is a call's failure status.
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+// grpcStatus returns a failure with the given status code.
+func grpcStatus(code int, format string, args ...any) error {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// grpcHandler serves the Incidents service. Requests that aren't gRPC get a
+// 415, and unknown methods UNIMPLEMENTED.
+func (a *apiServer) grpcHandler() http.Handler {
+	methods := map[string]http.HandlerFunc{
+		"/ncdot.v1.Incidents/ListIncidents": a.grpcUnary(a.grpcListIncidents),
+		"/ncdot.v1.Incidents/GetIncident":   a.grpcUnary(a.grpcGetIncident),
+		"/ncdot.v1.Incidents/StreamEvents":  a.grpcStreamEvents,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "this port serves gRPC", http.StatusUnsupportedMediaType)
+			return
+		}
+		method, ok := methods[r.URL.Path]
+		if !ok {
+			startGRPC(w)
+			finishGRPC(w, grpcStatus(grpcUnimplemented, "unknown method %s", r.URL.Path))
+			return
+		}
+		method(w, r)
+	})
+}
+
+// grpcUnary adapts a call taking and returning one message.
+func (a *apiServer) grpcUnary(call func(ctx context.Context, req []byte) (protoMessage, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startGRPC(w)
+		req, err := readGRPCMessage(r)
+		var resp protoMessage
+		if err == nil {
+			resp, err = call(r.Context(), req)
+		}
+		if err == nil {
+			err = writeGRPCMessage(w, resp)
+		}
+		finishGRPC(w, err)
+	}
+}
+
+// startGRPC writes a call's response headers.
+func startGRPC(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	w.WriteHeader(http.StatusOK)
+}
+
+// finishGRPC writes a call's status as trailers. Errors other than grpcError
+// are logged and reported as INTERNAL.
+func finishGRPC(w http.ResponseWriter, err error) {
+	code, message := grpcOK, ""
+	var gerr *grpcError
+	switch {
+	case errors.As(err, &gerr):
+		code, message = gerr.code, gerr.message
+	case err != nil:
+		slog.Error("gRPC: internal error", "err", err)
+		code, message = grpcInternal, "internal error"
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", fmt.Sprint(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcPercentEncode(message))
+	}
+}
+
+// grpcPercentEncode escapes a status message as the gRPC spec requires.
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// readGRPCMessage reads the request's length-prefixed message. Compressed
+// messages are refused, as the response headers only accept identity.
+func readGRPCMessage(r *http.Request) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r.Body, prefix[:]); err != nil {
+		return nil, grpcStatus(grpcInvalidArgument, "missing request message")
+	}
+	if prefix[0] != 0 {
+		return nil, grpcStatus(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcStatus(grpcResourceExhausted, "request message of %d bytes is over the %d byte limit", size, grpcMaxMessage)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r.Body, msg); err != nil {
+		return nil, grpcStatus(grpcInvalidArgument, "truncated request message")
+	}
+	return msg, nil
+}
+
+// writeGRPCMessage writes one length-prefixed response message.
+func writeGRPCMessage(w http.ResponseWriter, msg protoMessage) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// grpcListIncidents implements ListIncidents, checking the filters the way
+// GET /api/incidents does.
+func (a *apiServer) grpcListIncidents(ctx context.Context, req []byte) (protoMessage, error) {
+	q := incidentQuery{}
+	err := readProto(req, func(f protoField) error {
+		switch f.num {
+		case 1:
+			q.Status = string(f.bytes)
+		case 2:
+			q.CountyID = f.int32()
+		case 3:
+			q.IncidentType = string(f.bytes)
+		case 4:
+			q.Road = string(f.bytes)
+		case 5:
+			q.MinSeverity = f.int32()
+		case 6:
+			q.Limit = f.int32()
+		case 7:
+			q.Offset = f.int32()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultPageSize
+	}
+	switch {
+	case q.Status != "" && q.Status != "all" && q.Status != "active" && q.Status != "cleared":
+		return nil, grpcStatus(grpcInvalidArgument, "status must be active, cleared or all, got %q", q.Status)
+	case q.CountyID < 0 || q.CountyID > ncCountyCount:
+		return nil, grpcStatus(grpcInvalidArgument, "county_id must be from 1 to %d", ncCountyCount)
+	case q.MinSeverity < 0 || q.Offset < 0:
+		return nil, grpcStatus(grpcInvalidArgument, "min_severity and offset cannot be negative")
+	case q.Limit < 1 || q.Limit > maxPageSize:
+		return nil, grpcStatus(grpcInvalidArgument, "limit must be from 1 to %d", maxPageSize)
+	}
+
+	incidents, total, err := a.store.FindIncidents(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("error querying incidents: %w", err)
+	}
+	var resp protoMessage
+	for _, incident := range incidents {
+		resp.message(1, storedIncidentProto(incident))
+	}
+	resp.int(2, total)
+	return resp, nil
+}
+
+// grpcGetIncident implements GetIncident.
+func (a *apiServer) grpcGetIncident(ctx context.Context, req []byte) (protoMessage, error) {
+	id := 0
+	err := readProto(req, func(f protoField) error {
+		if f.num == 1 {
+			id = f.int32()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if id <= 0 {
+		return nil, grpcStatus(grpcInvalidArgument, "id must be positive")
+	}
+	incidents, _, err := a.store.FindIncidents(ctx, incidentQuery{ID: id, Limit: 1})
+	if err != nil {
+		return nil, fmt.Errorf("error querying incident %d: %w", id, err)
+	}
+	if len(incidents) == 0 {
+		return nil, grpcStatus(grpcNotFound, "incident %d not found", id)
+	}
+	return storedIncidentProto(incidents[0]), nil
+}
+
+// grpcStreamEvents implements StreamEvents, sending the live events that
+// match the request's filters until the client goes away or the server stops.
+func (a *apiServer) grpcStreamEvents(w http.ResponseWriter, r *http.Request) {
+	startGRPC(w)
+	if a.hub == nil {
+		finishGRPC(w, grpcStatus(grpcUnavailable, "live events are only served by run --daemon"))
+		return
+	}
+	req, err := readGRPCMessage(r)
+	if err != nil {
+		finishGRPC(w, err)
+		return
+	}
+	var filter eventFilter
+	err = readProto(req, func(f protoField) error {
+		switch f.num {
+		case 1:
+			return f.repeatedInt32(func(v int) { filter.Counties = append(filter.Counties, v) })
+		case 2:
+			filter.Types = append(filter.Types, string(f.bytes))
+		case 3:
+			filter.BBox = make([]float64, 4)
+			return readProto(f.bytes, func(f protoField) error {
+				if f.num >= 1 && f.num <= 4 {
+					filter.BBox[f.num-1] = f.double()
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err == nil && filter.validate() != nil {
+		err = grpcStatus(grpcInvalidArgument, "bbox must have west <= east and south <= north")
+	}
+	if err != nil {
+		finishGRPC(w, err)
+		return
+	}
+
+	events, unsubscribe := a.hub.subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-r.Context().Done():
+			finishGRPC(w, grpcStatus(grpcUnavailable, "server shutting down"))
+			return
+		case event, ok := <-events:
+			if !ok {
+				finishGRPC(w, grpcStatus(grpcUnavailable, "fell too far behind"))
+				return
+			}
+			if !filter.matches(event.Incident) {
+				continue
+			}
+			var msg protoMessage
+			msg.string(1, event.Event)
+			msg.timestamp(2, &event.Time)
+			msg.message(3, incidentProto(event.Incident))
+			if err := writeGRPCMessage(w, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// incidentProto encodes an incident as an ncdot.v1.Incident.
+func incidentProto(i Incident) protoMessage {
+	var m protoMessage
+	m.int(1, i.ID)
+	m.double(2, i.Latitude)
+	m.double(3, i.Longitude)
+	m.string(4, i.CommonName)
+	m.string(5, i.Reason)
+	m.string(6, i.Condition)
+	m.string(7, i.IncidentType)
+	m.int(8, i.Severity)
+	m.string(9, i.Direction)
+	m.string(10, i.Location)
+	m.int(11, i.CountyID)
+	m.string(12, i.CountyName)
+	m.string(13, i.City)
+	m.string(14, i.StartTime.String())
+	m.string(15, i.EndTime.String())
+	m.string(16, i.LastUpdate.String())
+	m.string(17, i.Road)
+	m.int(18, i.RouteID)
+	m.int(19, i.LanesClosed)
+	m.int(20, i.LanesTotal)
+	m.string(21, i.Detour)
+	m.string(22, i.CrossStreetPrefix)
+	m.int(23, i.CrossStreetNumber)
+	m.string(24, i.CrossStreetSuffix)
+	m.string(25, i.CrossStreetCommonName)
+	m.string(26, i.Event)
+	m.bool(27, i.CreatedFromConcurrent)
+	m.string(28, i.MovableConstruction)
+	m.int(29, i.WorkZoneSpeedLimit)
+	return m
+}
+
+// storedIncidentProto encodes a stored incident, with its status fields.
+func storedIncidentProto(si StoredIncident) protoMessage {
+	m := incidentProto(si.Incident)
+	m.string(30, si.Status)
+	m.timestamp(31, si.ClearedTime)
+	if si.DurationSeconds != nil {
+		m.varint(32, uint64(int64(*si.DurationSeconds)))
+	}
+	m.int(33, si.ReopenCount)
+	m.timestamp(34, si.ReopenedAt)
+	return m
+}
+
+// protoMessage is an encoded protobuf message, built a field at a time.
+// Fields holding their zero value are left out, as proto3 does.
+type protoMessage []byte
+
+// varint writes a varint field, even when zero.
+func (m *protoMessage) varint(field int, v uint64) {
+	*m = binary.AppendUvarint(*m, uint64(field)<<3)
+	*m = binary.AppendUvarint(*m, v)
+}
+
+// int writes an int32 or int64 field; negative values take ten bytes.
+func (m *protoMessage) int(field, v int) {
+	if v != 0 {
+		m.varint(field, uint64(int64(v)))
+	}
+}
+
+func (m *protoMessage) bool(field int, v bool) {
+	if v {
+		m.varint(field, 1)
+	}
+}
+
+func (m *protoMessage) double(field int, v float64) {
+	if v != 0 {
+		*m = binary.AppendUvarint(*m, uint64(field)<<3|1)
+		*m = binary.LittleEndian.AppendUint64(*m, math.Float64bits(v))
+	}
+}
+
+func (m *protoMessage) string(field int, s string) {
+	if s != "" {
+		m.bytes(field, []byte(s))
+	}
+}
+
+// message writes an embedded message, even when empty, so it is present.
+func (m *protoMessage) message(field int, sub protoMessage) {
+	m.bytes(field, sub)
+}
+
+// timestamp writes a google.protobuf.Timestamp, unless t is nil.
+func (m *protoMessage) timestamp(field int, t *time.Time) {
+	if t == nil {
+		return
+	}
+	var ts protoMessage
+	ts.int(1, int(t.Unix()))
+	ts.int(2, t.Nanosecond())
+	m.message(field, ts)
+}
+
+func (m *protoMessage) bytes(field int, b []byte) {
+	*m = binary.AppendUvarint(*m, uint64(field)<<3|2)
+	*m = binary.AppendUvarint(*m, uint64(len(b)))
+	*m = append(*m, b...)
+}
+
+// protoField is one field read from a protobuf message: varint and fixed
+// values are in value, length-delimited ones in bytes.
+type protoField struct {
+	num, wire int
+	value     uint64
+	bytes     []byte
+}
+
+func (f protoField) int32() int {
+	return int(int32(f.value))
+}
+
+func (f protoField) double() float64 {
+	return math.Float64frombits(f.value)
+}
+
+// repeatedInt32 reads a repeated int32 field, packed or not.
+func (f protoField) repeatedInt32(fn func(int)) error {
+	if f.wire != 2 {
+		fn(f.int32())
+		return nil
+	}
+	for b := f.bytes; len(b) > 0; {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return grpcStatus(grpcInvalidArgument, "malformed request message")
+		}
+		fn(int(int32(v)))
+		b = b[n:]
+	}
+	return nil
+}
+
+// readProto calls fn with each field of a protobuf message, in order.
+func readProto(data []byte, fn func(protoField) error) error {
+	malformed := grpcStatus(grpcInvalidArgument, "malformed request message")
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 == 0 {
+			return malformed
+		}
+		data = data[n:]
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			if f.value, n = binary.Uvarint(data); n <= 0 {
+				return malformed
+			}
+		case 1:
+			if n = 8; len(data) < n {
+				return malformed
+			}
+			f.value = binary.LittleEndian.Uint64(data)
+		case 2:
+			size, m := binary.Uvarint(data)
+			if m <= 0 || size > uint64(len(data)-m) {
+				return malformed
+			}
+			n = m + int(size)
+			f.bytes = data[m:n]
+		case 5:
+			if n = 4; len(data) < n {
+				return malformed
+			}
+			f.value = uint64(binary.LittleEndian.Uint32(data))
+		default:
+			return malformed
+		}
+		data = data[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// incidentsService compiles proto/incidents.proto, so responses are checked
+// against the published schema rather than the encoder's idea of it.
+func incidentsService(t *testing.T) protoreflect.ServiceDescriptor {
+	t.Helper()
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: []string{"proto"}}),
+	}
+	files, err := compiler.Compile(context.Background(), "incidents.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := files[0].Services().ByName("Incidents")
+	if service == nil {
+		t.Fatal("incidents.proto has no Incidents service")
+	}
+	return service
+}
+
+// grpcMethod returns a method of the service by name.
+func grpcMethod(t *testing.T, service protoreflect.ServiceDescriptor, name protoreflect.Name) protoreflect.MethodDescriptor {
+	t.Helper()
+	method := service.Methods().ByName(name)
+	if method == nil {
+		t.Fatalf("incidents.proto has no method %s", name)
+	}
+	return method
+}
+
+// newRequest returns an empty request message of the method with fields set
+// by name.
+func newRequest(method protoreflect.MethodDescriptor, fields map[protoreflect.Name]protoreflect.Value) *dynamicpb.Message {
+	req := dynamicpb.NewMessage(method.Input())
+	for name, v := range fields {
+		req.Set(method.Input().Fields().ByName(name), v)
+	}
+	return req
+}
+
+// grpcFrame length-prefixes a request message.
+func grpcFrame(t *testing.T, msg proto.Message) []byte {
+	t.Helper()
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	return append(frame, b...)
+}
+
+// readGRPCFrames decodes the length-prefixed response messages in body as
+// the method's output type. Fields the schema doesn't know fail the test.
+func readGRPCFrames(t *testing.T, method protoreflect.MethodDescriptor, body []byte) []*dynamicpb.Message {
+	t.Helper()
+	var msgs []*dynamicpb.Message
+	for len(body) > 0 {
+		if len(body) < 5 || body[0] != 0 {
+			t.Fatalf("malformed response frame % x", body)
+		}
+		size := binary.BigEndian.Uint32(body[1:5])
+		if uint32(len(body)-5) < size {
+			t.Fatalf("response frame of %d bytes has only %d", size, len(body)-5)
+		}
+		msg := dynamicpb.NewMessage(method.Output())
+		if err := proto.Unmarshal(body[5:5+size], msg); err != nil {
+			t.Fatalf("could not decode %s: %v", method.Output().FullName(), err)
+		}
+		checkNoUnknownFields(t, msg)
+		msgs = append(msgs, msg)
+		body = body[5+size:]
+	}
+	return msgs
+}
+
+// checkNoUnknownFields fails the test if msg or a message in it has fields
+// the schema doesn't declare, or declares with another type.
+func checkNoUnknownFields(t *testing.T, msg protoreflect.Message) {
+	t.Helper()
+	if len(msg.GetUnknown()) > 0 {
+		t.Errorf("%s has unknown fields % x", msg.Descriptor().FullName(), msg.GetUnknown())
+	}
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			for i := range v.List().Len() {
+				checkNoUnknownFields(t, v.List().Get(i).Message())
+			}
+		case fd.Message() != nil:
+			checkNoUnknownFields(t, v.Message())
+		}
+		return true
+	})
+}
+
+// get returns a field of msg by name.
+func get(msg protoreflect.Message, name protoreflect.Name) protoreflect.Value {
+	return msg.Get(msg.Descriptor().Fields().ByName(name))
+}
+
+// has reports whether msg has a field set, by name.
+func has(msg protoreflect.Message, name protoreflect.Name) bool {
+	return msg.Has(msg.Descriptor().Fields().ByName(name))
+}
+
+// callGRPC makes a unary call and returns the decoded response messages and
+// the call's grpc-status and grpc-message.
+func callGRPC(t *testing.T, api *apiServer, method protoreflect.MethodDescriptor, req proto.Message) ([]*dynamicpb.Message, string, string) {
+	t.Helper()
+	path := "/" + string(method.Parent().FullName()) + "/" + string(method.Name())
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(grpcFrame(t, req)))
+	r.Header.Set("Content-Type", "application/grpc")
+	rec := httptest.NewRecorder()
+	api.grpcHandler().ServeHTTP(rec, r)
+	resp := rec.Result()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readGRPCFrames(t, method, body), resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+}
+
+// newGRPCTestStore returns a store of two incidents, the first cleared after
+// an hour.
+func newGRPCTestStore(t *testing.T) *memStore {
+	t.Helper()
+	started := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	cleared := started.Add(time.Hour)
+	mem := newMemStore()
+	mem.now = func() time.Time { return cleared }
+	ctx := context.Background()
+	incidents := []Incident{
+		{ID: 1, Latitude: 35.78, Longitude: -78.64, IncidentType: "Vehicle Crash", Road: "I-40", CountyID: 92, CountyName: "Wake",
+			Severity: 2, StartTime: FeedTime{started}, LanesClosed: 1, LanesTotal: 3, CreatedFromConcurrent: true},
+		{ID: 2, Latitude: 35.99, Longitude: -78.90, IncidentType: "Construction", Road: "US-15", CountyID: 32, Severity: 1},
+	}
+	if _, err := mem.UpsertIncidents(ctx, incidents); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.MarkCleared(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	return mem
+}
+
+func TestGRPCListIncidents(t *testing.T) {
+	service := incidentsService(t)
+	method := grpcMethod(t, service, "ListIncidents")
+	api := &apiServer{store: newGRPCTestStore(t)}
+
+	msgs, status, message := callGRPC(t, api, method, newRequest(method, nil))
+	if status != "0" || len(msgs) != 1 {
+		t.Fatalf("call = %d messages, status %s %q; want 1 message, status 0", len(msgs), status, message)
+	}
+	resp := msgs[0]
+	if total := get(resp, "total").Int(); total != 2 {
+		t.Errorf("total = %d, want 2", total)
+	}
+	incidents := get(resp, "incidents").List()
+	if incidents.Len() != 2 {
+		t.Fatalf("got %d incidents, want 2", incidents.Len())
+	}
+
+	first := incidents.Get(0).Message()
+	for name, want := range map[protoreflect.Name]any{
+		"id":                      int32(1),
+		"latitude":                35.78,
+		"longitude":               -78.64,
+		"incident_type":           "Vehicle Crash",
+		"road":                    "I-40",
+		"county_id":               int32(92),
+		"county_name":             "Wake",
+		"severity":                int32(2),
+		"start_time":              "2024-05-01T11:00:00Z",
+		"lanes_closed":            int32(1),
+		"lanes_total":             int32(3),
+		"created_from_concurrent": true,
+		"status":                  "cleared",
+		"duration_seconds":        int32(3600),
+	} {
+		if got := get(first, name).Interface(); got != want {
+			t.Errorf("incident 1 %s = %v (%T), want %v (%T)", name, got, got, want, want)
+		}
+	}
+	clearedTime := get(first, "cleared_time").Message()
+	if seconds := get(clearedTime, "seconds").Int(); seconds != time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Unix() {
+		t.Errorf("incident 1 cleared_time = %d seconds, want noon", seconds)
+	}
+
+	second := incidents.Get(1).Message()
+	if get(second, "status").String() != "active" || has(second, "cleared_time") || has(second, "duration_seconds") {
+		t.Errorf("incident 2 = %v, want active without cleared_time or duration_seconds", second)
+	}
+}
+
+func TestGRPCListIncidentsFilters(t *testing.T) {
+	service := incidentsService(t)
+	method := grpcMethod(t, service, "ListIncidents")
+	api := &apiServer{store: newGRPCTestStore(t)}
+
+	msgs, status, _ := callGRPC(t, api, method, newRequest(method, map[protoreflect.Name]protoreflect.Value{
+		"status":    protoreflect.ValueOfString("active"),
+		"county_id": protoreflect.ValueOfInt32(32),
+		"limit":     protoreflect.ValueOfInt32(10),
+	}))
+	if status != "0" || len(msgs) != 1 {
+		t.Fatalf("call = %d messages, status %s; want 1 message, status 0", len(msgs), status)
+	}
+	incidents := get(msgs[0], "incidents").List()
+	if incidents.Len() != 1 || get(incidents.Get(0).Message(), "id").Int() != 2 || get(msgs[0], "total").Int() != 1 {
+		t.Errorf("response = %v, want incident 2 of 1", msgs[0])
+	}
+
+	msgs, status, message := callGRPC(t, api, method, newRequest(method, map[protoreflect.Name]protoreflect.Value{
+		"status": protoreflect.ValueOfString("open"),
+	}))
+	if len(msgs) != 0 || status != "3" || message != `status must be active, cleared or all, got "open"` {
+		t.Errorf("call = %d messages, status %s %q; want INVALID_ARGUMENT (3)", len(msgs), status, message)
+	}
+}
+
+func TestGRPCGetIncident(t *testing.T) {
+	service := incidentsService(t)
+	method := grpcMethod(t, service, "GetIncident")
+	api := &apiServer{store: newGRPCTestStore(t)}
+
+	msgs, status, _ := callGRPC(t, api, method, newRequest(method, map[protoreflect.Name]protoreflect.Value{
+		"id": protoreflect.ValueOfInt32(2),
+	}))
+	if status != "0" || len(msgs) != 1 || get(msgs[0], "road").String() != "US-15" {
+		t.Errorf("call = %v, status %s; want incident 2, status 0", msgs, status)
+	}
+
+	msgs, status, message := callGRPC(t, api, method, newRequest(method, map[protoreflect.Name]protoreflect.Value{
+		"id": protoreflect.ValueOfInt32(7),
+	}))
+	if len(msgs) != 0 || status != "5" || message != "incident 7 not found" {
+		t.Errorf("call = %d messages, status %s %q; want NOT_FOUND (5)", len(msgs), status, message)
+	}
+}
+
+func TestGRPCStreamEvents(t *testing.T) {
+	service := incidentsService(t)
+	method := grpcMethod(t, service, "StreamEvents")
+	hub := newEventHub()
+	server := httptest.NewServer((&apiServer{store: newMemStore(), hub: hub}).grpcHandler())
+	defer server.Close()
+
+	req := newRequest(method, nil)
+	counties := req.Mutable(method.Input().Fields().ByName("county_ids")).List()
+	counties.Append(protoreflect.ValueOfInt32(92))
+	// The stream only sees events published once it has subscribed, and its
+	// response headers only come with the first event, so events are
+	// published until one arrives.
+	at := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			hub.publish([]IncidentEvent{
+				{Event: "created", Time: at, Incident: Incident{ID: 3, CountyID: 32}},
+				{Event: "updated", Time: at, Incident: Incident{ID: 4, CountyID: 92, Road: "I-440"}},
+			})
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/ncdot.v1.Incidents/StreamEvents", bytes.NewReader(grpcFrame(t, req)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(resp.Body, prefix); err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(resp.Body, frame); err != nil {
+		t.Fatal(err)
+	}
+	msgs := readGRPCFrames(t, method, append(prefix, frame...))
+	event := msgs[0]
+	incident := get(event, "incident").Message()
+	if get(event, "event").String() != "updated" || get(get(event, "time").Message(), "seconds").Int() != at.Unix() ||
+		get(incident, "id").Int() != 4 || get(incident, "road").String() != "I-440" {
+		t.Errorf("first event = %v, want the update of incident 4 in county 92", event)
+	}
+}
+
+func TestGRPCStreamEventsWithoutDaemon(t *testing.T) {
+	service := incidentsService(t)
+	method := grpcMethod(t, service, "StreamEvents")
+	msgs, status, message := callGRPC(t, &apiServer{store: newMemStore()}, method, newRequest(method, nil))
+	if len(msgs) != 0 || status != "14" || message != "live events are only served by run --daemon" {
+		t.Errorf("call = %d messages, status %s %q; want UNAVAILABLE (14)", len(msgs), status, message)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthStaleCycles is how many polling intervals may pass without a
+// successful feed fetch before the poller counts as wedged.
+const healthStaleCycles = 3
+
+// pollerHealth tracks the daemon's feed fetches for the health checks.
+type pollerHealth struct {
+	mu sync.Mutex
+	// started is when the daemon began, so the first fetch gets the same grace
+	// period as later ones.
+	started   time.Time
+	staleAge  time.Duration
+	lastFetch time.Time
+	lastError string
+	// standby is set while another instance leads and this one doesn't
+	// fetch; lastStandby is its last check for the leader lock.
+	standby     bool
+	lastStandby time.Time
+}
+
+// newPollerHealth returns the health of a poller starting now.
+func newPollerHealth(interval time.Duration) *pollerHealth {
+	return &pollerHealth{started: time.Now(), staleAge: healthStaleCycles * interval}
+}
+
+// fetched records a feed fetch and its error. A nil tracker does nothing.
+func (h *pollerHealth) fetched(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.lastError = err.Error()
+		return
+	}
+	h.lastFetch = time.Now()
+	h.lastError = ""
+	h.standby = false
+}
+
+// standingBy records a cycle skipped because another instance leads. A
+// standby instance counts as fresh as long as it keeps checking. A nil
+// tracker does nothing.
+func (h *pollerHealth) standingBy() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.standby = true
+	h.lastStandby = time.Now()
+}
+
+// pollerStatus is the poller part of a health response.
+type pollerStatus struct {
+	LastFetch *time.Time `json:"lastFetch"`
+	// LastFetchAgeSeconds counts from the daemon's start before the first fetch.
+	LastFetchAgeSeconds int    `json:"lastFetchAgeSeconds"`
+	LastError           string `json:"lastError,omitempty"`
+	Standby             bool   `json:"standby,omitempty"`
+	Stale               bool   `json:"stale"`
+}
+
+// status reports the poller's state now.
+func (h *pollerHealth) status() pollerStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var ps pollerStatus
+	since := h.started
+	if !h.lastFetch.IsZero() {
+		last := h.lastFetch
+		ps.LastFetch = &last
+		since = last
+	}
+	age := time.Since(since)
+	ps.LastFetchAgeSeconds = int(age.Seconds())
+	ps.LastError = h.lastError
+	ps.Stale = age > h.staleAge
+	if h.standby {
+		ps.Standby = true
+		ps.Stale = time.Since(h.lastStandby) > h.staleAge
+	}
+	return ps
+}
+
+// healthResponse is the body of /healthz and /readyz.
+type healthResponse struct {
+	Status   string        `json:"status"`
+	Database string        `json:"database"`
+	Poller   *pollerStatus `json:"poller,omitempty"`
+}
+
+// checkHealth pings the database and reports the daemon's poller. With
+// requirePoller, a stale poller fails the check.
+func (a *apiServer) checkHealth(ctx context.Context, requirePoller bool) healthResponse {
+	resp := healthResponse{Status: "ok", Database: "ok"}
+	ok := true
+	if err := a.store.Ping(ctx); err != nil {
+		slog.Warn("Health check: database unreachable", "err", err)
+		resp.Database = "unreachable"
+		ok = false
+	}
+	if a.health != nil {
+		ps := a.health.status()
+		resp.Poller = &ps
+		if requirePoller && ps.Stale {
+			ok = false
+		}
+	}
+	if !ok {
+		resp.Status = "unavailable"
+	}
+	return resp
+}
+
+// healthz is the liveness check: the database answers and, in the daemon, the
+// feed was fetched within the last few polling intervals. A wedged poller
+// fails it, so a supervisor restarts the process.
+func (a *apiServer) healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, a.checkHealth(r.Context(), true))
+}
+
+// readyz is the readiness check: the database answers, so the API can serve.
+// It still reports the poller's state, without failing on it.
+func (a *apiServer) readyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, a.checkHealth(r.Context(), false))
+}
+
+// writeHealth writes a health response, with a 503 when unhealthy.
+func writeHealth(w http.ResponseWriter, resp healthResponse) {
+	w.Header().Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
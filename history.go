@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Fields whose changes are recorded in incident_updates.
+const (
+	fieldSeverity    = "severity"
+	fieldLanesClosed = "lanes_closed"
+	fieldLanesTotal  = "lanes_total"
+	fieldCondition   = "condition"
+	fieldReason      = "reason"
+)
+
+// IncidentChange is one field of an incident changing between two observations
+// of the feed, as recorded in incident_updates.
+type IncidentChange struct {
+	IncidentID int       `json:"incidentId"`
+	ObservedAt time.Time `json:"observedAt"`
+	Field      string    `json:"field"`
+	OldValue   string    `json:"oldValue"`
+	NewValue   string    `json:"newValue"`
+	// LastUpdate is the feed's lastUpdate for the new value.
+	LastUpdate FeedTime `json:"lastUpdate"`
+}
+
+// changes lists the tracked fields that differ between the stored incident and
+// the feed's copy. A new incident has none; its first row is its starting state.
+func (s storedIncident) changes(incident Incident) []IncidentChange {
+	if !s.Exists {
+		return nil
+	}
+	var changes []IncidentChange
+	add := func(field, old, new string) {
+		if old != new {
+			changes = append(changes, IncidentChange{IncidentID: incident.ID, Field: field, OldValue: old, NewValue: new, LastUpdate: incident.LastUpdate})
+		}
+	}
+	add(fieldSeverity, strconv.Itoa(s.Severity), strconv.Itoa(incident.Severity))
+	add(fieldLanesClosed, strconv.Itoa(s.LanesClosed), strconv.Itoa(incident.LanesClosed))
+	add(fieldLanesTotal, strconv.Itoa(s.LanesTotal), strconv.Itoa(incident.LanesTotal))
+	add(fieldCondition, s.Condition, incident.Condition)
+	add(fieldReason, s.Reason, incident.Reason)
+	return changes
+}
+
+// incidentChangeColumns are the columns insertIncidentChanges writes; the
+// database sets update_id and observed_at.
+const incidentChangeColumns = "incident_id, field, old_value, new_value, last_update"
+
+// insertIncidentChanges records changes in multi-row statements of up to
+// upsertBatchSize.
+func insertIncidentChanges(ctx context.Context, tx *Tx, changes []IncidentChange) error {
+	const columns = 5
+	for start := 0; start < len(changes); start += upsertBatchSize {
+		batch := changes[start:min(start+upsertBatchSize, len(changes))]
+		rows := make([]string, len(batch))
+		args := make([]any, 0, len(batch)*columns)
+		for i, c := range batch {
+			rows[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", i*columns+1, i*columns+2, i*columns+3, i*columns+4, i*columns+5)
+			args = append(args, c.IncidentID, c.Field, c.OldValue, c.NewValue, c.LastUpdate)
+		}
+		query := "INSERT INTO incident_updates (" + incidentChangeColumns + ") VALUES " + strings.Join(rows, ", ")
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IncidentChanges reads an incident's changes in the order they were recorded.
+func (s *sqlStore) IncidentChanges(ctx context.Context, id int) ([]IncidentChange, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT incident_id, observed_at, field, COALESCE(old_value, ''), COALESCE(new_value, ''), last_update
+		FROM incident_updates
+		WHERE incident_id = $1
+		ORDER BY update_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []IncidentChange{}
+	for rows.Next() {
+		var c IncidentChange
+		if err := rows.Scan(&c.IncidentID, &c.ObservedAt, &c.Field, &c.OldValue, &c.NewValue, &c.LastUpdate); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// IncidentChangesByID reads the changes in batches of IDs. Each batch gets its
+// own timeout, so an export of every incident isn't cut short.
+func (s *sqlStore) IncidentChangesByID(ctx context.Context, ids []int) (map[int][]IncidentChange, error) {
+	changes := make(map[int][]IncidentChange)
+	for start := 0; start < len(ids); start += upsertBatchSize {
+		batch, err := s.incidentChangesBatch(ctx, ids[start:min(start+upsertBatchSize, len(ids))])
+		if err != nil {
+			return nil, err
+		}
+		for id, c := range batch {
+			changes[id] = append(changes[id], c...)
+		}
+	}
+	return changes, nil
+}
+
+// incidentChangesBatch reads the changes of one batch of IDs.
+func (s *sqlStore) incidentChangesBatch(ctx context.Context, ids []int) (map[int][]IncidentChange, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	var changes map[int][]IncidentChange
+	err := withReconnect(s.db, func() error {
+		changes = make(map[int][]IncidentChange)
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT incident_id, observed_at, field, COALESCE(old_value, ''), COALESCE(new_value, ''), last_update
+			FROM incident_updates
+			WHERE incident_id IN `+placeholderList(len(args))+`
+			ORDER BY update_id`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c IncidentChange
+			if err := rows.Scan(&c.IncidentID, &c.ObservedAt, &c.Field, &c.OldValue, &c.NewValue, &c.LastUpdate); err != nil {
+				return err
+			}
+			changes[c.IncidentID] = append(changes[c.IncidentID], c)
+		}
+		return rows.Err()
+	})
+	return changes, err
+}
+
+// historyCommand implements the "history" subcommand, printing how an incident
+// changed over time.
+func historyCommand(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	configPath := addConfigFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s history [flags] <incident id>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("history takes one incident ID")
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid incident ID %q", fs.Arg(0))
+	}
+
+	cfg, err := loadCommandConfig(fs, *configPath, false)
+	if err != nil {
+		return err
+	}
+	store, err := openStore(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	changes, err := store.IncidentChanges(context.Background(), id)
+	if err != nil {
+		return fmt.Errorf("could not query incident history: %w", err)
+	}
+	if len(changes) == 0 {
+		fmt.Printf("No changes recorded for incident %d.\n", id)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OBSERVED\tFIELD\tFROM\tTO\tFEED LAST UPDATE")
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.ObservedAt.Local().Format(time.DateTime), c.Field, c.OldValue, c.NewValue, c.LastUpdate)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mtickle/crash-reporting/geo"
+)
+
+// HotspotReportConfig sends the roads where crashes keep happening every Day at
+// Time. Crashes from the last Days are counted per road in a grid of squares
+// CellMiles on a side; squares with at least MinCrashes are hotspots, and the
+// Top of them are reported. A cluster straddling two squares counts as two.
+type HotspotReportConfig struct {
+	Day        string  `yaml:"day"`
+	Time       string  `yaml:"time"`
+	Days       int     `yaml:"days"`
+	Top        int     `yaml:"top"`
+	CellMiles  float64 `yaml:"cell_miles"`
+	MinCrashes int     `yaml:"min_crashes"`
+
+	// weekday and minute are parsed from Day and Time by load.
+	weekday time.Weekday
+	minute  int
+}
+
+// enabled reports whether the hotspot report is scheduled.
+func (h HotspotReportConfig) enabled() bool {
+	return h.Time != ""
+}
+
+// validate checks the analysis settings.
+func (h HotspotReportConfig) validate() error {
+	if h.Days <= 0 || h.Top <= 0 || h.CellMiles <= 0 || h.MinCrashes <= 0 {
+		return errors.New("reports.hotspots days, top, cell_miles and min_crashes must be positive")
+	}
+	return nil
+}
+
+// hotspot is a stretch of road with repeated crashes.
+type hotspot struct {
+	Road string
+	// Location is the location the crashes were most often reported at.
+	Location string
+	// Latitude and Longitude are the middle of the crashes.
+	Latitude, Longitude float64
+	Crashes             int
+	LastCrash           time.Time
+	IncidentIDs         []int
+}
+
+// label names the hotspot, e.g. "I-40 at Exit 293 (Wake)".
+func (h hotspot) label() string {
+	if h.Location == "" {
+		return orNA(h.Road)
+	}
+	return h.Location
+}
+
+// findHotspots groups the crashes by road and grid cell and returns the cells
+// with at least minCrashes, most crashes first. Crashes without a location are
+// left out.
+func findHotspots(crashes []StoredIncident, cellMiles float64, minCrashes int) []hotspot {
+	type key struct {
+		road string
+		cell geo.Cell
+	}
+	groups := make(map[key][]StoredIncident)
+	for _, c := range crashes {
+		at := geo.Point{Lat: c.Latitude, Lon: c.Longitude}
+		if !at.Valid() {
+			continue
+		}
+		k := key{roadKey(c.Incident), geo.CellOf(at, cellMiles)}
+		groups[k] = append(groups[k], c)
+	}
+
+	var hotspots []hotspot
+	for k, group := range groups {
+		if len(group) < minCrashes {
+			continue
+		}
+		h := hotspot{Road: k.road, Crashes: len(group)}
+		locations := make(map[string]int)
+		for _, c := range group {
+			h.Latitude += c.Latitude / float64(len(group))
+			h.Longitude += c.Longitude / float64(len(group))
+			h.IncidentIDs = append(h.IncidentIDs, c.ID)
+			if c.StartTime.After(h.LastCrash) {
+				h.LastCrash = c.StartTime.Time
+			}
+			if c.Location != "" {
+				locations[c.Location]++
+			}
+		}
+		for _, loc := range sortedKeys(locations) {
+			if locations[loc] > locations[h.Location] {
+				h.Location = loc
+			}
+		}
+		slices.Sort(h.IncidentIDs)
+		hotspots = append(hotspots, h)
+	}
+	slices.SortFunc(hotspots, func(a, b hotspot) int {
+		return cmp.Or(
+			cmp.Compare(b.Crashes, a.Crashes),
+			b.LastCrash.Compare(a.LastCrash),
+			cmp.Compare(a.IncidentIDs[0], b.IncidentIDs[0]),
+		)
+	})
+	return hotspots
+}
+
+// hotspotReport lists the worst crash hotspots of a period.
+type hotspotReport struct {
+	Since    time.Time
+	Days     int
+	Crashes  int
+	Hotspots []hotspot
+}
+
+// hotspotAnalysis finds the hotspots among the crashes that started in the
+// days before now, keeping the top ones, or all of them with top 0.
+func hotspotAnalysis(ctx context.Context, store Store, h HotspotReportConfig, now time.Time, top int) (hotspotReport, error) {
+	report := hotspotReport{Since: now.AddDate(0, 0, -h.Days), Days: h.Days}
+	incidents, _, err := store.FindIncidents(ctx, incidentQuery{Status: "all", IncidentType: "Vehicle Crash", Limit: math.MaxInt32})
+	if err != nil {
+		return report, fmt.Errorf("could not query crashes: %w", err)
+	}
+	var crashes []StoredIncident
+	for _, si := range incidents {
+		if !si.StartTime.IsZero() && !si.StartTime.Before(report.Since) && si.StartTime.Before(now) {
+			crashes = append(crashes, si)
+		}
+	}
+	report.Crashes = len(crashes)
+	report.Hotspots = findHotspots(crashes, h.CellMiles, h.MinCrashes)
+	if top > 0 && len(report.Hotspots) > top {
+		report.Hotspots = report.Hotspots[:top]
+	}
+	return report, nil
+}
+
+// title is the report's heading.
+func (r hotspotReport) title() string {
+	return fmt.Sprintf("Top %d Crash Hotspots, Last %d Days", len(r.Hotspots), r.Days)
+}
+
+// lines returns the report as label/value pairs, shared by every format.
+func (r hotspotReport) lines() [][2]string {
+	var hotspots []string
+	for i, h := range r.Hotspots {
+		hotspots = append(hotspots, fmt.Sprintf("%d. %s: %d crashes, last %s", i+1, h.label(), h.Crashes, h.LastCrash.Format("Jan 2")))
+	}
+	return [][2]string{
+		{"Crashes", fmt.Sprintf("%d since %s", r.Crashes, r.Since.Format("Jan 2"))},
+		{"Hotspots", orNA(strings.Join(hotspots, "\n"))},
+	}
+}
+
+// mapURL returns a map marking the hotspots, or "" without one.
+func (r hotspotReport) mapURL(mapsAPIKey string) string {
+	points := make([]reportPoint, 0, len(r.Hotspots))
+	for _, h := range r.Hotspots {
+		points = append(points, reportPoint{Latitude: h.Latitude, Longitude: h.Longitude, Severity: 3})
+	}
+	return reportMapURL(points, mapsAPIKey)
+}
+
+// hotspotFeature is a hotspot as a GeoJSON Feature.
+type hotspotFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONPoint      `json:"geometry"`
+	Properties hotspotProperties `json:"properties"`
+}
+
+// hotspotProperties are a hotspot feature's properties.
+type hotspotProperties struct {
+	Rank        int       `json:"rank"`
+	Road        string    `json:"road"`
+	Location    string    `json:"location"`
+	Crashes     int       `json:"crashes"`
+	LastCrash   time.Time `json:"lastCrash"`
+	IncidentIDs []int     `json:"incidentIds"`
+}
+
+// hotspotFeatureCollection is a GeoJSON layer of hotspots.
+type hotspotFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []hotspotFeature `json:"features"`
+}
+
+// geoJSON returns the report's hotspots as a GeoJSON layer, one Point each.
+func (r hotspotReport) geoJSON() hotspotFeatureCollection {
+	collection := hotspotFeatureCollection{Type: "FeatureCollection", Features: []hotspotFeature{}}
+	for i, h := range r.Hotspots {
+		collection.Features = append(collection.Features, hotspotFeature{
+			Type:     "Feature",
+			Geometry: geoJSONPoint{Type: "Point", Coordinates: [2]float64{h.Longitude, h.Latitude}},
+			Properties: hotspotProperties{
+				Rank:        i + 1,
+				Road:        h.Road,
+				Location:    h.Location,
+				Crashes:     h.Crashes,
+				LastCrash:   h.LastCrash,
+				IncidentIDs: h.IncidentIDs,
+			},
+		})
+	}
+	return collection
+}
+
+// sendHotspotReport sends the hotspots of the days before day.
+func sendHotspotReport(ctx context.Context, store Store, cfg Config, day time.Time) error {
+	h := cfg.Reports.Hotspots
+	report, err := hotspotAnalysis(ctx, store, h, day, h.Top)
+	if err == nil {
+		err = sendReport(ctx, cfg, report)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Sent the hotspot report", "since", report.Since.Format(time.DateOnly), "hotspots", len(report.Hotspots))
+	return nil
+}
+
+// hotspotsCommand implements the "hotspots" subcommand, printing, sending or
+// writing as GeoJSON the crash hotspots on demand.
+func hotspotsCommand(args []string) error {
+	fs := flag.NewFlagSet("hotspots", flag.ExitOnError)
+	configPath := addConfigFlag(fs)
+	days := fs.Int("days", 0, "count crashes from this many days back (default reports.hotspots.days)")
+	top := fs.Int("top", 0, "list this many hotspots; with --geojson, 0 writes them all (default reports.hotspots.top)")
+	cellMiles := fs.Float64("cell-miles", 0, "side of the grid squares crashes are counted in (default reports.hotspots.cell_miles)")
+	minCrashes := fs.Int("min-crashes", 0, "crashes a square needs to be a hotspot (default reports.hotspots.min_crashes)")
+	asGeoJSON := fs.Bool("geojson", false, "write the hotspots to stdout as a GeoJSON FeatureCollection")
+	send := fs.Bool("send", false, "send the report to the configured targets instead of printing it")
+	fs.Parse(args)
+
+	cfg, err := loadCommandConfig(fs, *configPath, false)
+	if err != nil {
+		return err
+	}
+	h := cfg.Reports.Hotspots
+	limit := h.Top
+	if *asGeoJSON {
+		limit = 0
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "days":
+			h.Days = *days
+		case "top":
+			limit = *top
+		case "cell-miles":
+			h.CellMiles = *cellMiles
+		case "min-crashes":
+			h.MinCrashes = *minCrashes
+		}
+	})
+	if err := h.validate(); err != nil {
+		return err
+	}
+	if limit < 0 {
+		return errors.New("--top cannot be negative")
+	}
+	if *send && !cfg.Reports.hasTargets() {
+		return errors.New("--send needs reports.discord_webhook or reports.email_recipients")
+	}
+
+	store, err := openStore(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	report, err := hotspotAnalysis(ctx, store, h, cfg.Reports.reportDay(time.Now()), limit)
+	if err != nil {
+		return err
+	}
+	switch {
+	case *send:
+		return sendReport(ctx, cfg, report)
+	case *asGeoJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report.geoJSON())
+	}
+	fmt.Print(reportText(report))
+	return nil
+}
+
+// hotspotsGeoJSON serves every crash hotspot as a GeoJSON layer. The days,
+// cell_miles and min_crashes parameters override reports.hotspots.
+func (a *apiServer) hotspotsGeoJSON(w http.ResponseWriter, r *http.Request) {
+	h := a.hotspots
+	params := r.URL.Query()
+	var errs []string
+	for _, p := range []struct {
+		name string
+		dest *int
+	}{{"days", &h.Days}, {"min_crashes", &h.MinCrashes}} {
+		if text := params.Get(p.name); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil || n <= 0 {
+				errs = append(errs, fmt.Sprintf("%s must be a positive whole number, got %q", p.name, text))
+			}
+			*p.dest = n
+		}
+	}
+	if text := params.Get("cell_miles"); text != "" {
+		miles, err := strconv.ParseFloat(text, 64)
+		if err != nil || miles <= 0 || miles > 100 {
+			errs = append(errs, fmt.Sprintf("cell_miles must be a number of miles from 0 to 100, got %q", text))
+		}
+		h.CellMiles = miles
+	}
+	if len(errs) > 0 {
+		writeAPIError(w, http.StatusBadRequest, strings.Join(errs, "; "))
+		return
+	}
+
+	report, err := hotspotAnalysis(r.Context(), a.store, h, time.Now(), 0)
+	if err != nil {
+		slog.Error("API: error finding hotspots", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not find hotspots")
+		return
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	if err := json.NewEncoder(w).Encode(report.geoJSON()); err != nil {
+		slog.Error("API: error writing response", "err", err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// HTTPConfig holds the settings of the client every outgoing request goes
+// through: feed fetches, notifications, event publishing and trace export.
+type HTTPConfig struct {
+	// Timeout bounds each request, including reading the response body, so a
+	// hung server fails the request instead of stalling the run. Zero means
+	// no limit.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// httpClient is the shared client for outgoing requests, set up from the
+// configuration by setupHTTPClient. It leaves Transport unset so requests go
+// through http.DefaultTransport, which the http log module wraps.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// setupHTTPClient applies the configured timeout to httpClient.
+func setupHTTPClient(cfg HTTPConfig) {
+	httpClient.Timeout = cfg.Timeout
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// plannedTypeWords pick out the incident types for planned work, such as
+// "Construction", "Night Time Construction", "Maintenance" and "Road Closure".
+var plannedTypeWords = []string{"construction", "maintenance", "closure"}
+
+// isPlannedWork reports whether an incident is scheduled road work or a
+// closure, as opposed to something like a crash.
+func isPlannedWork(incident Incident) bool {
+	t := strings.ToLower(incident.IncidentType)
+	for _, word := range plannedTypeWords {
+		if strings.Contains(t, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// icalTime formats a time as an iCalendar UTC date-time.
+func icalTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// icalEscape escapes a TEXT value.
+var icalEscape = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace
+
+// icalWriter writes content lines, folding them at 75 octets as RFC 5545
+// requires without splitting a UTF-8 sequence.
+type icalWriter struct {
+	b strings.Builder
+}
+
+func (w *icalWriter) line(name, value string) {
+	line := name + ":" + value
+	// Continuation lines start with a space, which counts toward their 75.
+	for limit := 75; len(line) > limit; limit = 74 {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	w.b.WriteString(line + "\r\n")
+}
+
+// serveICal serves planned construction and closures with a start and end
+// time as an iCalendar feed, so road work can be overlaid on a calendar. It
+// takes the incident list's filters, defaulting to active incidents; a type
+// parameter replaces the planned work types.
+func (a *apiServer) serveICal(w http.ResponseWriter, r *http.Request) {
+	q, err := parseIncidentQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	params := r.URL.Query()
+	if !params.Has("status") {
+		q.Status = "active"
+	}
+	if !params.Has("limit") {
+		q.Limit = math.MaxInt32
+	}
+	incidents, _, err := a.store.FindIncidents(r.Context(), q)
+	if err != nil {
+		slog.Error("API: error querying incidents", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "could not query incidents")
+		return
+	}
+
+	var cal icalWriter
+	cal.line("BEGIN", "VCALENDAR")
+	cal.line("VERSION", "2.0")
+	cal.line("PRODID", "-//ncdot//Planned road work//EN")
+	cal.line("CALSCALE", "GREGORIAN")
+	cal.line("X-WR-CALNAME", icalEscape(strings.Replace(feedTitle(r), "traffic incidents", "road work", 1)))
+	now := time.Now()
+	for _, si := range incidents {
+		if q.IncidentType == "" && !isPlannedWork(si.Incident) {
+			continue
+		}
+		start, end := si.StartTime.Time, si.EndTime.Time
+		if start.IsZero() || !end.After(start) {
+			continue
+		}
+		where := strings.TrimSuffix(fmt.Sprintf("%s at %s, %s", orNA(si.Road), orNA(si.Location), si.City), ", ")
+		description := digestLine(si.Incident)
+		if si.Reason != "" {
+			description += "\n" + si.Reason
+		}
+		if si.Detour != "" {
+			description += "\nDetour: " + si.Detour
+		}
+
+		cal.line("BEGIN", "VEVENT")
+		cal.line("UID", fmt.Sprintf("ncdot-incident-%d@%s", si.ID, r.Host))
+		cal.line("DTSTAMP", icalTime(now))
+		if !si.LastUpdate.IsZero() {
+			cal.line("LAST-MODIFIED", icalTime(si.LastUpdate.Time))
+		}
+		cal.line("DTSTART", icalTime(start))
+		cal.line("DTEND", icalTime(end))
+		cal.line("SUMMARY", icalEscape(fmt.Sprintf("%s: %s", si.IncidentType, where)))
+		cal.line("LOCATION", icalEscape(where))
+		cal.line("DESCRIPTION", icalEscape(description))
+		if si.Latitude != 0 || si.Longitude != 0 {
+			cal.line("GEO", fmt.Sprintf("%.6f;%.6f", si.Latitude, si.Longitude))
+			cal.line("URL", mapLink(si.Latitude, si.Longitude))
+		}
+		cal.line("TRANSP", "TRANSPARENT")
+		cal.line("END", "VEVENT")
+	}
+	cal.line("END", "VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(cal.b.String()))
+}
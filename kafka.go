@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kafka message formats.
+const (
+	kafkaFormatJSON = "json"
+	kafkaFormatAvro = "avro"
+)
+
+// KafkaConfig produces events to a Kafka topic through a Confluent REST Proxy
+// (v2 API), which keeps the binary free of a native Kafka client. Records are
+// keyed by incident ID, so every event for an incident lands on the same
+// partition in order and compacted topics keep the latest state. Format is json,
+// or avro to register the built-in IncidentEvent schema with the proxy's schema
+// registry.
+type KafkaConfig struct {
+	RESTProxyURL string `yaml:"rest_proxy_url"`
+	Topic        string `yaml:"topic"`
+	Format       string `yaml:"format"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+}
+
+// enabled reports whether the Kafka sink is configured.
+func (k KafkaConfig) enabled() bool {
+	return k.RESTProxyURL != "" && k.Topic != ""
+}
+
+// kafkaRecord is one record in a REST Proxy produce request. Value is the
+// IncidentEvent, or its Avro JSON encoding from avroEventValue.
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+// kafkaProduceRequest is the REST Proxy produce body. Schemas are only sent for Avro.
+type kafkaProduceRequest struct {
+	KeySchema   string        `json:"key_schema,omitempty"`
+	ValueSchema string        `json:"value_schema,omitempty"`
+	Records     []kafkaRecord `json:"records"`
+}
+
+// feedTimeType is FeedTime, which is null in Avro when the time is unknown.
+var feedTimeType = reflect.TypeOf(FeedTime{})
+
+// avroType maps a Go field type to its Avro type: a primitive, or for a
+// FeedTime a union with null.
+func avroType(t reflect.Type) any {
+	if t == feedTimeType {
+		return []string{"null", "string"}
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return "long"
+	case reflect.Int32:
+		return "int"
+	case reflect.Float64:
+		return "double"
+	case reflect.Float32:
+		return "float"
+	case reflect.Bool:
+		return "boolean"
+	default:
+		return "string"
+	}
+}
+
+// avroRecordSchema derives an Avro record schema from a struct's JSON fields.
+func avroRecordSchema(name string, t reflect.Type) map[string]any {
+	var fields []map[string]any
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+		if jsonName == "" || jsonName == "-" {
+			continue
+		}
+		fields = append(fields, map[string]any{"name": jsonName, "type": avroType(f.Type)})
+	}
+	return map[string]any{"type": "record", "name": name, "namespace": "ncdot", "fields": fields}
+}
+
+// avroRecordValue encodes a struct's JSON fields as Avro JSON to match
+// avroRecordSchema: a FeedTime is null when unknown and otherwise names its
+// union branch, as {"string": "2024-05-01T12:00:00Z"}.
+func avroRecordValue(v reflect.Value) map[string]any {
+	t := v.Type()
+	values := make(map[string]any, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+		if jsonName == "" || jsonName == "-" {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if ft, ok := value.(FeedTime); ok {
+			if ft.IsZero() {
+				value = nil
+			} else {
+				value = map[string]string{"string": ft.String()}
+			}
+		}
+		values[jsonName] = value
+	}
+	return values
+}
+
+// avroEventValue encodes an event as Avro JSON for incidentEventAvroSchema.
+func avroEventValue(event IncidentEvent) map[string]any {
+	return map[string]any{
+		"event":    event.Event,
+		"time":     event.Time.Format(time.RFC3339Nano),
+		"incident": avroRecordValue(reflect.ValueOf(event.Incident)),
+	}
+}
+
+// incidentEventAvroSchema is the Avro schema for IncidentEvent. Time is sent as
+// its RFC 3339 JSON string, and the incident's feed times as nullable strings.
+func incidentEventAvroSchema() string {
+	schema := map[string]any{
+		"type":      "record",
+		"name":      "IncidentEvent",
+		"namespace": "ncdot",
+		"fields": []map[string]any{
+			{"name": "event", "type": "string"},
+			{"name": "time", "type": "string"},
+			{"name": "incident", "type": avroRecordSchema("Incident", reflect.TypeOf(Incident{}))},
+		},
+	}
+	data, _ := json.Marshal(schema)
+	return string(data)
+}
+
+// publishToKafka produces a cycle's events in one request.
+func publishToKafka(ctx context.Context, cfg KafkaConfig, events []IncidentEvent) error {
+	req := kafkaProduceRequest{Records: make([]kafkaRecord, len(events))}
+	for i, event := range events {
+		req.Records[i] = kafkaRecord{Key: strconv.Itoa(event.Incident.ID), Value: event}
+		if cfg.Format == kafkaFormatAvro {
+			req.Records[i].Value = avroEventValue(event)
+		}
+	}
+	contentType := "application/vnd.kafka.json.v2+json"
+	if cfg.Format == kafkaFormatAvro {
+		contentType = "application/vnd.kafka.avro.v2+json"
+		req.KeySchema = `"string"`
+		req.ValueSchema = incidentEventAvroSchema()
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimRight(cfg.RESTProxyURL, "/") + "/topics/" + url.PathEscape(cfg.Topic)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if cfg.Username != "" {
+		httpReq.SetBasicAuth(cfg.Username, cfg.Password)
+	}
+
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("REST Proxy returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+
+	// Per-record failures come back with a 200 and an error in the offsets list.
+	var result struct {
+		Offsets []struct {
+			Partition *int   `json:"partition"`
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("could not read REST Proxy response: %w", err)
+	}
+	failed := 0
+	var firstErr string
+	for _, o := range result.Offsets {
+		if o.ErrorCode != nil || o.Error != "" {
+			if failed == 0 {
+				firstErr = o.Error
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d records failed: %s", failed, len(events), firstErr)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublishToKafkaAvroUnknownTimes(t *testing.T) {
+	var req struct {
+		ValueSchema string `json:"value_schema"`
+		Records     []struct {
+			Value struct {
+				Incident map[string]any `json:"incident"`
+			} `json:"value"`
+		} `json:"records"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("could not read produce request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/vnd.kafka.v2+json")
+		io.WriteString(w, `{"offsets":[{"partition":0,"offset":1}]}`)
+	}))
+	defer server.Close()
+
+	start := FeedTime{Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	// The feed sent no end or last update time.
+	events := []IncidentEvent{{Event: eventCreated, Time: start.Time, Incident: Incident{ID: 7, StartTime: start}}}
+	cfg := KafkaConfig{RESTProxyURL: server.URL, Topic: "ncdot", Format: kafkaFormatAvro}
+	if err := publishToKafka(context.Background(), cfg, events); err != nil {
+		t.Fatalf("publishToKafka() error = %v", err)
+	}
+
+	var schema struct {
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(req.ValueSchema), &schema); err != nil || len(schema.Fields) != 3 || len(req.Records) != 1 {
+		t.Fatalf("unexpected produce request: schema %s, %d records", req.ValueSchema, len(req.Records))
+	}
+	var incident struct {
+		Fields []struct {
+			Name string `json:"name"`
+			Type any    `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(schema.Fields[2].Type, &incident); err != nil {
+		t.Fatal(err)
+	}
+	// Every value must fit its field's type, as the schema registry checks.
+	value := req.Records[0].Value.Incident
+	for _, f := range incident.Fields {
+		v, ok := value[f.Name]
+		if !ok {
+			t.Errorf("record has no %s", f.Name)
+			continue
+		}
+		switch f.Type {
+		case "string":
+			if _, ok := v.(string); !ok {
+				t.Errorf("%s = %#v, want a string", f.Name, v)
+			}
+		case "long", "int", "double", "float":
+			if _, ok := v.(float64); !ok {
+				t.Errorf("%s = %#v, want a number", f.Name, v)
+			}
+		case "boolean":
+			if _, ok := v.(bool); !ok {
+				t.Errorf("%s = %#v, want a boolean", f.Name, v)
+			}
+		default:
+			if union, ok := v.(map[string]any); v != nil && (!ok || len(union) != 1 || union["string"] == nil) {
+				t.Errorf("%s = %#v, want null or a string branch of %v", f.Name, v, f.Type)
+			}
+		}
+	}
+	if value["end"] != nil || value["lastUpdate"] != nil {
+		t.Errorf("end = %v, lastUpdate = %v, want null for unknown times", value["end"], value["lastUpdate"])
+	}
+	if got, ok := value["start"].(map[string]any); !ok || got["string"] != "2024-05-01T12:00:00Z" {
+		t.Errorf("start = %v, want {\"string\": \"2024-05-01T12:00:00Z\"}", value["start"])
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// LeaderElectionConfig lets several daemons run against one Postgres database
+// for redundancy. They hold a Postgres advisory lock by turns: the one holding
+// it polls the feed and sends notifications, and the others stand by until it
+// goes away, taking the lock over within a polling interval.
+type LeaderElectionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Key is the advisory lock's key. Instances sharing a database and a feed
+	// use the same key; a second deployment on the same database needs its own.
+	// One-off runs take the same lock; see acquireRunLock.
+	Key int `yaml:"key"`
+}
+
+// defaultLeaderKey is the advisory lock key used unless configured: "ncdot"
+// in ASCII.
+const defaultLeaderKey = 0x6e63646f74
+
+// LockingStore is a Store that takes advisory locks in the database. Stores
+// on Postgres are LockingStores.
+type LockingStore interface {
+	Store
+	// TryAdvisoryLock takes the advisory lock key without waiting. It returns
+	// nil if another session holds it.
+	TryAdvisoryLock(ctx context.Context, key int64) (*advisoryLock, error)
+}
+
+// advisoryLock is a session-level advisory lock held on its own connection.
+// Postgres releases it when the connection closes, so a crashed holder loses
+// it as soon as the server notices the connection is gone.
+type advisoryLock struct {
+	conn *sql.Conn
+	key  int64
+}
+
+// TryAdvisoryLock takes the advisory lock key on a dedicated connection.
+func (s *sqlStore) TryAdvisoryLock(ctx context.Context, key int64) (*advisoryLock, error) {
+	if s.db.dialect != dialects[driverPostgres] {
+		return nil, fmt.Errorf("advisory locks need the %s driver", driverPostgres)
+	}
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !locked {
+		conn.Close()
+		return nil, nil
+	}
+	return &advisoryLock{conn: conn, key: key}, nil
+}
+
+// held reports whether the lock's connection is still alive, and so the lock
+// still held.
+func (l *advisoryLock) held(ctx context.Context) error {
+	return l.conn.PingContext(ctx)
+}
+
+// release unlocks and closes the connection. Closing alone would release the
+// lock, but the pool may keep the connection open.
+func (l *advisoryLock) release(ctx context.Context) {
+	if _, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		slog.Warn("Error releasing the advisory lock", "err", err)
+	}
+	l.conn.Close()
+}
+
+// leaderElector decides each cycle whether this instance leads. A nil elector
+// always leads.
+type leaderElector struct {
+	store LockingStore
+	key   int64
+	// stateFile is feed.state_file, brought up to date on taking over.
+	stateFile string
+
+	lock *advisoryLock
+	// standby is set once the instance has waited on another leader.
+	standby bool
+}
+
+// newLeaderElector returns the elector for cfg, or nil when leader election is
+// off.
+func newLeaderElector(store Store, cfg Config) (*leaderElector, error) {
+	if !cfg.Polling.LeaderElection.Enabled {
+		return nil, nil
+	}
+	locking, ok := store.(LockingStore)
+	if !ok {
+		return nil, fmt.Errorf("polling.leader_election needs the %s driver", driverPostgres)
+	}
+	return &leaderElector{store: locking, key: int64(cfg.Polling.LeaderElection.Key), stateFile: cfg.Feed.StateFile}, nil
+}
+
+// lead reports whether this instance is the leader, taking the lock if it is
+// free and checking it is still held if it was taken before.
+func (e *leaderElector) lead(ctx context.Context) bool {
+	if e == nil {
+		return true
+	}
+	if e.lock != nil {
+		err := e.lock.held(ctx)
+		if err == nil {
+			return true
+		}
+		slog.Error("Lost the leader lock", "err", err)
+		e.lock.conn.Close()
+		e.lock = nil
+	}
+
+	lock, err := e.store.TryAdvisoryLock(ctx, e.key)
+	if err != nil {
+		slog.Error("Error taking the leader lock", "err", err)
+		return false
+	}
+	if lock == nil {
+		if !e.standby {
+			slog.Info("Another instance is the leader; standing by.")
+			e.standby = true
+		}
+		return false
+	}
+	e.lock = lock
+	if e.standby {
+		// The old leader alerted on the incidents it stored, but into its own
+		// state file. Take them as sent so they aren't alerted twice.
+		if err := adoptSentIncidents(ctx, e.store, e.stateFile); err != nil {
+			slog.Error("Error adopting the previous leader's incidents", "err", err)
+		}
+	}
+	slog.Info("Became the leader.")
+	e.standby = false
+	return true
+}
+
+// leading reports whether the last call to lead made this instance the leader.
+func (e *leaderElector) leading() bool {
+	return e == nil || e.lock != nil
+}
+
+// resign releases the lock, if held, so a standby can take over straight away.
+func (e *leaderElector) resign() {
+	if e == nil || e.lock == nil {
+		return
+	}
+	e.lock.release(context.Background())
+	e.lock = nil
+}
+
+// adoptSentIncidents marks every active incident in the store as sent.
+func adoptSentIncidents(ctx context.Context, store Store, stateFile string) error {
+	active, err := store.ActiveIncidents(ctx)
+	if err != nil {
+		return err
+	}
+	sentIDs, err := loadSentIncidents(stateFile)
+	if err != nil {
+		return err
+	}
+	for _, inc := range active {
+		sentIDs[inc.ID] = true
+	}
+	slog.Info("Took over the previous leader's incidents", "active", len(active))
+	return saveSentIncidents(stateFile, sentIDs)
+}
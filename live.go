@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveBufferSize is how many events a live subscriber may fall behind by before
+// it is dropped, so one slow client never holds up the poller.
+const liveBufferSize = 256
+
+// sseKeepAlive is how often an idle event stream gets a comment line, keeping
+// proxies from closing it.
+const sseKeepAlive = 30 * time.Second
+
+// eventHub fans the poller's incident events out to the API's live clients.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan IncidentEvent]struct{}
+}
+
+// newEventHub returns a hub with no subscribers.
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan IncidentEvent]struct{})}
+}
+
+// subscribe returns a channel of the events published from now on and a
+// function that unsubscribes. The channel is closed when the subscriber falls
+// too far behind.
+func (h *eventHub) subscribe() (<-chan IncidentEvent, func()) {
+	ch := make(chan IncidentEvent, liveBufferSize)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends events to every subscriber without blocking.
+func (h *eventHub) publish(events []IncidentEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		for _, event := range events {
+			select {
+			case ch <- event:
+				continue
+			default:
+			}
+			slog.Warn("Dropping a live event subscriber that fell behind.")
+			delete(h.subs, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// eventFilter selects the live events a client receives. Empty fields match
+// everything.
+type eventFilter struct {
+	Counties []int    `json:"counties"`
+	Types    []string `json:"types"`
+	// BBox is [west, south, east, north] in degrees.
+	BBox []float64 `json:"bbox"`
+}
+
+// parseEventFilter reads a filter from the county, type and bbox parameters.
+// county and type may repeat or hold comma-separated lists.
+func parseEventFilter(params url.Values) (eventFilter, error) {
+	var f eventFilter
+	for _, text := range splitParams(params["county"]) {
+		id, err := strconv.Atoi(text)
+		if err != nil {
+			return f, fmt.Errorf("invalid county %q", text)
+		}
+		f.Counties = append(f.Counties, id)
+	}
+	f.Types = splitParams(params["type"])
+	if bbox := params.Get("bbox"); bbox != "" {
+		for _, text := range strings.Split(bbox, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+			if err != nil {
+				return f, fmt.Errorf("invalid bbox %q", bbox)
+			}
+			f.BBox = append(f.BBox, v)
+		}
+	}
+	return f, f.validate()
+}
+
+// splitParams splits comma-separated values, dropping empty ones.
+func splitParams(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// validate checks the bounding box's shape.
+func (f eventFilter) validate() error {
+	if len(f.BBox) == 0 {
+		return nil
+	}
+	if len(f.BBox) != 4 || f.BBox[0] > f.BBox[2] || f.BBox[1] > f.BBox[3] {
+		return errors.New("bbox must be west,south,east,north")
+	}
+	return nil
+}
+
+// matches reports whether an incident passes the filter. Incidents without a
+// location never fall inside a bounding box.
+func (f eventFilter) matches(incident Incident) bool {
+	if len(f.Counties) > 0 && !slices.Contains(f.Counties, incident.CountyID) {
+		return false
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, incident.IncidentType) {
+		return false
+	}
+	if len(f.BBox) == 4 {
+		if incident.Latitude == 0 && incident.Longitude == 0 {
+			return false
+		}
+		return incident.Longitude >= f.BBox[0] && incident.Latitude >= f.BBox[1] &&
+			incident.Longitude <= f.BBox[2] && incident.Latitude <= f.BBox[3]
+	}
+	return true
+}
+
+// streamEvents serves the live events as Server-Sent Events, each named after
+// the event kind with the IncidentEvent as JSON data, filtered by the county,
+// type and bbox parameters. A client that falls too far behind is
+// disconnected; EventSource reconnects on its own.
+func (a *apiServer) streamEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	events, unsubscribe := a.hub.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !filter.matches(event.Incident) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("API: error encoding event", "err", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// wsPingInterval is how often the server pings a WebSocket client; a client
+// silent for two intervals is dropped.
+const wsPingInterval = 30 * time.Second
+
+// liveMessage is a message to a WebSocket client: a "snapshot" of the active
+// incidents matching its filter, an "event", or an "error" about its last
+// message.
+type liveMessage struct {
+	Type      string           `json:"type"`
+	Incidents []StoredIncident `json:"incidents,omitempty"`
+	Event     *IncidentEvent   `json:"event,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// liveSubscription is a WebSocket client's message replacing its filter.
+type liveSubscription struct {
+	Type string `json:"type"`
+	eventFilter
+}
+
+// liveSocket serves the live events over a WebSocket. The connection starts
+// with the filter in the county, type and bbox parameters and a snapshot of
+// the active incidents matching it; the client may send
+// {"type": "subscribe", "counties": [...], "types": [...], "bbox": [...]}
+// at any time to change the filter and get a new snapshot.
+func (a *apiServer) liveSocket(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		slog.Warn("API: WebSocket handshake failed", "err", err)
+		return
+	}
+	defer ws.conn.Close()
+	ws.readTimeout = 2 * wsPingInterval
+	// Subscribing before the snapshot means no event falls between the two.
+	events, unsubscribe := a.hub.subscribe()
+	defer unsubscribe()
+
+	send := func(m liveMessage) bool {
+		data, err := json.Marshal(m)
+		if err == nil {
+			err = ws.writeText(data)
+		}
+		return err == nil
+	}
+
+	filters := make(chan eventFilter)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			data, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			var sub liveSubscription
+			if err := json.Unmarshal(data, &sub); err != nil || sub.Type != "subscribe" {
+				send(liveMessage{Type: "error", Error: `expected {"type": "subscribe", ...}`})
+				continue
+			}
+			if err := sub.validate(); err != nil {
+				send(liveMessage{Type: "error", Error: err.Error()})
+				continue
+			}
+			select {
+			case filters <- sub.eventFilter:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	snapshot := func() bool {
+		active, _, err := a.store.FindIncidents(r.Context(), incidentQuery{Status: "active", Limit: math.MaxInt32})
+		if err != nil {
+			slog.Error("API: error querying incidents", "err", err)
+			return send(liveMessage{Type: "error", Error: "could not query incidents"})
+		}
+		incidents := []StoredIncident{}
+		for _, si := range active {
+			if filter.matches(si.Incident) {
+				incidents = append(incidents, si)
+			}
+		}
+		return send(liveMessage{Type: "snapshot", Incidents: incidents})
+	}
+	if !snapshot() {
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			ws.close(1001, "server shutting down")
+			return
+		case <-done:
+			return
+		case filter = <-filters:
+			if !snapshot() {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				ws.close(1008, "fell too far behind")
+				return
+			}
+			if filter.matches(event.Incident) && !send(liveMessage{Type: "event", Event: &event}) {
+				return
+			}
+		case <-ping.C:
+			if ws.writeFrame(wsPing, nil) != nil {
+				return
+			}
+		}
+	}
+}
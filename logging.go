@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Log formats for log.format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Modules whose verbosity log.modules can raise or lower on their own.
+const (
+	// logModuleFeed logs each feed response's status, payload size and timing.
+	logModuleFeed = "feed"
+	// logModuleSQL logs each database statement and how long it took.
+	logModuleSQL = "sql"
+	// logModuleHTTP logs every outgoing HTTP request's status and timing.
+	logModuleHTTP = "http"
+)
+
+var logModuleNames = []string{logModuleFeed, logModuleSQL, logModuleHTTP}
+
+// LogConfig controls the log output. Format "text" writes readable lines with
+// key=value fields; "json" writes one JSON object per line, for Loki,
+// CloudWatch and other log stores to index the fields.
+type LogConfig struct {
+	Format string `yaml:"format"`
+	// Level is the minimum level logged: debug, info, warn or error. Debug
+	// turns on every module's debug logs.
+	Level string `yaml:"level"`
+	// Modules are "module=level" pairs overriding Level for one module, e.g.
+	// "sql=debug" to time the queries without the rest of the debug output.
+	Modules []string `yaml:"modules"`
+}
+
+// parseLogLevel parses a level name.
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("%q is not debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// moduleLevels parses Modules.
+func (c LogConfig) moduleLevels() (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, m := range c.Modules {
+		name, levelName, ok := strings.Cut(m, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("%q is not module=level", m)
+		}
+		if !slices.Contains(logModuleNames, name) {
+			return nil, fmt.Errorf("unknown module %q, expected one of %s", name, strings.Join(logModuleNames, ", "))
+		}
+		level, err := parseLogLevel(strings.TrimSpace(levelName))
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", name, err)
+		}
+		levels[name] = level
+	}
+	return levels, nil
+}
+
+// validate checks the log settings.
+func (c LogConfig) validate() []error {
+	var errs []error
+	if c.Format != logFormatText && c.Format != logFormatJSON {
+		errs = append(errs, fmt.Errorf("log.format must be %q or %q, got %q", logFormatText, logFormatJSON, c.Format))
+	}
+	if _, err := parseLogLevel(c.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	if _, err := c.moduleLevels(); err != nil {
+		errs = append(errs, fmt.Errorf("log.modules: %w", err))
+	}
+	return errs
+}
+
+// moduleLoggers holds a logger for each module, set by setupLogging.
+var moduleLoggers map[string]*slog.Logger
+
+// moduleLogger returns the logger for a module, which tags its records with
+// the module's name and logs at the module's own level.
+func moduleLogger(name string) *slog.Logger {
+	if l, ok := moduleLoggers[name]; ok {
+		return l
+	}
+	return slog.Default().With("module", name)
+}
+
+// levelHandler drops records below its level, so module loggers can log more
+// or less than the rest of the program through the same handler.
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{h.Handler.WithGroup(name), h.level}
+}
+
+// setupLogging installs the configured log handler and levels. Text keeps the
+// standard logger's timestamped lines. Invalid levels are left at info; the
+// configuration's validation reports them.
+func setupLogging(cfg LogConfig) {
+	level, _ := parseLogLevel(cfg.Level)
+	modules, _ := cfg.moduleLevels()
+
+	// The base handler passes every level; the loggers on top of it filter.
+	var base slog.Handler
+	if cfg.Format == logFormatJSON {
+		base = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		slog.SetDefault(slog.New(levelHandler{base, level}))
+	} else {
+		// The default handler writes through the log package. It can't be
+		// wrapped and made the default again, so its own level setting
+		// filters the default logger.
+		base = slog.Default().Handler()
+		slog.SetLogLoggerLevel(level)
+	}
+
+	moduleLoggers = make(map[string]*slog.Logger)
+	for _, name := range logModuleNames {
+		moduleLevel, ok := modules[name]
+		if !ok {
+			moduleLevel = level
+		}
+		moduleLoggers[name] = slog.New(levelHandler{base.WithAttrs([]slog.Attr{slog.String("module", name)}), moduleLevel})
+	}
+
+	if moduleLoggers[logModuleHTTP].Enabled(context.Background(), slog.LevelDebug) {
+		http.DefaultTransport = loggingTransport{http.DefaultTransport}
+	}
+}
+
+// loggingTransport logs each request's outcome to the http module. Only the
+// host is logged, since webhook and bot API paths carry tokens.
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	log := moduleLogger(logModuleHTTP)
+	if err != nil {
+		log.Debug("HTTP request failed", "method", req.Method, "host", req.URL.Host, "duration", time.Since(start), "err", err)
+		return resp, err
+	}
+	log.Debug("HTTP request", "method", req.Method, "host", req.URL.Host, "status", resp.StatusCode, "duration", time.Since(start))
+	return resp, nil
+}
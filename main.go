@@ -1,15 +1,18 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv" // Library to read .env files
@@ -18,73 +21,56 @@ import (
 
 // Incident struct matches the JSON data from the NCDOT feed.
 type Incident struct {
-	ID                    int     `json:"id" db:"id"`
-	Latitude              float64 `json:"latitude" db:"latitude"`
-	Longitude             float64 `json:"longitude" db:"longitude"`
-	CommonName            string  `json:"commonName" db:"common_name"`
-	Reason                string  `json:"reason" db:"reason"`
-	Condition             string  `json:"condition" db:"condition"`
-	IncidentType          string  `json:"incidentType" db:"incident_type"`
-	Severity              int     `json:"severity" db:"severity"`
-	Direction             string  `json:"direction" db:"direction"`
-	Location              string  `json:"location" db:"location"`
-	CountyID              int     `json:"countyId" db:"county_id"`
-	CountyName            string  `json:"countyName" db:"county_name"`
-	City                  string  `json:"city" db:"city"`
-	StartTime             string  `json:"start" db:"start_time"`
-	EndTime               string  `json:"end" db:"end_time"`
-	LastUpdate            string  `json:"lastUpdate" db:"last_update"`
-	Road                  string  `json:"road" db:"road"`
-	RouteID               int     `json:"routeId" db:"route_id"`
-	LanesClosed           int     `json:"lanesClosed" db:"lanes_closed"`
-	LanesTotal            int     `json:"lanesTotal" db:"lanes_total"`
-	Detour                string  `json:"detour" db:"detour"`
-	CrossStreetPrefix     string  `json:"crossStreetPrefix" db:"cross_street_prefix"`
-	CrossStreetNumber     int     `json:"crossStreetNumber" db:"cross_street_number"`
-	CrossStreetSuffix     string  `json:"crossStreetSuffix" db:"cross_street_suffix"`
-	CrossStreetCommonName string  `json:"crossStreetCommonName" db:"cross_street_common_name"`
-	Event                 string  `json:"event" db:"event"`
-	CreatedFromConcurrent bool    `json:"createdFromConcurrent" db:"created_from_concurrent"`
-	MovableConstruction   string  `json:"movableConstruction" db:"movable_construction"`
-	WorkZoneSpeedLimit    int     `json:"workZoneSpeedLimit" db:"work_zone_speed_limit"`
-}
-
-// Structs for creating a rich Discord Embed with a thumbnail
-type DiscordWebhookPayload struct {
-	Username  string         `json:"username"`
-	AvatarURL string         `json:"avatar_url,omitempty"`
-	Embeds    []DiscordEmbed `json:"embeds"`
-}
-
-type DiscordEmbed struct {
-	Title     string         `json:"title"`
-	Color     int            `json:"color"`
-	Fields    []EmbedField   `json:"fields"`
-	Footer    EmbedFooter    `json:"footer"`
-	Timestamp string         `json:"timestamp"`
-	Thumbnail EmbedThumbnail `json:"thumbnail,omitempty"`
-}
-
-type EmbedThumbnail struct {
-	URL string `json:"url"`
-}
-
-type EmbedField struct {
-	Name   string `json:"name"`
-	Value  string `json:"value"`
-	Inline bool   `json:"inline"`
-}
-
-type EmbedFooter struct {
-	Text string `json:"text"`
+	ID                    int      `json:"id" db:"id"`
+	Latitude              float64  `json:"latitude" db:"latitude"`
+	Longitude             float64  `json:"longitude" db:"longitude"`
+	CommonName            string   `json:"commonName" db:"common_name"`
+	Reason                string   `json:"reason" db:"reason"`
+	Condition             string   `json:"condition" db:"condition"`
+	IncidentType          string   `json:"incidentType" db:"incident_type"`
+	Severity              int      `json:"severity" db:"severity"`
+	Direction             string   `json:"direction" db:"direction"`
+	Location              string   `json:"location" db:"location"`
+	CountyID              int      `json:"countyId" db:"county_id"`
+	CountyName            string   `json:"countyName" db:"county_name"`
+	City                  string   `json:"city" db:"city"`
+	StartTime             FeedTime `json:"start" db:"start_time"`
+	EndTime               FeedTime `json:"end" db:"end_time"`
+	LastUpdate            FeedTime `json:"lastUpdate" db:"last_update"`
+	Road                  string   `json:"road" db:"road"`
+	RouteID               int      `json:"routeId" db:"route_id"`
+	LanesClosed           int      `json:"lanesClosed" db:"lanes_closed"`
+	LanesTotal            int      `json:"lanesTotal" db:"lanes_total"`
+	Detour                string   `json:"detour" db:"detour"`
+	CrossStreetPrefix     string   `json:"crossStreetPrefix" db:"cross_street_prefix"`
+	CrossStreetNumber     int      `json:"crossStreetNumber" db:"cross_street_number"`
+	CrossStreetSuffix     string   `json:"crossStreetSuffix" db:"cross_street_suffix"`
+	CrossStreetCommonName string   `json:"crossStreetCommonName" db:"cross_street_common_name"`
+	Event                 string   `json:"event" db:"event"`
+	CreatedFromConcurrent bool     `json:"createdFromConcurrent" db:"created_from_concurrent"`
+	MovableConstruction   string   `json:"movableConstruction" db:"movable_construction"`
+	WorkZoneSpeedLimit    int      `json:"workZoneSpeedLimit" db:"work_zone_speed_limit"`
+
+	// Weather describes the weather at the incident when it started. The feed
+	// doesn't send it; it is filled in for alerts when notifications.weather
+	// is on.
+	Weather string `json:"-" db:"-"`
+	// Duplicates are the other incidents combined into this one's alert when
+	// notifications.dedup is on.
+	Duplicates []Incident `json:"-" db:"-"`
 }
 
 // ClearedIncident holds just enough info for a cleared notification.
 type ClearedIncident struct {
-	ID       int
-	Road     string
-	Location string
-	City     string
+	ID           int
+	CountyID     int
+	IncidentType string
+	Severity     int
+	Road         string
+	Location     string
+	City         string
+	// DurationSeconds is how long the incident was active, or 0 if unknown.
+	DurationSeconds int
 }
 
 // loadSentIncidents reads the JSON file of sent alert IDs into a map.
@@ -102,9 +88,15 @@ func loadSentIncidents(filename string) (map[int]bool, error) {
 	}
 
 	err = json.Unmarshal(data, &sentIDs)
-	// If the file is corrupt and not valid JSON, log a warning and start fresh.
+	// If the file is corrupt and not valid JSON, back it up, log a warning and start fresh.
 	if err != nil {
-		log.Printf("WARNING: Could not parse %s. File may be corrupt. Starting with a fresh state. Error: %v", filename, err)
+		backupName := fmt.Sprintf("%s.corrupt-%s", filename, time.Now().Format("20060102-150405"))
+		if renameErr := os.Rename(filename, backupName); renameErr != nil {
+			slog.Warn("Could not back up corrupt state file", "path", filename, "err", renameErr)
+		} else {
+			slog.Warn("Backed up corrupt state file", "path", backupName)
+		}
+		slog.Warn("Could not parse state file. File may be corrupt. Starting with a fresh state.", "path", filename, "err", err)
 		return make(map[int]bool), nil // Return an empty map, not an error.
 	}
 
@@ -117,286 +109,622 @@ func saveSentIncidents(filename string, sentIDs map[int]bool) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename, data, 0644)
+	return writeFileAtomic(filename, data)
 }
 
-// sendToDiscord sends a rich, color-coded embed for a new vehicle crash.
-func sendToDiscord(webhookURL string, incident Incident, parsedTime time.Time, mapsAPIKey string) {
-	// Determine embed color based on severity
-	var color int
-	switch incident.Severity {
-	case 1:
-		color = 3066993 // Green
-	case 2:
-		color = 16776960 // Yellow
-	case 3:
-		color = 15158332 // Red
-	default:
-		color = 2105893 // Grey
-	}
-
-	// All fields are now single-column (Inline: false) for mobile readability.
-	fields := []EmbedField{
-		{Name: "Reason", Value: incident.Reason, Inline: false},
-		{Name: "Road", Value: incident.Road, Inline: false},
-		{Name: "Location", Value: incident.Location, Inline: false},
-		{Name: "Severity", Value: strconv.Itoa(incident.Severity), Inline: false},
+// writeFileAtomic writes data to a temp file first and then renames it over the
+// original, so a crash mid-write can never leave a truncated file behind.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
 	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // No-op once the rename has succeeded.
 
-	embed := DiscordEmbed{
-		Title:     "New Vehicle Crash Alert",
-		Color:     color,
-		Fields:    fields,
-		Footer:    EmbedFooter{Text: "Fetched from NC DOT API"},
-		Timestamp: parsedTime.Format(time.RFC3339),
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
 	}
-
-	// Generate and add the static map thumbnail if an API key is provided.
-	if mapsAPIKey != "" {
-		mapURL := fmt.Sprintf(
-			"https://maps.googleapis.com/maps/api/staticmap?center=%.6f,%.6f&zoom=14&size=600x600&markers=color:red%%7C%.6f,%.6f&key=%s",
-			incident.Latitude, incident.Longitude, incident.Latitude, incident.Longitude, mapsAPIKey,
-		)
-		embed.Thumbnail = EmbedThumbnail{URL: mapURL}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
 	}
-
-	payload := DiscordWebhookPayload{
-		Username: "NC DOT Crash Bot",
-		Embeds:   []DiscordEmbed{embed},
+	if err := tmp.Close(); err != nil {
+		return err
 	}
-
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Error creating JSON payload: %s", err)
-		return
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		return err
 	}
+	return os.Rename(tmpName, filename)
+}
 
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		log.Printf("Error sending to Discord: %s", err)
-		return
-	}
-	defer resp.Body.Close()
+// storedIncident is what the database held for an incident before an upsert.
+// Cleared means the incident had cleared and is back in the feed, which the
+// upsert counts in ReopenCount. LastUpdate is the feed's lastUpdate when it was
+// last written.
+type storedIncident struct {
+	Exists      bool
+	Cleared     bool
+	Severity    int
+	LanesClosed int
+	LanesTotal  int
+	ReopenCount int
+	LastUpdate  FeedTime
+	Condition   string
+	Reason      string
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		log.Printf("Discord returned non-2xx status: %s", resp.Status)
-	}
+// unchanged reports whether incident is the active incident already stored, as
+// far as its feed lastUpdate tells, so it needn't be written again.
+func (s storedIncident) unchanged(incident Incident) bool {
+	return s.Exists && !s.Cleared && !incident.LastUpdate.IsZero() && incident.LastUpdate.Equal(s.LastUpdate.Time)
 }
 
-// sendClearedNotificationToDiscord sends a rich embed when an incident is cleared.
-func sendClearedNotificationToDiscord(webhookURL string, incident ClearedIncident) {
-	embed := DiscordEmbed{
-		Title: "Incident Cleared ",
-		Color: 3066993, // Green
-		Fields: []EmbedField{
-			{Name: "Road", Value: incident.Road, Inline: false},
-			{Name: "Location", Value: incident.Location, Inline: false},
-			{Name: "City", Value: incident.City, Inline: false},
-		},
-		Footer:    EmbedFooter{Text: "Incident no longer in NC DOT feed"},
-		Timestamp: time.Now().Format(time.RFC3339),
+// incidentUpdate decides what to send about an alerted incident that changed
+// since it was stored. An incident back in the feed after clearing is reopened.
+// Otherwise a higher severity or more lanes closed is an escalation, when
+// escalations are on, and the changed fields whose update toggle is on make an
+// update. The kind is empty when there is nothing to send.
+func incidentUpdate(cfg NotificationConfig, previous storedIncident, incident Incident) (string, []string) {
+	if !previous.Exists {
+		return "", nil
+	}
+	if previous.Cleared {
+		times := "once"
+		if previous.ReopenCount > 1 {
+			times = fmt.Sprintf("%d times", previous.ReopenCount)
+		}
+		return notifyReopened, []string{
+			"Back in the NC DOT feed after clearing (reopened " + times + ")",
+			"Lanes: " + lanesText(incident.LanesClosed, incident.LanesTotal),
+		}
 	}
-
-	payload := DiscordWebhookPayload{
-		Username: "NC DOT Crash Bot",
-		Embeds:   []DiscordEmbed{embed},
+	verb := "lowered"
+	if incident.Severity > previous.Severity {
+		verb = "raised"
 	}
+	severity := fmt.Sprintf("Severity %s from %d to %d", verb, previous.Severity, incident.Severity)
+	lanes := fmt.Sprintf("Lanes: %s → %s",
+		lanesText(previous.LanesClosed, previous.LanesTotal), lanesText(incident.LanesClosed, incident.LanesTotal))
 
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Error creating cleared JSON payload: %s", err)
-		return
+	var changes []string
+	if cfg.Escalations {
+		if incident.Severity > previous.Severity {
+			changes = append(changes, severity)
+		}
+		if incident.LanesClosed > previous.LanesClosed {
+			changes = append(changes, lanes)
+		}
+		if len(changes) > 0 {
+			return notifyEscalated, changes
+		}
 	}
 
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		log.Printf("Error sending cleared notification to Discord: %s", err)
-		return
+	u := cfg.Updates
+	if u.Severity && incident.Severity != previous.Severity {
+		changes = append(changes, severity)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		log.Printf("Discord returned non-2xx status for cleared notification: %s", resp.Status)
+	if (u.LanesClosed && incident.LanesClosed != previous.LanesClosed) || (u.LanesTotal && incident.LanesTotal != previous.LanesTotal) {
+		changes = append(changes, lanes)
 	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+	return notifyUpdated, changes
 }
 
-// upsertIncident inserts a new crash or updates an existing one in the database.
-func upsertIncident(db *sql.DB, incident Incident) error {
-	sqlStatement := `
-		INSERT INTO ncdot_incidents (
-			id, latitude, longitude, common_name, reason, "condition", incident_type,
-			severity, direction, location, county_id, county_name, city, start_time,
-			end_time, last_update, road, route_id, lanes_closed, lanes_total, detour,
-			cross_street_prefix, cross_street_number, cross_street_suffix,
-			cross_street_common_name, event, created_from_concurrent, movable_construction,
-			work_zone_speed_limit, status, cleared_time
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,
-			$18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, 'active', NULL
-		)
-		ON CONFLICT (id) DO UPDATE SET
-			latitude = EXCLUDED.latitude,
-			longitude = EXCLUDED.longitude,
-			reason = EXCLUDED.reason,
-			"condition" = EXCLUDED.condition,
-			incident_type = EXCLUDED.incident_type,
-			severity = EXCLUDED.severity,
-			end_time = EXCLUDED.end_time,
-			last_update = EXCLUDED.last_update,
-			lanes_closed = EXCLUDED.lanes_closed,
-			detour = EXCLUDED.detour,
-			status = 'active',
-			cleared_time = NULL;`
-
-	_, err := db.Exec(sqlStatement,
-		incident.ID, incident.Latitude, incident.Longitude, incident.CommonName, incident.Reason,
-		incident.Condition, incident.IncidentType, incident.Severity, incident.Direction,
-		incident.Location, incident.CountyID, incident.CountyName, incident.City, incident.StartTime,
-		incident.EndTime, incident.LastUpdate, incident.Road, incident.RouteID, incident.LanesClosed,
-		incident.LanesTotal, incident.Detour, incident.CrossStreetPrefix, incident.CrossStreetNumber,
-		incident.CrossStreetSuffix, incident.CrossStreetCommonName, incident.Event,
-		incident.CreatedFromConcurrent, incident.MovableConstruction, incident.WorkZoneSpeedLimit,
-	)
-	return err
-}
-
-// clearOldCrashes finds crashes in the DB that are no longer in the feed and marks them cleared.
-func clearOldCrashes(db *sql.DB, currentCrashIDs map[int]bool, webhookURL string) error {
-	rows, err := db.Query("SELECT id, road, location, city FROM ncdot_incidents WHERE status = 'active' AND incident_type = 'Vehicle Crash'")
+// clearOldIncidents finds incidents in the DB that are no longer in the feed and marks them cleared.
+// Only incident types selected by the filter are considered. When fetchedCounties is non-nil,
+// only incidents in those counties are considered, so a county whose fetch failed this cycle
+// doesn't have all of its incidents cleared. No notifications are sent during quiet hours.
+// It returns the incidents it marked cleared.
+func clearOldIncidents(ctx context.Context, store Store, currentIDs map[int]bool, fetchedCounties map[int]bool, types IncidentTypeFilter, notifications NotificationConfig, notifiers notifierSet, messages map[int][]DiscordMessage, quietNow bool) ([]ClearedIncident, error) {
+	activeDbIncidents, err := store.ActiveIncidents(ctx)
 	if err != nil {
-		return fmt.Errorf("could not query active crashes: %w", err)
+		return nil, fmt.Errorf("could not query active incidents: %w", err)
 	}
-	defer rows.Close()
 
-	var activeDbCrashes []ClearedIncident
-	for rows.Next() {
-		var i ClearedIncident
-		if err := rows.Scan(&i.ID, &i.Road, &i.Location, &i.City); err != nil {
-			log.Printf("Error scanning active crash from DB: %s", err)
+	var incidentsToClear []ClearedIncident
+	for _, dbIncident := range activeDbIncidents {
+		if !types.matches(dbIncident.IncidentType) {
 			continue
 		}
-		activeDbCrashes = append(activeDbCrashes, i)
-	}
-
-	var crashesToClear []ClearedIncident
-	for _, dbCrash := range activeDbCrashes {
-		if !currentCrashIDs[dbCrash.ID] {
-			crashesToClear = append(crashesToClear, dbCrash)
+		if fetchedCounties != nil && !fetchedCounties[dbIncident.CountyID] {
+			continue
+		}
+		if !currentIDs[dbIncident.ID] {
+			incidentsToClear = append(incidentsToClear, dbIncident)
 		}
 	}
 
-	if len(crashesToClear) > 0 {
-		log.Printf("Found %d crashes to mark as cleared.", len(crashesToClear))
-		for _, crash := range crashesToClear {
-			_, err := db.Exec(
-				"UPDATE ncdot_incidents SET status = 'cleared', cleared_time = NOW() WHERE id = $1",
-				crash.ID,
-			)
+	var cleared []ClearedIncident
+	if len(incidentsToClear) > 0 {
+		slog.Info("Found incidents to mark as cleared", "count", len(incidentsToClear))
+		for _, incident := range incidentsToClear {
+			duration, err := store.MarkCleared(ctx, incident.ID)
 			if err != nil {
-				log.Printf("Error updating crash %d to cleared: %s", crash.ID, err)
+				slog.Error("Error marking incident cleared", "incident_id", incident.ID, "err", err)
+				continue
+			}
+			incident.DurationSeconds = duration
+			cleared = append(cleared, incident)
+
+			// Pages resolve regardless of quiet hours; on-call shouldn't chase a cleared closure.
+			resolvePage(ctx, notifications.Paging, incident.ID)
+
+			if quietNow {
+				slog.Info("Incident cleared during quiet hours. Not notifying.", "incident_id", incident.ID)
+				clearDiscordRecords(ctx, messages[incident.ID], incident, notifications)
 			} else {
-				log.Printf("Crash %d cleared. Sending notification to Discord.", crash.ID)
-				sendClearedNotificationToDiscord(webhookURL, crash)
+				slog.Info("Incident cleared. Sending notifications.", "incident_id", incident.ID, "county", incident.CountyID)
+				notifiers.notifyCleared(ctx, incident)
 			}
+			delete(messages, incident.ID)
 		}
 	} else {
-		log.Println("No old crashes to clear.")
+		slog.Info("No old incidents to clear.")
 	}
 
-	return nil
+	return cleared, nil
 }
 
-func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("Note: .env file not found, reading credentials from environment")
+// upsertByCounty upserts incidents a county at a time, workers counties at
+// once, and returns what was stored for each incident in order. A county whose
+// upsert fails is logged and its incidents get zero stored rows, as if new,
+// without holding back the other counties; the error joins those failures.
+func upsertByCounty(ctx context.Context, store Store, incidents []Incident, workers int) ([]storedIncident, error) {
+	var counties []int
+	byCounty := make(map[int][]int) // County ID to indexes into incidents.
+	for i, incident := range incidents {
+		if _, ok := byCounty[incident.CountyID]; !ok {
+			counties = append(counties, incident.CountyID)
+		}
+		byCounty[incident.CountyID] = append(byCounty[incident.CountyID], i)
 	}
 
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=require",
-		os.Getenv("DATABASE_HOST"), os.Getenv("DATABASE_PORT"), os.Getenv("DATABASE_USERNAME"),
-		os.Getenv("DATABASE_PASSWORD"), os.Getenv("DATABASE_NAME"))
+	stored := make([]storedIncident, len(incidents))
+	var mu sync.Mutex
+	var errs []error
+	forEachConcurrently(counties, workers, func(countyID int) {
+		indexes := byCounty[countyID]
+		batch := make([]Incident, len(indexes))
+		for j, i := range indexes {
+			batch[j] = incidents[i]
+		}
+		rows, err := store.UpsertIncidents(ctx, batch)
+		if err != nil {
+			slog.Error("Error upserting incidents", "county", countyID, "count", len(batch), "err", err)
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("county %d: %w", countyID, err))
+			mu.Unlock()
+			return
+		}
+		// Each county writes its own indexes, so this needs no lock.
+		for j, i := range indexes {
+			stored[i] = rows[j]
+		}
+	})
+	return stored, errors.Join(errs...)
+}
 
-	db, err := sql.Open("postgres", psqlInfo)
+// runCycle performs a single fetch, upsert, notify and clear pass against the feed.
+func runCycle(ctx context.Context, store Store, cfg Config) (err error) {
+	ctx, span := startSpan(ctx, "cycle", spanKindInternal)
+	defer func() { span.finish(err) }()
+	if cfg.DryRun {
+		// The store and notifiers print instead of writing and sending, and
+		// paging, event publishing and reports are left out entirely.
+		store = dryRunStore{store}
+		cfg.Notifications.Paging = PagingConfig{}
+		cfg.Events = EventsConfig{}
+		cfg.Reports = ReportsConfig{}
+		cfg.Feed.SchemaStateFile = ""
+	}
+
+	sentIDs, err := loadSentIncidents(cfg.Feed.StateFile)
 	if err != nil {
-		log.Fatalf("Error opening database: %s", err)
+		// This error will only trigger for actual file system issues, not bad JSON.
+		return fmt.Errorf("error loading sent incidents: %w", err)
 	}
-	defer db.Close()
 
-	if err := db.Ping(); err != nil {
-		log.Fatalf("Error connecting to database: %s", err)
+	var snapshots *snapshotRecorder
+	if cfg.Feed.Snapshots {
+		snapshots = &snapshotRecorder{}
+	}
+	// A dry run always fetches the whole feed, so it has something to compare.
+	var cache *feedCache
+	if cfg.Feed.CacheFile != "" && !cfg.DryRun {
+		if cache, err = loadFeedCache(cfg.Feed.CacheFile); err != nil {
+			slog.Error("Error loading the feed cache", "err", err)
+		}
+	}
+	schema := newSchemaMonitor()
+	allIncidents, fetchedCounties, err := fetchFeed(ctx, cfg.Feed, snapshots, schema, cache)
+	// Responses are saved even when the fetch failed, since those are the
+	// ones worth looking at.
+	snapshots.save(ctx, store)
+	schema.report(ctx, cfg.Feed, cfg.schemaWebhook())
+	unchanged := errors.Is(err, errFeedNotModified)
+	if unchanged {
+		err = nil
+	}
+	cfg.Polling.health.fetched(err)
+	if err != nil {
+		return err
 	}
-	log.Println("Successfully connected to the database.")
-
-	dotURL := os.Getenv("DOT_URL")
-	webhookURL := os.Getenv("DISCORD_HOOK")
-	mapsAPIKey := os.Getenv("GOOGLE_MAPS_API_KEY")
-	stateFilename := "sent_incidents_ncdot.json"
 
-	if dotURL == "" || webhookURL == "" {
-		log.Fatalln("Error: DOT_URL and DISCORD_HOOK must be set in your environment or .env file.")
+	quiet := cfg.Filters.QuietHours
+	quietNow := quiet.active(time.Now())
+	var queued []QueuedAlert
+	if quiet.enabled() && quiet.Mode == quietModeQueue {
+		if queued, err = loadQueuedAlerts(quiet.QueueFile); err != nil {
+			slog.Error("Error loading quiet hours queue", "err", err)
+		}
 	}
 
-	sentIDs, err := loadSentIncidents(stateFilename)
+	messages, err := loadDiscordMessages(cfg.Notifications.MessagesFile)
 	if err != nil {
-		// This fatal error will now only trigger for actual file system issues, not bad JSON.
-		log.Fatalf("Error loading sent incidents: %s", err)
+		return fmt.Errorf("error loading Discord message records: %w", err)
+	}
+	notifiers := buildNotifiers(cfg.Notifications, notifierState{discordMessages: messages})
+	if cfg.DryRun {
+		notifiers = notifiers.dryRun()
+		// Without the records, no Discord alert is edited or threaded.
+		messages = make(map[int][]DiscordMessage)
+	}
+	if !quietNow && len(queued) > 0 && !cfg.DryRun {
+		queued = flushQueuedAlerts(ctx, notifiers, queued)
 	}
 
-	resp, err := http.Get(dotURL)
-	if err != nil {
-		log.Fatalf("Error fetching data: %s\n", err)
+	if unchanged {
+		// Nothing to parse or store, but queued work still goes out on time.
+		slog.Info("The feed hasn't changed since the last fetch. Skipping the upsert.")
+		if quiet.enabled() && quiet.Mode == quietModeQueue {
+			if err := saveQueuedAlerts(quiet.QueueFile, queued); err != nil {
+				slog.Error("Error saving quiet hours queue", "err", err)
+			}
+		}
+		if !quietNow {
+			notifiers.retryQueued(ctx, store)
+		}
+		notifiers.queueFailed(ctx, store)
+		sendDueReports(ctx, store, cfg)
+		cfg.Polling.pacer.observe(0)
+		return nil
+	}
+
+	incidents := cfg.Filters.IncidentTypes.apply(allIncidents)
+	span.set("ncdot.incidents", len(incidents))
+	slog.Info("Found incidents", "count", len(allIncidents), "matching_type", len(incidents))
+
+	currentIDs := make(map[int]bool)
+	for _, incident := range incidents {
+		currentIDs[incident.ID] = true
+	}
+	pageCriticalIncidents(ctx, cfg.Notifications.Paging, incidents)
+
+	var tracker *eventTracker
+	var events []IncidentEvent
+	if cfg.Events.enabled() {
+		if tracker, err = loadEventTracker(cfg.Events.StateFile); err != nil {
+			slog.Error("Error loading event state", "err", err)
+		}
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	slog.Info("Processing current incidents from feed...")
+	belowSeverity, outsideGeofence, offRoute, offCorridor, tooFar := 0, 0, 0, 0, 0
+	// changes counts the incidents that appeared or changed, for adaptive polling.
+	changes := 0
+	var pending, updates []Notification
+	// alerted are the current incidents already alerted, which new ones may duplicate.
+	var alerted []Incident
+	_, upsertSpan := startSpan(ctx, "store.upsert", spanKindClient)
+	upsertSpan.set("db.system", cfg.Database.Driver)
+	upsertSpan.set("ncdot.incidents", len(incidents))
+	stored, err := upsertByCounty(ctx, store, incidents, cfg.Feed.Workers)
+	upsertSpan.finish(err)
+	// Without the stored rows of a county that failed, nothing in it is known
+	// to have changed, so it gets no updates this cycle; new incidents still
+	// alert.
+	// complete is whether the cycle stored the feed and sent every alert, so
+	// the feed's validators may be kept.
+	complete := err == nil
+	upserted := complete
+	// The database can only check the geofence once the incidents are stored.
+	geofenceStore := store
 	if err != nil {
-		log.Fatalf("Error reading response body: %s\n", err)
+		geofenceStore = nil
+	}
+	inGeofence := geofenceCheck(ctx, geofenceStore, cfg.Filters.Geofence)
+	annotations := newAnnotator(ctx, cfg.Notifications, store, incidents)
+	for n, incident := range incidents {
+		previous := stored[n]
+		if !previous.Exists || previous.Cleared || !previous.LastUpdate.Equal(incident.LastUpdate.Time) {
+			changes++
+		}
+		// shown is the incident as alerts show it. It is annotated before the
+		// posted messages' fingerprints are compared, so an unchanged incident
+		// matches the alert already posted.
+		shown := incident
+		if sentIDs[incident.ID] || len(messages[incident.ID]) > 0 {
+			shown = annotations.annotate(ctx, incident)
+		}
+		// Only incidents that were already alerted get updates; the rest get a
+		// normal alert below once they pass the filters.
+		if sentIDs[incident.ID] {
+			alerted = append(alerted, incident)
+			if kind, what := incidentUpdate(cfg.Notifications, previous, incident); kind != "" {
+				startTime := incident.StartTime.Time
+				if startTime.IsZero() {
+					startTime = time.Now()
+				}
+				updates = append(updates, Notification{Kind: kind, Incident: shown, StartTime: startTime, Changes: what})
+			}
+		}
+		if tracker != nil {
+			if event, ok := tracker.observe(incident); ok {
+				events = append(events, event)
+			}
+		}
+
+		for i, msg := range messages[incident.ID] {
+			if incidentFingerprint(shown) != msg.Fingerprint {
+				messages[incident.ID][i] = updateDiscordAlert(ctx, msg, shown, cfg.Notifications)
+			}
+		}
+
+		if !sentIDs[incident.ID] {
+			if incident.Severity < cfg.Filters.MinSeverity {
+				// Not marked as sent, so the incident still alerts if its severity is raised later.
+				belowSeverity++
+				continue
+			}
+			if !inGeofence(incident) {
+				// Also left unsent, in case a later update moves the incident into the area.
+				outsideGeofence++
+				continue
+			}
+			if !cfg.Filters.Roads.matches(incident) {
+				offRoute++
+				continue
+			}
+			if !cfg.Filters.alongCorridor(incident) {
+				offCorridor++
+				continue
+			}
+			if !cfg.Filters.withinDistance(incident, cfg.Notifications.Places) {
+				tooFar++
+				continue
+			}
+
+			parsedTime := incident.StartTime.Time
+			if parsedTime.IsZero() {
+				// With an age limit configured, an unknown start time may mean a stale incident.
+				if cfg.Filters.MaxAge > 0 && !cfg.Filters.MaxAgeUnknownAlerts {
+					slog.Warn("Skipping alert: unknown start time", "incident_id", incident.ID)
+					sentIDs[incident.ID] = true
+					continue
+				}
+				slog.Warn("Unknown start time. Using current time.", "incident_id", incident.ID)
+				parsedTime = time.Now()
+			} else if cfg.Filters.MaxAge > 0 && time.Since(parsedTime) > cfg.Filters.MaxAge {
+				// Old incidents are marked as sent so they stay quiet on later runs too.
+				slog.Info("Skipping alert: older than the configured max age", "incident_id", incident.ID, "age", time.Since(parsedTime).Round(time.Minute))
+				sentIDs[incident.ID] = true
+				continue
+			}
+
+			shown = annotations.annotate(ctx, incident)
+
+			if quietNow {
+				if quiet.Mode == quietModeQueue {
+					slog.Info("Quiet hours: queueing alert", "incident_id", incident.ID)
+					queued = append(queued, QueuedAlert{Incident: newSavedIncident(shown), StartTime: parsedTime})
+				} else {
+					slog.Info("Quiet hours: suppressing alert", "incident_id", incident.ID)
+				}
+				sentIDs[incident.ID] = true
+				continue
+			}
+
+			slog.Info("Found new incident", "incident_id", incident.ID, "county", incident.CountyID, "type", incident.IncidentType)
+			pending = append(pending, Notification{Kind: notifyNew, Incident: shown, StartTime: parsedTime})
+		}
 	}
+	if err := annotations.save(); err != nil {
+		slog.Error("Error saving the geocoding cache", "err", err)
+	}
+	pending = cfg.Notifications.Dedup.apply(pending, alerted, sentIDs)
 
-	var allIncidents []Incident
-	if err := json.Unmarshal(body, &allIncidents); err != nil {
-		log.Fatalf("Error unmarshalling JSON: %s\n", err)
+	// Alerts are left unsent on any failure so they are retried next run rather than dropped.
+	if threshold := cfg.Notifications.BatchThreshold; threshold > 0 && len(pending) >= threshold {
+		slog.Info("Sending new incidents as one digest per channel...", "count", len(pending))
+		if notifiers.notifyBatch(ctx, pending) {
+			for _, n := range pending {
+				for _, id := range duplicateIDs(n.Incident) {
+					sentIDs[id] = true
+				}
+			}
+		} else {
+			complete = false
+		}
+	} else {
+		for _, n := range pending {
+			slog.Info("Sending notifications...", "incident_id", n.Incident.ID, "county", n.Incident.CountyID)
+			if notifiers.notifyNew(ctx, n.Incident, n.StartTime) {
+				for _, id := range duplicateIDs(n.Incident) {
+					sentIDs[id] = true
+				}
+			} else {
+				complete = false
+			}
+		}
+	}
+	for _, n := range updates {
+		if quietNow {
+			slog.Info("Incident changed during quiet hours. Not notifying.", "incident_id", n.Incident.ID, "kind", n.Kind)
+			continue
+		}
+		slog.Info("Incident changed. Sending notifications.", "incident_id", n.Incident.ID, "county", n.Incident.CountyID, "kind", n.Kind, "changes", strings.Join(n.Changes, "; "))
+		notifiers.notifyUpdate(ctx, n)
+	}
+	slog.Info("Upserted/updated incidents in the database", "count", len(incidents))
+	if belowSeverity > 0 {
+		slog.Info("Held back alerts below the minimum severity", "count", belowSeverity, "min_severity", cfg.Filters.MinSeverity)
+	}
+	if outsideGeofence > 0 {
+		slog.Info("Held back alerts outside the geofence", "count", outsideGeofence)
+	}
+	if offRoute > 0 {
+		slog.Info("Held back alerts not on the allowed roads", "count", offRoute)
+	}
+	if offCorridor > 0 {
+		slog.Info("Held back alerts not along any corridor", "count", offCorridor)
+	}
+	if tooFar > 0 {
+		slog.Info("Held back alerts farther than the max distance from every place", "count", tooFar, "max_distance_miles", cfg.Filters.MaxDistanceMiles)
 	}
 
-	var vehicleCrashes []Incident
-	for _, incident := range allIncidents {
-		if incident.IncidentType == "Vehicle Crash" {
-			vehicleCrashes = append(vehicleCrashes, incident)
+	if quiet.enabled() && quiet.Mode == quietModeQueue && !cfg.DryRun {
+		if err := saveQueuedAlerts(quiet.QueueFile, queued); err != nil {
+			slog.Error("Error saving quiet hours queue", "err", err)
 		}
 	}
-	log.Printf("Found %d total incidents, %d of which are vehicle crashes.", len(allIncidents), len(vehicleCrashes))
 
-	currentCrashIDs := make(map[int]bool)
-	for _, crash := range vehicleCrashes {
-		currentCrashIDs[crash.ID] = true
+	clearCtx, clearSpan := startSpan(ctx, "clear", spanKindInternal)
+	cleared, err := clearOldIncidents(clearCtx, store, currentIDs, fetchedCounties, cfg.Filters.IncidentTypes, cfg.Notifications, notifiers, messages, quietNow)
+	clearSpan.set("ncdot.cleared", len(cleared))
+	clearSpan.finish(err)
+	if err != nil {
+		slog.Error("Error during clearing of old incidents", "err", err)
+		complete = false
+	}
+	notifiers.flush(ctx)
+	// A county that failed to upsert has no stored rows, so its incidents
+	// would only look new.
+	if upserted {
+		cfg.Polling.pacer.observe(changes + len(cleared))
 	}
 
-	log.Println("Processing current vehicle crashes from feed...")
-	for _, crash := range vehicleCrashes {
-		if err := upsertIncident(db, crash); err != nil {
-			log.Printf("Error upserting crash %d: %s", crash.ID, err)
+	if tracker != nil {
+		for _, incident := range cleared {
+			events = append(events, tracker.clear(incident))
+		}
+		publishEvents(ctx, cfg.Events, events)
+		if err := tracker.save(); err != nil {
+			slog.Error("Error saving event state", "err", err)
 		}
+	}
+
+	if cfg.DryRun {
+		return nil
+	}
+	// Queued notifications wait out quiet hours like new ones. This cycle's
+	// failures are queued after the retries, so they wait their first backoff.
+	if !quietNow {
+		notifiers.retryQueued(ctx, store)
+	}
+	notifiers.queueFailed(ctx, store)
+	if err := saveSentIncidents(cfg.Feed.StateFile, sentIDs); err != nil {
+		slog.Error("Error saving sent incidents file", "err", err)
+	}
+	if err := saveDiscordMessages(cfg.Notifications.MessagesFile, messages); err != nil {
+		slog.Error("Error saving Discord message records", "err", err)
+	}
+	if err := cache.save(complete); err != nil {
+		slog.Error("Error saving the feed cache", "err", err)
+	}
+	sendDueReports(ctx, store, cfg)
+	return nil
+}
 
-		if !sentIDs[crash.ID] {
-			log.Printf("Found new crash (ID: %d). Sending to Discord...", crash.ID)
+// runCommand implements the "run" subcommand: a single cycle, or a polling loop with --daemon.
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := addConfigFlag(fs)
+	daemon := fs.Bool("daemon", false, "keep running and poll the feed on an interval")
+	interval := fs.Duration("interval", 0, "polling interval in daemon mode (overrides the config file)")
+	dryRun := fs.Bool("dry-run", false, "fetch and compare, but print what would be stored and sent instead of doing it")
+	fs.Parse(args)
 
-			parsedTime, err := time.Parse(time.RFC3339, crash.StartTime)
-			if err != nil {
-				log.Printf("Error parsing timestamp for crash %d: %s. Using current time.", crash.ID, err)
-				parsedTime = time.Now()
+	cfg, err := loadCommandConfig(fs, *configPath, true)
+	if err != nil {
+		return err
+	}
+	if *daemon {
+		cfg.Polling.Daemon = true
+	}
+	if *interval > 0 {
+		cfg.Polling.Interval = *interval
+	}
+	cfg.DryRun = *dryRun
+	if cfg.DryRun {
+		slog.Info("Dry run: nothing is stored or sent.")
+	}
+	startTracing(cfg.Tracing)
+
+	store, err := openStore(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Polling.Daemon {
+		var elector *leaderElector
+		if !cfg.DryRun {
+			if elector, err = newLeaderElector(store, cfg); err != nil {
+				return err
 			}
+		}
+		var served chan struct{}
+		if cfg.API.Enabled {
+			cfg.Events.hub = newEventHub()
+			cfg.Polling.health = newPollerHealth(cfg.pollSpacing())
+			api := &apiServer{store: store, hub: cfg.Events.hub, health: cfg.Polling.health, hotspots: cfg.Reports.Hotspots}
+			served = make(chan struct{})
+			go func() {
+				defer close(served)
+				if err := serveAPIs(ctx, cfg.API, api); err != nil {
+					slog.Error("Error serving the API", "err", err)
+				}
+			}()
+		}
+		runDaemon(ctx, store, cfg, elector)
+		if served != nil {
+			<-served
+		}
+		return nil
+	}
 
-			sendToDiscord(webhookURL, crash, parsedTime, mapsAPIKey)
-			sentIDs[crash.ID] = true
+	// A dry run writes nothing, so it can't collide with a real one.
+	if !cfg.DryRun {
+		release, err := acquireRunLock(ctx, store, cfg)
+		if errors.Is(err, errRunActive) {
+			slog.Info("Another run is still active. Exiting.")
+			return nil
+		} else if err != nil {
+			return err
 		}
+		defer release()
+	}
+	if err := runCycle(ctx, store, cfg); err != nil {
+		return err
 	}
-	log.Printf("Upserted/updated %d crashes in the database.", len(vehicleCrashes))
+	slog.Info("Run complete.")
+	return nil
+}
 
-	if err := clearOldCrashes(db, currentCrashIDs, webhookURL); err != nil {
-		log.Printf("Error during clearing of old crashes: %s", err)
+func main() {
+	if err := godotenv.Load(); err != nil {
+		slog.Info("Note: .env file not found, reading credentials from environment")
 	}
 
-	if err := saveSentIncidents(stateFilename, sentIDs); err != nil {
-		log.Printf("Error saving sent incidents file: %s", err)
+	if err := runCLI(os.Args[1:]); err != nil {
+		slog.Error("Error: " + err.Error())
+		os.Exit(1)
 	}
-	log.Println("Run complete.")
 }
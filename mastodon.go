@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// mastodonMaxChars is the default post length limit on most instances.
+const mastodonMaxChars = 500
+
+// MastodonConfig posts major incidents to a Mastodon account, e.g. a public
+// traffic bot. Only incidents at or above MinSeverity, or full closures when
+// FullClosure is set, are posted.
+type MastodonConfig struct {
+	InstanceURL string   `yaml:"instance_url"`
+	AccessToken string   `yaml:"access_token"`
+	MinSeverity int      `yaml:"min_severity"`
+	FullClosure bool     `yaml:"full_closure"`
+	Hashtags    []string `yaml:"hashtags"`
+	Visibility  string   `yaml:"visibility"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether Mastodon posting is configured.
+func (m MastodonConfig) enabled() bool {
+	return m.InstanceURL != "" && m.AccessToken != ""
+}
+
+// qualifies reports whether an incident is major enough to post publicly.
+func (m MastodonConfig) qualifies(incident Incident) bool {
+	if incident.Severity >= m.MinSeverity {
+		return true
+	}
+	return m.FullClosure && isFullClosure(incident)
+}
+
+// hashtagText renders the configured hashtags, adding # where missing.
+func hashtagText(tags []string) string {
+	var out []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			out = append(out, "#"+strings.TrimPrefix(tag, "#"))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// buildMastodonStatus composes the post, shortening the description if the
+// hashtags and map link would push it over the limit.
+func buildMastodonStatus(incident Incident, parsedTime time.Time, hashtags []string) string {
+	text := fmt.Sprintf("🚨 %s: %s at %s, %s. Lanes %s. Started %s.",
+		incident.IncidentType, orNA(incident.Road), orNA(incident.Location), orNA(incident.City),
+		lanesText(incident.LanesClosed, incident.LanesTotal), localTime(parsedTime).Format("3:04 PM"))
+	// Mastodon counts every link as 23 characters regardless of length.
+	tail := "\n\n🗺️ " + mapLink(incident.Latitude, incident.Longitude)
+	tailLen := len([]rune("\n\n🗺️ ")) + 23
+	if tags := hashtagText(hashtags); tags != "" {
+		tail += "\n" + tags
+		tailLen += 1 + len([]rune(tags))
+	}
+	return truncateRunes(text, mastodonMaxChars-tailLen) + tail
+}
+
+// sendToMastodon posts a qualifying incident.
+func sendToMastodon(ctx context.Context, cfg MastodonConfig, incident Incident, parsedTime time.Time) error {
+	if !cfg.qualifies(incident) {
+		return nil
+	}
+	status := buildMastodonStatus(incident, parsedTime, cfg.Hashtags)
+	if custom, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		status = truncateRunes(custom, mastodonMaxChars)
+	}
+	payload := map[string]string{
+		"status":     status,
+		"visibility": cfg.Visibility,
+	}
+	headers := map[string]string{
+		"Authorization": "Bearer " + cfg.AccessToken,
+		// The instance drops a repeat of the same key, so a retried run can't double-post.
+		"Idempotency-Key": fmt.Sprintf("ncdot-incident-%d", incident.ID),
+	}
+	endpoint := strings.TrimRight(cfg.InstanceURL, "/") + "/api/v1/statuses"
+	return postJSON(ctx, endpoint, payload, headers, nil)
+}
+
+// mastodonNotifier delivers notifications to a Mastodon account. Only new incidents are posted.
+type mastodonNotifier struct {
+	cfg MastodonConfig
+}
+
+func init() {
+	registerNotifier("mastodon", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Mastodon.enabled() {
+			return nil
+		}
+		c := cfg.Mastodon
+		c.templates = cfg.templatesFor("mastodon")
+		return mastodonNotifier{cfg: c}
+	})
+}
+
+func (m mastodonNotifier) Name() string { return "Mastodon" }
+
+func (m mastodonNotifier) Notify(ctx context.Context, n Notification) error {
+	if n.Kind != notifyNew {
+		return nil // Only new incidents are posted.
+	}
+	return sendToMastodon(ctx, m.cfg, n.Incident, n.StartTime)
+}
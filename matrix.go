@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MatrixConfig posts alerts to a Matrix room as the account owning AccessToken,
+// which must already have joined the room.
+type MatrixConfig struct {
+	HomeserverURL string `yaml:"homeserver_url"`
+	AccessToken   string `yaml:"access_token"`
+	RoomID        string `yaml:"room_id"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether Matrix alerts are configured.
+func (m MatrixConfig) enabled() bool {
+	return m.HomeserverURL != "" && m.AccessToken != "" && m.RoomID != ""
+}
+
+// matrixMessage is an m.room.message event with an HTML body and a plain fallback.
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+// matrixRow formats a label and escaped value as plain and HTML lines.
+func matrixRow(label, value string) (string, string) {
+	value = orNA(value)
+	return fmt.Sprintf("%s: %s", label, value), fmt.Sprintf("<b>%s:</b> %s", label, html.EscapeString(value))
+}
+
+// buildMatrixMessage lays out a title, labelled rows and a footer.
+func buildMatrixMessage(title string, rows [][2]string, footer string) matrixMessage {
+	plain := []string{title}
+	formatted := []string{"<h4>" + html.EscapeString(title) + "</h4>"}
+	for _, row := range rows {
+		p, f := matrixRow(row[0], row[1])
+		plain = append(plain, p)
+		formatted = append(formatted, f)
+	}
+	plain = append(plain, footer)
+	formatted = append(formatted, "<i>"+footer+"</i>")
+	return matrixMessage{
+		MsgType:       "m.text",
+		Body:          strings.Join(plain, "\n"),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: strings.Join(formatted, "<br>"),
+	}
+}
+
+// postToMatrix sends a message event to the room.
+func postToMatrix(ctx context.Context, cfg MatrixConfig, msg matrixMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error creating Matrix payload: %w", err)
+	}
+	// The transaction ID makes retries of the same request idempotent.
+	txnID := strconv.FormatInt(time.Now().UnixNano(), 10)
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(cfg.HomeserverURL, "/"), url.PathEscape(cfg.RoomID), txnID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.AccessToken)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Matrix returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// sendToMatrix posts a new-incident alert to the room.
+func sendToMatrix(ctx context.Context, cfg MatrixConfig, incident Incident, parsedTime time.Time) error {
+	link := mapLink(incident.Latitude, incident.Longitude)
+	msg := buildMatrixMessage(fmt.Sprintf("New %s Alert", incident.IncidentType), [][2]string{
+		{"Reason", incident.Reason},
+		{"Road", incident.Road},
+		{"Location", incident.Location},
+		{"City", incident.City},
+		{"Lanes", lanesText(incident.LanesClosed, incident.LanesTotal)},
+		{"Severity", strconv.Itoa(incident.Severity)},
+		{"Started", formatLocalTime(parsedTime)},
+	}, fmt.Sprintf("Incident #%d", incident.ID))
+	msg.Body += "\n" + link
+	msg.FormattedBody += fmt.Sprintf(`<br><a href="%s">View on map</a>`, html.EscapeString(link))
+	if text, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		msg = matrixMessage{MsgType: "m.text", Body: text} // Templates are sent as plain text.
+	}
+	return postToMatrix(ctx, cfg, msg)
+}
+
+// sendClearedNotificationToMatrix posts a cleared notification to the room.
+func sendClearedNotificationToMatrix(ctx context.Context, cfg MatrixConfig, incident ClearedIncident) error {
+	msg := buildMatrixMessage(clearedTitle(incident), [][2]string{
+		{"Road", incident.Road},
+		{"Location", incident.Location},
+		{"City", incident.City},
+	}, fmt.Sprintf("Incident #%d · No longer in NC DOT feed", incident.ID))
+	if text, ok := cfg.templates.render(clearedTemplateData(incident)); ok {
+		msg = matrixMessage{MsgType: "m.text", Body: text}
+	}
+	return postToMatrix(ctx, cfg, msg)
+}
+
+// matrixNotifier delivers notifications to a Matrix room.
+type matrixNotifier struct {
+	cfg MatrixConfig
+}
+
+func init() {
+	registerNotifier("matrix", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Matrix.enabled() {
+			return nil
+		}
+		c := cfg.Matrix
+		c.templates = cfg.templatesFor("matrix")
+		return matrixNotifier{cfg: c}
+	})
+}
+
+func (m matrixNotifier) Name() string { return "Matrix" }
+
+// NotifyBatch posts one digest message to the room.
+func (m matrixNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	return postToMatrix(ctx, m.cfg, matrixMessage{MsgType: "m.text", Body: digestTitle(batch) + "\n" + digestText(batch, 0, nil)})
+}
+
+func (m matrixNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedNotificationToMatrix(ctx, m.cfg, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return postToMatrix(ctx, m.cfg, matrixMessage{MsgType: "m.text", Body: updateTitle(n) + "\n" + updateText(m.cfg.templates, n)})
+	}
+	return sendToMatrix(ctx, m.cfg, n.Incident, n.StartTime)
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// memStore is a Store kept in memory, for tests and for trying the tool out
+// with database.driver set to "memory". It follows sqlStore, including reopen
+// counting and clearance durations, but nothing outlives the process.
+type memStore struct {
+	mu        sync.Mutex
+	incidents map[int]*StoredIncident
+	// archive holds the incidents moved out by ArchiveCleared.
+	archive []StoredIncident
+	// changes are the recorded incident changes, oldest first.
+	changes []IncidentChange
+	// snapshots are the stored feed responses, oldest first.
+	snapshots []feedSnapshot
+	// queue is the notification retry queue, oldest first.
+	queue       []queuedNotification
+	lastQueueID int64
+	// weather is the stored incident weather, by incident ID.
+	weather map[int]incidentWeather
+	// now is the clock, replaceable in tests.
+	now func() time.Time
+}
+
+// newMemStore returns an empty memStore.
+func newMemStore() *memStore {
+	return &memStore{incidents: make(map[int]*StoredIncident), weather: make(map[int]incidentWeather), now: time.Now}
+}
+
+// UpsertIncidents stores the incidents one at a time.
+func (m *memStore) UpsertIncidents(_ context.Context, incidents []Incident) ([]storedIncident, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := make([]storedIncident, len(incidents))
+	for i, incident := range incidents {
+		stored[i] = m.upsert(incident)
+	}
+	return stored, nil
+}
+
+// upsert stores an incident, updating the same fields as the SQL upsert. The
+// caller holds the lock.
+func (m *memStore) upsert(incident Incident) storedIncident {
+	si, ok := m.incidents[incident.ID]
+	if !ok {
+		m.incidents[incident.ID] = &StoredIncident{Incident: incident, Status: "active"}
+		return storedIncident{}
+	}
+	previous := storedIncident{
+		Exists:      true,
+		Cleared:     si.Status == "cleared",
+		Severity:    si.Severity,
+		LanesClosed: si.LanesClosed,
+		LanesTotal:  si.LanesTotal,
+		LastUpdate:  si.LastUpdate,
+	}
+	if previous.Cleared {
+		now := m.now()
+		si.ReopenCount++
+		si.ReopenedAt = &now
+	}
+	previous.ReopenCount = si.ReopenCount
+	previous.Condition, previous.Reason = si.Condition, si.Reason
+	if previous.unchanged(incident) {
+		return previous
+	}
+	for _, c := range previous.changes(incident) {
+		c.ObservedAt = m.now()
+		m.changes = append(m.changes, c)
+	}
+
+	si.Latitude, si.Longitude = incident.Latitude, incident.Longitude
+	si.Reason, si.Condition, si.IncidentType = incident.Reason, incident.Condition, incident.IncidentType
+	si.Severity, si.EndTime, si.LastUpdate = incident.Severity, incident.EndTime, incident.LastUpdate
+	si.LanesClosed, si.LanesTotal, si.Detour = incident.LanesClosed, incident.LanesTotal, incident.Detour
+	si.Status, si.ClearedTime, si.DurationSeconds = "active", nil, nil
+	return previous
+}
+
+// ActiveIncidents returns the incidents that haven't cleared, by ID.
+func (m *memStore) ActiveIncidents(_ context.Context) ([]ClearedIncident, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var active []ClearedIncident
+	for _, si := range m.sorted() {
+		if si.Status == "active" {
+			active = append(active, ClearedIncident{
+				ID:           si.ID,
+				IncidentType: si.IncidentType,
+				CountyID:     si.CountyID,
+				Severity:     si.Severity,
+				Road:         si.Road,
+				Location:     si.Location,
+				City:         si.City,
+			})
+		}
+	}
+	return active, nil
+}
+
+// MarkCleared clears the incident, storing its duration when the start time parses.
+func (m *memStore) MarkCleared(_ context.Context, id int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	si, ok := m.incidents[id]
+	if !ok {
+		return 0, fmt.Errorf("incident %d is not stored", id)
+	}
+	now := m.now()
+	si.Status, si.ClearedTime, si.DurationSeconds = "cleared", &now, nil
+	if si.StartTime.IsZero() {
+		return 0, nil
+	}
+	seconds := max(int(now.Sub(si.StartTime.Time).Seconds()), 0)
+	si.DurationSeconds = &seconds
+	return seconds, nil
+}
+
+// Incidents returns copies of the incidents with a status, or all of them, by ID.
+func (m *memStore) Incidents(_ context.Context, status string) ([]StoredIncident, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	incidents := []StoredIncident{}
+	for _, si := range m.sorted() {
+		if status == "all" || si.Status == status {
+			incidents = append(incidents, *si)
+		}
+	}
+	return incidents, nil
+}
+
+// FindIncidents returns copies of the page of incidents matching q.
+func (m *memStore) FindIncidents(_ context.Context, q incidentQuery) ([]StoredIncident, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matched []StoredIncident
+	for _, si := range m.sorted() {
+		if q.matches(*si) {
+			matched = append(matched, *si)
+		}
+	}
+	if q.Newest {
+		slices.Reverse(matched)
+	}
+	start := min(q.Offset, len(matched))
+	end := min(start+q.Limit, len(matched))
+	return append([]StoredIncident{}, matched[start:end]...), len(matched), nil
+}
+
+// IncidentCounts counts incidents by type and status.
+func (m *memStore) IncidentCounts(_ context.Context) ([]incidentCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var counts []incidentCount
+	for _, si := range m.incidents {
+		i := slices.IndexFunc(counts, func(c incidentCount) bool {
+			return c.IncidentType == si.IncidentType && c.Status == si.Status
+		})
+		if i < 0 {
+			counts = append(counts, incidentCount{IncidentType: si.IncidentType, Status: si.Status})
+			i = len(counts) - 1
+		}
+		counts[i].Count++
+	}
+	slices.SortFunc(counts, func(a, b incidentCount) int {
+		return cmp.Or(cmp.Compare(a.IncidentType, b.IncidentType), cmp.Compare(a.Status, b.Status))
+	})
+	return counts, nil
+}
+
+// IncidentChanges returns the incident's recorded changes, oldest first.
+func (m *memStore) IncidentChanges(_ context.Context, id int) ([]IncidentChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changes := []IncidentChange{}
+	for _, c := range m.changes {
+		if c.IncidentID == id {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// IncidentChangesByID returns the incidents' recorded changes.
+func (m *memStore) IncidentChangesByID(_ context.Context, ids []int) (map[int][]IncidentChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changes := make(map[int][]IncidentChange)
+	for _, c := range m.changes {
+		if slices.Contains(ids, c.IncidentID) {
+			changes[c.IncidentID] = append(changes[c.IncidentID], c)
+		}
+	}
+	return changes, nil
+}
+
+// PurgeCleared deletes incidents cleared before cutoff.
+func (m *memStore) PurgeCleared(_ context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, si := range m.incidents {
+		if si.Status == "cleared" && si.ClearedTime != nil && si.ClearedTime.Before(cutoff) {
+			delete(m.incidents, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ArchiveCleared moves incidents cleared before cutoff to the archive.
+func (m *memStore) ArchiveCleared(_ context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, si := range m.sorted() {
+		if si.Status == "cleared" && si.ClearedTime != nil && si.ClearedTime.Before(cutoff) {
+			m.archive = append(m.archive, *si)
+			delete(m.incidents, si.ID)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// SaveFeedSnapshots keeps the snapshots whose payload differs from the URL's last.
+func (m *memStore) SaveFeedSnapshots(_ context.Context, snapshots []feedSnapshot) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := 0
+	for _, snapshot := range snapshots {
+		if m.latestSnapshotHash(snapshot.URL) == snapshot.sha256() {
+			continue
+		}
+		snapshot.ID = int64(len(m.snapshots) + 1)
+		m.snapshots = append(m.snapshots, snapshot)
+		saved++
+	}
+	return saved, nil
+}
+
+// latestSnapshotHash returns the digest of url's newest snapshot, or "". The
+// caller holds the lock.
+func (m *memStore) latestSnapshotHash(url string) string {
+	for i := len(m.snapshots) - 1; i >= 0; i-- {
+		if m.snapshots[i].URL == url {
+			return m.snapshots[i].sha256()
+		}
+	}
+	return ""
+}
+
+// FeedSnapshots calls fn with the snapshots fetched since since, oldest first,
+// without holding the lock.
+func (m *memStore) FeedSnapshots(_ context.Context, since time.Time, fn func(feedSnapshot) error) error {
+	m.mu.Lock()
+	snapshots := slices.Clone(m.snapshots)
+	m.mu.Unlock()
+
+	for _, snapshot := range snapshots {
+		if snapshot.FetchedAt.Before(since) {
+			continue
+		}
+		if err := fn(snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeFeedSnapshots deletes snapshots fetched before cutoff.
+func (m *memStore) PurgeFeedSnapshots(_ context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := len(m.snapshots)
+	m.snapshots = slices.DeleteFunc(m.snapshots, func(s feedSnapshot) bool { return s.FetchedAt.Before(cutoff) })
+	return int64(n - len(m.snapshots)), nil
+}
+
+// QueueNotifications adds notifications to the retry queue.
+func (m *memStore) QueueNotifications(_ context.Context, queued []queuedNotification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, q := range queued {
+		m.lastQueueID++
+		q.ID = m.lastQueueID
+		m.queue = append(m.queue, q)
+	}
+	return nil
+}
+
+// DueNotifications returns the queued notifications due by now.
+func (m *memStore) DueNotifications(_ context.Context, now time.Time) ([]queuedNotification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []queuedNotification
+	for _, q := range m.queue {
+		if !q.NextAttempt.After(now) {
+			due = append(due, q)
+		}
+	}
+	return due, nil
+}
+
+// RescheduleNotification updates a queued notification.
+func (m *memStore) RescheduleNotification(_ context.Context, q queuedNotification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.queue {
+		if m.queue[i].ID == q.ID {
+			m.queue[i] = q
+		}
+	}
+	return nil
+}
+
+// DeleteQueuedNotification removes a notification from the retry queue.
+func (m *memStore) DeleteQueuedNotification(_ context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queue = slices.DeleteFunc(m.queue, func(q queuedNotification) bool { return q.ID == id })
+	return nil
+}
+
+// IncidentWeather returns the stored weather of the incidents that have it.
+func (m *memStore) IncidentWeather(_ context.Context, ids []int) (map[int]incidentWeather, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	weather := make(map[int]incidentWeather)
+	for _, id := range ids {
+		if w, ok := m.weather[id]; ok {
+			weather[id] = w
+		}
+	}
+	return weather, nil
+}
+
+// SaveIncidentWeather stores an incident's weather.
+func (m *memStore) SaveIncidentWeather(_ context.Context, id int, w incidentWeather) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.weather[id] = w
+	return nil
+}
+
+// DailyReport aggregates the incidents that started during day.
+func (m *memStore) DailyReport(_ context.Context, day time.Time) (dailyReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := dailyReport{Day: day, ByType: make(map[string]int)}
+	window := m.window(day, day.AddDate(0, 0, 1))
+	var clearances []time.Duration
+	for _, w := range window {
+		report.Total++
+		report.ByType[w.IncidentType]++
+		if w.IncidentType == "Vehicle Crash" {
+			report.Crashes++
+		}
+		if w.ClearedTime != nil {
+			report.Cleared++
+		}
+		if d, ok := w.clearance(); ok {
+			clearances = append(clearances, d)
+		}
+		if w.Latitude != 0 && w.Longitude != 0 {
+			report.Points = append(report.Points, reportPoint{Latitude: w.Latitude, Longitude: w.Longitude, Severity: w.Severity})
+		}
+	}
+	report.AvgClearance = avgDuration(clearances)
+	report.BusiestRoads = busiestRoads(window, 5)
+
+	// Points follow the window's start order, so a stable sort by severity
+	// matches the SQL's ORDER BY severity DESC, started.
+	slices.SortStableFunc(report.Points, func(a, b reportPoint) int { return cmp.Compare(b.Severity, a.Severity) })
+	report.Points = report.Points[:min(len(report.Points), maxReportPoints)]
+	return report, nil
+}
+
+// RoadCounts returns the busiest roads among the incidents that started in [from, to).
+func (m *memStore) RoadCounts(_ context.Context, from, to time.Time, limit int) ([]roadCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return busiestRoads(m.window(from, to), limit), nil
+}
+
+// HourlyCounts counts the incidents that started in [from, to) by UTC hour.
+func (m *memStore) HourlyCounts(_ context.Context, from, to time.Time) ([]hourCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var counts []hourCount
+	for _, w := range m.window(from, to) {
+		hour := w.started.UTC().Truncate(time.Hour)
+		if n := len(counts); n > 0 && counts[n-1].Hour.Equal(hour) {
+			counts[n-1].Count++
+		} else {
+			counts = append(counts, hourCount{Hour: hour, Count: 1})
+		}
+	}
+	return counts, nil
+}
+
+// WeeklyReport compares the week starting at start with the week before.
+func (m *memStore) WeeklyReport(_ context.Context, start time.Time, top int) (weeklyReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if top <= 0 {
+		top = defaultWeeklyTop
+	}
+	window := m.window(start.AddDate(0, 0, -7), start.AddDate(0, 0, 7))
+	report := weeklyReport{Start: start, Total: weekTrend("", window, start)}
+	report.Roads = groupTrends(window, start, top, func(si StoredIncident) string { return si.Road })
+	report.Counties = groupTrends(window, start, top, func(si StoredIncident) string { return si.CountyName })
+	return report, nil
+}
+
+// IncidentStats aggregates the incidents that started in [from, to).
+func (m *memStore) IncidentStats(_ context.Context, from, to time.Time, top int) (incidentStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	window := m.window(from, to)
+	stats := incidentStats{Since: from, Until: to}
+	var clearances []time.Duration
+	for _, w := range window {
+		stats.Total++
+		if w.ClearedTime != nil {
+			stats.Cleared++
+		}
+		if d, ok := w.clearance(); ok {
+			clearances = append(clearances, d)
+		}
+		started := w.started.In(from.Location())
+		stats.ByHour[started.Hour()]++
+		stats.ByWeekday[started.Weekday()]++
+	}
+	stats.AvgClearanceSeconds = int(avgDuration(clearances).Seconds())
+	stats.ByType = statGroups(window, top, func(si StoredIncident) string { return si.IncidentType })
+	stats.ByRoad = statGroups(window, top, func(si StoredIncident) string { return si.Road })
+	stats.ByCounty = statGroups(window, top, func(si StoredIncident) string { return si.CountyName })
+	return stats, nil
+}
+
+// Ping always succeeds.
+func (m *memStore) Ping(_ context.Context) error {
+	return nil
+}
+
+// Close does nothing; the incidents are dropped with the store.
+func (m *memStore) Close() error {
+	return nil
+}
+
+// sorted returns the incidents by ID. The caller holds the lock.
+func (m *memStore) sorted() []*StoredIncident {
+	incidents := make([]*StoredIncident, 0, len(m.incidents))
+	for _, si := range m.incidents {
+		incidents = append(incidents, si)
+	}
+	slices.SortFunc(incidents, func(a, b *StoredIncident) int { return cmp.Compare(a.ID, b.ID) })
+	return incidents
+}
+
+// windowIncident is an incident in a report window, with its parsed start.
+type windowIncident struct {
+	StoredIncident
+	started time.Time
+}
+
+// clearance returns how long the incident took to clear, if it has.
+func (w windowIncident) clearance() (time.Duration, bool) {
+	if w.ClearedTime == nil || !w.ClearedTime.After(w.started) {
+		return 0, false
+	}
+	return w.ClearedTime.Sub(w.started), true
+}
+
+// window returns the incidents that started in [from, to), by start time.
+// Unknown start times are left out, as in the SQL.
+func (m *memStore) window(from, to time.Time) []windowIncident {
+	var window []windowIncident
+	for _, si := range m.sorted() {
+		started := si.StartTime.Time
+		if started.IsZero() || started.Before(from) || !started.Before(to) {
+			continue
+		}
+		window = append(window, windowIncident{StoredIncident: *si, started: started})
+	}
+	slices.SortStableFunc(window, func(a, b windowIncident) int { return a.started.Compare(b.started) })
+	return window
+}
+
+// busiestRoads returns the limit roads with the most incidents in window.
+func busiestRoads(window []windowIncident, limit int) []roadCount {
+	roads := make(map[string]int)
+	for _, w := range window {
+		if w.Road != "" {
+			roads[w.Road]++
+		}
+	}
+	var counts []roadCount
+	for road, count := range roads {
+		counts = append(counts, roadCount{Road: road, Count: count})
+	}
+	slices.SortFunc(counts, func(a, b roadCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Road, b.Road))
+	})
+	return counts[:min(len(counts), limit)]
+}
+
+// weekTrend computes a trend over window, split into weeks at split.
+func weekTrend(name string, window []windowIncident, split time.Time) trend {
+	t := trend{Name: name}
+	var cur, prev []time.Duration
+	for _, w := range window {
+		d, cleared := w.clearance()
+		if w.started.Before(split) {
+			t.PrevCount++
+			if cleared {
+				prev = append(prev, d)
+			}
+		} else {
+			t.Count++
+			if cleared {
+				cur = append(cur, d)
+			}
+		}
+	}
+	t.Clearance, t.PrevClearance = avgDuration(cur), avgDuration(prev)
+	return t
+}
+
+// groupTrends returns the trends of window grouped by key, busiest this week
+// first, leaving out incidents with an empty key.
+func groupTrends(window []windowIncident, split time.Time, limit int, key func(StoredIncident) string) []trend {
+	groups := make(map[string][]windowIncident)
+	for _, w := range window {
+		if k := key(w.StoredIncident); k != "" {
+			groups[k] = append(groups[k], w)
+		}
+	}
+	var trends []trend
+	for name, incidents := range groups {
+		trends = append(trends, weekTrend(name, incidents, split))
+	}
+	slices.SortFunc(trends, func(a, b trend) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(b.PrevCount, a.PrevCount), cmp.Compare(a.Name, b.Name))
+	})
+	return trends[:min(len(trends), limit)]
+}
+
+// statGroups returns the counts and average clearance of window grouped by
+// key, busiest first, leaving out incidents with an empty key.
+func statGroups(window []windowIncident, limit int, key func(StoredIncident) string) []statGroup {
+	groups := make(map[string][]time.Duration)
+	counts := make(map[string]int)
+	for _, w := range window {
+		k := key(w.StoredIncident)
+		if k == "" {
+			continue
+		}
+		counts[k]++
+		if d, ok := w.clearance(); ok {
+			groups[k] = append(groups[k], d)
+		}
+	}
+	var stats []statGroup
+	for name, count := range counts {
+		stats = append(stats, statGroup{Name: name, Count: count, AvgClearanceSeconds: int(avgDuration(groups[name]).Seconds())})
+	}
+	slices.SortFunc(stats, func(a, b statGroup) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Name, b.Name))
+	})
+	return stats[:min(len(stats), limit)]
+}
+
+// avgDuration returns the mean of durations, or 0 for none.
+func avgDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+	return sum / time.Duration(len(durations))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testClock is a memStore clock that tests move along.
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+// newMemStoreAt returns an empty memStore whose clock reads start until moved.
+func newMemStoreAt(start time.Time) (*memStore, *testClock) {
+	clock := &testClock{now: start}
+	m := newMemStore()
+	m.now = clock.Now
+	return m, clock
+}
+
+// storedIncidentByID returns a copy of the stored incident.
+func storedIncidentByID(t *testing.T, m *memStore, id int) StoredIncident {
+	t.Helper()
+	incidents, err := m.Incidents(context.Background(), "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(incidents, func(si StoredIncident) bool { return si.ID == id })
+	if i < 0 {
+		t.Fatalf("incident %d is not stored", id)
+	}
+	return incidents[i]
+}
+
+func TestMemStoreUpsert(t *testing.T) {
+	start := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	later := start.Add(30 * time.Minute)
+	cleared := start.Add(10 * time.Minute)
+	incident := Incident{ID: 1, IncidentType: "Vehicle Crash", Road: "I-40", Reason: "Crash", Condition: "Right lane closed",
+		Severity: 1, LanesClosed: 1, LanesTotal: 3, StartTime: FeedTime{start}, LastUpdate: FeedTime{start}}
+	with := func(change func(*Incident)) Incident {
+		i := incident
+		change(&i)
+		return i
+	}
+	previous := storedIncident{Exists: true, Severity: 1, LanesClosed: 1, LanesTotal: 3, LastUpdate: FeedTime{start},
+		Condition: "Right lane closed", Reason: "Crash"}
+
+	tests := []struct {
+		name string
+		// history is upserted in turn before incident, and the incident
+		// cleared after each step in clearAfter, at the time cleared.
+		history    []Incident
+		clearAfter []int
+		incident   Incident
+		// wantPrevious is what the upsert returns; want is the stored
+		// incident after it, and wantChanges the changes it records.
+		wantPrevious storedIncident
+		want         StoredIncident
+		wantChanges  []IncidentChange
+	}{{
+		name:     "new",
+		incident: incident,
+		want:     StoredIncident{Incident: incident, Status: "active"},
+	}, {
+		name:    "same lastUpdate",
+		history: []Incident{incident},
+		// The feed's lastUpdate says nothing changed, so what else differs
+		// isn't written.
+		incident:     with(func(i *Incident) { i.Severity = 3 }),
+		wantPrevious: previous,
+		want:         StoredIncident{Incident: incident, Status: "active"},
+	}, {
+		name:    "updated",
+		history: []Incident{incident},
+		incident: with(func(i *Incident) {
+			i.Severity, i.LanesClosed, i.Reason, i.LastUpdate = 3, 2, "Crash with injuries", FeedTime{later}
+			i.Road, i.CountyName = "I-440", "Wake"
+		}),
+		wantPrevious: previous,
+		// The feed's location and naming fields stay as first stored.
+		want: StoredIncident{Incident: with(func(i *Incident) {
+			i.Severity, i.LanesClosed, i.Reason, i.LastUpdate = 3, 2, "Crash with injuries", FeedTime{later}
+		}), Status: "active"},
+		wantChanges: []IncidentChange{
+			{IncidentID: 1, ObservedAt: later, Field: fieldSeverity, OldValue: "1", NewValue: "3", LastUpdate: FeedTime{later}},
+			{IncidentID: 1, ObservedAt: later, Field: fieldLanesClosed, OldValue: "1", NewValue: "2", LastUpdate: FeedTime{later}},
+			{IncidentID: 1, ObservedAt: later, Field: fieldReason, OldValue: "Crash", NewValue: "Crash with injuries", LastUpdate: FeedTime{later}},
+		},
+	}, {
+		name:       "reopened",
+		history:    []Incident{incident},
+		clearAfter: []int{0},
+		// A cleared incident back in the feed reopens even though its
+		// lastUpdate hasn't moved.
+		incident: incident,
+		wantPrevious: func() storedIncident {
+			p := previous
+			p.Cleared, p.ReopenCount = true, 1
+			return p
+		}(),
+		want: StoredIncident{Incident: incident, Status: "active", ReopenCount: 1, ReopenedAt: &later},
+	}, {
+		name:       "reopened twice",
+		history:    []Incident{incident, incident},
+		clearAfter: []int{0, 1},
+		incident:   with(func(i *Incident) { i.LanesClosed, i.LastUpdate = 2, FeedTime{later} }),
+		wantPrevious: func() storedIncident {
+			p := previous
+			p.Cleared, p.ReopenCount = true, 2
+			return p
+		}(),
+		want: StoredIncident{Incident: with(func(i *Incident) { i.LanesClosed, i.LastUpdate = 2, FeedTime{later} }),
+			Status: "active", ReopenCount: 2, ReopenedAt: &later},
+		wantChanges: []IncidentChange{
+			{IncidentID: 1, ObservedAt: later, Field: fieldLanesClosed, OldValue: "1", NewValue: "2", LastUpdate: FeedTime{later}},
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m, clock := newMemStoreAt(start)
+			for i, incident := range tt.history {
+				clock.now = start
+				if _, err := m.UpsertIncidents(ctx, []Incident{incident}); err != nil {
+					t.Fatal(err)
+				}
+				if slices.Contains(tt.clearAfter, i) {
+					clock.now = cleared
+					if _, err := m.MarkCleared(ctx, incident.ID); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			clock.now = later
+			got, err := m.UpsertIncidents(ctx, []Incident{tt.incident})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != tt.wantPrevious {
+				t.Errorf("UpsertIncidents() = %+v, want [%+v]", got, tt.wantPrevious)
+			}
+			if stored := storedIncidentByID(t, m, tt.incident.ID); !reflect.DeepEqual(stored, tt.want) {
+				t.Errorf("stored incident =\n%+v\nwant\n%+v", stored, tt.want)
+			}
+			changes, err := m.IncidentChanges(ctx, tt.incident.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != len(tt.wantChanges) || (len(changes) > 0 && !reflect.DeepEqual(changes, tt.wantChanges)) {
+				t.Errorf("changes =\n%+v\nwant\n%+v", changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func TestMemStoreMarkCleared(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	seconds := func(n int) *int { return &n }
+	tests := []struct {
+		name      string
+		startTime time.Time
+		// id is the incident cleared, which is stored unless it's 7.
+		id           int
+		wantSeconds  int
+		wantDuration *int
+		wantErr      bool
+	}{
+		{"started", now.Add(-90 * time.Minute), 1, 5400, seconds(5400), false},
+		{"no start time", time.Time{}, 1, 0, nil, false},
+		// A start time after the clearance counts as no time at all.
+		{"started later", now.Add(time.Minute), 1, 0, seconds(0), false},
+		{"not stored", now, 7, 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m, _ := newMemStoreAt(now)
+			if _, err := m.UpsertIncidents(ctx, []Incident{{ID: 1, StartTime: FeedTime{tt.startTime}}}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := m.MarkCleared(ctx, tt.id)
+			if (err != nil) != tt.wantErr || got != tt.wantSeconds {
+				t.Fatalf("MarkCleared(%d) = %d, %v; want %d, error %v", tt.id, got, err, tt.wantSeconds, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			stored := storedIncidentByID(t, m, tt.id)
+			if stored.Status != "cleared" || stored.ClearedTime == nil || !stored.ClearedTime.Equal(now) ||
+				!reflect.DeepEqual(stored.DurationSeconds, tt.wantDuration) {
+				t.Errorf("stored incident = %s cleared %v after %v seconds, want cleared %v after %v",
+					stored.Status, stored.ClearedTime, stored.DurationSeconds, now, tt.wantDuration)
+			}
+		})
+	}
+}
+
+func TestMemStorePurgeCleared(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		cutoff  time.Time
+		want    int64
+		wantIDs []int
+	}{
+		{"none cleared before", start, 0, []int{1, 2, 3, 4}},
+		// The cutoff itself is kept.
+		{"cleared before", start.Add(time.Hour), 1, []int{1, 3, 4}},
+		{"all cleared", start.Add(3 * time.Hour), 3, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			// Incident 1 stays active; 2, 3 and 4 clear an hour apart.
+			m, clock := newMemStoreAt(start)
+			if _, err := m.UpsertIncidents(ctx, []Incident{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}); err != nil {
+				t.Fatal(err)
+			}
+			for i, id := range []int{2, 3, 4} {
+				clock.now = start.Add(time.Duration(i) * time.Hour)
+				if _, err := m.MarkCleared(ctx, id); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n, err := m.PurgeCleared(ctx, tt.cutoff)
+			if err != nil || n != tt.want {
+				t.Fatalf("PurgeCleared() = %d, %v; want %d", n, err, tt.want)
+			}
+			incidents, err := m.Incidents(ctx, "all")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, si := range incidents {
+				ids = append(ids, si.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("incidents left = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
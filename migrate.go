@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles holds each backend's schema as numbered SQL files, e.g.
+// migrations/postgres/0002_add_duration.sql. A migration never changes once
+// released; schema changes add a new file for every backend.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationsTable records the applied migrations.
+const migrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+
+// migration is one numbered schema change.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads dir's migrations in version order.
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || name == e.Name() {
+			return nil, fmt.Errorf("migration %s is not named like 0001_name.sql", e.Name())
+		}
+		data, err := migrationFiles.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(data)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// migrate applies the backend's migrations that haven't run yet, each in a
+// transaction with its schema_migrations row. The first migrations only create
+// what is missing, so databases set up by hand are adopted as they are.
+func migrate(db *DB) error {
+	migrations, err := loadMigrations(path.Join("migrations", db.dialect.name))
+	if err != nil {
+		return fmt.Errorf("could not load migrations: %w", err)
+	}
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, migrationsTable); err != nil {
+		return fmt.Errorf("could not create schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("could not read schema_migrations: %w", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return fmt.Errorf("could not read schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("could not apply migration %s: %w", m.name, err)
+		}
+		slog.Info("Applied database migration", "migration", m.name)
+	}
+	return nil
+}
+
+// applyMigration runs m's statements and records it. MySQL commits schema
+// changes as they run, so a failed migration there may be partly applied.
+func applyMigration(ctx context.Context, db *DB, m migration) error {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	// Statements are split on semicolons, which the migrations only use to end
+	// them, because not every driver runs several in one Exec.
+	for _, stmt := range strings.Split(m.sql, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,41 @@
+-- Cleared incidents moved out of ncdot_incidents by the retention policy. An
+-- incident that reopens after being archived can be archived again, so IDs
+-- repeat.
+CREATE TABLE IF NOT EXISTS ncdot_incidents_archive (
+    id BIGINT NOT NULL,
+    latitude DOUBLE,
+    longitude DOUBLE,
+    common_name TEXT,
+    reason TEXT,
+    "condition" TEXT,
+    incident_type VARCHAR(100),
+    severity INT,
+    direction VARCHAR(50),
+    location TEXT,
+    county_id INT,
+    county_name VARCHAR(100),
+    city VARCHAR(100),
+    start_time VARCHAR(40),
+    end_time VARCHAR(40),
+    last_update VARCHAR(40),
+    road VARCHAR(255),
+    route_id INT,
+    lanes_closed INT,
+    lanes_total INT,
+    detour TEXT,
+    cross_street_prefix VARCHAR(50),
+    cross_street_number INT,
+    cross_street_suffix VARCHAR(50),
+    cross_street_common_name TEXT,
+    event TEXT,
+    created_from_concurrent BOOLEAN,
+    movable_construction TEXT,
+    work_zone_speed_limit INT,
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    cleared_time DATETIME,
+    duration_seconds INT,
+    reopen_count INT NOT NULL DEFAULT 0,
+    reopened_at DATETIME,
+    archived_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX ncdot_incidents_archive_id ON ncdot_incidents_archive (id);
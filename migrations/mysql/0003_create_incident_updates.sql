@@ -0,0 +1,13 @@
+-- Every change to an incident's severity, lanes, condition or reason seen in
+-- the feed, one row per field, so its history can be rebuilt. Rows are kept
+-- when the incident itself is purged.
+CREATE TABLE IF NOT EXISTS incident_updates (
+    update_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    incident_id INTEGER NOT NULL,
+    observed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    field VARCHAR(32) NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    last_update VARCHAR(40)
+);
+CREATE INDEX incident_updates_incident ON incident_updates (incident_id, update_id);
@@ -0,0 +1,13 @@
+-- Notifications a notifier failed to deliver, retried on later cycles with
+-- backoff until they go through or run out of attempts. The notification is
+-- kept as JSON.
+CREATE TABLE IF NOT EXISTS notification_queue (
+    queue_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    notifier VARCHAR(64) NOT NULL,
+    notification JSON NOT NULL,
+    attempts INTEGER NOT NULL,
+    next_attempt DATETIME NOT NULL,
+    last_error TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX notification_queue_next_attempt ON notification_queue (next_attempt);
@@ -0,0 +1,43 @@
+-- The feed's timestamps were kept as its text. They are rewritten as UTC, with
+-- text that doesn't look like one becoming NULL, and the columns become
+-- DATETIME like the other timestamps.
+UPDATE ncdot_incidents SET
+    start_time = CASE WHEN start_time REGEXP '^[0-9]{4}-[0-9]{2}-[0-9]{2}T' THEN CONVERT_TZ(
+        STR_TO_DATE(LEFT(start_time, 19), '%Y-%m-%dT%H:%i:%s'),
+        CASE WHEN start_time REGEXP '[+-][0-9]{2}:[0-9]{2}$' THEN RIGHT(start_time, 6) ELSE '+00:00' END,
+        '+00:00') END,
+    end_time = CASE WHEN end_time REGEXP '^[0-9]{4}-[0-9]{2}-[0-9]{2}T' THEN CONVERT_TZ(
+        STR_TO_DATE(LEFT(end_time, 19), '%Y-%m-%dT%H:%i:%s'),
+        CASE WHEN end_time REGEXP '[+-][0-9]{2}:[0-9]{2}$' THEN RIGHT(end_time, 6) ELSE '+00:00' END,
+        '+00:00') END,
+    last_update = CASE WHEN last_update REGEXP '^[0-9]{4}-[0-9]{2}-[0-9]{2}T' THEN CONVERT_TZ(
+        STR_TO_DATE(LEFT(last_update, 19), '%Y-%m-%dT%H:%i:%s'),
+        CASE WHEN last_update REGEXP '[+-][0-9]{2}:[0-9]{2}$' THEN RIGHT(last_update, 6) ELSE '+00:00' END,
+        '+00:00') END;
+ALTER TABLE ncdot_incidents
+    MODIFY start_time DATETIME,
+    MODIFY end_time DATETIME,
+    MODIFY last_update DATETIME;
+UPDATE ncdot_incidents_archive SET
+    start_time = CASE WHEN start_time REGEXP '^[0-9]{4}-[0-9]{2}-[0-9]{2}T' THEN CONVERT_TZ(
+        STR_TO_DATE(LEFT(start_time, 19), '%Y-%m-%dT%H:%i:%s'),
+        CASE WHEN start_time REGEXP '[+-][0-9]{2}:[0-9]{2}$' THEN RIGHT(start_time, 6) ELSE '+00:00' END,
+        '+00:00') END,
+    end_time = CASE WHEN end_time REGEXP '^[0-9]{4}-[0-9]{2}-[0-9]{2}T' THEN CONVERT_TZ(
+        STR_TO_DATE(LEFT(end_time, 19), '%Y-%m-%dT%H:%i:%s'),
+        CASE WHEN end_time REGEXP '[+-][0-9]{2}:[0-9]{2}$' THEN RIGHT(end_time, 6) ELSE '+00:00' END,
+        '+00:00') END,
+    last_update = CASE WHEN last_update REGEXP '^[0-9]{4}-[0-9]{2}-[0-9]{2}T' THEN CONVERT_TZ(
+        STR_TO_DATE(LEFT(last_update, 19), '%Y-%m-%dT%H:%i:%s'),
+        CASE WHEN last_update REGEXP '[+-][0-9]{2}:[0-9]{2}$' THEN RIGHT(last_update, 6) ELSE '+00:00' END,
+        '+00:00') END;
+ALTER TABLE ncdot_incidents_archive
+    MODIFY start_time DATETIME,
+    MODIFY end_time DATETIME,
+    MODIFY last_update DATETIME;
+UPDATE incident_updates SET
+    last_update = CASE WHEN last_update REGEXP '^[0-9]{4}-[0-9]{2}-[0-9]{2}T' THEN CONVERT_TZ(
+        STR_TO_DATE(LEFT(last_update, 19), '%Y-%m-%dT%H:%i:%s'),
+        CASE WHEN last_update REGEXP '[+-][0-9]{2}:[0-9]{2}$' THEN RIGHT(last_update, 6) ELSE '+00:00' END,
+        '+00:00') END;
+ALTER TABLE incident_updates MODIFY last_update DATETIME;
@@ -0,0 +1,35 @@
+-- The incidents table as it was first created by hand. Existing databases
+-- already have it, so this only creates it for new ones.
+CREATE TABLE IF NOT EXISTS ncdot_incidents (
+    id INTEGER PRIMARY KEY,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    common_name TEXT,
+    reason TEXT,
+    "condition" TEXT,
+    incident_type TEXT,
+    severity INTEGER,
+    direction TEXT,
+    location TEXT,
+    county_id INTEGER,
+    county_name TEXT,
+    city TEXT,
+    start_time TEXT,
+    end_time TEXT,
+    last_update TEXT,
+    road TEXT,
+    route_id INTEGER,
+    lanes_closed INTEGER,
+    lanes_total INTEGER,
+    detour TEXT,
+    cross_street_prefix TEXT,
+    cross_street_number INTEGER,
+    cross_street_suffix TEXT,
+    cross_street_common_name TEXT,
+    event TEXT,
+    created_from_concurrent BOOLEAN,
+    movable_construction TEXT,
+    work_zone_speed_limit INTEGER,
+    status TEXT NOT NULL DEFAULT 'active',
+    cleared_time TIMESTAMPTZ
+);
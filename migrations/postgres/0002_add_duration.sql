@@ -0,0 +1 @@
+ALTER TABLE ncdot_incidents ADD COLUMN IF NOT EXISTS duration_seconds INTEGER;
@@ -0,0 +1,2 @@
+ALTER TABLE ncdot_incidents ADD COLUMN IF NOT EXISTS reopen_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE ncdot_incidents ADD COLUMN IF NOT EXISTS reopened_at TIMESTAMPTZ;
@@ -0,0 +1,41 @@
+-- Cleared incidents moved out of ncdot_incidents by the retention policy. An
+-- incident that reopens after being archived can be archived again, so IDs
+-- repeat.
+CREATE TABLE IF NOT EXISTS ncdot_incidents_archive (
+    id INTEGER NOT NULL,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    common_name TEXT,
+    reason TEXT,
+    "condition" TEXT,
+    incident_type TEXT,
+    severity INTEGER,
+    direction TEXT,
+    location TEXT,
+    county_id INTEGER,
+    county_name TEXT,
+    city TEXT,
+    start_time TEXT,
+    end_time TEXT,
+    last_update TEXT,
+    road TEXT,
+    route_id INTEGER,
+    lanes_closed INTEGER,
+    lanes_total INTEGER,
+    detour TEXT,
+    cross_street_prefix TEXT,
+    cross_street_number INTEGER,
+    cross_street_suffix TEXT,
+    cross_street_common_name TEXT,
+    event TEXT,
+    created_from_concurrent BOOLEAN,
+    movable_construction TEXT,
+    work_zone_speed_limit INTEGER,
+    status TEXT NOT NULL DEFAULT 'active',
+    cleared_time TIMESTAMPTZ,
+    duration_seconds INTEGER,
+    reopen_count INTEGER NOT NULL DEFAULT 0,
+    reopened_at TIMESTAMPTZ,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX ncdot_incidents_archive_id ON ncdot_incidents_archive (id);
@@ -0,0 +1,18 @@
+-- Feed payloads as fetched, when feed.snapshots is on, for replaying them with
+-- "backfill --snapshots" and for debugging. A payload identical to the URL's
+-- previous one isn't stored again. Bodies that aren't JSON are kept as a JSON
+-- string.
+CREATE TABLE IF NOT EXISTS feed_snapshots (
+    snapshot_id BIGSERIAL PRIMARY KEY,
+    fetched_at TIMESTAMPTZ NOT NULL,
+    url TEXT NOT NULL,
+    county_id INTEGER,
+    status_code INTEGER,
+    headers JSONB,
+    payload JSONB NOT NULL,
+    payload_sha256 TEXT NOT NULL,
+    duration_ms INTEGER,
+    error TEXT
+);
+CREATE INDEX feed_snapshots_url ON feed_snapshots (url, snapshot_id);
+CREATE INDEX feed_snapshots_fetched_at ON feed_snapshots (fetched_at);
@@ -0,0 +1,12 @@
+-- The feed's timestamps were kept as its text. They become timestamps, with
+-- text that doesn't look like one becoming NULL rather than failing the cast.
+ALTER TABLE ncdot_incidents
+    ALTER COLUMN start_time TYPE TIMESTAMPTZ USING CASE WHEN start_time ~ '^\d{4}-\d{2}-\d{2}T' THEN start_time::timestamptz END,
+    ALTER COLUMN end_time TYPE TIMESTAMPTZ USING CASE WHEN end_time ~ '^\d{4}-\d{2}-\d{2}T' THEN end_time::timestamptz END,
+    ALTER COLUMN last_update TYPE TIMESTAMPTZ USING CASE WHEN last_update ~ '^\d{4}-\d{2}-\d{2}T' THEN last_update::timestamptz END;
+ALTER TABLE ncdot_incidents_archive
+    ALTER COLUMN start_time TYPE TIMESTAMPTZ USING CASE WHEN start_time ~ '^\d{4}-\d{2}-\d{2}T' THEN start_time::timestamptz END,
+    ALTER COLUMN end_time TYPE TIMESTAMPTZ USING CASE WHEN end_time ~ '^\d{4}-\d{2}-\d{2}T' THEN end_time::timestamptz END,
+    ALTER COLUMN last_update TYPE TIMESTAMPTZ USING CASE WHEN last_update ~ '^\d{4}-\d{2}-\d{2}T' THEN last_update::timestamptz END;
+ALTER TABLE incident_updates
+    ALTER COLUMN last_update TYPE TIMESTAMPTZ USING CASE WHEN last_update ~ '^\d{4}-\d{2}-\d{2}T' THEN last_update::timestamptz END;
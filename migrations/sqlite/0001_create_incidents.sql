@@ -0,0 +1,38 @@
+-- Timestamps are UTC text, which the driver reads back as time.Time for
+-- TIMESTAMP columns.
+CREATE TABLE IF NOT EXISTS ncdot_incidents (
+    id INTEGER PRIMARY KEY,
+    latitude REAL,
+    longitude REAL,
+    common_name TEXT,
+    reason TEXT,
+    "condition" TEXT,
+    incident_type TEXT,
+    severity INTEGER,
+    direction TEXT,
+    location TEXT,
+    county_id INTEGER,
+    county_name TEXT,
+    city TEXT,
+    start_time TEXT,
+    end_time TEXT,
+    last_update TEXT,
+    road TEXT,
+    route_id INTEGER,
+    lanes_closed INTEGER,
+    lanes_total INTEGER,
+    detour TEXT,
+    cross_street_prefix TEXT,
+    cross_street_number INTEGER,
+    cross_street_suffix TEXT,
+    cross_street_common_name TEXT,
+    event TEXT,
+    created_from_concurrent BOOLEAN,
+    movable_construction TEXT,
+    work_zone_speed_limit INTEGER,
+    status TEXT NOT NULL DEFAULT 'active',
+    cleared_time TIMESTAMP,
+    duration_seconds INTEGER,
+    reopen_count INTEGER NOT NULL DEFAULT 0,
+    reopened_at TIMESTAMP
+);
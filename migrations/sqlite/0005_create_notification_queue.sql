@@ -0,0 +1,13 @@
+-- Notifications a notifier failed to deliver, retried on later cycles with
+-- backoff until they go through or run out of attempts. The notification is
+-- kept as JSON.
+CREATE TABLE IF NOT EXISTS notification_queue (
+    queue_id INTEGER PRIMARY KEY,
+    notifier TEXT NOT NULL,
+    notification TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    next_attempt TIMESTAMP NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX notification_queue_next_attempt ON notification_queue (next_attempt);
@@ -0,0 +1,13 @@
+-- The feed's timestamps were kept as its text. They are rewritten as UTC text
+-- like the other timestamps, with text that doesn't look like one becoming
+-- NULL. SQLite can't change a column's type, so the columns stay TEXT.
+UPDATE ncdot_incidents SET
+    start_time = CASE WHEN start_time GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T*' THEN datetime(start_time) END,
+    end_time = CASE WHEN end_time GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T*' THEN datetime(end_time) END,
+    last_update = CASE WHEN last_update GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T*' THEN datetime(last_update) END;
+UPDATE ncdot_incidents_archive SET
+    start_time = CASE WHEN start_time GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T*' THEN datetime(start_time) END,
+    end_time = CASE WHEN end_time GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T*' THEN datetime(end_time) END,
+    last_update = CASE WHEN last_update GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T*' THEN datetime(last_update) END;
+UPDATE incident_updates SET
+    last_update = CASE WHEN last_update GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T*' THEN datetime(last_update) END;
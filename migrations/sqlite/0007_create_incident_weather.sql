@@ -0,0 +1,13 @@
+-- The weather at each alerted incident in the hour it started, when
+-- notifications.weather is on, for comparing crashes with the weather. Rows
+-- are kept when the incident is purged or archived.
+CREATE TABLE IF NOT EXISTS incident_weather (
+    incident_id INTEGER PRIMARY KEY,
+    observed_at TIMESTAMP NOT NULL,
+    temperature_f REAL NOT NULL,
+    precipitation_in REAL NOT NULL,
+    visibility_mi REAL NOT NULL,
+    weather_code INTEGER NOT NULL,
+    conditions TEXT NOT NULL,
+    fetched_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// mqttTimeout bounds connecting and each broker round trip.
+const mqttTimeout = 15 * time.Second
+
+// MQTT control packet types (MQTT 3.1.1).
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttDisconnect = 14
+)
+
+// MQTTConfig publishes events to an MQTT broker. Topic holds the retained state
+// of each active incident (its latest JSON; cleared incidents have the retained
+// message removed, as is the one under its old topic when an update moves it to
+// another), so home automation can read the current picture on connect.
+// EventTopic, if set, additionally receives every event unretained. Both accept
+// the {event}, {id}, {county}, {county_id}, {type} and {severity} placeholders.
+// BrokerURL is tcp://host:1883, or ssl:// / mqtts:// for TLS.
+type MQTTConfig struct {
+	BrokerURL  string `yaml:"broker_url"`
+	ClientID   string `yaml:"client_id"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	Topic      string `yaml:"topic"`
+	EventTopic string `yaml:"event_topic"`
+	QoS        int    `yaml:"qos"`
+	Retain     bool   `yaml:"retain"`
+}
+
+// enabled reports whether MQTT publishing is configured.
+func (m MQTTConfig) enabled() bool {
+	return m.BrokerURL != ""
+}
+
+// mqttClient is a minimal MQTT 3.1.1 publisher: connect, publish at QoS 0 or 1, disconnect.
+type mqttClient struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	packetID uint16
+}
+
+// mqttString encodes a length-prefixed UTF-8 string.
+func mqttString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttPacket frames a packet with its fixed header and variable-length remaining length.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// dialMQTT connects and completes the CONNECT/CONNACK handshake. The connection
+// is closed once ctx is done.
+func dialMQTT(ctx context.Context, cfg MQTTConfig) (*mqttClient, error) {
+	u, err := url.Parse(cfg.BrokerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %w", err)
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "1883")
+		}
+		conn, err = dialContext(ctx, host, nil, mqttTimeout)
+	case "ssl", "tls", "mqtts":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "8883")
+		}
+		conn, err = dialContext(ctx, host, &tls.Config{ServerName: u.Hostname()}, mqttTimeout)
+	default:
+		return nil, fmt.Errorf("unsupported broker URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &mqttClient{conn: conn, r: bufio.NewReader(conn)}
+	if err := c.connect(cfg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect sends CONNECT and waits for the broker to accept it.
+func (c *mqttClient) connect(cfg MQTTConfig) error {
+	var flags byte = 0x02                 // clean session
+	body := append(mqttString("MQTT"), 4) // protocol level 3.1.1
+	if cfg.Username != "" {
+		flags |= 0x80
+		if cfg.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, flags, 0, 60) // 60s keep alive
+	body = append(body, mqttString(cfg.ClientID)...)
+	if cfg.Username != "" {
+		body = append(body, mqttString(cfg.Username)...)
+		if cfg.Password != "" {
+			body = append(body, mqttString(cfg.Password)...)
+		}
+	}
+	if err := c.write(mqttPacket(mqttConnect<<4, body)); err != nil {
+		return err
+	}
+	packetType, payload, err := c.read()
+	if err != nil {
+		return err
+	}
+	if packetType != mqttConnack || len(payload) < 2 {
+		return errors.New("broker did not acknowledge the connection")
+	}
+	if payload[1] != 0 {
+		return fmt.Errorf("broker refused the connection (code %d)", payload[1])
+	}
+	return nil
+}
+
+// write sends a packet within the timeout.
+func (c *mqttClient) write(packet []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// read receives one packet within the timeout and returns its type and body.
+func (c *mqttClient) read() (byte, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(mqttTimeout))
+	header, payload, err := readMQTTPacket(c.r)
+	return header >> 4, payload, err
+}
+
+// readMQTTPacket reads one packet and returns its fixed header byte and body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header, payload, nil
+}
+
+// publish sends a message, waiting for the broker's PUBACK at QoS 1.
+func (c *mqttClient) publish(topic string, payload []byte, qos int, retain bool) error {
+	header := byte(mqttPublish<<4) | byte(qos)<<1
+	if retain {
+		header |= 0x01
+	}
+	body := mqttString(topic)
+	if qos > 0 {
+		c.packetID++
+		if c.packetID == 0 {
+			c.packetID = 1
+		}
+		body = binary.BigEndian.AppendUint16(body, c.packetID)
+	}
+	body = append(body, payload...)
+	if err := c.write(mqttPacket(header, body)); err != nil {
+		return err
+	}
+	if qos == 0 {
+		return nil
+	}
+	packetType, ack, err := c.read()
+	if err != nil {
+		return err
+	}
+	if packetType != mqttPuback || len(ack) < 2 || binary.BigEndian.Uint16(ack) != c.packetID {
+		return fmt.Errorf("unexpected reply to publish on %s", topic)
+	}
+	return nil
+}
+
+// close disconnects cleanly.
+func (c *mqttClient) close() {
+	c.write(mqttPacket(mqttDisconnect<<4, nil))
+	c.conn.Close()
+}
+
+// publishMQTTState publishes an incident's state to Topic. An empty retained
+// message deletes the retained state of a cleared incident, and of an updated
+// one whose topic changed, e.g. when the feed reclassifies it. Without Retain,
+// there is no state to delete.
+func publishMQTTState(c *mqttClient, cfg MQTTConfig, event IncidentEvent) error {
+	topic := expandTopic(cfg.Topic, event)
+	if event.Event == eventCleared {
+		if !cfg.Retain {
+			return nil
+		}
+		return c.publish(topic, nil, cfg.QoS, true)
+	}
+	if cfg.Retain && event.Previous != nil {
+		old := event
+		old.Incident = *event.Previous
+		if oldTopic := expandTopic(cfg.Topic, old); oldTopic != topic {
+			if err := c.publish(oldTopic, nil, cfg.QoS, true); err != nil {
+				return err
+			}
+		}
+	}
+	state, err := json.Marshal(event.Incident)
+	if err != nil {
+		return err
+	}
+	return c.publish(topic, state, cfg.QoS, cfg.Retain)
+}
+
+// publishToMQTT publishes a cycle's events over one connection.
+func publishToMQTT(ctx context.Context, cfg MQTTConfig, events []IncidentEvent) error {
+	c, err := dialMQTT(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", cfg.BrokerURL, err)
+	}
+	defer c.close()
+
+	var errs []error
+	for _, event := range events {
+		if cfg.Topic != "" {
+			if err := publishMQTTState(c, cfg, event); err != nil {
+				errs = append(errs, fmt.Errorf("incident %d: %w", event.Incident.ID, err))
+				continue
+			}
+		}
+		if cfg.EventTopic != "" {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if err := c.publish(expandTopic(cfg.EventTopic, event), data, cfg.QoS, false); err != nil {
+				errs = append(errs, fmt.Errorf("incident %d event: %w", event.Incident.ID, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// mqttMessage is a PUBLISH as the broker received it.
+type mqttMessage struct {
+	Topic   string
+	Payload string
+	Retain  bool
+}
+
+// mqttPipe connects a client to a scripted broker over net.Pipe.
+func mqttPipe(t *testing.T) (client, broker *mqttClient) {
+	t.Helper()
+	c, b := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		b.Close()
+	})
+	return &mqttClient{conn: c, r: bufio.NewReader(c)}, &mqttClient{conn: b, r: bufio.NewReader(b)}
+}
+
+// readMQTTPublishes reads QoS 0 PUBLISH packets until the client disconnects.
+func readMQTTPublishes(broker *mqttClient) <-chan []mqttMessage {
+	done := make(chan []mqttMessage, 1)
+	go func() {
+		var got []mqttMessage
+		for {
+			header, body, err := readMQTTPacket(broker.r)
+			if err != nil || header>>4 != mqttPublish {
+				done <- got
+				return
+			}
+			n := binary.BigEndian.Uint16(body)
+			got = append(got, mqttMessage{Topic: string(body[2 : 2+n]), Payload: string(body[2+n:]), Retain: header&0x01 != 0})
+		}
+	}()
+	return done
+}
+
+// mqttState is an incident's retained state payload.
+func mqttState(t *testing.T, incident Incident) string {
+	t.Helper()
+	data, err := json.Marshal(incident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPublishMQTTState(t *testing.T) {
+	wake := Incident{ID: 7, CountyName: "Wake", IncidentType: "Vehicle Crash"}
+	durham := wake
+	durham.CountyName = "Durham"
+	severe := wake
+	severe.Severity = 3
+	tests := []struct {
+		name   string
+		retain bool
+		event  IncidentEvent
+		want   []mqttMessage
+	}{
+		{"created", true, IncidentEvent{Event: eventCreated, Incident: wake}, []mqttMessage{
+			{"ncdot/wake/vehicle-crash/7", mqttState(t, wake), true},
+		}},
+		{"updated in place", true, IncidentEvent{Event: eventUpdated, Incident: severe, Previous: &wake}, []mqttMessage{
+			{"ncdot/wake/vehicle-crash/7", mqttState(t, severe), true},
+		}},
+		{"moved to another county", true, IncidentEvent{Event: eventUpdated, Incident: durham, Previous: &wake}, []mqttMessage{
+			{"ncdot/wake/vehicle-crash/7", "", true},
+			{"ncdot/durham/vehicle-crash/7", mqttState(t, durham), true},
+		}},
+		{"moved, not retained", false, IncidentEvent{Event: eventUpdated, Incident: durham, Previous: &wake}, []mqttMessage{
+			{"ncdot/durham/vehicle-crash/7", mqttState(t, durham), false},
+		}},
+		{"cleared", true, IncidentEvent{Event: eventCleared, Incident: wake}, []mqttMessage{
+			{"ncdot/wake/vehicle-crash/7", "", true},
+		}},
+		{"cleared, not retained", false, IncidentEvent{Event: eventCleared, Incident: wake}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, broker := mqttPipe(t)
+			received := readMQTTPublishes(broker)
+			cfg := MQTTConfig{Topic: "ncdot/{county}/{type}/{id}", Retain: tt.retain}
+			if err := publishMQTTState(client, cfg, tt.event); err != nil {
+				t.Fatalf("publishMQTTState() error = %v", err)
+			}
+			client.conn.Close()
+			if got := <-received; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("published %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMQTTPacket(t *testing.T) {
+	tests := []struct {
+		size int
+		// wantLength is the encoded remaining length.
+		wantLength []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		body := bytes.Repeat([]byte{'x'}, tt.size)
+		packet := mqttPacket(mqttPublish<<4, body)
+		if got := packet[1 : 1+len(tt.wantLength)]; !bytes.Equal(got, tt.wantLength) {
+			t.Errorf("mqttPacket() with a %d-byte body has remaining length %x, want %x", tt.size, got, tt.wantLength)
+		}
+		header, got, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)))
+		if err != nil || header != mqttPublish<<4 || !bytes.Equal(got, body) {
+			t.Errorf("readMQTTPacket() of a %d-byte body = %#x, %d bytes, %v", tt.size, header, len(got), err)
+		}
+	}
+}
+
+func TestMQTTConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     MQTTConfig
+		connack []byte
+		// wantConnect is the CONNECT body after the protocol name and level.
+		wantConnect string
+		wantErr     string
+	}{
+		{"anonymous", MQTTConfig{ClientID: "ncdot"}, []byte{0, 0},
+			"\x02\x00\x3c\x00\x05ncdot", ""},
+		{"credentials", MQTTConfig{ClientID: "ncdot", Username: "poller", Password: "s3cret"}, []byte{0, 0},
+			"\xc2\x00\x3c\x00\x05ncdot\x00\x06poller\x00\x06s3cret", ""},
+		{"refused", MQTTConfig{ClientID: "ncdot", Username: "poller"}, []byte{0, 5},
+			"\x82\x00\x3c\x00\x05ncdot\x00\x06poller", "broker refused the connection (code 5)"},
+		{"not acknowledged", MQTTConfig{ClientID: "ncdot"}, []byte{0},
+			"\x02\x00\x3c\x00\x05ncdot", "broker did not acknowledge the connection"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, broker := mqttPipe(t)
+			connect := make(chan []byte, 1)
+			go func() {
+				header, body, err := readMQTTPacket(broker.r)
+				if err != nil || header != mqttConnect<<4 {
+					close(connect)
+					return
+				}
+				connect <- body
+				broker.conn.Write(mqttPacket(mqttConnack<<4, tt.connack))
+			}()
+			err := client.connect(tt.cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("connect() error = %v", err)
+			} else if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("connect() error = %v, want %q", err, tt.wantErr)
+			}
+			if got, want := string(<-connect), "\x00\x04MQTT\x04"+tt.wantConnect; got != want {
+				t.Errorf("CONNECT body %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMQTTPublishQoS1(t *testing.T) {
+	tests := []struct {
+		name    string
+		ackID   uint16
+		wantErr bool
+	}{
+		{"acknowledged", 1, false},
+		{"wrong packet ID", 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, broker := mqttPipe(t)
+			publish := make(chan []byte, 1)
+			go func() {
+				header, body, err := readMQTTPacket(broker.r)
+				if err != nil {
+					close(publish)
+					return
+				}
+				publish <- append([]byte{header}, body...)
+				broker.conn.Write(mqttPacket(mqttPuback<<4, binary.BigEndian.AppendUint16(nil, tt.ackID)))
+			}()
+			err := client.publish("ncdot/7", []byte("{}"), 1, true)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("publish() error = %v, want error %v", err, tt.wantErr)
+			}
+			// QoS 1 and retained, then the topic, packet ID 1 and payload.
+			if got, want := string(<-publish), "\x33\x00\x07ncdot/7\x00\x01{}"; got != want {
+				t.Errorf("PUBLISH %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build mysql
+
+package main
+
+// The MySQL driver is only linked into builds that ask for it, like SQLite's:
+// go build -tags mysql.
+import _ "github.com/go-sql-driver/mysql"
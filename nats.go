@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// natsTimeout bounds connecting and each server round trip.
+const natsTimeout = 15 * time.Second
+
+// NATSConfig publishes events to NATS subjects. Subject accepts the same
+// placeholders as the MQTT topic. With JetStream set, each publish waits for the
+// stream's acknowledgement so events are persisted, and carries a Nats-Msg-Id
+// so a retried publish isn't stored twice. Stream, if set, is created on first
+// use to capture every subject the pattern can produce.
+type NATSConfig struct {
+	URL       string `yaml:"url"`
+	Subject   string `yaml:"subject"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	Token     string `yaml:"token"`
+	JetStream bool   `yaml:"jetstream"`
+	Stream    string `yaml:"stream"`
+}
+
+// enabled reports whether NATS publishing is configured.
+func (n NATSConfig) enabled() bool {
+	return n.URL != ""
+}
+
+// natsPlaceholder matches the {placeholders} in a subject pattern.
+var natsPlaceholder = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// natsConn is a minimal client for the NATS text protocol.
+type natsConn struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	inbox string
+}
+
+// dialNATS connects, authenticates and confirms the server is responding. The
+// connection is closed once ctx is done.
+func dialNATS(ctx context.Context, cfg NATSConfig) (*natsConn, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %w", err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "nats":
+		conn, err = dialContext(ctx, host, nil, natsTimeout)
+	case "tls":
+		conn, err = dialContext(ctx, host, &tls.Config{ServerName: u.Hostname()}, natsTimeout)
+	default:
+		return nil, fmt.Errorf("unsupported NATS URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &natsConn{conn: conn, r: bufio.NewReader(conn), inbox: fmt.Sprintf("_INBOX.ncdot.%d", time.Now().UnixNano())}
+	if err := c.handshake(cfg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake reads the server's INFO, sends CONNECT and waits for the PONG that
+// confirms it was accepted.
+func (c *natsConn) handshake(cfg NATSConfig) error {
+	// The server opens with INFO; nothing in it is needed here.
+	if line, err := c.readLine(); err != nil || !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected NATS greeting: %q %v", line, err)
+	}
+	opts := map[string]any{
+		"verbose": false, "pedantic": false, "headers": true,
+		"name": "ncdot-crash-reporting", "lang": "go", "version": "1",
+	}
+	if cfg.Username != "" {
+		opts["user"], opts["pass"] = cfg.Username, cfg.Password
+	}
+	if cfg.Token != "" {
+		opts["auth_token"] = cfg.Token
+	}
+	connect, _ := json.Marshal(opts)
+	if err := c.write("CONNECT " + string(connect) + "\r\nPING\r\n"); err != nil {
+		return err
+	}
+	return c.expectPong()
+}
+
+// write sends raw protocol text within the timeout.
+func (c *natsConn) write(s string) error {
+	c.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	_, err := io.WriteString(c.conn, s)
+	return err
+}
+
+// readLine reads one protocol line within the timeout, answering server PINGs.
+func (c *natsConn) readLine() (string, error) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(natsTimeout))
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "PING" {
+			if err := c.write("PONG\r\n"); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return "", errors.New(strings.Trim(strings.TrimPrefix(line, "-ERR "), "'"))
+		}
+		return line, nil
+	}
+}
+
+// expectPong waits for the reply to a PING.
+func (c *natsConn) expectPong() error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "PONG" {
+			return nil
+		}
+	}
+}
+
+// readMsg waits for the next MSG/HMSG and returns its payload.
+func (c *natsConn) readMsg() ([]byte, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (fields[0] != "MSG" && fields[0] != "HMSG") {
+			continue
+		}
+		size, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			return nil, fmt.Errorf("bad message header %q", line)
+		}
+		data := make([]byte, size+2) // payload plus trailing CRLF
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		data = data[:size]
+		if fields[0] == "HMSG" {
+			// Skip the header block; the reply body follows it.
+			headerSize, _ := strconv.Atoi(fields[len(fields)-2])
+			if headerSize <= len(data) {
+				data = data[headerSize:]
+			}
+		}
+		return data, nil
+	}
+}
+
+// request publishes with a reply subject and waits for the response.
+func (c *natsConn) request(subject string, headers map[string]string, payload []byte) ([]byte, error) {
+	reply := fmt.Sprintf("%s.%d", c.inbox, time.Now().UnixNano())
+	if err := c.publish(subject, reply, headers, payload); err != nil {
+		return nil, err
+	}
+	return c.readMsg()
+}
+
+// publish sends a message, using HPUB when there are headers.
+func (c *natsConn) publish(subject, reply string, headers map[string]string, payload []byte) error {
+	target := subject
+	if reply != "" {
+		target += " " + reply
+	}
+	if len(headers) == 0 {
+		return c.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", target, len(payload), payload))
+	}
+	var hdr strings.Builder
+	hdr.WriteString("NATS/1.0\r\n")
+	for k, v := range headers {
+		fmt.Fprintf(&hdr, "%s: %s\r\n", k, v)
+	}
+	hdr.WriteString("\r\n")
+	return c.write(fmt.Sprintf("HPUB %s %d %d\r\n%s%s\r\n", target, hdr.Len(), hdr.Len()+len(payload), hdr.String(), payload))
+}
+
+// jetStreamError extracts the error from a JetStream API reply, if any.
+func jetStreamError(reply []byte) error {
+	var resp struct {
+		Error *struct {
+			Code        int    `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		return fmt.Errorf("could not read JetStream reply: %w", err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("JetStream error %d: %s", resp.Error.Code, resp.Error.Description)
+	}
+	return nil
+}
+
+// ensureStream creates the stream if it doesn't exist yet.
+func (c *natsConn) ensureStream(cfg NATSConfig) error {
+	reply, err := c.request("$JS.API.STREAM.INFO."+cfg.Stream, nil, nil)
+	if err != nil {
+		return err
+	}
+	if jetStreamError(reply) == nil {
+		return nil
+	}
+	subjects := natsPlaceholder.ReplaceAllString(cfg.Subject, "*")
+	create, _ := json.Marshal(map[string]any{"name": cfg.Stream, "subjects": []string{subjects}, "storage": "file"})
+	if reply, err = c.request("$JS.API.STREAM.CREATE."+cfg.Stream, nil, create); err != nil {
+		return err
+	}
+	return jetStreamError(reply)
+}
+
+// publishToNATS publishes a cycle's events over one connection.
+func publishToNATS(ctx context.Context, cfg NATSConfig, events []IncidentEvent) error {
+	c, err := dialNATS(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", cfg.URL, err)
+	}
+	defer c.conn.Close()
+
+	if cfg.JetStream {
+		if err := c.write(fmt.Sprintf("SUB %s.* 1\r\n", c.inbox)); err != nil {
+			return err
+		}
+		if cfg.Stream != "" {
+			if err := c.ensureStream(cfg); err != nil {
+				return fmt.Errorf("could not set up stream %s: %w", cfg.Stream, err)
+			}
+		}
+	}
+
+	var errs []error
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		subject := expandTopic(cfg.Subject, event)
+		if !cfg.JetStream {
+			if err := c.publish(subject, "", nil, data); err != nil {
+				return err
+			}
+			continue
+		}
+		msgID := fmt.Sprintf("ncdot-%d-%s-%d", event.Incident.ID, event.Event, event.Time.UnixNano())
+		reply, err := c.request(subject, map[string]string{"Nats-Msg-Id": msgID}, data)
+		if err == nil {
+			err = jetStreamError(reply)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("incident %d: %w", event.Incident.ID, err))
+		}
+	}
+
+	// A final PING flushes plain publishes and surfaces any -ERR from the server.
+	if err := c.write("PING\r\n"); err != nil {
+		return err
+	}
+	if err := c.expectPong(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// natsServer scripts the server side of a NATS connection.
+type natsServer struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// natsPipe connects a client to a scripted server over net.Pipe and runs script
+// against it. The returned function closes the client side and returns the
+// script's result.
+func natsPipe(t *testing.T, script func(*natsServer) error) (*natsConn, func() error) {
+	t.Helper()
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	done := make(chan error, 1)
+	go func() { done <- script(&natsServer{conn: s, r: bufio.NewReader(s)}) }()
+	return &natsConn{conn: c, r: bufio.NewReader(c), inbox: "_INBOX.test"}, func() error {
+		c.Close()
+		return <-done
+	}
+}
+
+// readLine reads one protocol line from the client.
+func (s *natsServer) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	return strings.TrimSuffix(line, "\r\n"), err
+}
+
+// expect reads a line and fails unless it starts with prefix.
+func (s *natsServer) expect(prefix string) (string, error) {
+	line, err := s.readLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return "", fmt.Errorf("client sent %q, want %s", line, prefix)
+	}
+	return line, nil
+}
+
+// readPublish reads a PUB or HPUB and returns its fields, headers and payload.
+func (s *natsServer) readPublish() (fields []string, headers, payload string, err error) {
+	line, err := s.readLine()
+	if err != nil {
+		return nil, "", "", err
+	}
+	fields = strings.Fields(line)
+	headerSize := 0
+	if fields[0] == "HPUB" {
+		headerSize, _ = strconv.Atoi(fields[len(fields)-2])
+	} else if fields[0] != "PUB" {
+		return nil, "", "", fmt.Errorf("client sent %q, want a publish", line)
+	}
+	size, _ := strconv.Atoi(fields[len(fields)-1])
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		return nil, "", "", err
+	}
+	if string(data[size:]) != "\r\n" {
+		return nil, "", "", fmt.Errorf("payload of %q doesn't end where its size says", line)
+	}
+	return fields, string(data[:headerSize]), string(data[headerSize:size]), nil
+}
+
+// write sends protocol text to the client.
+func (s *natsServer) write(text string) error {
+	_, err := io.WriteString(s.conn, text)
+	return err
+}
+
+func TestNATSHandshake(t *testing.T) {
+	tests := []struct {
+		name string
+		// reply is the server's answer to CONNECT and PING.
+		reply   string
+		wantErr string
+	}{
+		{"accepted", "PONG\r\n", ""},
+		{"accepted after a ping", "PING\r\nPONG\r\n", ""},
+		{"refused", "-ERR 'Authorization Violation'\r\n", "Authorization Violation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var connect map[string]any
+			c, wait := natsPipe(t, func(s *natsServer) error {
+				if err := s.write("INFO {\"server_id\":\"test\",\"headers\":true}\r\n"); err != nil {
+					return err
+				}
+				line, err := s.expect("CONNECT ")
+				if err != nil {
+					return err
+				}
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &connect); err != nil {
+					return err
+				}
+				if _, err := s.expect("PING"); err != nil {
+					return err
+				}
+				if err := s.write(tt.reply); err != nil {
+					return err
+				}
+				if strings.HasPrefix(tt.reply, "PING") {
+					_, err = s.expect("PONG")
+				}
+				return err
+			})
+			err := c.handshake(NATSConfig{Username: "poller", Password: "s3cret"})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("handshake() error = %v", err)
+			} else if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("handshake() error = %v, want %q", err, tt.wantErr)
+			}
+			if err := wait(); err != nil {
+				t.Fatal(err)
+			}
+			if connect["user"] != "poller" || connect["pass"] != "s3cret" || connect["headers"] != true {
+				t.Errorf("CONNECT %v, want the credentials and headers enabled", connect)
+			}
+		})
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"plain", nil, "PUB ncdot.created.7 5\r\nhello\r\n"},
+		// The header block is "NATS/1.0\r\n", the header and a blank line: 28
+		// bytes, then 33 with the payload.
+		{"headers", map[string]string{"Nats-Msg-Id": "x"}, "HPUB ncdot.created.7 28 33\r\nNATS/1.0\r\nNats-Msg-Id: x\r\n\r\nhello\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]byte, len(tt.want))
+			c, wait := natsPipe(t, func(s *natsServer) error {
+				_, err := io.ReadFull(s.r, got)
+				return err
+			})
+			if err := c.publish("ncdot.created.7", "", tt.headers, []byte("hello")); err != nil {
+				t.Fatalf("publish() error = %v", err)
+			}
+			if err := wait(); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("publish() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNATSJetStreamRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		// reply formats the server's answer given the reply subject.
+		reply   func(inbox string) string
+		wantErr string
+	}{
+		{"stored", func(inbox string) string {
+			body := `{"stream":"NCDOT","seq":1}`
+			return fmt.Sprintf("MSG %s 1 %d\r\n%s\r\n", inbox, len(body), body)
+		}, ""},
+		{"stored with headers", func(inbox string) string {
+			header, body := "NATS/1.0\r\nNats-Stream: NCDOT\r\n\r\n", `{"stream":"NCDOT","seq":2}`
+			return fmt.Sprintf("HMSG %s 1 %d %d\r\n%s%s\r\n", inbox, len(header), len(header)+len(body), header, body)
+		}, ""},
+		{"stream error", func(inbox string) string {
+			body := `{"error":{"code":503,"description":"no responders"}}`
+			return fmt.Sprintf("MSG %s 1 %d\r\n%s\r\n", inbox, len(body), body)
+		}, "JetStream error 503: no responders"},
+		{"server error", func(string) string {
+			return "-ERR 'Permissions Violation for Publish to \"ncdot.created.7\"'\r\n"
+		}, `Permissions Violation for Publish to "ncdot.created.7"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, wait := natsPipe(t, func(s *natsServer) error {
+				fields, headers, payload, err := s.readPublish()
+				if err != nil {
+					return err
+				}
+				if fields[0] != "HPUB" || fields[1] != "ncdot.created.7" || !strings.HasPrefix(fields[2], "_INBOX.test.") {
+					return fmt.Errorf("client sent %v, want an HPUB with an inbox reply subject", fields)
+				}
+				if !strings.Contains(headers, "Nats-Msg-Id: ncdot-7\r\n") || payload != "{}" {
+					return fmt.Errorf("client sent headers %q and payload %q", headers, payload)
+				}
+				return s.write(tt.reply(fields[2]))
+			})
+			reply, err := c.request("ncdot.created.7", map[string]string{"Nats-Msg-Id": "ncdot-7"}, []byte("{}"))
+			if err == nil {
+				err = jetStreamError(reply)
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("request() error = %v", err)
+			} else if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("request() error = %v, want %q", err, tt.wantErr)
+			}
+			if err := wait(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
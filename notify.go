@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Notification kinds.
+const (
+	notifyNew       = "new"
+	notifyCleared   = "cleared"
+	notifyEscalated = "escalated"
+	notifyUpdated   = "updated"
+	notifyReopened  = "reopened"
+)
+
+// Notification is one message for notifiers to deliver: a new incident alert, with
+// its parsed start time, a cleared notification, or an escalation, reopening or
+// other update of an incident that was already alerted, with what changed in Changes.
+type Notification struct {
+	Kind      string
+	Incident  Incident
+	StartTime time.Time
+	Cleared   ClearedIncident
+	Changes   []string
+}
+
+// Notifier delivers notifications to one channel. Notify should return an error
+// if any part of the delivery failed; the dispatcher logs it. A notifier with
+// several targets returns targetErrors' result, so one that reached some of
+// them isn't sent to them again.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n Notification) error
+}
+
+// flusher is implemented by notifiers that batch messages, such as email digests.
+// Flush runs once at the end of every cycle.
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// batcher is implemented by notifiers that can combine several new-incident
+// alerts into one digest message. Notifiers without it get the alerts one by one.
+type batcher interface {
+	NotifyBatch(ctx context.Context, batch []Notification) error
+}
+
+// notifierState is per-cycle state a notifier may keep delivery records in.
+type notifierState struct {
+	discordMessages map[int][]DiscordMessage
+}
+
+// notifierFactory builds a notifier from the configuration, returning nil when
+// the channel isn't configured.
+type notifierFactory func(cfg NotificationConfig, state notifierState) Notifier
+
+// notifierRegistration is a channel known to the registry. Tracked notifiers keep
+// their own record of what they delivered (as Discord does with message IDs), so
+// a failed alert can be retried on the next cycle without duplicating the parts
+// that went through.
+type notifierRegistration struct {
+	name    string
+	tracked bool
+	build   notifierFactory
+}
+
+// notifierRegistry lists every channel in registration order.
+var notifierRegistry []notifierRegistration
+
+// registerNotifier adds a channel to the registry. Each notifier registers itself
+// from an init function in its own file.
+func registerNotifier(name string, tracked bool, build notifierFactory) {
+	notifierRegistry = append(notifierRegistry, notifierRegistration{name: name, tracked: tracked, build: build})
+}
+
+// notifierSet is the configured notifiers for a cycle.
+type notifierSet struct {
+	tracked []Notifier
+	others  []Notifier
+
+	// cfg holds the routing rules, and names maps each notifier's Name to the name
+	// it registered under, which is what the rules refer to.
+	cfg   NotificationConfig
+	names map[string]string
+
+	// failed collects the deliveries that failed this cycle, for the retry queue.
+	failed *failedDeliveries
+}
+
+// buildNotifiers instantiates every configured notifier.
+func buildNotifiers(cfg NotificationConfig, state notifierState) notifierSet {
+	configureBreakers(cfg.CircuitBreaker)
+	set := notifierSet{cfg: cfg, names: make(map[string]string), failed: &failedDeliveries{}}
+	for _, reg := range notifierRegistry {
+		n := reg.build(cfg, state)
+		if n == nil {
+			continue
+		}
+		set.names[n.Name()] = reg.name
+		if reg.tracked {
+			set.tracked = append(set.tracked, n)
+		} else {
+			set.others = append(set.others, n)
+		}
+	}
+	return set
+}
+
+// empty reports whether no notifier is configured.
+func (s notifierSet) empty() bool {
+	return len(s.tracked) == 0 && len(s.others) == 0
+}
+
+// all returns the tracked notifiers then the others in a new slice, so
+// appending to or filtering it leaves the set's own slices alone.
+func (s notifierSet) all() []Notifier {
+	return slices.Concat(s.tracked, s.others)
+}
+
+// notifyNew sends a new-incident alert and reports whether it was fully delivered.
+// Tracked notifiers go first; the others only get the alert once every tracked one
+// has delivered it, so when an alert is retried after a failure they don't
+// receive it twice. The others' failures go to the retry queue.
+func (s notifierSet) notifyNew(ctx context.Context, incident Incident, startTime time.Time) bool {
+	n := Notification{Kind: notifyNew, Incident: incident, StartTime: startTime}
+	if len(dispatch(ctx, s.routed(s.tracked, n), n)) > 0 {
+		return false
+	}
+	s.failed.add(dispatch(ctx, s.routed(s.others, n), n))
+	return true
+}
+
+// routed returns the notifiers the routing rules send a notification to.
+func (s notifierSet) routed(notifiers []Notifier, n Notification) []Notifier {
+	countyID, incidentType, severity := n.Incident.CountyID, n.Incident.IncidentType, n.Incident.Severity
+	if n.Kind == notifyCleared {
+		countyID, incidentType, severity = n.Cleared.CountyID, n.Cleared.IncidentType, n.Cleared.Severity
+	}
+	var routed []Notifier
+	for _, notifier := range notifiers {
+		if s.cfg.routesTo(s.names[notifier.Name()], countyID, incidentType, severity) {
+			routed = append(routed, notifier)
+		}
+	}
+	return routed
+}
+
+// routedBatches splits a batch by notifier according to the routing rules,
+// dropping the notifiers that get none of it.
+func (s notifierSet) routedBatches(notifiers []Notifier, batch []Notification) ([]Notifier, [][]Notification) {
+	var routed []Notifier
+	var batches [][]Notification
+	for _, notifier := range notifiers {
+		var sub []Notification
+		for _, n := range batch {
+			if len(s.routed([]Notifier{notifier}, n)) > 0 {
+				sub = append(sub, n)
+			}
+		}
+		if len(sub) > 0 {
+			routed = append(routed, notifier)
+			batches = append(batches, sub)
+		}
+	}
+	return routed, batches
+}
+
+// notifyBatch sends several new-incident alerts as one digest per notifier and
+// reports whether every notifier delivered them, with the same ordering and retry
+// rules as notifyNew.
+func (s notifierSet) notifyBatch(ctx context.Context, batch []Notification) bool {
+	tracked, trackedBatches := s.routedBatches(s.tracked, batch)
+	if len(dispatchBatch(ctx, tracked, trackedBatches)) > 0 {
+		return false
+	}
+	others, otherBatches := s.routedBatches(s.others, batch)
+	s.failed.add(dispatchBatch(ctx, others, otherBatches))
+	return true
+}
+
+// notifyCleared sends a cleared notification to every notifier.
+func (s notifierSet) notifyCleared(ctx context.Context, incident ClearedIncident) {
+	n := Notification{Kind: notifyCleared, Cleared: incident}
+	s.failed.add(dispatch(ctx, s.routed(s.all(), n), n))
+}
+
+// notifyUpdate sends an escalation, reopening or update to every notifier.
+func (s notifierSet) notifyUpdate(ctx context.Context, n Notification) {
+	s.failed.add(dispatch(ctx, s.routed(s.all(), n), n))
+}
+
+// flush lets batching notifiers send what they have collected.
+func (s notifierSet) flush(ctx context.Context) {
+	for _, n := range s.all() {
+		if f, ok := n.(flusher); ok {
+			breaker := breakerFor(n.Name())
+			err := breaker.allow()
+			if err == nil {
+				err = f.Flush(ctx)
+				breaker.record(err)
+			}
+			if err != nil {
+				slog.Error("Error flushing notifications", "channel", n.Name(), "err", err)
+			}
+		}
+	}
+}
+
+// deliveryFailure is a notifier that failed to deliver notifications.
+type deliveryFailure struct {
+	notifier      Notifier
+	notifications []Notification
+	err           error
+}
+
+// partialDelivery is the error of a notifier that sends to several targets,
+// such as webhooks or chats, when some of them got the notification. It isn't
+// queued for retry, which would send it to those again.
+type partialDelivery struct{ err error }
+
+func (p partialDelivery) Error() string { return p.err.Error() }
+func (p partialDelivery) Unwrap() error { return p.err }
+
+// targetErrors joins the errors of sending to several targets, of which
+// delivered got it, making it a partialDelivery when that's any of them.
+func targetErrors(delivered int, errs []error) error {
+	err := errors.Join(errs...)
+	if err != nil && delivered > 0 {
+		return partialDelivery{err}
+	}
+	return err
+}
+
+// dispatch fans a notification out to the notifiers concurrently, logs failures
+// and returns them.
+func dispatch(ctx context.Context, notifiers []Notifier, n Notification) []deliveryFailure {
+	id := n.Incident.ID
+	if n.Kind == notifyCleared {
+		id = n.Cleared.ID
+	}
+	errs := make([]error, len(notifiers))
+	var wg sync.WaitGroup
+	for i, notifier := range notifiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+			breaker := breakerFor(notifier.Name())
+			if errs[i] = breaker.allow(); errs[i] != nil {
+				return
+			}
+			ctx, span := startSpan(ctx, "notify "+notifier.Name(), spanKindClient)
+			span.set("ncdot.incident_id", id)
+			span.set("ncdot.notification.kind", n.Kind)
+			errs[i] = notifier.Notify(ctx, n)
+			breaker.record(errs[i])
+			span.finish(errs[i])
+		}()
+	}
+	wg.Wait()
+
+	var failed []deliveryFailure
+	for i, err := range errs {
+		if err != nil {
+			slog.Error("Error sending notification", "channel", notifiers[i].Name(), "kind", n.Kind, "incident_id", id, "err", err)
+			failed = append(failed, deliveryFailure{notifiers[i], []Notification{n}, err})
+		}
+	}
+	return failed
+}
+
+// dispatchBatch is dispatch for batches of new-incident alerts, batches[i] going
+// to notifiers[i].
+func dispatchBatch(ctx context.Context, notifiers []Notifier, batches [][]Notification) []deliveryFailure {
+	errs := make([]error, len(notifiers))
+	// unsent are the notifications to retry of each notifier that failed.
+	unsent := slices.Clone(batches)
+	var wg sync.WaitGroup
+	for i, notifier := range notifiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+			breaker := breakerFor(notifier.Name())
+			if errs[i] = breaker.allow(); errs[i] != nil {
+				return
+			}
+			batch := batches[i]
+			ctx, span := startSpan(ctx, "notify "+notifier.Name(), spanKindClient)
+			span.set("ncdot.batch_size", len(batch))
+			defer func() {
+				breaker.record(errs[i])
+				span.finish(errs[i])
+			}()
+			if b, ok := notifier.(batcher); ok {
+				errs[i] = b.NotifyBatch(ctx, batch)
+				return
+			}
+			// Sent one by one, only the notifications that failed outright
+			// are retried.
+			var nerrs []error
+			unsent[i] = nil
+			for _, n := range batch {
+				err := notifier.Notify(ctx, n)
+				if err == nil {
+					continue
+				}
+				nerrs = append(nerrs, err)
+				if _, partial := err.(partialDelivery); !partial {
+					unsent[i] = append(unsent[i], n)
+				}
+			}
+			errs[i] = errors.Join(nerrs...)
+		}()
+	}
+	wg.Wait()
+
+	var failed []deliveryFailure
+	for i, err := range errs {
+		if err != nil {
+			slog.Error("Error sending digest", "channel", notifiers[i].Name(), "count", len(batches[i]), "err", err)
+			failed = append(failed, deliveryFailure{notifiers[i], unsent[i], err})
+		}
+	}
+	return failed
+}
+
+// digestTitle is the heading of a batched alert.
+func digestTitle(batch []Notification) string {
+	return fmt.Sprintf("%d New Incidents", len(batch))
+}
+
+// maxSeverity returns the highest severity in a batch.
+func maxSeverity(batch []Notification) int {
+	highest := 0
+	for _, n := range batch {
+		highest = max(highest, n.Incident.Severity)
+	}
+	return highest
+}
+
+// digestLine summarizes one incident of a batched alert.
+func digestLine(incident Incident) string {
+	return fmt.Sprintf("%s: %s at %s, %s (lanes %s, severity %d)",
+		incident.IncidentType, orNA(incident.Road), orNA(incident.Location), orNA(incident.City),
+		lanesText(incident.LanesClosed, incident.LanesTotal), incident.Severity)
+}
+
+// digestText lists the incidents of a batch one per line, each formatted by line
+// (digestLine if nil), stopping with an "...and N more" line before the text
+// would exceed maxLen runes. maxLen 0 means no limit.
+func digestText(batch []Notification, maxLen int, line func(Incident) string) string {
+	if line == nil {
+		line = digestLine
+	}
+	var b strings.Builder
+	length := 0
+	for i, n := range batch {
+		next := line(n.Incident)
+		if i > 0 {
+			next = "\n" + next
+		}
+		// Leave room to say how many were left out if this isn't the last line.
+		reserve := 0
+		if i < len(batch)-1 {
+			reserve = utf8.RuneCountInString(fmt.Sprintf("\n…and %d more", len(batch)-i-1))
+		}
+		if maxLen > 0 && length+utf8.RuneCountInString(next)+reserve > maxLen {
+			fmt.Fprintf(&b, "\n…and %d more", len(batch)-i)
+			break
+		}
+		b.WriteString(next)
+		length += utf8.RuneCountInString(next)
+	}
+	return strings.TrimPrefix(b.String(), "\n")
+}
+
+// updateTitle is the heading of an escalation, reopening or update.
+func updateTitle(n Notification) string {
+	switch n.Kind {
+	case notifyEscalated:
+		return fmt.Sprintf("%s Escalated", n.Incident.IncidentType)
+	case notifyReopened:
+		return fmt.Sprintf("%s Reopened", n.Incident.IncidentType)
+	}
+	return fmt.Sprintf("%s Updated", n.Incident.IncidentType)
+}
+
+// updateText describes an escalation, reopening or update as plain text: where
+// the incident is, what changed and the map link. A notifier's "updated" template replaces it.
+func updateText(templates MessageTemplates, n Notification) string {
+	data := newTemplateData(templateUpdated, n.Incident, n.StartTime)
+	data.Changes = n.Changes
+	if text, ok := templates.render(data); ok {
+		return text
+	}
+	incident := n.Incident
+	return fmt.Sprintf("%s at %s, %s\n• %s\n%s",
+		orNA(incident.Road), orNA(incident.Location), orNA(incident.City),
+		strings.Join(n.Changes, "\n• "), mapLink(incident.Latitude, incident.Longitude))
+}
+
+// hasTargets reports whether any notification channel is configured.
+func (n NotificationConfig) hasTargets() bool {
+	return !buildNotifiers(n, notifierState{}).empty()
+}
+
+// formatDuration renders a duration as "2h 14m" or "38m".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// duration returns how long a cleared incident was active, or 0 if unknown.
+func (c ClearedIncident) duration() time.Duration {
+	return time.Duration(c.DurationSeconds) * time.Second
+}
+
+// clearedTitle is the heading of a cleared notification, with how long the
+// incident lasted when known, e.g. "Incident Cleared after 2h 14m".
+func clearedTitle(incident ClearedIncident) string {
+	if incident.DurationSeconds <= 0 {
+		return "Incident Cleared"
+	}
+	return "Incident Cleared after " + formatDuration(incident.duration())
+}
+
+// isFullClosure reports whether every lane is closed.
+func isFullClosure(incident Incident) bool {
+	return incident.LanesTotal > 0 && incident.LanesClosed >= incident.LanesTotal
+}
+
+// mapLink returns a Google Maps link that drops a pin on the incident.
+func mapLink(latitude, longitude float64) string {
+	return fmt.Sprintf("https://www.google.com/maps/search/?api=1&query=%.6f,%.6f", latitude, longitude)
+}
+
+// postJSON POSTs payload as JSON with the given extra headers and, if out is not
+// nil, decodes the response into it. Non-2xx responses are returned as errors
+// including the start of the body, which is where most APIs explain themselves.
+func postJSON(ctx context.Context, endpoint string, payload any, headers map[string]string, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error creating payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("non-2xx status %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
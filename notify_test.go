@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// namedNotifier is a Notifier that only has a name.
+type namedNotifier string
+
+func (n namedNotifier) Name() string                               { return string(n) }
+func (n namedNotifier) Notify(context.Context, Notification) error { return nil }
+
+func TestNotifierSetAll(t *testing.T) {
+	// tracked has room to grow, which appending the others to it would
+	// write into.
+	tracked := make([]Notifier, 1, 4)
+	tracked[0] = namedNotifier("discord")
+	s := notifierSet{tracked: tracked, others: []Notifier{namedNotifier("email"), namedNotifier("sms")}}
+
+	all := s.all()
+	if want := []Notifier{namedNotifier("discord"), namedNotifier("email"), namedNotifier("sms")}; !slices.Equal(all, want) {
+		t.Errorf("all() = %v, want %v", all, want)
+	}
+	if spare := tracked[1:cap(tracked)]; slices.ContainsFunc(spare, func(n Notifier) bool { return n != nil }) {
+		t.Errorf("all() wrote %v past the tracked notifiers", spare)
+	}
+}
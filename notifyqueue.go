@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// NotificationRetryConfig keeps notifications that failed to send in the
+// notification_queue table and retries them on later cycles, so an outage of
+// Discord, Slack or another service doesn't drop them. New-incident alerts to
+// tracked notifiers (Discord) aren't queued: the whole alert is retried on the
+// next cycle instead.
+type NotificationRetryConfig struct {
+	// MaxAttempts is how many times a notification is tried in all, counting
+	// the first; 0 or 1 turns the queue off.
+	MaxAttempts int `yaml:"max_attempts"`
+	// Backoff is the wait before the first retry, doubling for each one after
+	// up to MaxBackoff. Retries happen on the first cycle after the wait.
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// enabled reports whether failed notifications are queued.
+func (r NotificationRetryConfig) enabled() bool {
+	return r.MaxAttempts > 1
+}
+
+// delay returns the wait after a notification's attempts-th failed attempt.
+func (r NotificationRetryConfig) delay(attempts int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempts && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, r.MaxBackoff)
+}
+
+// queuedNotification is a notification waiting in notification_queue to be
+// retried. Notifier is the name the notifier registered under.
+type queuedNotification struct {
+	ID           int64
+	Notifier     string
+	Notification Notification
+	Attempts     int
+	NextAttempt  time.Time
+	LastError    string
+}
+
+// savedIncident is an incident as the notification queues save it, keeping
+// the weather and duplicates that alerts show but Incident's JSON leaves out.
+type savedIncident struct {
+	Incident
+	Weather    string          `json:"weather,omitempty"`
+	Duplicates []savedIncident `json:"duplicates,omitempty"`
+}
+
+// newSavedIncident returns the incident to save.
+func newSavedIncident(incident Incident) savedIncident {
+	s := savedIncident{Incident: incident, Weather: incident.Weather}
+	for _, d := range incident.Duplicates {
+		s.Duplicates = append(s.Duplicates, newSavedIncident(d))
+	}
+	return s
+}
+
+// incident returns the saved incident as alerts show it.
+func (s savedIncident) incident() Incident {
+	incident := s.Incident
+	incident.Weather = s.Weather
+	incident.Duplicates = nil
+	for _, d := range s.Duplicates {
+		incident.Duplicates = append(incident.Duplicates, d.incident())
+	}
+	return incident
+}
+
+// savedNotification is a notification as the retry queue saves it.
+type savedNotification struct {
+	Notification
+	Incident savedIncident
+}
+
+// failedDeliveries collects a cycle's failed deliveries. Notifiers run
+// concurrently, so it is locked.
+type failedDeliveries struct {
+	mu       sync.Mutex
+	failures []deliveryFailure
+}
+
+// add records failures.
+func (f *failedDeliveries) add(failures []deliveryFailure) {
+	f.mu.Lock()
+	f.failures = append(f.failures, failures...)
+	f.mu.Unlock()
+}
+
+// notifier returns the notifier registered under name, or nil.
+func (s notifierSet) notifier(name string) Notifier {
+	for _, n := range s.all() {
+		if s.names[n.Name()] == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// retryQueued tries the queued notifications that are due again. Delivered
+// ones leave the queue, as do those that reached only some targets; the rest
+// wait longer, or are dropped after their last attempt.
+func (s notifierSet) retryQueued(ctx context.Context, store Store) {
+	retry := s.cfg.Retry
+	if !retry.enabled() {
+		return
+	}
+	due, err := store.DueNotifications(ctx, time.Now())
+	if err != nil {
+		slog.Error("Error loading the notification queue", "err", err)
+		return
+	}
+	for _, q := range due {
+		id := q.Notification.Incident.ID
+		if q.Notification.Kind == notifyCleared {
+			id = q.Notification.Cleared.ID
+		}
+		notifier := s.notifier(q.Notifier)
+		if notifier == nil {
+			slog.Warn("Dropping a queued notification for a channel that is no longer configured",
+				"channel", q.Notifier, "kind", q.Notification.Kind, "incident_id", id)
+			s.dequeue(ctx, store, q)
+			continue
+		}
+
+		failed := dispatch(ctx, []Notifier{notifier}, q.Notification)
+		q.Attempts++
+		var partial bool
+		if len(failed) > 0 {
+			_, partial = failed[0].err.(partialDelivery)
+		}
+		switch {
+		case len(failed) == 0:
+			slog.Info("Delivered a queued notification", "channel", q.Notifier, "kind", q.Notification.Kind,
+				"incident_id", id, "attempts", q.Attempts)
+			s.dequeue(ctx, store, q)
+		case partial:
+			slog.Warn("Delivered a queued notification to only some targets. Not retrying the rest.",
+				"channel", q.Notifier, "kind", q.Notification.Kind, "incident_id", id, "attempts", q.Attempts, "err", failed[0].err)
+			s.dequeue(ctx, store, q)
+		case q.Attempts >= retry.MaxAttempts:
+			slog.Error("Giving up on a notification", "channel", q.Notifier, "kind", q.Notification.Kind,
+				"incident_id", id, "attempts", q.Attempts, "err", failed[0].err)
+			s.dequeue(ctx, store, q)
+		default:
+			q.LastError = failed[0].err.Error()
+			q.NextAttempt = time.Now().Add(retry.delay(q.Attempts))
+			if err := store.RescheduleNotification(ctx, q); err != nil {
+				slog.Error("Error rescheduling a queued notification", "err", err)
+			}
+		}
+	}
+}
+
+// dequeue removes a notification from the queue.
+func (s notifierSet) dequeue(ctx context.Context, store Store, q queuedNotification) {
+	if err := store.DeleteQueuedNotification(ctx, q.ID); err != nil {
+		slog.Error("Error removing a queued notification", "err", err)
+	}
+}
+
+// queueFailed adds the cycle's failed deliveries to the queue, except those
+// that reached some of the notifier's targets.
+func (s notifierSet) queueFailed(ctx context.Context, store Store) {
+	retry := s.cfg.Retry
+	s.failed.mu.Lock()
+	failures := s.failed.failures
+	s.failed.failures = nil
+	s.failed.mu.Unlock()
+	if !retry.enabled() || len(failures) == 0 {
+		return
+	}
+
+	next := time.Now().Add(retry.delay(1))
+	var queued []queuedNotification
+	for _, f := range failures {
+		if _, partial := f.err.(partialDelivery); partial {
+			slog.Warn("Not queueing a notification that reached some of its targets", "channel", f.notifier.Name(),
+				"count", len(f.notifications), "err", f.err)
+			continue
+		}
+		for _, n := range f.notifications {
+			queued = append(queued, queuedNotification{
+				Notifier:     s.names[f.notifier.Name()],
+				Notification: n,
+				Attempts:     1,
+				NextAttempt:  next,
+				LastError:    f.err.Error(),
+			})
+		}
+	}
+	if len(queued) == 0 {
+		return
+	}
+	if err := store.QueueNotifications(ctx, queued); err != nil {
+		slog.Error("Error queueing failed notifications", "count", len(queued), "err", err)
+		return
+	}
+	slog.Info("Queued failed notifications for retry", "count", len(queued), "next_attempt", next)
+}
+
+// QueueNotifications adds notifications to the retry queue.
+func (s *sqlStore) QueueNotifications(ctx context.Context, queued []queuedNotification) error {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	return withReconnect(s.db, func() error {
+		tx, err := s.db.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() // No-op once committed.
+
+		for _, q := range queued {
+			notification, err := json.Marshal(savedNotification{q.Notification, newSavedIncident(q.Notification.Incident)})
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO notification_queue (notifier, notification, attempts, next_attempt, last_error)
+				VALUES ($1, $2, $3, $4, $5)`,
+				q.Notifier, string(notification), q.Attempts, q.NextAttempt, q.LastError)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// DueNotifications returns the queued notifications due by now, oldest first.
+// A notification that no longer decodes, after a change to its fields or a
+// truncated write, is logged and removed so it doesn't hold up the rest.
+func (s *sqlStore) DueNotifications(ctx context.Context, now time.Time) ([]queuedNotification, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	var due []queuedNotification
+	err := withReconnect(s.db, func() error {
+		due = nil
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT queue_id, notifier, notification, attempts, next_attempt, COALESCE(last_error, '')
+			FROM notification_queue
+			WHERE next_attempt <= $1
+			ORDER BY queue_id`, now)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		var bad []int64
+		for rows.Next() {
+			var q queuedNotification
+			var notification string
+			if err := rows.Scan(&q.ID, &q.Notifier, &notification, &q.Attempts, &q.NextAttempt, &q.LastError); err != nil {
+				return err
+			}
+			var saved savedNotification
+			if err := json.Unmarshal([]byte(notification), &saved); err != nil {
+				slog.Error("Dropping a queued notification that can't be read", "queue_id", q.ID, "channel", q.Notifier, "err", err)
+				bad = append(bad, q.ID)
+				continue
+			}
+			q.Notification = saved.Notification
+			q.Notification.Incident = saved.Incident.incident()
+			due = append(due, q)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+		for _, id := range bad {
+			if _, err := s.db.ExecContext(ctx, "DELETE FROM notification_queue WHERE queue_id = $1", id); err != nil {
+				slog.Error("Error removing a queued notification", "queue_id", id, "err", err)
+			}
+		}
+		return nil
+	})
+	return due, err
+}
+
+// RescheduleNotification records a failed retry of a queued notification.
+func (s *sqlStore) RescheduleNotification(ctx context.Context, q queuedNotification) error {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	return withReconnect(s.db, func() error {
+		_, err := s.db.ExecContext(ctx,
+			"UPDATE notification_queue SET attempts = $1, next_attempt = $2, last_error = $3 WHERE queue_id = $4",
+			q.Attempts, q.NextAttempt, q.LastError, q.ID)
+		return err
+	})
+}
+
+// DeleteQueuedNotification removes a notification from the queue.
+func (s *sqlStore) DeleteQueuedNotification(ctx context.Context, id int64) error {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	return withReconnect(s.db, func() error {
+		_, err := s.db.ExecContext(ctx, "DELETE FROM notification_queue WHERE queue_id = $1", id)
+		return err
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDueNotificationsSkipsUnreadable(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	good := func(id int) string {
+		data, err := json.Marshal(Notification{Kind: notifyNew, Incident: Incident{ID: id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	db, mock := newMockDB(t)
+	columns := []string{"queue_id", "notifier", "notification", "attempts", "next_attempt", "last_error"}
+	mock.ExpectQuery("SELECT queue_id, notifier, notification").WithArgs(now).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(1, "slack", good(10), 1, now, "").
+		AddRow(2, "slack", `{"Kind":"new","Incident":{"id":`, 1, now, "").
+		AddRow(3, "email", good(30), 2, now, "timeout"))
+	mock.ExpectExec("DELETE FROM notification_queue").WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	due, err := (&sqlStore{db: db}).DueNotifications(context.Background(), now)
+	if err != nil {
+		t.Fatalf("DueNotifications() error = %v", err)
+	}
+	var got []int64
+	for _, q := range due {
+		got = append(got, q.ID)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 || due[1].Notification.Incident.ID != 30 {
+		t.Errorf("DueNotifications() = %+v, want queue IDs 1 and 3", due)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueFailedPartialDelivery(t *testing.T) {
+	tests := []struct {
+		name string
+		// failing is which of the two webhooks fail.
+		failing []bool
+		// wantQueued is whether the update waits in the queue.
+		wantQueued bool
+	}{
+		{"delivered", []bool{false, false}, false},
+		// Retrying would post the update to the first webhook again.
+		{"one of two failed", []bool{false, true}, false},
+		{"both failed", []bool{true, true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var webhooks []OutboundWebhook
+			for _, failing := range tt.failing {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if failing {
+						w.WriteHeader(http.StatusInternalServerError)
+					}
+				}))
+				defer server.Close()
+				webhooks = append(webhooks, OutboundWebhook{URL: server.URL})
+			}
+			s := notifierSet{
+				others: []Notifier{webhookNotifier{webhooks: webhooks}},
+				names:  map[string]string{"webhook": "webhook"},
+				cfg:    NotificationConfig{Retry: NotificationRetryConfig{MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour}},
+				failed: &failedDeliveries{},
+			}
+			ctx := context.Background()
+			store := newMemStore()
+
+			s.notifyUpdate(ctx, Notification{Kind: notifyUpdated, Incident: Incident{ID: 1}, Changes: []string{"Lanes closed: 1 → 2"}})
+			s.queueFailed(ctx, store)
+			queued, err := store.DueNotifications(ctx, time.Now().Add(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(queued) > 0; got != tt.wantQueued {
+				t.Errorf("queued %d notifications, want queued %v", len(queued), tt.wantQueued)
+			}
+		})
+	}
+}
+
+// capturedArg is a sqlmock argument that matches anything and keeps it.
+type capturedArg struct{ value *string }
+
+func (a capturedArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*a.value = s
+	return ok
+}
+
+func TestQueuedNotificationKeepsAnnotations(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	n := Notification{Kind: notifyNew, StartTime: now, Incident: Incident{
+		ID: 1, Road: "I-40", Weather: "Light rain, 54°F",
+		Duplicates: []Incident{{ID: 2, Road: "I-40", Direction: "East"}},
+	}}
+	db, mock := newMockDB(t)
+	store := &sqlStore{db: db}
+	var saved string
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO notification_queue").
+		WithArgs("email", capturedArg{&saved}, 1, now, "").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	ctx := context.Background()
+	if err := store.QueueNotifications(ctx, []queuedNotification{{Notifier: "email", Notification: n, Attempts: 1, NextAttempt: now}}); err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []string{"queue_id", "notifier", "notification", "attempts", "next_attempt", "last_error"}
+	mock.ExpectQuery("SELECT queue_id, notifier, notification").WithArgs(now).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "email", saved, 1, now, ""))
+	due, err := store.DueNotifications(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || !reflect.DeepEqual(due[0].Notification, n) {
+		t.Errorf("DueNotifications() = %+v, want %+v", due, n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NtfyConfig publishes alerts to an ntfy topic, e.g. https://ntfy.sh/my-nc-traffic
+// or a topic on a self-hosted server. Token is an optional access token.
+type NtfyConfig struct {
+	TopicURL string `yaml:"topic_url"`
+	Token    string `yaml:"token"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether ntfy alerts are configured.
+func (n NtfyConfig) enabled() bool {
+	return n.TopicURL != ""
+}
+
+// ntfyTypeTags are the emoji tags shown for each incident type.
+var ntfyTypeTags = map[string]string{
+	"vehicle crash":           "car",
+	"disabled vehicle":        "red_car",
+	"road closure":            "no_entry",
+	"construction":            "construction",
+	"night time construction": "construction",
+	"weather event":           "cloud_with_rain",
+	"congestion":              "vertical_traffic_light",
+}
+
+// ntfyPriority maps severity to an ntfy priority from 1 (min) to 5 (max). Full
+// closures are always urgent.
+func ntfyPriority(incident Incident) int {
+	if isFullClosure(incident) {
+		return 5
+	}
+	switch incident.Severity {
+	case 1:
+		return 2
+	case 3:
+		return 4
+	default:
+		return 3
+	}
+}
+
+// ntfyTags returns the tags for an incident: its type emoji plus a warning for severe ones.
+func ntfyTags(incident Incident) []string {
+	var tags []string
+	if tag, ok := ntfyTypeTags[strings.ToLower(incident.IncidentType)]; ok {
+		tags = append(tags, tag)
+	}
+	if incident.Severity >= 3 {
+		tags = append(tags, "warning")
+	}
+	return tags
+}
+
+// publishToNtfy sends one message to the topic. ntfy takes the message as the body
+// and everything else as headers.
+func publishToNtfy(ctx context.Context, cfg NtfyConfig, title, message string, priority int, tags []string, click string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TopicURL, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Priority", fmt.Sprint(priority))
+	if len(tags) > 0 {
+		req.Header.Set("Tags", strings.Join(tags, ","))
+	}
+	if click != "" {
+		req.Header.Set("Click", click)
+	}
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ntfy returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// sendToNtfy publishes a new-incident alert; tapping it opens the map.
+func sendToNtfy(ctx context.Context, cfg NtfyConfig, incident Incident, parsedTime time.Time) error {
+	message := fmt.Sprintf("%s at %s, %s\nLanes: %s\nStarted %s",
+		orNA(incident.Road), orNA(incident.Location), orNA(incident.City),
+		lanesText(incident.LanesClosed, incident.LanesTotal), localTime(parsedTime).Format("3:04 PM"))
+	if custom, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		message = custom
+	}
+	return publishToNtfy(ctx, cfg, fmt.Sprintf("New %s Alert", incident.IncidentType), message,
+		ntfyPriority(incident), ntfyTags(incident), mapLink(incident.Latitude, incident.Longitude))
+}
+
+// sendClearedNotificationToNtfy publishes a low-priority cleared notification.
+func sendClearedNotificationToNtfy(ctx context.Context, cfg NtfyConfig, incident ClearedIncident) error {
+	message := fmt.Sprintf("%s at %s, %s", orNA(incident.Road), orNA(incident.Location), orNA(incident.City))
+	if custom, ok := cfg.templates.render(clearedTemplateData(incident)); ok {
+		message = custom
+	}
+	return publishToNtfy(ctx, cfg, clearedTitle(incident), message, 2, []string{"white_check_mark"}, "")
+}
+
+// ntfyNotifier delivers notifications to an ntfy topic.
+type ntfyNotifier struct {
+	cfg NtfyConfig
+}
+
+func init() {
+	registerNotifier("ntfy", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Ntfy.enabled() {
+			return nil
+		}
+		c := cfg.Ntfy
+		c.templates = cfg.templatesFor("ntfy")
+		return ntfyNotifier{cfg: c}
+	})
+}
+
+func (t ntfyNotifier) Name() string { return "ntfy" }
+
+// NotifyBatch publishes one digest at the priority of the most severe incident.
+func (t ntfyNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	priority := 0
+	for _, n := range batch {
+		priority = max(priority, ntfyPriority(n.Incident))
+	}
+	return publishToNtfy(ctx, t.cfg, digestTitle(batch), digestText(batch, 4000, nil), priority, []string{"rotating_light"}, "")
+}
+
+func (t ntfyNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedNotificationToNtfy(ctx, t.cfg, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		tags := ntfyTags(n.Incident)
+		if n.Kind == notifyEscalated {
+			tags = append(tags, "arrow_up")
+		}
+		return publishToNtfy(ctx, t.cfg, updateTitle(n), updateText(t.cfg.templates, n),
+			ntfyPriority(n.Incident), tags, mapLink(n.Incident.Latitude, n.Incident.Longitude))
+	}
+	return sendToNtfy(ctx, t.cfg, n.Incident, n.StartTime)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// AdaptivePollingConfig lets the daemon poll faster while incidents are
+// appearing, changing or clearing, and slower while the feed is quiet, as it
+// usually is overnight. A cycle that sees any change drops the interval to
+// MinInterval; each quiet cycle after it doubles the interval, up to
+// MaxInterval. polling.interval is the interval it starts at.
+type AdaptivePollingConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	MinInterval time.Duration `yaml:"min_interval"`
+	MaxInterval time.Duration `yaml:"max_interval"`
+}
+
+// pollPacer spaces the daemon's polls: polling.interval, or the adaptive
+// interval, plus up to polling.jitter at random so redundant or restarted
+// daemons don't all hit the feed at once.
+type pollPacer struct {
+	jitter   time.Duration
+	adaptive AdaptivePollingConfig
+
+	mu       sync.Mutex
+	interval time.Duration
+}
+
+// newPollPacer returns the pacer for cfg's polling settings.
+func newPollPacer(cfg PollingConfig) *pollPacer {
+	return &pollPacer{jitter: cfg.Jitter, adaptive: cfg.Adaptive, interval: cfg.Interval}
+}
+
+// next returns when to poll after one due at t.
+func (p *pollPacer) next(t time.Time) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return t.Add(p.interval).Add(p.delay())
+}
+
+// delay returns a random wait of up to the jitter. A nil pacer never waits.
+func (p *pollPacer) delay() time.Duration {
+	if p == nil || p.jitter <= 0 {
+		return 0
+	}
+	return rand.N(p.jitter + 1)
+}
+
+// observe adapts the interval to a cycle in which changes incidents appeared,
+// changed or cleared. A nil pacer, or one that isn't adaptive, does nothing.
+func (p *pollPacer) observe(changes int) {
+	if p == nil || !p.adaptive.Enabled {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous := p.interval
+	if changes > 0 {
+		p.interval = p.adaptive.MinInterval
+	} else {
+		p.interval = min(2*p.interval, p.adaptive.MaxInterval)
+	}
+	if p.interval != previous {
+		slog.Info("Adapted the polling interval", "changes", changes, "interval", p.interval)
+	}
+}
+
+// longestWait is the longest the pacer may wait between polls.
+func (c PollingConfig) longestWait() time.Duration {
+	wait := c.Interval
+	if c.Adaptive.Enabled {
+		wait = max(wait, c.Adaptive.MaxInterval)
+	}
+	return wait + max(c.Jitter, 0)
+}
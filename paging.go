@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// Paging endpoints.
+const (
+	pagerDutyEventsURL     = "https://events.pagerduty.com/v2/enqueue"
+	defaultOpsgenieAPIURL  = "https://api.opsgenie.com"
+	pagingDedupKeyTemplate = "ncdot-incident-%d"
+)
+
+// PagingConfig pages on-call through PagerDuty and/or Opsgenie when every lane of
+// a critical corridor is closed, and resolves the page when the incident clears.
+// Corridors uses the same patterns as filters.roads; left empty, a full closure
+// on any road pages. Paged incidents are tracked in StateFile so each one pages
+// once and only paged incidents are resolved.
+type PagingConfig struct {
+	PagerDutyRoutingKey string     `yaml:"pagerduty_routing_key"`
+	OpsgenieAPIKey      string     `yaml:"opsgenie_api_key"`
+	OpsgenieAPIURL      string     `yaml:"opsgenie_api_url"`
+	Corridors           RoadFilter `yaml:"corridors"`
+	StateFile           string     `yaml:"state_file"`
+}
+
+// enabled reports whether a paging service is configured.
+func (p PagingConfig) enabled() bool {
+	return p.PagerDutyRoutingKey != "" || p.OpsgenieAPIKey != ""
+}
+
+// shouldPage reports whether an incident is a full closure on a critical corridor.
+func (p PagingConfig) shouldPage(incident Incident) bool {
+	return isFullClosure(incident) && p.Corridors.matches(incident)
+}
+
+// pagerDutyEvent is an Events API v2 request.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string         `json:"summary"`
+	Source        string         `json:"source"`
+	Severity      string         `json:"severity"`
+	Component     string         `json:"component,omitempty"`
+	CustomDetails map[string]any `json:"custom_details,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// pageSummary is the one-line description both services show.
+func pageSummary(incident Incident) string {
+	return fmt.Sprintf("Full closure: %s at %s, %s (%s)", orNA(incident.Road), orNA(incident.Location), orNA(incident.City), incident.IncidentType)
+}
+
+// pageDetails are the structured fields attached to the page.
+func pageDetails(incident Incident) map[string]any {
+	return map[string]any{
+		"incident_id": incident.ID,
+		"type":        incident.IncidentType,
+		"reason":      incident.Reason,
+		"road":        incident.Road,
+		"direction":   incident.Direction,
+		"location":    incident.Location,
+		"county":      incident.CountyName,
+		"lanes":       lanesText(incident.LanesClosed, incident.LanesTotal),
+		"detour":      incident.Detour,
+		"start":       incident.StartTime,
+	}
+}
+
+// triggerPagerDuty opens (or, with the same dedup key, updates) a PagerDuty incident.
+func triggerPagerDuty(ctx context.Context, routingKey string, incident Incident) error {
+	return postJSON(ctx, pagerDutyEventsURL, pagerDutyEvent{
+		RoutingKey:  routingKey,
+		EventAction: "trigger",
+		DedupKey:    fmt.Sprintf(pagingDedupKeyTemplate, incident.ID),
+		Payload: &pagerDutyPayload{
+			Summary:       pageSummary(incident),
+			Source:        "NC DOT",
+			Severity:      "critical",
+			Component:     incident.Road,
+			CustomDetails: pageDetails(incident),
+		},
+		Links: []pagerDutyLink{{Href: mapLink(incident.Latitude, incident.Longitude), Text: "View on map"}},
+	}, nil, nil)
+}
+
+// resolvePagerDuty resolves the PagerDuty incident for an NC DOT incident.
+func resolvePagerDuty(ctx context.Context, routingKey string, incidentID int) error {
+	return postJSON(ctx, pagerDutyEventsURL, pagerDutyEvent{
+		RoutingKey:  routingKey,
+		EventAction: "resolve",
+		DedupKey:    fmt.Sprintf(pagingDedupKeyTemplate, incidentID),
+	}, nil, nil)
+}
+
+// opsgenieHeaders authenticates Opsgenie API requests.
+func (p PagingConfig) opsgenieHeaders() map[string]string {
+	return map[string]string{"Authorization": "GenieKey " + p.OpsgenieAPIKey}
+}
+
+// opsgenieURL joins a path to the configured (e.g. EU) API base.
+func (p PagingConfig) opsgenieURL(path string) string {
+	return strings.TrimRight(p.OpsgenieAPIURL, "/") + path
+}
+
+// createOpsgenieAlert opens a P1 alert; the alias makes repeats update the same alert.
+func (p PagingConfig) createOpsgenieAlert(ctx context.Context, incident Incident) error {
+	details := make(map[string]string)
+	for k, v := range pageDetails(incident) {
+		details[k] = fmt.Sprint(v)
+	}
+	details["map"] = mapLink(incident.Latitude, incident.Longitude)
+	return postJSON(ctx, p.opsgenieURL("/v2/alerts"), map[string]any{
+		"message":  truncateRunes(pageSummary(incident), 130),
+		"alias":    fmt.Sprintf(pagingDedupKeyTemplate, incident.ID),
+		"priority": "P1",
+		"source":   "NC DOT",
+		"tags":     []string{"ncdot", "full-closure"},
+		"details":  details,
+	}, p.opsgenieHeaders(), nil)
+}
+
+// closeOpsgenieAlert closes the alert for an NC DOT incident.
+func (p PagingConfig) closeOpsgenieAlert(ctx context.Context, incidentID int) error {
+	alias := url.PathEscape(fmt.Sprintf(pagingDedupKeyTemplate, incidentID))
+	return postJSON(ctx, p.opsgenieURL("/v2/alerts/"+alias+"/close?identifierType=alias"),
+		map[string]string{"source": "NC DOT", "note": "Incident cleared from the NC DOT feed"}, p.opsgenieHeaders(), nil)
+}
+
+// pageCriticalIncidents pages for every full closure on a critical corridor that
+// hasn't been paged yet. It runs over the whole feed each cycle, so an incident
+// that grows into a full closure pages when it does. Quiet hours don't apply.
+func pageCriticalIncidents(ctx context.Context, cfg PagingConfig, incidents []Incident) {
+	if !cfg.enabled() {
+		return
+	}
+	paged, err := loadSentIncidents(cfg.StateFile)
+	if err != nil {
+		slog.Error("Error loading paged incidents file", "err", err)
+		return
+	}
+
+	changed := false
+	for _, incident := range incidents {
+		if paged[incident.ID] || !cfg.shouldPage(incident) {
+			continue
+		}
+		slog.Warn("Full closure on a critical corridor. Paging on-call.", "incident_id", incident.ID, "county", incident.CountyID)
+		ok := true
+		if cfg.PagerDutyRoutingKey != "" {
+			if err := triggerPagerDuty(ctx, cfg.PagerDutyRoutingKey, incident); err != nil {
+				slog.Error("Error triggering page", "channel", "pagerduty", "incident_id", incident.ID, "err", err)
+				ok = false
+			}
+		}
+		if cfg.OpsgenieAPIKey != "" {
+			if err := cfg.createOpsgenieAlert(ctx, incident); err != nil {
+				slog.Error("Error triggering page", "channel", "opsgenie", "incident_id", incident.ID, "err", err)
+				ok = false
+			}
+		}
+		// A failed page is retried next cycle; the dedup key stops the other service doubling up.
+		if ok {
+			paged[incident.ID] = true
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := saveSentIncidents(cfg.StateFile, paged); err != nil {
+			slog.Error("Error saving paged incidents file", "err", err)
+		}
+	}
+}
+
+// resolvePage resolves the page for a cleared incident, if it was paged.
+func resolvePage(ctx context.Context, cfg PagingConfig, incidentID int) {
+	if !cfg.enabled() {
+		return
+	}
+	paged, err := loadSentIncidents(cfg.StateFile)
+	if err != nil {
+		slog.Error("Error loading paged incidents file", "err", err)
+		return
+	}
+	if !paged[incidentID] {
+		return
+	}
+
+	slog.Info("Paged incident cleared. Resolving.", "incident_id", incidentID)
+	ok := true
+	if cfg.PagerDutyRoutingKey != "" {
+		if err := resolvePagerDuty(ctx, cfg.PagerDutyRoutingKey, incidentID); err != nil {
+			slog.Error("Error resolving page", "channel", "pagerduty", "incident_id", incidentID, "err", err)
+			ok = false
+		}
+	}
+	if cfg.OpsgenieAPIKey != "" {
+		if err := cfg.closeOpsgenieAlert(ctx, incidentID); err != nil {
+			slog.Error("Error resolving page", "channel", "opsgenie", "incident_id", incidentID, "err", err)
+			ok = false
+		}
+	}
+	if !ok {
+		return
+	}
+	delete(paged, incidentID)
+	if err := saveSentIncidents(cfg.StateFile, paged); err != nil {
+		slog.Error("Error saving paged incidents file", "err", err)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Parquet is written here without a library: flat files of one data page per
+// column per row group, PLAIN encoded and uncompressed, with the metadata in
+// Thrift's compact protocol. That is the format's baseline, which DuckDB,
+// Spark, pandas and other readers all take.
+
+// parquetMagic starts and ends every Parquet file.
+const parquetMagic = "PAR1"
+
+// parquetRowGroupRows is how many rows a row group holds, bounding the memory
+// a large export needs. Tests lower it to split a few rows into row groups.
+var parquetRowGroupRows = 50000
+
+// Parquet physical types, encodings and other enums (parquet.thrift).
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMicros = 10
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// parquetWriter writes rows of export columns as a Parquet file.
+type parquetWriter struct {
+	out     io.Writer
+	offset  int64
+	columns []exportColumn
+	// types are the columns' physical types.
+	types     []int32
+	pending   [][]any
+	rowGroups []parquetRowGroup
+	numRows   int64
+}
+
+// parquetRowGroup is the metadata of a written row group.
+type parquetRowGroup struct {
+	chunks    []parquetChunk
+	numRows   int64
+	totalSize int64
+}
+
+// parquetChunk is the metadata of a written column chunk.
+type parquetChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+// newParquetWriter starts a Parquet file of the columns on out.
+func newParquetWriter(out io.Writer, columns []exportColumn) (*parquetWriter, error) {
+	p := &parquetWriter{out: out, columns: columns}
+	for _, c := range columns {
+		switch c.Type.Kind() {
+		case reflect.Bool:
+			p.types = append(p.types, parquetBoolean)
+		case reflect.Int:
+			p.types = append(p.types, parquetInt64)
+		case reflect.Float64:
+			p.types = append(p.types, parquetDouble)
+		case reflect.String:
+			p.types = append(p.types, parquetByteArray)
+		default:
+			if c.Type != timeType {
+				return nil, fmt.Errorf("column %s has type %s, which Parquet export doesn't support", c.Name, c.Type)
+			}
+			p.types = append(p.types, parquetInt64)
+		}
+	}
+	return p, p.write([]byte(parquetMagic))
+}
+
+// write writes to the file, keeping track of the offset.
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.out.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// writeRow adds a row, its values in the order of the columns. The row is
+// copied, so the caller may reuse it.
+func (p *parquetWriter) writeRow(row []any) error {
+	p.pending = append(p.pending, append([]any(nil), row...))
+	if len(p.pending) >= parquetRowGroupRows {
+		return p.flush()
+	}
+	return nil
+}
+
+// flush writes the pending rows as a row group.
+func (p *parquetWriter) flush() error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+	group := parquetRowGroup{numRows: int64(len(p.pending))}
+	for i, c := range p.columns {
+		body := p.encodeColumn(i, c)
+		var header thriftCompact
+		header.begin()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(body)))
+		header.i32(3, int32(len(body)))
+		header.structField(5)
+		header.i32(1, int32(len(p.pending)))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.end()
+		header.end()
+
+		chunk := parquetChunk{offset: p.offset, size: int64(len(header.buf) + len(body)), numValues: int64(len(p.pending))}
+		if err := p.write(header.buf); err != nil {
+			return err
+		}
+		if err := p.write(body); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		group.totalSize += chunk.size
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.numRows += group.numRows
+	p.pending = p.pending[:0]
+	return nil
+}
+
+// encodeColumn encodes the pending rows' values of column i as a data page:
+// the definition levels if the column is nullable, then the values that
+// aren't null.
+func (p *parquetWriter) encodeColumn(i int, c exportColumn) []byte {
+	var page []byte
+	if c.Nullable {
+		levels := make([]bool, len(p.pending))
+		for r, row := range p.pending {
+			levels[r] = row[i] != nil
+		}
+		runs := rleLevels(levels)
+		page = binary.LittleEndian.AppendUint32(page, uint32(len(runs)))
+		page = append(page, runs...)
+	}
+	var bits []bool
+	for _, row := range p.pending {
+		switch v := row[i].(type) {
+		case nil:
+		case bool:
+			bits = append(bits, v)
+		case int:
+			page = binary.LittleEndian.AppendUint64(page, uint64(v))
+		case float64:
+			page = binary.LittleEndian.AppendUint64(page, math.Float64bits(v))
+		case string:
+			page = binary.LittleEndian.AppendUint32(page, uint32(len(v)))
+			page = append(page, v...)
+		case time.Time:
+			page = binary.LittleEndian.AppendUint64(page, uint64(v.UnixMicro()))
+		}
+	}
+	if p.types[i] == parquetBoolean {
+		packed := make([]byte, (len(bits)+7)/8)
+		for b, set := range bits {
+			if set {
+				packed[b/8] |= 1 << (b % 8)
+			}
+		}
+		page = append(page, packed...)
+	}
+	return page
+}
+
+// rleLevels encodes definition levels of bit width 1 as RLE runs of the
+// Parquet RLE/bit-packing hybrid: each run is its length shifted left once,
+// as a varint, then the repeated level in a byte.
+func rleLevels(levels []bool) []byte {
+	var out []byte
+	for start := 0; start < len(levels); {
+		end := start
+		for end < len(levels) && levels[end] == levels[start] {
+			end++
+		}
+		out = binary.AppendUvarint(out, uint64(end-start)<<1)
+		if levels[start] {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		start = end
+	}
+	return out
+}
+
+// close writes any pending rows and the file's footer.
+func (p *parquetWriter) close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	var meta thriftCompact
+	meta.begin()
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(p.columns)+1)
+	meta.begin()
+	meta.binary(4, "incident")
+	meta.i32(5, int32(len(p.columns)))
+	meta.end()
+	for i, c := range p.columns {
+		meta.begin()
+		meta.i32(1, p.types[i])
+		if c.Nullable {
+			meta.i32(3, parquetOptional)
+		} else {
+			meta.i32(3, parquetRequired)
+		}
+		meta.binary(4, c.Name)
+		switch {
+		case p.types[i] == parquetByteArray:
+			meta.i32(6, parquetConvertedUTF8)
+			meta.structField(10)
+			meta.structField(1) // STRING
+			meta.end()
+			meta.end()
+		case c.Type == timeType:
+			meta.i32(6, parquetConvertedTimestampMicros)
+			meta.structField(10)
+			meta.structField(8) // TIMESTAMP
+			meta.bool(1, true)  // isAdjustedToUTC
+			meta.structField(2)
+			meta.structField(2) // MICROS
+			meta.end()
+			meta.end()
+			meta.end()
+			meta.end()
+		}
+		meta.end()
+	}
+	meta.i64(3, p.numRows)
+	meta.list(4, thriftStruct, len(p.rowGroups))
+	for _, g := range p.rowGroups {
+		meta.begin()
+		meta.list(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			meta.begin()
+			meta.i64(2, chunk.offset)
+			meta.structField(3)
+			meta.i32(1, p.types[i])
+			if p.columns[i].Nullable {
+				meta.list(2, thriftI32, 2)
+				meta.zigzag(parquetEncodingPlain)
+				meta.zigzag(parquetEncodingRLE)
+			} else {
+				meta.list(2, thriftI32, 1)
+				meta.zigzag(parquetEncodingPlain)
+			}
+			meta.list(3, thriftBinary, 1)
+			meta.str(p.columns[i].Name)
+			meta.i32(4, parquetUncompressed)
+			meta.i64(5, chunk.numValues)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, g.totalSize)
+		meta.i64(3, g.numRows)
+		meta.end()
+	}
+	meta.binary(6, "crash-reporting")
+	meta.end()
+
+	if err := p.write(meta.buf); err != nil {
+		return err
+	}
+	return p.write(append(binary.LittleEndian.AppendUint32(nil, uint32(len(meta.buf))), parquetMagic...))
+}
+
+// Thrift compact protocol field and element types.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompact is a struct encoded in Thrift's compact protocol, built a
+// field at a time. Each struct, nested or a list element, starts with begin
+// or structField and finishes with end.
+type thriftCompact struct {
+	buf []byte
+	// lastIDs are the last field IDs written in each open struct, as field
+	// headers hold the difference from the one before.
+	lastIDs []int16
+}
+
+// begin opens a struct: the file's top one, or an element of a list.
+func (t *thriftCompact) begin() {
+	t.lastIDs = append(t.lastIDs, 0)
+}
+
+// end closes the innermost struct.
+func (t *thriftCompact) end() {
+	t.buf = append(t.buf, 0)
+	t.lastIDs = t.lastIDs[:len(t.lastIDs)-1]
+}
+
+// field writes a field header.
+func (t *thriftCompact) field(id int16, typ byte) {
+	last := &t.lastIDs[len(t.lastIDs)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+// zigzag writes a zigzag varint, as integers are encoded; alone, it writes an
+// element of a list of integers.
+func (t *thriftCompact) zigzag(v int64) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(v<<1^v>>63))
+}
+
+// str writes a length-prefixed string; alone, an element of a list of them.
+func (t *thriftCompact) str(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thriftCompact) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftCompact) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftCompact) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+// bool writes a boolean field, whose value is in its header's type.
+func (t *thriftCompact) bool(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+// structField opens a nested struct field, closed with end.
+func (t *thriftCompact) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// list writes a list field's header; its n elements follow.
+func (t *thriftCompact) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+// writeIncidentsParquet writes the incidents as Parquet, with their changes
+// as eachExportRow lays them out unless changes is nil.
+func writeIncidentsParquet(out io.Writer, incidents []StoredIncident, changes map[int][]IncidentChange) error {
+	p, err := newParquetWriter(out, exportColumns(changes != nil))
+	if err != nil {
+		return err
+	}
+	if err := eachExportRow(incidents, changes, p.writeRow); err != nil {
+		return err
+	}
+	return p.close()
+}
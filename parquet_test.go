@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// readParquet reads a file back with parquet-go, checking its schema against
+// the export's columns, and returns its rows, with timestamps as microseconds
+// and nulls as nil, and how many row groups hold them.
+func readParquet(t *testing.T, file []byte, columns []exportColumn) ([][]any, int) {
+	t.Helper()
+	pr, err := reader.NewParquetColumnReader(buffer.NewBufferFileFromBytes(file), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	schema := pr.Footer.Schema
+	if len(schema) != len(columns)+1 || schema[0].GetNumChildren() != int32(len(columns)) {
+		t.Fatalf("schema has %d elements, want the root and %d columns", len(schema), len(columns))
+	}
+	for i, c := range columns {
+		el := schema[i+1]
+		wantType, wantConverted := parquet.Type_BYTE_ARRAY, parquet.ConvertedType_UTF8
+		switch c.Type.Kind() {
+		case reflect.Bool:
+			wantType = parquet.Type_BOOLEAN
+		case reflect.Int:
+			wantType = parquet.Type_INT64
+		case reflect.Float64:
+			wantType = parquet.Type_DOUBLE
+		}
+		if c.Type == timeType {
+			wantType, wantConverted = parquet.Type_INT64, parquet.ConvertedType_TIMESTAMP_MICROS
+			ts := el.GetLogicalType().GetTIMESTAMP()
+			if ts == nil || !ts.IsAdjustedToUTC || !ts.Unit.IsSetMICROS() {
+				t.Errorf("column %s has logical type %v, want a UTC timestamp in microseconds", c.Name, el.GetLogicalType())
+			}
+		}
+		wantRepetition := parquet.FieldRepetitionType_REQUIRED
+		if c.Nullable {
+			wantRepetition = parquet.FieldRepetitionType_OPTIONAL
+		}
+		// The reader renames columns in the schema it keeps, but not here.
+		name := pr.SchemaHandler.GetExName(i + 1)
+		if name != c.Name || el.GetType() != wantType || el.GetRepetitionType() != wantRepetition {
+			t.Errorf("column %d = %s %s %s, want %s %s %s", i, name, el.GetType(), el.GetRepetitionType(), c.Name, wantType, wantRepetition)
+		}
+		if wantType == parquet.Type_BYTE_ARRAY || c.Type == timeType {
+			if !el.IsSetConvertedType() || el.GetConvertedType() != wantConverted {
+				t.Errorf("column %s has converted type %v, want %s", c.Name, el.ConvertedType, wantConverted)
+			}
+		}
+	}
+
+	n := pr.GetNumRows()
+	rows := make([][]any, n)
+	for r := range rows {
+		rows[r] = make([]any, len(columns))
+	}
+	for i, c := range columns {
+		values, _, defLevels, err := pr.ReadColumnByIndex(int64(i), n)
+		if err != nil {
+			t.Fatalf("reading column %s: %v", c.Name, err)
+		}
+		if int64(len(values)) != n {
+			t.Fatalf("column %s has %d values, want %d", c.Name, len(values), n)
+		}
+		for r, v := range values {
+			if c.Nullable && defLevels[r] == 0 {
+				v = nil
+			}
+			rows[r][i] = v
+		}
+	}
+	return rows, len(pr.Footer.RowGroups)
+}
+
+// parquetRows returns an export's rows as readParquet reads them.
+func parquetRows(t *testing.T, incidents []StoredIncident, changes map[int][]IncidentChange) [][]any {
+	t.Helper()
+	var rows [][]any
+	err := eachExportRow(incidents, changes, func(row []any) error {
+		read := make([]any, len(row))
+		for i, v := range row {
+			switch v := v.(type) {
+			case int:
+				read[i] = int64(v)
+			case time.Time:
+				read[i] = v.UnixMicro()
+			default:
+				read[i] = v
+			}
+		}
+		rows = append(rows, read)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestWriteIncidentsParquet(t *testing.T) {
+	started := time.Date(2024, 5, 1, 11, 0, 0, 123456000, time.UTC)
+	cleared := started.Add(90 * time.Minute)
+	duration := 5400
+	incidents := []StoredIncident{{
+		Incident: Incident{ID: 1, Latitude: 35.78, Longitude: -78.64, IncidentType: "Vehicle Crash", Road: "I-40",
+			CountyID: 92, CountyName: "Wake", City: "Raleigh", Severity: 2, StartTime: FeedTime{started},
+			Reason: "Crash — use caution", LanesClosed: 1, LanesTotal: 3, CreatedFromConcurrent: true},
+		Status:          "cleared",
+		ClearedTime:     &cleared,
+		DurationSeconds: &duration,
+		ReopenCount:     1,
+	}, {
+		Incident: Incident{ID: 2, IncidentType: "Construction", Road: "US-15"},
+		Status:   "active",
+	}}
+	changes := map[int][]IncidentChange{1: {
+		{IncidentID: 1, ObservedAt: started.Add(time.Minute), Field: "severity", OldValue: "1", NewValue: "2", LastUpdate: FeedTime{started}},
+		{IncidentID: 1, ObservedAt: started.Add(2 * time.Minute), Field: "road", OldValue: "", NewValue: "I-40"},
+	}}
+
+	// Enough incidents for several row groups of two.
+	many := make([]StoredIncident, 5)
+	for i := range many {
+		many[i] = StoredIncident{Incident: Incident{ID: i + 1, Road: "I-40"}, Status: "active"}
+	}
+
+	tests := []struct {
+		name      string
+		incidents []StoredIncident
+		changes   map[int][]IncidentChange
+		// groupRows, if set, replaces parquetRowGroupRows.
+		groupRows int
+		rowGroups int
+	}{
+		{"incidents", incidents, nil, 0, 1},
+		{"with changes", incidents, changes, 0, 1},
+		{"no incidents", nil, nil, 0, 0},
+		{"row groups", many, nil, 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.groupRows > 0 {
+				defer func(rows int) { parquetRowGroupRows = rows }(parquetRowGroupRows)
+				parquetRowGroupRows = tt.groupRows
+			}
+			var file bytes.Buffer
+			if err := writeIncidentsParquet(&file, tt.incidents, tt.changes); err != nil {
+				t.Fatal(err)
+			}
+			got, rowGroups := readParquet(t, file.Bytes(), exportColumns(tt.changes != nil))
+			want := parquetRows(t, tt.incidents, tt.changes)
+			if len(got) != len(want) {
+				t.Fatalf("read %d rows, want %d", len(got), len(want))
+			}
+			for r := range want {
+				if !reflect.DeepEqual(got[r], want[r]) {
+					t.Errorf("row %d =\n%v\nwant\n%v", r, got[r], want[r])
+				}
+			}
+			if rowGroups != tt.rowGroups {
+				t.Errorf("file has %d row groups, want %d", rowGroups, tt.rowGroups)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mtickle/crash-reporting/geo"
+)
+
+// Place is a named point, such as home or work, whose distance alerts give.
+type Place struct {
+	Name      string  `yaml:"name"`
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+}
+
+// point returns the place's coordinates.
+func (p Place) point() geo.Point {
+	return geo.Point{Lat: p.Latitude, Lon: p.Longitude}
+}
+
+// placeDistance is how far an incident is from a place.
+type placeDistance struct {
+	Place string
+	Miles float64
+}
+
+// placeDistances returns the incident's distance from each place, nearest
+// first. An incident without coordinates has none.
+func placeDistances(incident Incident, places []Place) []placeDistance {
+	at := geo.Point{Lat: incident.Latitude, Lon: incident.Longitude}
+	if len(places) == 0 || !at.Valid() {
+		return nil
+	}
+	distances := make([]placeDistance, 0, len(places))
+	for _, p := range places {
+		distances = append(distances, placeDistance{Place: p.Name, Miles: geo.HaversineMiles(p.point(), at)})
+	}
+	sort.SliceStable(distances, func(i, j int) bool { return distances[i].Miles < distances[j].Miles })
+	return distances
+}
+
+// distancesText describes the distances as "3.2 mi from Home / 7.8 mi from Work".
+func distancesText(distances []placeDistance) string {
+	parts := make([]string, 0, len(distances))
+	for _, d := range distances {
+		parts = append(parts, fmt.Sprintf("%.1f mi from %s", d.Miles, d.Place))
+	}
+	return strings.Join(parts, " / ")
+}
+
+// withinDistance reports whether the incident is within MaxDistanceMiles of
+// any place. With no limit everything passes, as do incidents without
+// coordinates, which can't be placed.
+func (f FilterConfig) withinDistance(incident Incident, places []Place) bool {
+	if f.MaxDistanceMiles <= 0 {
+		return true
+	}
+	distances := placeDistances(incident, places)
+	return len(distances) == 0 || distances[0].Miles <= f.MaxDistanceMiles
+}
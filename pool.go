@@ -0,0 +1,25 @@
+package main
+
+import "sync"
+
+// forEachConcurrently calls fn with each item, running at most workers calls
+// at once, and returns once every call has. fn must be safe to call
+// concurrently; errors are its own to collect.
+func forEachConcurrently[T any](items []T, workers int, fn func(T)) {
+	work := make(chan T)
+	var wg sync.WaitGroup
+	for range max(1, min(workers, len(items))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				fn(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+}
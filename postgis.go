@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// metersPerMile converts geofence radii for PostGIS, which measures in meters.
+const metersPerMile = 1609.344
+
+// SpatialStore is a Store that answers spatial queries in the database. Stores
+// on Postgres with the PostGIS extension installed are SpatialStores.
+type SpatialStore interface {
+	Store
+	// IncidentsWithin returns the incidents with a status ("active", "cleared"
+	// or "all") within meters of a point, nearest first.
+	IncidentsWithin(ctx context.Context, status string, lat, lon, meters float64) ([]StoredIncident, error)
+	// IncidentsNearRoute returns the incidents with a status within meters of a
+	// route, a line through [longitude, latitude] points as in GeoJSON, by ID.
+	IncidentsNearRoute(ctx context.Context, status string, route [][2]float64, meters float64) ([]StoredIncident, error)
+}
+
+// postgisSetup adds the geometry column and its index. The column is generated
+// from latitude and longitude, so the upserts don't change, and is NULL for
+// incidents the feed placed at 0,0.
+var postgisSetup = []string{
+	`ALTER TABLE ncdot_incidents ADD COLUMN IF NOT EXISTS geom geometry(Point, 4326)
+		GENERATED ALWAYS AS (CASE WHEN latitude <> 0 OR longitude <> 0
+			THEN ST_SetSRID(ST_MakePoint(longitude, latitude), 4326) END) STORED`,
+	`CREATE INDEX IF NOT EXISTS ncdot_incidents_geom ON ncdot_incidents USING GIST (geom)`,
+}
+
+// enablePostGIS reports whether the PostGIS extension is installed in the
+// database and, if it is, adds the geometry column. Without it the store works
+// as before and the geofence is checked in Go; "CREATE EXTENSION postgis" in
+// the database turns it on at the next start.
+func enablePostGIS(db *DB) (bool, error) {
+	ctx, cancel := db.timeout(context.Background())
+	defer cancel()
+	var installed bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'postgis')").Scan(&installed); err != nil {
+		return false, fmt.Errorf("could not check for PostGIS: %w", err)
+	}
+	if !installed {
+		return false, nil
+	}
+	for _, stmt := range postgisSetup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return false, fmt.Errorf("could not add the PostGIS geometry column: %w", err)
+		}
+	}
+	slog.Info("PostGIS is installed; spatial queries run in the database.")
+	return true, nil
+}
+
+// postgisStore is a sqlStore on a database with PostGIS and the geom column.
+type postgisStore struct {
+	*sqlStore
+}
+
+// IncidentsWithin returns the incidents within meters of a point, nearest first.
+// Distances are measured on the spheroid through geography, while the
+// geometry's index narrows the rows first.
+func (s *postgisStore) IncidentsWithin(ctx context.Context, status string, lat, lon, meters float64) ([]StoredIncident, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	query := `
+		SELECT ` + incidentColumns + ` FROM ncdot_incidents
+		WHERE ($1 = 'all' OR status = $1)
+			AND ST_DWithin(geom::geography, ST_SetSRID(ST_MakePoint($3, $2), 4326)::geography, $4)
+		ORDER BY geom::geography <-> ST_SetSRID(ST_MakePoint($3, $2), 4326)::geography, id`
+	return s.queryIncidents(ctx, query, status, lat, lon, meters)
+}
+
+// IncidentsNearRoute returns the incidents within meters of a route, by ID.
+func (s *postgisStore) IncidentsNearRoute(ctx context.Context, status string, route [][2]float64, meters float64) ([]StoredIncident, error) {
+	if len(route) < 2 {
+		return nil, fmt.Errorf("a route needs at least 2 points, got %d", len(route))
+	}
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	query := `
+		SELECT ` + incidentColumns + ` FROM ncdot_incidents
+		WHERE ($1 = 'all' OR status = $1)
+			AND ST_DWithin(geom::geography, ST_GeomFromText($2, 4326)::geography, $3)
+		ORDER BY id`
+	return s.queryIncidents(ctx, query, status, lineStringWKT(route), meters)
+}
+
+// lineStringWKT writes [longitude, latitude] points as a WKT LINESTRING.
+func lineStringWKT(points [][2]float64) string {
+	coords := make([]string, len(points))
+	for i, p := range points {
+		coords[i] = strconv.FormatFloat(p[0], 'f', -1, 64) + " " + strconv.FormatFloat(p[1], 'f', -1, 64)
+	}
+	return "LINESTRING(" + strings.Join(coords, ", ") + ")"
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Google Cloud endpoints.
+const (
+	defaultPubSubEndpoint  = "https://pubsub.googleapis.com"
+	googleMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	pubsubScope            = "https://www.googleapis.com/auth/pubsub"
+)
+
+// PubSubConfig publishes events to a Google Cloud Pub/Sub topic. Each message is
+// given its incident ID as the ordering key, so subscribers with message
+// ordering enabled see an incident's events in order (ordering needs a regional
+// Endpoint such as https://us-east1-pubsub.googleapis.com). Credentials come from
+// a service account key file, GOOGLE_APPLICATION_CREDENTIALS, or the GCE metadata
+// server. With PUBSUB_EMULATOR_HOST set, the emulator is used without auth.
+type PubSubConfig struct {
+	Project         string `yaml:"project"`
+	Topic           string `yaml:"topic"`
+	Endpoint        string `yaml:"endpoint"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+// enabled reports whether Pub/Sub publishing is configured.
+func (p PubSubConfig) enabled() bool {
+	return p.Project != "" && p.Topic != ""
+}
+
+// serviceAccountKey is the part of a service account JSON key needed to sign tokens.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// googleAccessToken gets an OAuth access token for Pub/Sub, from a service account
+// key when one is configured and the metadata server otherwise.
+func (p PubSubConfig) googleAccessToken(ctx context.Context) (string, error) {
+	file := p.CredentialsFile
+	if file == "" {
+		file = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if file == "" {
+		return metadataAccessToken(ctx)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("could not read credentials: %w", err)
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return "", fmt.Errorf("could not parse credentials %s: %w", file, err)
+	}
+	return serviceAccountAccessToken(ctx, key)
+}
+
+// metadataAccessToken asks the GCE/GKE/Cloud Run metadata server for a token.
+// It gives up after a few seconds, as off Google Cloud nothing answers.
+func metadataAccessToken(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, googleMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("no credentials configured and the metadata server is unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+	return decodeAccessToken(resp)
+}
+
+// serviceAccountAccessToken exchanges a signed JWT for an access token.
+func serviceAccountAccessToken(ctx context.Context, key serviceAccountKey) (string, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", errors.New("service account private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("could not parse service account private key: %w", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("service account private key is not an RSA key")
+	}
+	tokenURI := key.TokenURI
+	if tokenURI == "" {
+		tokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	now := time.Now()
+	encode := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := encode(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encode(map[string]any{
+		"iss":   key.ClientEmail,
+		"scope": pubsubScope,
+		"aud":   tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("could not sign token request: %w", err)
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return decodeAccessToken(resp)
+}
+
+// decodeAccessToken reads an OAuth token response.
+func decodeAccessToken(resp *http.Response) (string, error) {
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("token request returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("could not read token response: %w", err)
+	}
+	return token.AccessToken, nil
+}
+
+// pubsubMessage is one message in a publish request; Data is base64 encoded.
+type pubsubMessage struct {
+	Data        string            `json:"data"`
+	Attributes  map[string]string `json:"attributes"`
+	OrderingKey string            `json:"orderingKey"`
+}
+
+// publishToPubSub publishes a cycle's events in one request.
+func publishToPubSub(ctx context.Context, cfg PubSubConfig, events []IncidentEvent) error {
+	messages := make([]pubsubMessage, len(events))
+	for i, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages[i] = pubsubMessage{
+			Data: base64.StdEncoding.EncodeToString(data),
+			Attributes: map[string]string{
+				"event":     event.Event,
+				"county_id": strconv.Itoa(event.Incident.CountyID),
+				"type":      event.Incident.IncidentType,
+				"severity":  strconv.Itoa(event.Incident.Severity),
+			},
+			OrderingKey: strconv.Itoa(event.Incident.ID),
+		}
+	}
+
+	endpoint := cfg.Endpoint
+	headers := map[string]string{}
+	if emulator := os.Getenv("PUBSUB_EMULATOR_HOST"); emulator != "" {
+		endpoint = "http://" + emulator
+	} else {
+		token, err := cfg.googleAccessToken(ctx)
+		if err != nil {
+			return err
+		}
+		headers["Authorization"] = "Bearer " + token
+	}
+	publishURL := fmt.Sprintf("%s/v1/projects/%s/topics/%s:publish",
+		strings.TrimRight(endpoint, "/"), url.PathEscape(cfg.Project), url.PathEscape(cfg.Topic))
+	return postJSON(ctx, publishURL, map[string]any{"messages": messages}, headers, nil)
+}
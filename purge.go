@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// RetentionConfig limits how long cleared incidents stay in ncdot_incidents. The
+// daemon applies it once a day; the purge subcommand applies it on demand.
+type RetentionConfig struct {
+	// Days is how long after clearing an incident is kept; 0 keeps it forever.
+	Days int `yaml:"days"`
+	// Archive moves expired incidents to ncdot_incidents_archive instead of
+	// deleting them.
+	Archive bool `yaml:"archive"`
+	// SnapshotDays is how long feed snapshots are kept; 0 keeps them forever.
+	SnapshotDays int `yaml:"snapshot_days"`
+}
+
+// enabled reports whether cleared incidents or feed snapshots expire.
+func (r RetentionConfig) enabled() bool {
+	return r.Days > 0 || r.SnapshotDays > 0
+}
+
+// defaultPurgeDays is the purge subcommand's cutoff when neither --days nor
+// retention.days is set.
+const defaultPurgeDays = 90
+
+// retentionInterval is how often the daemon applies the retention policy.
+const retentionInterval = 24 * time.Hour
+
+// applyRetention archives or deletes the incidents cleared more than r.Days ago
+// and deletes the feed snapshots older than r.SnapshotDays, each when set.
+func applyRetention(ctx context.Context, store Store, r RetentionConfig) error {
+	if r.SnapshotDays > 0 {
+		n, err := store.PurgeFeedSnapshots(ctx, time.Now().AddDate(0, 0, -r.SnapshotDays))
+		if err != nil {
+			return fmt.Errorf("could not purge feed snapshots: %w", err)
+		}
+		slog.Info("Purged old feed snapshots", "count", n, "days", r.SnapshotDays)
+	}
+	if r.Days <= 0 {
+		return nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -r.Days)
+	if r.Archive {
+		n, err := store.ArchiveCleared(ctx, cutoff)
+		if err != nil {
+			return fmt.Errorf("could not archive incidents: %w", err)
+		}
+		slog.Info("Archived old cleared incidents", "count", n, "days", r.Days)
+		return nil
+	}
+	n, err := store.PurgeCleared(ctx, cutoff)
+	if err != nil {
+		return fmt.Errorf("could not purge incidents: %w", err)
+	}
+	slog.Info("Purged old cleared incidents", "count", n, "days", r.Days)
+	return nil
+}
+
+// purgeCommand implements the "purge" subcommand, deleting or archiving cleared
+// incidents older than a cutoff. The flags default to the retention settings.
+func purgeCommand(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	configPath := addConfigFlag(fs)
+	days := fs.Int("days", 0, fmt.Sprintf("delete incidents cleared more than this many days ago (default retention.days, or %d)", defaultPurgeDays))
+	archive := fs.Bool("archive", false, "move the incidents to ncdot_incidents_archive instead of deleting them (default retention.archive)")
+	fs.Parse(args)
+
+	if *days < 0 {
+		return fmt.Errorf("--days cannot be negative, got %d", *days)
+	}
+
+	cfg, err := loadCommandConfig(fs, *configPath, false)
+	if err != nil {
+		return err
+	}
+	r := cfg.Retention
+	if *days > 0 {
+		r.Days = *days
+	} else if r.Days <= 0 {
+		r.Days = defaultPurgeDays
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "archive" {
+			r.Archive = *archive
+		}
+	})
+
+	store, err := openStore(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return applyRetention(context.Background(), store, r)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pushoverMessagesURL is the Pushover message API endpoint.
+const pushoverMessagesURL = "https://api.pushover.net/1/messages.json"
+
+// PushoverConfig sends alerts to phones through Pushover. Priorities maps incident
+// severity to a Pushover priority (-2 to 1); unmapped severities use 0. Emergency
+// priority (2) isn't supported because it needs acknowledgement handling.
+type PushoverConfig struct {
+	AppToken   string      `yaml:"app_token"`
+	UserKeys   []string    `yaml:"user_keys"`
+	Priorities map[int]int `yaml:"priorities"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether Pushover alerts are configured.
+func (p PushoverConfig) enabled() bool {
+	return p.AppToken != "" && len(p.UserKeys) > 0
+}
+
+// priority returns the Pushover priority for a severity.
+func (p PushoverConfig) priority(severity int) int {
+	return p.Priorities[severity]
+}
+
+// pushoverMessage is the message API request body.
+type pushoverMessage struct {
+	Token     string `json:"token"`
+	User      string `json:"user"`
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	Priority  int    `json:"priority"`
+	URL       string `json:"url,omitempty"`
+	URLTitle  string `json:"url_title,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// postToPushover sends a message to every user key.
+func postToPushover(ctx context.Context, cfg PushoverConfig, msg pushoverMessage) error {
+	msg.Token = cfg.AppToken
+	var errs []error
+	for _, user := range cfg.UserKeys {
+		msg.User = user
+		var result struct {
+			Status int      `json:"status"`
+			Errors []string `json:"errors"`
+		}
+		err := postJSON(ctx, pushoverMessagesURL, msg, nil, &result)
+		if err == nil && result.Status != 1 {
+			err = fmt.Errorf("Pushover API error: %s", strings.Join(result.Errors, "; "))
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return targetErrors(len(cfg.UserKeys)-len(errs), errs)
+}
+
+// sendToPushover sends a new-incident alert with a link to the map.
+func sendToPushover(ctx context.Context, cfg PushoverConfig, incident Incident, parsedTime time.Time) error {
+	lines := []string{
+		fmt.Sprintf("%s at %s", orNA(incident.Road), orNA(incident.Location)),
+		orNA(incident.City),
+		"Lanes: " + lanesText(incident.LanesClosed, incident.LanesTotal),
+		"Severity: " + strconv.Itoa(incident.Severity),
+	}
+	message := strings.Join(lines, "\n")
+	if custom, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		message = custom
+	}
+	return postToPushover(ctx, cfg, pushoverMessage{
+		Title:     fmt.Sprintf("New %s Alert", incident.IncidentType),
+		Message:   message,
+		Priority:  cfg.priority(incident.Severity),
+		URL:       mapLink(incident.Latitude, incident.Longitude),
+		URLTitle:  "View on map",
+		Timestamp: parsedTime.Unix(),
+	})
+}
+
+// sendClearedNotificationToPushover sends a quiet cleared notification.
+func sendClearedNotificationToPushover(ctx context.Context, cfg PushoverConfig, incident ClearedIncident) error {
+	message := fmt.Sprintf("%s at %s, %s", orNA(incident.Road), orNA(incident.Location), orNA(incident.City))
+	if custom, ok := cfg.templates.render(clearedTemplateData(incident)); ok {
+		message = custom
+	}
+	return postToPushover(ctx, cfg, pushoverMessage{
+		Title:    clearedTitle(incident),
+		Message:  message,
+		Priority: -1,
+	})
+}
+
+// pushoverNotifier delivers notifications to Pushover users.
+type pushoverNotifier struct {
+	cfg PushoverConfig
+}
+
+func init() {
+	registerNotifier("pushover", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Pushover.enabled() {
+			return nil
+		}
+		c := cfg.Pushover
+		c.templates = cfg.templatesFor("pushover")
+		return pushoverNotifier{cfg: c}
+	})
+}
+
+func (p pushoverNotifier) Name() string { return "Pushover" }
+
+// NotifyBatch sends one digest notification at the priority of the most severe incident.
+func (p pushoverNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	return postToPushover(ctx, p.cfg, pushoverMessage{
+		Title:    digestTitle(batch),
+		Message:  digestText(batch, 1024, nil),
+		Priority: p.cfg.priority(maxSeverity(batch)),
+	})
+}
+
+func (p pushoverNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedNotificationToPushover(ctx, p.cfg, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return postToPushover(ctx, p.cfg, pushoverMessage{
+			Title:    updateTitle(n),
+			Message:  updateText(p.cfg.templates, n),
+			Priority: p.cfg.priority(n.Incident.Severity),
+		})
+	}
+	return sendToPushover(ctx, p.cfg, n.Incident, n.StartTime)
+}
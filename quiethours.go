@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Quiet hours modes.
+const (
+	quietModeSuppress = "suppress"
+	quietModeQueue    = "queue"
+)
+
+// QuietHoursConfig defines a daily window during which new-incident alerts are not
+// sent. In "suppress" mode they are dropped; in "queue" mode they are kept in
+// QueueFile and delivered as one catch-up summary per channel once the window ends.
+// The window may wrap past midnight, e.g. 23:00 to 06:00.
+type QuietHoursConfig struct {
+	Start     string `yaml:"start"`
+	End       string `yaml:"end"`
+	Mode      string `yaml:"mode"`
+	Timezone  string `yaml:"timezone"`
+	QueueFile string `yaml:"queue_file"`
+
+	// Parsed by load: minutes after midnight and the zone the window is in.
+	startMin, endMin int
+	loc              *time.Location
+}
+
+// QueuedAlert is a new-incident alert held back during quiet hours, for every
+// channel the routing rules send it to.
+type QueuedAlert struct {
+	Incident  savedIncident `json:"incident"`
+	StartTime time.Time     `json:"startTime"`
+}
+
+// enabled reports whether a quiet hours window has been configured.
+func (q QuietHoursConfig) enabled() bool {
+	return q.Start != "" && q.End != ""
+}
+
+// load parses the window and time zone.
+func (q *QuietHoursConfig) load() error {
+	if !q.enabled() {
+		return nil
+	}
+	var err error
+	if q.startMin, err = parseClock(q.Start); err != nil {
+		return fmt.Errorf("filters.quiet_hours.start: %w", err)
+	}
+	if q.endMin, err = parseClock(q.End); err != nil {
+		return fmt.Errorf("filters.quiet_hours.end: %w", err)
+	}
+	if q.Mode != quietModeSuppress && q.Mode != quietModeQueue {
+		return fmt.Errorf("filters.quiet_hours.mode must be %q or %q, got %q", quietModeSuppress, quietModeQueue, q.Mode)
+	}
+	if q.loc, err = time.LoadLocation(q.Timezone); err != nil {
+		return fmt.Errorf("filters.quiet_hours.timezone: %w", err)
+	}
+	return nil
+}
+
+// parseClock parses an "HH:MM" time of day into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid HH:MM time", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active reports whether now falls inside the quiet hours window.
+func (q QuietHoursConfig) active(now time.Time) bool {
+	if !q.enabled() || q.loc == nil {
+		return false
+	}
+	local := now.In(q.loc)
+	m := local.Hour()*60 + local.Minute()
+	if q.startMin <= q.endMin {
+		return m >= q.startMin && m < q.endMin
+	}
+	return m >= q.startMin || m < q.endMin // Window wraps past midnight.
+}
+
+// loadQueuedAlerts reads alerts held back during quiet hours.
+func loadQueuedAlerts(filename string) ([]QueuedAlert, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var queued []QueuedAlert
+	if err := json.Unmarshal(data, &queued); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	return queued, nil
+}
+
+// saveQueuedAlerts writes the quiet hours queue back to disk.
+func saveQueuedAlerts(filename string, queued []QueuedAlert) error {
+	if queued == nil {
+		queued = []QueuedAlert{}
+	}
+	data, err := json.MarshalIndent(queued, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// flushQueuedAlerts sends everything queued during quiet hours to the channels
+// the routing rules pick, as one digest per channel, with duplicates combined
+// as live alerts are. If a tracked channel fails, the alerts are all kept for
+// the next run, as new alerts are.
+func flushQueuedAlerts(ctx context.Context, notifiers notifierSet, queued []QueuedAlert) []QueuedAlert {
+	batch := make([]Notification, len(queued))
+	for i, q := range queued {
+		batch[i] = Notification{Kind: notifyNew, Incident: q.Incident.incident(), StartTime: q.StartTime}
+	}
+	batch = notifiers.cfg.Dedup.apply(batch, nil, nil)
+	var delivered bool
+	if len(batch) == 1 {
+		delivered = notifiers.notifyNew(ctx, batch[0].Incident, batch[0].StartTime)
+	} else {
+		delivered = notifiers.notifyBatch(ctx, batch)
+	}
+	if !delivered {
+		slog.Error("Error sending quiet hours summary; keeping the alerts for the next run", "count", len(queued))
+		return queued
+	}
+	slog.Info("Sent quiet hours summary", "count", len(queued))
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// discordMaxAttempts bounds how often one request is retried after a 429.
+	discordMaxAttempts = 5
+	// discordMaxRetryWait is the longest rate limit we will wait out for a single retry.
+	discordMaxRetryWait = 2 * time.Minute
+)
+
+// discordDo sends a JSON request to Discord, waiting out 429 rate limits and
+// retrying, so a burst of alerts is delivered late rather than dropped. Alerts are
+// sent one at a time, so blocking here also holds back the rest of the burst. It
+// also pauses when a response says the rate limit bucket is empty, to avoid the
+// 429 on the next request. Either wait ends early with ctx's error when ctx is
+// done. botToken is only sent when non-empty; webhooks don't need it.
+func discordDo(ctx context.Context, method, endpoint string, body []byte, botToken string) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if botToken != "" {
+			req.Header.Set("Authorization", "Bot "+botToken)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+				if wait := parseSeconds(resp.Header.Get("X-RateLimit-Reset-After")); wait > 0 && wait <= discordMaxRetryWait {
+					select {
+					case <-ctx.Done():
+						resp.Body.Close()
+						return nil, ctx.Err()
+					case <-time.After(wait):
+					}
+				}
+			}
+			return resp, nil
+		}
+
+		wait := discordRetryAfter(resp)
+		resp.Body.Close()
+		if attempt >= discordMaxAttempts {
+			return nil, fmt.Errorf("still rate limited by Discord after %d attempts", attempt)
+		}
+		if wait > discordMaxRetryWait {
+			return nil, fmt.Errorf("Discord rate limit of %s is too long to wait out", wait)
+		}
+		slog.Warn("Rate limited by Discord. Retrying.", "wait", wait, "attempt", attempt+1, "max_attempts", discordMaxAttempts)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// discordRetryAfter reads how long to wait from a 429 response. The JSON body's
+// retry_after has sub-second precision, so it is preferred over the header.
+func discordRetryAfter(resp *http.Response) time.Duration {
+	var body struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(data, &body) == nil && body.RetryAfter > 0 {
+		return time.Duration(body.RetryAfter * float64(time.Second))
+	}
+	if wait := parseSeconds(resp.Header.Get("Retry-After")); wait > 0 {
+		return wait
+	}
+	return time.Second // Rate limited without saying for how long; back off briefly.
+}
+
+// parseSeconds parses a (possibly fractional) number of seconds from a header value.
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDiscordDoCancelledWhileRateLimited(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(w http.ResponseWriter)
+	}{
+		{"retry after a 429", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"retry_after": 60}`))
+		}},
+		{"empty bucket", func(w http.ResponseWriter) {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "60")
+			w.WriteHeader(http.StatusNoContent)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := make(chan struct{}, discordMaxAttempts)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests <- struct{}{}
+				tt.respond(w)
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-requests
+				cancel()
+			}()
+			start := time.Now()
+			resp, err := discordDo(ctx, http.MethodPost, server.URL, []byte(`{}`), "")
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("discordDo() error = %v, want %v", err, context.Canceled)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("discordDo() took %s, want it to return promptly once cancelled", elapsed)
+			}
+			if n := len(requests); n != 0 {
+				t.Errorf("discordDo() retried %d more times after the cancellation", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReportsConfig schedules summaries built from the database. Reports go to a
+// Discord webhook and/or by email through the notifications.email SMTP settings.
+type ReportsConfig struct {
+	Timezone        string              `yaml:"timezone"`
+	DiscordWebhook  string              `yaml:"discord_webhook"`
+	EmailRecipients []string            `yaml:"email_recipients"`
+	StateFile       string              `yaml:"state_file"`
+	Daily           DailyReportConfig   `yaml:"daily"`
+	Weekly          WeeklyReportConfig  `yaml:"weekly"`
+	Hotspots        HotspotReportConfig `yaml:"hotspots"`
+
+	// loc is parsed from Timezone by load.
+	loc *time.Location
+}
+
+// DailyReportConfig sends a summary of the previous day every morning at Time.
+type DailyReportConfig struct {
+	Time string `yaml:"time"`
+
+	// minute is Time as minutes after midnight, set by load.
+	minute int
+}
+
+// enabled reports whether the daily report is scheduled.
+func (d DailyReportConfig) enabled() bool {
+	return d.Time != ""
+}
+
+// WeeklyReportConfig sends a week-over-week comparison of the last full Monday to
+// Sunday week every Day at Time.
+type WeeklyReportConfig struct {
+	Day  string `yaml:"day"`
+	Time string `yaml:"time"`
+	// Top is how many roads and counties to compare.
+	Top int `yaml:"top"`
+
+	// weekday and minute are parsed from Day and Time by load.
+	weekday time.Weekday
+	minute  int
+}
+
+// enabled reports whether the weekly report is scheduled.
+func (w WeeklyReportConfig) enabled() bool {
+	return w.Time != ""
+}
+
+// hasTargets reports whether reports have somewhere to go.
+func (r ReportsConfig) hasTargets() bool {
+	return r.DiscordWebhook != "" || len(r.EmailRecipients) > 0
+}
+
+// load parses the time zone and schedule.
+func (r *ReportsConfig) load() error {
+	var err error
+	if r.loc, err = time.LoadLocation(r.Timezone); err != nil {
+		return fmt.Errorf("reports.timezone: %w", err)
+	}
+	if r.Daily.enabled() {
+		if r.Daily.minute, err = parseClock(r.Daily.Time); err != nil {
+			return fmt.Errorf("reports.daily.time: %w", err)
+		}
+	}
+	if r.Weekly.enabled() {
+		if r.Weekly.minute, err = parseClock(r.Weekly.Time); err != nil {
+			return fmt.Errorf("reports.weekly.time: %w", err)
+		}
+		if r.Weekly.weekday, err = parseWeekday(r.Weekly.Day); err != nil {
+			return fmt.Errorf("reports.weekly.day: %w", err)
+		}
+	}
+	if r.Hotspots.enabled() {
+		if r.Hotspots.minute, err = parseClock(r.Hotspots.Time); err != nil {
+			return fmt.Errorf("reports.hotspots.time: %w", err)
+		}
+		if r.Hotspots.weekday, err = parseWeekday(r.Hotspots.Day); err != nil {
+			return fmt.Errorf("reports.hotspots.day: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseWeekday parses a day name such as "monday" or "Mon".
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if name := d.String(); strings.EqualFold(s, name) || strings.EqualFold(s, name[:3]) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a day of the week", s)
+}
+
+// roadCount is a road and how many incidents it had.
+type roadCount struct {
+	Road  string
+	Count int
+}
+
+// hourCount is how many incidents started in an hour.
+type hourCount struct {
+	Hour  time.Time
+	Count int
+}
+
+// reportPoint is an incident location for the report map.
+type reportPoint struct {
+	Latitude, Longitude float64
+	Severity            int
+}
+
+// dailyReport summarizes the incidents that started on one day.
+type dailyReport struct {
+	Day          time.Time
+	Total        int
+	Crashes      int
+	ByType       map[string]int
+	Cleared      int
+	AvgClearance time.Duration
+	BusiestRoads []roadCount
+	Points       []reportPoint
+}
+
+// maxReportPoints caps the markers on the report map to keep the image URL
+// within the Static Maps length limit.
+const maxReportPoints = 60
+
+// trend is one week's figures next to the week before's.
+type trend struct {
+	Name                     string
+	Count, PrevCount         int
+	Clearance, PrevClearance time.Duration
+}
+
+// weeklyReport compares a Monday to Sunday week with the week before it.
+type weeklyReport struct {
+	Start    time.Time
+	Total    trend
+	Roads    []trend
+	Counties []trend
+}
+
+// defaultWeeklyTop is how many roads and counties a weekly report compares when
+// reports.weekly.top is unset.
+const defaultWeeklyTop = 10
+
+// startOfWeek returns midnight on the Monday of day's week.
+func startOfWeek(day time.Time) time.Time {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// lastFullWeek returns the Monday of the last full week before day.
+func lastFullWeek(day time.Time) time.Time {
+	return startOfWeek(day).AddDate(0, 0, -7)
+}
+
+// countChange describes a count next to last week's, e.g. "14 (+3, +27%)".
+func countChange(cur, prev int) string {
+	switch {
+	case cur == prev:
+		return fmt.Sprintf("%d (no change)", cur)
+	case prev == 0:
+		return fmt.Sprintf("%d (+%d)", cur, cur)
+	}
+	return fmt.Sprintf("%d (%+d, %+.0f%%)", cur, cur-prev, float64(cur-prev)/float64(prev)*100)
+}
+
+// clearanceChange describes an average clearance time next to last week's.
+func clearanceChange(cur, prev time.Duration) string {
+	switch {
+	case cur <= 0:
+		return "N/A"
+	case prev <= 0:
+		return formatDuration(cur)
+	}
+	diff := (cur - prev).Round(time.Minute)
+	if diff == 0 {
+		return formatDuration(cur) + " (no change)"
+	}
+	sign := "+"
+	if diff < 0 {
+		sign, diff = "-", -diff
+	}
+	return fmt.Sprintf("%s (%s%s)", formatDuration(cur), sign, formatDuration(diff))
+}
+
+// trendLines formats one line per road or county.
+func trendLines(trends []trend) string {
+	var lines []string
+	for _, t := range trends {
+		lines = append(lines, fmt.Sprintf("%s: %s, clearance %s", t.Name, countChange(t.Count, t.PrevCount), clearanceChange(t.Clearance, t.PrevClearance)))
+	}
+	return orNA(strings.Join(lines, "\n"))
+}
+
+// lines returns the report as label/value pairs, shared by every format.
+func (r weeklyReport) lines() [][2]string {
+	return [][2]string{
+		{"Incidents", countChange(r.Total.Count, r.Total.PrevCount)},
+		{"Average clearance", clearanceChange(r.Total.Clearance, r.Total.PrevClearance)},
+		{"Roads", trendLines(r.Roads)},
+		{"Counties", trendLines(r.Counties)},
+	}
+}
+
+// title is the report's heading.
+func (r weeklyReport) title() string {
+	return "Weekly Trends for " + r.Start.Format("Jan 2") + " to " + r.Start.AddDate(0, 0, 6).Format("Jan 2")
+}
+
+// mapURL is always empty; weekly reports have no map.
+func (r weeklyReport) mapURL(string) string {
+	return ""
+}
+
+// reportMapURL returns a Static Maps image with a marker per incident, colored by
+// severity, or "" without an API key or incidents.
+func reportMapURL(points []reportPoint, mapsAPIKey string) string {
+	if mapsAPIKey == "" || len(points) == 0 {
+		return ""
+	}
+	colors := map[int]string{1: "yellow", 2: "orange", 3: "red"}
+	bySeverity := make(map[string][]string)
+	var order []string
+	for _, p := range points {
+		color, ok := colors[p.Severity]
+		if !ok {
+			color = "gray"
+		}
+		if _, seen := bySeverity[color]; !seen {
+			order = append(order, color)
+		}
+		bySeverity[color] = append(bySeverity[color], fmt.Sprintf("%.5f,%.5f", p.Latitude, p.Longitude))
+	}
+	q := url.Values{"size": {"640x400"}, "key": {mapsAPIKey}}
+	for _, color := range order {
+		q.Add("markers", "size:small|color:"+color+"|"+strings.Join(bySeverity[color], "|"))
+	}
+	return "https://maps.googleapis.com/maps/api/staticmap?" + q.Encode()
+}
+
+// lines returns the report as label/value pairs, shared by every format.
+func (r dailyReport) lines() [][2]string {
+	avg := "N/A"
+	if r.AvgClearance > 0 {
+		avg = formatDuration(r.AvgClearance)
+	}
+	var types []string
+	for _, t := range sortedKeys(r.ByType) {
+		types = append(types, fmt.Sprintf("%s: %d", t, r.ByType[t]))
+	}
+	var roads []string
+	for i, rc := range r.BusiestRoads {
+		roads = append(roads, fmt.Sprintf("%d. %s (%d)", i+1, rc.Road, rc.Count))
+	}
+	return [][2]string{
+		{"Incidents", fmt.Sprintf("%d (%d crashes)", r.Total, r.Crashes)},
+		{"By type", orNA(strings.Join(types, "\n"))},
+		{"Cleared", fmt.Sprintf("%d, average clearance %s", r.Cleared, avg)},
+		{"Busiest roads", orNA(strings.Join(roads, "\n"))},
+	}
+}
+
+// title is the report's heading.
+func (r dailyReport) title() string {
+	return "Daily Summary for " + r.Day.Format("Monday, Jan 2")
+}
+
+// sortedKeys returns a map's keys in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mapURL returns the map of the day's incidents, or "" without one.
+func (r dailyReport) mapURL(mapsAPIKey string) string {
+	return reportMapURL(r.Points, mapsAPIKey)
+}
+
+// summaryReport is a report that can be sent to every report target.
+type summaryReport interface {
+	title() string
+	lines() [][2]string
+	mapURL(mapsAPIKey string) string
+}
+
+// reportEmbed lays a report out as a Discord embed with its map as the image.
+func reportEmbed(r summaryReport, mapsAPIKey string) DiscordEmbed {
+	embed := DiscordEmbed{
+		Title:     r.title(),
+		Color:     colorGreen,
+		Footer:    EmbedFooter{Text: "From the NC DOT incident database"},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	for _, line := range r.lines() {
+		embed.Fields = append(embed.Fields, EmbedField{Name: line[0], Value: truncateRunes(line[1], 1024)})
+	}
+	if mapURL := r.mapURL(mapsAPIKey); mapURL != "" {
+		embed.Image = &EmbedImage{URL: mapURL}
+	}
+	return embed
+}
+
+// reportEmailTemplate lays out a report email.
+var reportEmailTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif; color: #222;">
+<h2>{{.Title}}</h2>
+<table cellpadding="6" style="border-collapse: collapse;">
+{{range .Lines}}<tr><th align="left" valign="top">{{index . 0}}</th><td style="white-space: pre-line;">{{index . 1}}</td></tr>
+{{end}}</table>
+{{if .MapURL}}<p><img src="{{.MapURL}}" alt="Map of the incidents" width="640"></p>{{end}}
+<p style="color: #888; font-size: small;">From the NC DOT incident database.</p>
+</body></html>`))
+
+// renderReportEmail renders a report as HTML.
+func renderReportEmail(title string, lines [][2]string, mapURL string) (string, error) {
+	var buf bytes.Buffer
+	err := reportEmailTemplate.Execute(&buf, map[string]any{"Title": title, "Lines": lines, "MapURL": mapURL})
+	return buf.String(), err
+}
+
+// reportText renders a report as plain text for the terminal.
+func reportText(r summaryReport) string {
+	var b strings.Builder
+	b.WriteString(r.title() + "\n")
+	for _, line := range r.lines() {
+		fmt.Fprintf(&b, "\n%s:\n  %s\n", line[0], strings.ReplaceAll(line[1], "\n", "\n  "))
+	}
+	return b.String()
+}
+
+// sendReport delivers a report to every configured target.
+func sendReport(ctx context.Context, cfg Config, report summaryReport) error {
+	var errs []error
+	if webhook := cfg.Reports.DiscordWebhook; webhook != "" {
+		if _, err := postToDiscord(ctx, webhook, reportEmbed(report, cfg.Notifications.GoogleMapsAPIKey)); err != nil {
+			errs = append(errs, fmt.Errorf("Discord: %w", err))
+		}
+	}
+	if to := cfg.Reports.EmailRecipients; len(to) > 0 {
+		html, err := renderReportEmail(report.title(), report.lines(), report.mapURL(cfg.Notifications.GoogleMapsAPIKey))
+		if err == nil {
+			err = cfg.Notifications.Email.sendMail(ctx, to, "NC DOT "+report.title(), html)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sendDueReports sends each scheduled report once its time has passed and it
+// hasn't gone out yet. It runs at the end of every cycle, so reports work the same
+// under cron and the daemon; a failed report is retried next cycle, and a report
+// whose time passed while the poller was down goes out on the next run.
+func sendDueReports(ctx context.Context, store Store, cfg Config) {
+	r := cfg.Reports
+	if (!r.Daily.enabled() && !r.Weekly.enabled() && !r.Hotspots.enabled()) || !r.hasTargets() || r.loc == nil {
+		return
+	}
+	sent, err := loadReportState(r.StateFile)
+	if err != nil {
+		slog.Error("Error loading report state", "err", err)
+		return
+	}
+	now := time.Now().In(r.loc)
+	today := r.reportDay(now)
+	changed := false
+
+	if r.Daily.enabled() && now.Sub(today) >= time.Duration(r.Daily.minute)*time.Minute {
+		if date := today.Format(time.DateOnly); sent["daily"] != date {
+			if err := sendDailyReport(ctx, store, cfg, today); err != nil {
+				slog.Error("Error sending daily report", "err", err)
+			} else {
+				sent["daily"] = date
+				changed = true
+			}
+		}
+	}
+
+	if r.Weekly.enabled() {
+		due := lastWeeklySend(today, now, r.Weekly.weekday, r.Weekly.minute)
+		if date := due.Format(time.DateOnly); sent["weekly"] != date {
+			if err := sendWeeklyReport(ctx, store, cfg, due); err != nil {
+				slog.Error("Error sending weekly report", "err", err)
+			} else {
+				sent["weekly"] = date
+				changed = true
+			}
+		}
+	}
+
+	if r.Hotspots.enabled() {
+		due := lastWeeklySend(today, now, r.Hotspots.weekday, r.Hotspots.minute)
+		if date := due.Format(time.DateOnly); sent["hotspots"] != date {
+			if err := sendHotspotReport(ctx, store, cfg, due); err != nil {
+				slog.Error("Error sending hotspot report", "err", err)
+			} else {
+				sent["hotspots"] = date
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		if err := saveReportState(r.StateFile, sent); err != nil {
+			slog.Error("Error saving report state", "err", err)
+		}
+	}
+}
+
+// lastWeeklySend returns the day of the most recent send at or before now of a
+// report scheduled every weekday at minute, where today is the start of now's day.
+func lastWeeklySend(today, now time.Time, weekday time.Weekday, minute int) time.Time {
+	due := today.AddDate(0, 0, -((int(today.Weekday()) - int(weekday) + 7) % 7))
+	if due.Add(time.Duration(minute) * time.Minute).After(now) {
+		due = due.AddDate(0, 0, -7)
+	}
+	return due
+}
+
+// sendDailyReport sends the report on the day before today.
+func sendDailyReport(ctx context.Context, store Store, cfg Config, today time.Time) error {
+	report, err := store.DailyReport(ctx, today.AddDate(0, 0, -1))
+	if err == nil {
+		err = sendReport(ctx, cfg, report)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Sent the daily report", "day", report.Day.Format(time.DateOnly))
+	return nil
+}
+
+// sendWeeklyReport sends the report on the last full week before day.
+func sendWeeklyReport(ctx context.Context, store Store, cfg Config, day time.Time) error {
+	report, err := store.WeeklyReport(ctx, lastFullWeek(day), cfg.Reports.Weekly.Top)
+	if err == nil {
+		err = sendReport(ctx, cfg, report)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Sent the weekly report", "week_of", report.Start.Format(time.DateOnly))
+	return nil
+}
+
+// reportDay returns the start of t's day in the reports' time zone.
+func (r ReportsConfig) reportDay(t time.Time) time.Time {
+	t = t.In(r.loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, r.loc)
+}
+
+// loadReportState reads the date each report was last sent, by report name.
+func loadReportState(filename string) (map[string]string, error) {
+	sent := make(map[string]string)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return sent, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sent); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	return sent, nil
+}
+
+// saveReportState writes the report state back to disk.
+func saveReportState(filename string, sent map[string]string) error {
+	data, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// reportCommand implements the "report" subcommand, printing or sending a report on demand.
+func reportCommand(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	configPath := addConfigFlag(fs)
+	date := fs.String("date", "", "day to report on as YYYY-MM-DD (default yesterday); with --weekly, any day in the week (default last week)")
+	weekly := fs.Bool("weekly", false, "compare a week with the week before instead of summarizing a day")
+	send := fs.Bool("send", false, "send the report to the configured targets instead of printing it")
+	fs.Parse(args)
+
+	cfg, err := loadCommandConfig(fs, *configPath, false)
+	if err != nil {
+		return err
+	}
+	loc := cfg.Reports.loc
+	now := time.Now().In(loc)
+	day := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, loc)
+	if *date != "" {
+		if day, err = time.ParseInLocation(time.DateOnly, *date, loc); err != nil {
+			return fmt.Errorf("--date must be YYYY-MM-DD, got %q", *date)
+		}
+	}
+	if *send && !cfg.Reports.hasTargets() {
+		return errors.New("--send needs reports.discord_webhook or reports.email_recipients")
+	}
+
+	store, err := openStore(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	var report summaryReport
+	if *weekly {
+		if *date == "" {
+			day = day.AddDate(0, 0, -6)
+		}
+		report, err = store.WeeklyReport(ctx, startOfWeek(day), cfg.Reports.Weekly.Top)
+	} else {
+		report, err = store.DailyReport(ctx, day)
+	}
+	if err != nil {
+		return err
+	}
+	if *send {
+		return sendReport(ctx, cfg, report)
+	}
+	fmt.Print(reportText(report))
+	return nil
+}
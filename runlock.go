@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errRunActive is a run that found another run still holding the run lock.
+var errRunActive = errors.New("another run is active")
+
+// acquireRunLock keeps a one-off run, as started by cron, from overlapping
+// another one that is still going, which would race it on the state files and
+// send its alerts twice. On Postgres it takes the leader election advisory
+// lock, so it also stands back for a leading daemon on any host; on other
+// databases it takes an exclusive lock on a file next to feed.state_file,
+// which covers runs on the same host. It returns errRunActive when the lock is
+// held, and otherwise a func that releases it.
+func acquireRunLock(ctx context.Context, store Store, cfg Config) (func(), error) {
+	if locking, ok := store.(LockingStore); ok && cfg.Database.Driver == driverPostgres {
+		lock, err := locking.TryAdvisoryLock(ctx, int64(cfg.Polling.LeaderElection.Key))
+		if err != nil {
+			return nil, fmt.Errorf("could not take the run lock: %w", err)
+		}
+		if lock == nil {
+			return nil, errRunActive
+		}
+		return func() { lock.release(context.Background()) }, nil
+	}
+
+	// The lock is on a file of its own: the state file is replaced on every
+	// save, and a lock on the replaced file would exclude nothing.
+	f, err := os.OpenFile(cfg.Feed.StateFile+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the run lock file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// lockFile is a no-op where flock isn't available: runs can overlap unless the
+// database is Postgres.
+func lockFile(f *os.File) error {
+	slog.Warn("File locks aren't supported on this platform; overlapping runs aren't prevented.")
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ScheduleConfig gives the daemon's tasks cron expressions (see cronSchedule)
+// in place of their fixed timing. Each is optional: polling otherwise runs
+// every polling.interval, reports at reports.daily.time and the day and
+// time of reports.weekly and reports.hotspots, and retention once a day. One-off runs ignore
+// the schedule.
+type ScheduleConfig struct {
+	// Timezone is the zone the expressions are evaluated in.
+	Timezone      string `yaml:"timezone"`
+	Poll          string `yaml:"poll"`
+	DailyReport   string `yaml:"daily_report"`
+	WeeklyReport  string `yaml:"weekly_report"`
+	HotspotReport string `yaml:"hotspot_report"`
+	Retention     string `yaml:"retention"`
+
+	// The expressions, parsed by load; nil when unset.
+	poll, dailyReport, weeklyReport, hotspotReport, retention *cronSchedule
+}
+
+// load parses the time zone and expressions.
+func (s *ScheduleConfig) load() error {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return fmt.Errorf("schedule.timezone: %w", err)
+	}
+	for _, f := range []struct {
+		name string
+		expr string
+		dst  **cronSchedule
+	}{
+		{"poll", s.Poll, &s.poll},
+		{"daily_report", s.DailyReport, &s.dailyReport},
+		{"weekly_report", s.WeeklyReport, &s.weeklyReport},
+		{"hotspot_report", s.HotspotReport, &s.hotspotReport},
+		{"retention", s.Retention, &s.retention},
+	} {
+		if f.expr == "" {
+			continue
+		}
+		if *f.dst, err = parseCron(f.expr, loc); err != nil {
+			return fmt.Errorf("schedule.%s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// pollSpacing is the longest wait between two polls, for telling a wedged
+// poller from one waiting on its schedule: polling.interval, or the adaptive
+// maximum, or with a poll schedule, its longest gap over the coming week,
+// plus any jitter.
+func (c Config) pollSpacing() time.Duration {
+	s := c.Schedule.poll
+	if s == nil {
+		return c.Polling.longestWait()
+	}
+	var longest time.Duration
+	t := s.next(time.Now())
+	for end := t.AddDate(0, 0, 7); t.Before(end); {
+		n := s.next(t)
+		longest = max(longest, n.Sub(t))
+		t = n
+	}
+	return longest + max(c.Polling.Jitter, 0)
+}
+
+// scheduledTask is a job the daemon runs on a schedule.
+type scheduledTask struct {
+	name string
+	// next returns the task's first run after t.
+	next func(t time.Time) time.Time
+	run  func()
+	// due is when the task runs next.
+	due time.Time
+}
+
+// runScheduler runs tasks when they are due until ctx is cancelled, pinging
+// systemd's watchdog while it waits. Tasks run one at a time, in order when
+// due together, so they never race on the state files; a task that came due
+// while another ran runs straight after it, once. Cancellation is only acted
+// on between tasks.
+func runScheduler(ctx context.Context, tasks []*scheduledTask) {
+	var watchdog <-chan time.Time
+	if d := watchdogInterval(); d > 0 {
+		slog.Info("Pinging the systemd watchdog", "every", d)
+		t := time.NewTicker(d)
+		defer t.Stop()
+		watchdog = t.C
+	}
+
+	for {
+		for _, t := range tasks {
+			if !t.due.After(time.Now()) {
+				t.run()
+				if t.due = t.next(t.due); t.due.Before(time.Now()) {
+					t.due = time.Now()
+				}
+			}
+		}
+		if watchdog != nil {
+			sdNotify("WATCHDOG=1")
+		}
+
+		next := tasks[0]
+		for _, t := range tasks[1:] {
+			if t.due.Before(next.due) {
+				next = t
+			}
+		}
+		timer := time.NewTimer(time.Until(next.due))
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-watchdog:
+				sdNotify("WATCHDOG=1")
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incidentFields maps each feed field Incident parses to the JSON type it
+// expects: "string", "number" or "boolean".
+var incidentFields = func() map[string]string {
+	fields := make(map[string]string)
+	t := reflect.TypeOf(Incident{})
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.String, reflect.Struct: // FeedTime is text in the feed.
+			fields[name] = "string"
+		case reflect.Bool:
+			fields[name] = "boolean"
+		default:
+			fields[name] = "number"
+		}
+	}
+	return fields
+}()
+
+// schemaDrift is how a feed payload departs from the fields Incident parses.
+// Each list is sorted.
+type schemaDrift struct {
+	// Unknown are fields the feed sent that aren't parsed, such as new or
+	// renamed ones.
+	Unknown []string `json:"unknown,omitempty"`
+	// Missing are parsed fields no incident had, such as removed or renamed ones.
+	Missing []string `json:"missing,omitempty"`
+	// Mistyped are parsed fields sent as another type, as "field: type", which
+	// are left at their zero value.
+	Mistyped []string `json:"mistyped,omitempty"`
+}
+
+// empty reports whether the payload matched the schema.
+func (d schemaDrift) empty() bool {
+	return len(d.Unknown) == 0 && len(d.Missing) == 0 && len(d.Mistyped) == 0
+}
+
+// equal reports whether two drifts list the same fields.
+func (d schemaDrift) equal(o schemaDrift) bool {
+	return slices.Equal(d.Unknown, o.Unknown) && slices.Equal(d.Missing, o.Missing) && slices.Equal(d.Mistyped, o.Mistyped)
+}
+
+// lines describes the drift, one kind of change per line.
+func (d schemaDrift) lines() []string {
+	var lines []string
+	if len(d.Unknown) > 0 {
+		lines = append(lines, "Unknown fields: "+strings.Join(d.Unknown, ", "))
+	}
+	if len(d.Missing) > 0 {
+		lines = append(lines, "Missing fields: "+strings.Join(d.Missing, ", "))
+	}
+	if len(d.Mistyped) > 0 {
+		lines = append(lines, "Unexpected types: "+strings.Join(d.Mistyped, ", "))
+	}
+	return lines
+}
+
+// checkFeedSchema compares a payload's records, decoded as raw fields, with
+// the fields Incident parses. A null is accepted for any field. With no
+// records, nothing is missing.
+func checkFeedSchema(records []map[string]json.RawMessage) schemaDrift {
+	unknown := make(map[string]bool)
+	mistyped := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, record := range records {
+		for name, raw := range record {
+			want, ok := incidentFields[name]
+			if !ok {
+				unknown[name] = true
+				continue
+			}
+			seen[name] = true
+			if got := jsonType(raw); got != "null" && got != want {
+				mistyped[name+": "+got] = true
+			}
+		}
+	}
+	drift := schemaDrift{
+		Unknown:  sortedKeys(unknown),
+		Mistyped: sortedKeys(mistyped),
+	}
+	if len(records) > 0 {
+		for name := range incidentFields {
+			if !seen[name] {
+				drift.Missing = append(drift.Missing, name)
+			}
+		}
+		slices.Sort(drift.Missing)
+	}
+	return drift
+}
+
+// jsonType names the type of a raw JSON value.
+func jsonType(raw json.RawMessage) string {
+	switch s := strings.TrimSpace(string(raw)); {
+	case s == "null":
+		return "null"
+	case s == "true" || s == "false":
+		return "boolean"
+	case strings.HasPrefix(s, `"`):
+		return "string"
+	case strings.HasPrefix(s, "{"):
+		return "object"
+	case strings.HasPrefix(s, "["):
+		return "array"
+	default:
+		return "number"
+	}
+}
+
+// schemaMonitor collects the schema drift of a cycle's feed responses, which
+// may arrive from concurrent county fetches. A nil monitor checks nothing.
+type schemaMonitor struct {
+	mu       sync.Mutex
+	unknown  map[string]bool
+	missing  map[string]bool
+	mistyped map[string]bool
+	// checked counts the payloads checked, and payloads the non-empty ones,
+	// since a field is only missing if none of them had it.
+	checked, payloads int
+}
+
+// newSchemaMonitor returns a monitor with nothing recorded.
+func newSchemaMonitor() *schemaMonitor {
+	return &schemaMonitor{unknown: make(map[string]bool), missing: make(map[string]bool), mistyped: make(map[string]bool)}
+}
+
+// record checks a payload fetched from url. A payload that doesn't parse is
+// left to the decoder to report.
+func (m *schemaMonitor) record(url string, data []byte) {
+	if m == nil {
+		return
+	}
+	var records []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &records); err != nil {
+		return
+	}
+	drift := checkFeedSchema(records)
+	if !drift.empty() {
+		moduleLogger(logModuleFeed).Debug("Feed schema drift", "url", url,
+			"unknown", drift.Unknown, "missing", drift.Missing, "mistyped", drift.Mistyped)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checked++
+	for _, name := range drift.Unknown {
+		m.unknown[name] = true
+	}
+	for _, name := range drift.Mistyped {
+		m.mistyped[name] = true
+	}
+	if len(records) == 0 {
+		return
+	}
+	// A field is only missing if every non-empty payload lacked it.
+	if m.payloads == 0 {
+		for _, name := range drift.Missing {
+			m.missing[name] = true
+		}
+	} else {
+		for name := range m.missing {
+			if !slices.Contains(drift.Missing, name) {
+				delete(m.missing, name)
+			}
+		}
+	}
+	m.payloads++
+}
+
+// drift returns what the cycle's payloads recorded.
+func (m *schemaMonitor) drift() schemaDrift {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return schemaDrift{
+		Unknown:  sortedKeys(m.unknown),
+		Missing:  sortedKeys(m.missing),
+		Mistyped: sortedKeys(m.mistyped),
+	}
+}
+
+// report logs the cycle's schema drift and, the first time the feed drifts
+// this way, posts a warning to Discord. The drift last warned about is kept
+// in cfg.SchemaStateFile, so a lasting change warns once and a feed going
+// back to normal is logged. A cycle that checked no payload, such as one
+// whose feed was unchanged, reports nothing. A nil monitor does nothing.
+func (m *schemaMonitor) report(ctx context.Context, cfg FeedConfig, webhook string) {
+	if m == nil || m.checked == 0 {
+		return
+	}
+	drift := m.drift()
+	last, err := loadSchemaState(cfg.SchemaStateFile)
+	if err != nil {
+		slog.Error("Error loading the feed schema state", "err", err)
+		return
+	}
+	if drift.empty() {
+		if !last.empty() {
+			slog.Info("The feed matches the expected schema again")
+			if err := saveSchemaState(cfg.SchemaStateFile, drift); err != nil {
+				slog.Error("Error saving the feed schema state", "err", err)
+			}
+		}
+		return
+	}
+	slog.Warn("The feed's schema has drifted from the fields parsed",
+		"unknown", drift.Unknown, "missing", drift.Missing, "mistyped", drift.Mistyped)
+	if drift.equal(last) {
+		return
+	}
+	if webhook != "" {
+		embed := DiscordEmbed{
+			Title:       "NC DOT feed schema drift",
+			Description: strings.Join(drift.lines(), "\n"),
+			Color:       colorYellow,
+			Footer:      EmbedFooter{Text: "Check the feed parsing before incidents go missing"},
+			Timestamp:   time.Now().Format(time.RFC3339),
+		}
+		if _, err := postToDiscord(ctx, webhook, embed); err != nil {
+			// Not saved, so the warning is retried next cycle.
+			slog.Error("Error sending the schema drift warning", "err", err)
+			return
+		}
+	}
+	if err := saveSchemaState(cfg.SchemaStateFile, drift); err != nil {
+		slog.Error("Error saving the feed schema state", "err", err)
+	}
+}
+
+// schemaWebhook is where schema drift warnings go.
+func (c Config) schemaWebhook() string {
+	if c.Feed.SchemaWebhook != "" {
+		return c.Feed.SchemaWebhook
+	}
+	return c.Notifications.DiscordWebhook
+}
+
+// loadSchemaState reads the schema drift last warned about.
+func loadSchemaState(filename string) (schemaDrift, error) {
+	var drift schemaDrift
+	if filename == "" {
+		return drift, nil
+	}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return drift, nil
+	}
+	if err != nil {
+		return drift, err
+	}
+	if err := json.Unmarshal(data, &drift); err != nil {
+		slog.Warn("Could not parse the feed schema state. Starting fresh.", "file", filename, "err", err)
+		return schemaDrift{}, nil
+	}
+	return drift, nil
+}
+
+// saveSchemaState records the schema drift warned about.
+func saveSchemaState(filename string, drift schemaDrift) error {
+	if filename == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(drift, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
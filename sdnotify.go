@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The daemon speaks systemd's notify protocol when systemd asks for it, with
+// no setting of its own. In a unit like
+//
+//	[Service]
+//	Type=notify
+//	WatchdogSec=5min
+//	ExecStart=/usr/local/bin/ncdot run --daemon
+//
+// systemd waits for READY=1 before counting the service as started, and
+// restarts it if the polling loop goes WatchdogSec without a ping. The loop
+// pings between cycles, so WatchdogSec must outlast the longest cycle,
+// retries and timeouts included.
+
+// sdNotify sends state to systemd's notification socket, as sd_notify(3)
+// does. Without NOTIFY_SOCKET, outside a Type=notify unit, it does nothing.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		// An abstract socket, named with a leading NUL.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Could not notify systemd", "state", state, "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("Could not notify systemd", "state", state, "err", err)
+	}
+}
+
+// watchdogInterval returns how often to ping systemd's watchdog: half of
+// WatchdogSec, as sd_watchdog_enabled(3) advises. It returns 0 when the
+// watchdog is off or meant for another process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignalConfig sends alerts through a signal-cli-rest-api instance. Number is the
+// registered sender; recipients are phone numbers or group IDs ("group.…", as
+// listed by the API's /v1/groups endpoint).
+type SignalConfig struct {
+	APIURL     string   `yaml:"api_url"`
+	Number     string   `yaml:"number"`
+	Recipients []string `yaml:"recipients"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether Signal alerts are configured.
+func (s SignalConfig) enabled() bool {
+	return s.APIURL != "" && s.Number != "" && len(s.Recipients) > 0
+}
+
+// signalMessage is the /v2/send request body. Styled text mode renders **bold**.
+type signalMessage struct {
+	Message    string   `json:"message"`
+	Number     string   `json:"number"`
+	Recipients []string `json:"recipients"`
+	TextMode   string   `json:"text_mode"`
+}
+
+// postToSignal sends one message to every recipient.
+func postToSignal(ctx context.Context, cfg SignalConfig, text string) error {
+	msg := signalMessage{Message: text, Number: cfg.Number, Recipients: cfg.Recipients, TextMode: "styled"}
+	return postJSON(ctx, strings.TrimRight(cfg.APIURL, "/")+"/v2/send", msg, nil, nil)
+}
+
+// sendToSignal sends a new-incident alert.
+func sendToSignal(ctx context.Context, cfg SignalConfig, incident Incident, parsedTime time.Time) error {
+	lines := []string{
+		fmt.Sprintf("🚨 **New %s Alert**", incident.IncidentType),
+		"Road: " + orNA(incident.Road),
+		"Location: " + orNA(incident.Location),
+		"City: " + orNA(incident.City),
+		"Lanes: " + lanesText(incident.LanesClosed, incident.LanesTotal),
+		"Severity: " + strconv.Itoa(incident.Severity),
+		"Started: " + formatLocalTime(parsedTime),
+		mapLink(incident.Latitude, incident.Longitude),
+	}
+	text := strings.Join(lines, "\n")
+	if custom, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		text = custom
+	}
+	return postToSignal(ctx, cfg, text)
+}
+
+// sendClearedNotificationToSignal sends a cleared notification.
+func sendClearedNotificationToSignal(ctx context.Context, cfg SignalConfig, incident ClearedIncident) error {
+	text := fmt.Sprintf("✅ **%s**\n%s at %s, %s", clearedTitle(incident), orNA(incident.Road), orNA(incident.Location), orNA(incident.City))
+	if custom, ok := cfg.templates.render(clearedTemplateData(incident)); ok {
+		text = custom
+	}
+	return postToSignal(ctx, cfg, text)
+}
+
+// signalNotifier delivers notifications to Signal recipients.
+type signalNotifier struct {
+	cfg SignalConfig
+}
+
+func init() {
+	registerNotifier("signal", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Signal.enabled() {
+			return nil
+		}
+		c := cfg.Signal
+		c.templates = cfg.templatesFor("signal")
+		return signalNotifier{cfg: c}
+	})
+}
+
+func (s signalNotifier) Name() string { return "Signal" }
+
+// NotifyBatch sends one digest message.
+func (s signalNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	return postToSignal(ctx, s.cfg, fmt.Sprintf("🚨 **%s**\n%s", digestTitle(batch), digestText(batch, 0, nil)))
+}
+
+func (s signalNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedNotificationToSignal(ctx, s.cfg, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return postToSignal(ctx, s.cfg, fmt.Sprintf("⚠️ **%s**\n%s", updateTitle(n), updateText(s.cfg.templates, n)))
+	}
+	return sendToSignal(ctx, s.cfg, n.Incident, n.StartTime)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The credentials and time of AWS's SigV4 test suite.
+var sigv4TestCredentials = awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+func TestSignAWSRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"get-vanilla", http.MethodGet, "https://example.amazonaws.com/", "", "",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, " +
+				"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", http.MethodGet, "https://example.amazonaws.com/?Param2=value2&Param1=value1", "", "",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, " +
+				"Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"post-x-www-form-urlencoded", http.MethodPost, "https://example.amazonaws.com/", "application/x-www-form-urlencoded", "Param1=value1",
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, " +
+				"Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			signAWSRequest(req, []byte(tt.body), sigv4TestCredentials, "us-east-1", "service", now)
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWSCanonicalQuery(t *testing.T) {
+	query := url.Values{"b": {"two words"}, "a-b": {"1"}, "a": {"~*", "x+y"}}
+	if got, want := awsCanonicalQuery(query), "a=x%2By&a=~%2A&a-b=1&b=two%20words"; got != want {
+		t.Errorf("awsCanonicalQuery() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slackPostMessageURL is the Web API method used when posting with a bot token.
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// SlackConfig sends alerts to Slack, either through an incoming webhook or with a
+// bot token and a channel. Both may be set; each is posted to.
+type SlackConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+	BotToken   string `yaml:"bot_token"`
+	Channel    string `yaml:"channel"`
+}
+
+// enabled reports whether Slack alerts have a default destination.
+func (s SlackConfig) enabled() bool {
+	return s.WebhookURL != "" || (s.BotToken != "" && s.Channel != "")
+}
+
+// slackTarget is one place a Slack message goes: an incoming webhook or a channel.
+type slackTarget struct {
+	WebhookURL string
+	Channel    string
+}
+
+// slackTargetsFor returns every Slack destination an incident should be posted to:
+// the default webhook and channel plus each matching Slack route, narrowed down by
+// the first matching rule.
+func (n NotificationConfig) slackTargetsFor(countyID int, incidentType string, severity int) []slackTarget {
+	rule := n.ruleFor(countyID, incidentType, severity)
+	var targets []slackTarget
+	if rule == nil || slices.Contains(rule.Targets, routeTypeSlack) {
+		if n.Slack.WebhookURL != "" {
+			targets = append(targets, slackTarget{WebhookURL: n.Slack.WebhookURL})
+		}
+		if n.Slack.BotToken != "" && n.Slack.Channel != "" {
+			targets = append(targets, slackTarget{Channel: n.Slack.Channel})
+		}
+	}
+	for _, route := range n.Routes {
+		if !route.isType(routeTypeSlack) || !route.matches(countyID, incidentType, severity) ||
+			(rule != nil && !slices.Contains(rule.Targets, route.Name)) {
+			continue
+		}
+		target := slackTarget{WebhookURL: route.Webhook}
+		if route.Webhook == "" {
+			target = slackTarget{Channel: route.Channel}
+		}
+		if !containsSlackTarget(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// containsSlackTarget reports whether target is already in targets.
+func containsSlackTarget(targets []slackTarget, target slackTarget) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Block Kit structures; only the pieces the alerts use.
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type      string      `json:"type"`
+	Text      *slackText  `json:"text,omitempty"`
+	Fields    []slackText `json:"fields,omitempty"`
+	Elements  []slackText `json:"elements,omitempty"`
+	Accessory *slackImage `json:"accessory,omitempty"`
+}
+
+type slackImage struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+type slackMessage struct {
+	Channel string       `json:"channel,omitempty"`
+	Text    string       `json:"text"`
+	Blocks  []slackBlock `json:"blocks"`
+}
+
+// slackEscaper escapes the characters mrkdwn treats as markup, so feed text
+// can't break a message or its links.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackEscape escapes text for use in mrkdwn.
+func slackEscape(s string) string {
+	return slackEscaper.Replace(s)
+}
+
+// slackField formats a label and escaped value as a Block Kit section field.
+func slackField(label, value string) slackText {
+	return slackMarkupField(label, slackEscape(orNA(value)))
+}
+
+// slackMarkupField formats a label and mrkdwn text as a Block Kit section field.
+func slackMarkupField(label, text string) slackText {
+	return slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", label, text)}
+}
+
+// buildSlackIncidentBlocks lays out a new incident like the Discord embed.
+func buildSlackIncidentBlocks(incident Incident, parsedTime time.Time, maps StaticMapConfig) []slackBlock {
+	title := fmt.Sprintf("New %s Alert", incident.IncidentType)
+	details := slackBlock{
+		Type: "section",
+		Fields: []slackText{
+			slackField("Reason", incident.Reason),
+			slackField("Road", incident.Road),
+			slackField("Location", incident.Location),
+			slackField("City", incident.City),
+			slackField("Lanes", lanesText(incident.LanesClosed, incident.LanesTotal)),
+			slackField("Severity", strconv.Itoa(incident.Severity)),
+		},
+	}
+	if incident.Weather != "" {
+		details.Fields = append(details.Fields, slackField("Weather", incident.Weather))
+	}
+	if len(incident.Duplicates) > 0 {
+		details.Fields = append(details.Fields, slackMarkupField("Also reported as", duplicatesText(incident, func(l duplicateLink) string {
+			return "<" + l.URL + "|" + slackEscape(l.Label) + ">"
+		})))
+	}
+	if mapURL := maps.url(incident); mapURL != "" {
+		details.Accessory = &slackImage{Type: "image", ImageURL: mapURL, AltText: "Incident location"}
+	}
+	return []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+		details,
+		{Type: "context", Elements: []slackText{{
+			Type: "mrkdwn",
+			Text: fmt.Sprintf("Incident #%d · Started <!date^%d^{date_short_pretty} {time}|%s> · Fetched from NC DOT API",
+				incident.ID, parsedTime.Unix(), formatLocalTime(parsedTime)),
+		}}},
+	}
+}
+
+// buildSlackClearedBlocks lays out a cleared notification.
+func buildSlackClearedBlocks(incident ClearedIncident) []slackBlock {
+	return []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: clearedTitle(incident)}},
+		{Type: "section", Fields: []slackText{
+			slackField("Road", incident.Road),
+			slackField("Location", incident.Location),
+			slackField("City", incident.City),
+		}},
+		{Type: "context", Elements: []slackText{{
+			Type: "mrkdwn",
+			Text: fmt.Sprintf("Incident #%d · No longer in NC DOT feed", incident.ID),
+		}}},
+	}
+}
+
+// applySlackTemplate replaces the details section of built-in blocks with
+// templated mrkdwn text and returns the text for use as the notification fallback.
+func applySlackTemplate(blocks []slackBlock, text string) string {
+	blocks[1].Text, blocks[1].Fields = &slackText{Type: "mrkdwn", Text: text}, nil
+	return text
+}
+
+// postToSlack sends a message to an incoming webhook, or with the bot token to a channel.
+func postToSlack(ctx context.Context, botToken string, target slackTarget, text string, blocks []slackBlock) error {
+	msg := slackMessage{Text: text, Blocks: blocks}
+	endpoint := target.WebhookURL
+	if endpoint == "" {
+		msg.Channel = target.Channel
+		endpoint = slackPostMessageURL
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error creating Slack payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if target.WebhookURL == "" {
+		req.Header.Set("Authorization", "Bearer "+botToken)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending to Slack: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Slack returned non-2xx status: %s", resp.Status)
+	}
+	// The Web API reports failures in the body with a 200 status.
+	if target.WebhookURL == "" {
+		var result struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("could not read Slack response: %w", err)
+		}
+		if !result.OK {
+			return fmt.Errorf("Slack API error: %s", result.Error)
+		}
+	}
+	return nil
+}
+
+// sendToSlack posts a new-incident alert to every matching Slack destination.
+func sendToSlack(ctx context.Context, notifications NotificationConfig, incident Incident, parsedTime time.Time) error {
+	blocks := buildSlackIncidentBlocks(incident, parsedTime, notifications.staticMap())
+	text := slackEscape(fmt.Sprintf("New %s: %s, %s", incident.IncidentType, orNA(incident.Road), orNA(incident.Location)))
+	if custom, ok := notifications.templatesFor("slack").render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		text = applySlackTemplate(blocks, custom)
+	}
+	targets := notifications.slackTargetsFor(incident.CountyID, incident.IncidentType, incident.Severity)
+	var errs []error
+	for _, target := range targets {
+		if err := postToSlack(ctx, notifications.Slack.BotToken, target, text, blocks); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return targetErrors(len(targets)-len(errs), errs)
+}
+
+// sendClearedNotificationToSlack posts a cleared notification to every matching Slack destination.
+func sendClearedNotificationToSlack(ctx context.Context, notifications NotificationConfig, incident ClearedIncident) error {
+	blocks := buildSlackClearedBlocks(incident)
+	text := slackEscape(fmt.Sprintf("Incident cleared: %s, %s", orNA(incident.Road), orNA(incident.Location)))
+	if custom, ok := notifications.templatesFor("slack").render(clearedTemplateData(incident)); ok {
+		text = applySlackTemplate(blocks, custom)
+	}
+	targets := notifications.slackTargetsFor(incident.CountyID, incident.IncidentType, incident.Severity)
+	var errs []error
+	for _, target := range targets {
+		if err := postToSlack(ctx, notifications.Slack.BotToken, target, text, blocks); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return targetErrors(len(targets)-len(errs), errs)
+}
+
+// sendUpdateToSlack posts an escalation, reopening or update to every matching Slack destination.
+func sendUpdateToSlack(ctx context.Context, notifications NotificationConfig, n Notification) error {
+	title := updateTitle(n)
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: slackUpdateText(notifications.templatesFor("slack"), n)}},
+	}
+	targets := notifications.slackTargetsFor(n.Incident.CountyID, n.Incident.IncidentType, n.Incident.Severity)
+	var errs []error
+	for _, target := range targets {
+		if err := postToSlack(ctx, notifications.Slack.BotToken, target, title, blocks); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return targetErrors(len(targets)-len(errs), errs)
+}
+
+// slackUpdateText is updateText with the default text escaped for mrkdwn; the
+// "updated" template's output is used as written.
+func slackUpdateText(templates MessageTemplates, n Notification) string {
+	data := newTemplateData(templateUpdated, n.Incident, n.StartTime)
+	data.Changes = n.Changes
+	if text, ok := templates.render(data); ok {
+		return text
+	}
+	return slackEscape(updateText(MessageTemplates{}, n))
+}
+
+// slackNotifier delivers notifications to Slack.
+type slackNotifier struct {
+	cfg NotificationConfig
+}
+
+func init() {
+	registerNotifier("slack", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Slack.enabled() && !slices.ContainsFunc(cfg.Routes, func(r Route) bool { return r.isType(routeTypeSlack) }) {
+			return nil
+		}
+		return slackNotifier{cfg: cfg}
+	})
+}
+
+func (s slackNotifier) Name() string { return "Slack" }
+
+// NotifyBatch posts one digest per Slack destination listing every incident routed to it.
+func (s slackNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	var targets []slackTarget
+	byTarget := make(map[slackTarget][]Notification)
+	for _, n := range batch {
+		for _, target := range s.cfg.slackTargetsFor(n.Incident.CountyID, n.Incident.IncidentType, n.Incident.Severity) {
+			if _, ok := byTarget[target]; !ok {
+				targets = append(targets, target)
+			}
+			byTarget[target] = append(byTarget[target], n)
+		}
+	}
+
+	var errs []error
+	for _, target := range targets {
+		group := byTarget[target]
+		title := digestTitle(group)
+		blocks := []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: title}},
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: digestText(group, 3000, slackDigestLine)}},
+		}
+		if err := postToSlack(ctx, s.cfg.Slack.BotToken, target, title, blocks); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return targetErrors(len(targets)-len(errs), errs)
+}
+
+// slackDigestLine is digestLine with the road linked to the map.
+func slackDigestLine(incident Incident) string {
+	return fmt.Sprintf("*%s*: <%s|%s> at %s, %s (lanes %s, severity %d)",
+		slackEscape(incident.IncidentType), mapLink(incident.Latitude, incident.Longitude), slackEscape(orNA(incident.Road)),
+		slackEscape(orNA(incident.Location)), slackEscape(orNA(incident.City)),
+		lanesText(incident.LanesClosed, incident.LanesTotal), incident.Severity)
+}
+
+func (s slackNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedNotificationToSlack(ctx, s.cfg, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return sendUpdateToSlack(ctx, s.cfg, n)
+	}
+	return sendToSlack(ctx, s.cfg, n.Incident, n.StartTime)
+}
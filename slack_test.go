@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackEscapesFeedText(t *testing.T) {
+	incident := Incident{
+		ID:           1,
+		IncidentType: "Vehicle Crash",
+		Road:         "US-1 <Bus> & I-40",
+		Location:     "Exit 293 <Jones St>",
+		City:         "Raleigh & Cary",
+		Reason:       "Crash > 3 vehicles",
+		Duplicates:   []Incident{{ID: 2, Road: "US-1 <Bus>"}},
+	}
+	const road = "US-1 &lt;Bus&gt; &amp; I-40"
+
+	blocks := buildSlackIncidentBlocks(incident, time.Now(), StaticMapConfig{})
+	var fields []string
+	for _, f := range blocks[1].Fields {
+		fields = append(fields, f.Text)
+	}
+	text := strings.Join(fields, "\n")
+	for _, want := range []string{road, "Exit 293 &lt;Jones St&gt;", "Raleigh &amp; Cary", "Crash &gt; 3 vehicles", "|#2 US-1 &lt;Bus&gt;>"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("incident blocks %q don't contain %q", text, want)
+		}
+	}
+
+	line := slackDigestLine(incident)
+	if want := "|" + road + "> at Exit 293 &lt;Jones St&gt;, Raleigh &amp; Cary"; !strings.Contains(line, want) {
+		t.Errorf("slackDigestLine() = %q, want it to contain %q", line, want)
+	}
+
+	update := slackUpdateText(MessageTemplates{}, Notification{Kind: notifyUpdated, Incident: incident, Changes: []string{"Road: I-40 → US-1 <Bus>"}})
+	if !strings.HasPrefix(update, road+" at ") || !strings.Contains(update, "US-1 &lt;Bus&gt;\n") {
+		t.Errorf("slackUpdateText() = %q, want the road and changes escaped", update)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// twilioAPIBase is the Twilio REST API root.
+const twilioAPIBase = "https://api.twilio.com/2010-04-01"
+
+// SMS providers.
+const (
+	smsProviderTwilio  = "twilio"
+	smsProviderGateway = "gateway"
+)
+
+// SMSConfig texts critical incidents to phone numbers, through Twilio or a generic
+// gateway that accepts a JSON POST of {"to", "from", "body"}. Only incidents at or
+// above MinSeverity, or with every lane closed when FullClosure is set, are sent,
+// and each number gets at most MaxPerHour messages.
+type SMSConfig struct {
+	Provider    string   `yaml:"provider"`
+	AccountSID  string   `yaml:"account_sid"`
+	AuthToken   string   `yaml:"auth_token"`
+	GatewayURL  string   `yaml:"gateway_url"`
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+	MinSeverity int      `yaml:"min_severity"`
+	FullClosure bool     `yaml:"full_closure"`
+	MaxLength   int      `yaml:"max_length"`
+	MaxPerHour  int      `yaml:"max_per_hour"`
+	StateFile   string   `yaml:"state_file"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether SMS alerts are configured.
+func (s SMSConfig) enabled() bool {
+	return len(s.To) > 0 && (s.AccountSID != "" || s.GatewayURL != "")
+}
+
+// qualifies reports whether an incident is critical enough to text about.
+func (s SMSConfig) qualifies(incident Incident) bool {
+	if s.MinSeverity > 0 && incident.Severity >= s.MinSeverity {
+		return true
+	}
+	return s.FullClosure && isFullClosure(incident)
+}
+
+// smsSender delivers one text message.
+type smsSender interface {
+	send(ctx context.Context, to, body string) error
+}
+
+// sender returns the configured provider.
+func (s SMSConfig) sender() smsSender {
+	if s.Provider == smsProviderGateway {
+		return gatewaySender{url: s.GatewayURL, from: s.From}
+	}
+	return twilioSender{accountSID: s.AccountSID, authToken: s.AuthToken, from: s.From}
+}
+
+// twilioSender sends through the Twilio Messages API.
+type twilioSender struct {
+	accountSID, authToken, from string
+}
+
+func (t twilioSender) send(ctx context.Context, to, body string) error {
+	form := url.Values{"To": {to}, "From": {t.from}, "Body": {body}}
+	endpoint := fmt.Sprintf("%s/Accounts/%s/Messages.json", twilioAPIBase, url.PathEscape(t.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.accountSID, t.authToken)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("Twilio returned %s: %s", resp.Status, apiErr.Message)
+	}
+	return nil
+}
+
+// gatewaySender POSTs to a generic SMS gateway.
+type gatewaySender struct {
+	url, from string
+}
+
+func (g gatewaySender) send(ctx context.Context, to, body string) error {
+	payload := map[string]string{"to": to, "from": g.from, "body": body}
+	return postJSON(ctx, g.url, payload, nil, nil)
+}
+
+// buildSMSText summarizes an incident in one line and truncates it to maxLength
+// characters, keeping the map link only if it fits.
+func buildSMSText(incident Incident, maxLength int) string {
+	text := fmt.Sprintf("NC DOT %s: %s at %s, %s. Lanes %s. Sev %d.",
+		incident.IncidentType, orNA(incident.Road), orNA(incident.Location), orNA(incident.City),
+		lanesText(incident.LanesClosed, incident.LanesTotal), incident.Severity)
+	link := mapLink(incident.Latitude, incident.Longitude)
+	if maxLength <= 0 {
+		return text + " " + link
+	}
+	if len([]rune(text))+1+len(link) <= maxLength {
+		return text + " " + link
+	}
+	return truncateRunes(text, maxLength)
+}
+
+// truncateRunes shortens s to at most n characters, ending with an ellipsis if cut.
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 1 {
+		return string(r[:n])
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// loadSMSLog reads recent send times per number; a missing file is an empty log.
+func loadSMSLog(filename string) (map[string][]time.Time, error) {
+	sent := make(map[string][]time.Time)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return sent, nil
+	} else if err != nil {
+		return sent, err
+	}
+	if err := json.Unmarshal(data, &sent); err != nil {
+		return make(map[string][]time.Time), fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	return sent, nil
+}
+
+// saveSMSLog writes the send log, dropping entries older than an hour.
+func saveSMSLog(filename string, sent map[string][]time.Time) error {
+	cutoff := time.Now().Add(-time.Hour)
+	for number, times := range sent {
+		var recent []time.Time
+		for _, t := range times {
+			if t.After(cutoff) {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(sent, number)
+		} else {
+			sent[number] = recent
+		}
+	}
+	data, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// sentInLastHour counts messages sent to a number in the past hour.
+func sentInLastHour(times []time.Time) int {
+	cutoff := time.Now().Add(-time.Hour)
+	n := 0
+	for _, t := range times {
+		if t.After(cutoff) {
+			n++
+		}
+	}
+	return n
+}
+
+// sendToSMS texts a qualifying incident to every number still under its hourly limit.
+func sendToSMS(ctx context.Context, cfg SMSConfig, incident Incident, parsedTime time.Time) error {
+	if !cfg.qualifies(incident) {
+		return nil
+	}
+	body := buildSMSText(incident, cfg.MaxLength)
+	if custom, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		body = custom
+		if cfg.MaxLength > 0 {
+			body = truncateRunes(body, cfg.MaxLength)
+		}
+	}
+	return textAll(ctx, cfg, incident, body)
+}
+
+// sendEscalationToSMS texts an escalation or reopening when the incident is critical enough.
+func sendEscalationToSMS(ctx context.Context, cfg SMSConfig, n Notification) error {
+	if !cfg.qualifies(n.Incident) {
+		return nil
+	}
+	body := updateTitle(n) + ": " + updateText(cfg.templates, n)
+	if cfg.MaxLength > 0 {
+		body = truncateRunes(body, cfg.MaxLength)
+	}
+	return textAll(ctx, cfg, n.Incident, body)
+}
+
+// textAll texts body about an incident to every number still under its hourly limit.
+func textAll(ctx context.Context, cfg SMSConfig, incident Incident, body string) error {
+	var errs []error
+	sent, err := loadSMSLog(cfg.StateFile)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("could not load send log: %w", err))
+	}
+
+	sender := cfg.sender()
+	delivered := 0
+	for _, number := range cfg.To {
+		if cfg.MaxPerHour > 0 && sentInLastHour(sent[number]) >= cfg.MaxPerHour {
+			slog.Warn("SMS hourly limit reached. Not texting.", "limit", cfg.MaxPerHour, "number", number, "incident_id", incident.ID)
+			continue
+		}
+		if err := sender.send(ctx, number, body); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", number, err))
+			continue
+		}
+		sent[number] = append(sent[number], time.Now())
+		delivered++
+	}
+
+	if err := saveSMSLog(cfg.StateFile, sent); err != nil {
+		errs = append(errs, fmt.Errorf("could not save send log: %w", err))
+	}
+	return targetErrors(delivered, errs)
+}
+
+// smsNotifier delivers notifications to phone numbers by text message. Only new,
+// escalated and reopened incidents are texted.
+type smsNotifier struct {
+	cfg SMSConfig
+}
+
+func init() {
+	registerNotifier("sms", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.SMS.enabled() {
+			return nil
+		}
+		c := cfg.SMS
+		c.templates = cfg.templatesFor("sms")
+		return smsNotifier{cfg: c}
+	})
+}
+
+func (s smsNotifier) Name() string { return "SMS" }
+
+func (s smsNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return nil
+	case notifyEscalated, notifyReopened:
+		return sendEscalationToSMS(ctx, s.cfg, n)
+	case notifyUpdated:
+		return nil
+	}
+	return sendToSMS(ctx, s.cfg, n.Incident, n.StartTime)
+}
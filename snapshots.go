@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// feedSnapshot is one response from a feed URL, as received.
+type feedSnapshot struct {
+	ID        int64
+	FetchedAt time.Time
+	URL       string
+	// CountyID is the county a per-county URL was fetched for, or 0.
+	CountyID   int
+	StatusCode int
+	Header     http.Header
+	Duration   time.Duration
+	Payload    []byte
+	// Error is why the payload didn't decode, if it didn't.
+	Error string
+}
+
+// sha256 returns the hex digest of the payload, which identifies repeats.
+func (s feedSnapshot) sha256() string {
+	sum := sha256.Sum256(s.Payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// payloadJSON returns the payload as it is stored: itself when it is JSON, or
+// else as a JSON string, so the JSON columns still take error pages.
+func (s feedSnapshot) payloadJSON() string {
+	if json.Valid(s.Payload) {
+		return string(s.Payload)
+	}
+	quoted, _ := json.Marshal(string(s.Payload))
+	return string(quoted)
+}
+
+// snapshotRecorder collects a cycle's feed responses, which may arrive from
+// concurrent county fetches. A nil recorder records nothing.
+type snapshotRecorder struct {
+	mu        sync.Mutex
+	snapshots []feedSnapshot
+}
+
+// record adds a response.
+func (r *snapshotRecorder) record(s feedSnapshot) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshots = append(r.snapshots, s)
+}
+
+// save stores the recorded responses. Failing to is logged rather than
+// stopping the cycle.
+func (r *snapshotRecorder) save(ctx context.Context, store Store) {
+	if r == nil || len(r.snapshots) == 0 {
+		return
+	}
+	n, err := store.SaveFeedSnapshots(ctx, r.snapshots)
+	if err != nil {
+		slog.Error("Error saving feed snapshots", "err", err)
+		return
+	}
+	if skipped := len(r.snapshots) - n; skipped > 0 {
+		slog.Info("Saved feed snapshots", "count", n, "unchanged", skipped)
+	} else {
+		slog.Info("Saved feed snapshots", "count", n)
+	}
+}
+
+// snapshotPageSize is how many snapshots FeedSnapshots reads per query.
+const snapshotPageSize = 50
+
+// latestSnapshotHashesQuery reads the payload digest of the newest snapshot of
+// each URL in the list the caller appends.
+const latestSnapshotHashesQuery = `
+	SELECT url, payload_sha256 FROM feed_snapshots f
+	WHERE snapshot_id = (SELECT MAX(snapshot_id) FROM feed_snapshots WHERE url = f.url)
+		AND url IN `
+
+// SaveFeedSnapshots stores the snapshots whose payload differs from the newest
+// one stored for the same URL, in one transaction, returning how many it stored.
+func (s *sqlStore) SaveFeedSnapshots(ctx context.Context, snapshots []feedSnapshot) (int, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	var saved int
+	err := withReconnect(s.db, func() error {
+		saved = 0
+		tx, err := s.db.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() // No-op once committed.
+
+		urls := make([]any, len(snapshots))
+		for i, snapshot := range snapshots {
+			urls[i] = snapshot.URL
+		}
+		latest := make(map[string]string, len(snapshots))
+		rows, err := tx.QueryContext(ctx, latestSnapshotHashesQuery+placeholderList(len(urls)), urls...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var url, digest string
+			if err := rows.Scan(&url, &digest); err != nil {
+				rows.Close()
+				return err
+			}
+			latest[url] = digest
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, snapshot := range snapshots {
+			digest := snapshot.sha256()
+			if latest[snapshot.URL] == digest {
+				continue
+			}
+			header, err := json.Marshal(snapshot.Header)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO feed_snapshots (fetched_at, url, county_id, status_code, headers, payload, payload_sha256, duration_ms, error)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+				snapshot.FetchedAt, snapshot.URL, snapshot.CountyID, snapshot.StatusCode, string(header),
+				snapshot.payloadJSON(), digest, snapshot.Duration.Milliseconds(), snapshot.Error)
+			if err != nil {
+				return err
+			}
+			latest[snapshot.URL] = digest
+			saved++
+		}
+		return tx.Commit()
+	})
+	return saved, err
+}
+
+// FeedSnapshots calls fn with each snapshot fetched since since, oldest first.
+// Snapshots are read a page at a time, so fn may use the store.
+func (s *sqlStore) FeedSnapshots(ctx context.Context, since time.Time, fn func(feedSnapshot) error) error {
+	var after int64
+	for {
+		page, err := s.feedSnapshotPage(ctx, since, after)
+		if err != nil {
+			return err
+		}
+		for _, snapshot := range page {
+			if err := fn(snapshot); err != nil {
+				return err
+			}
+			after = snapshot.ID
+		}
+		if len(page) < snapshotPageSize {
+			return nil
+		}
+	}
+}
+
+// feedSnapshotPage reads the snapshots after ID after that were fetched since since.
+func (s *sqlStore) feedSnapshotPage(ctx context.Context, since time.Time, after int64) ([]feedSnapshot, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT snapshot_id, fetched_at, url, COALESCE(county_id, 0), COALESCE(status_code, 0), headers,
+			payload, COALESCE(duration_ms, 0), COALESCE(error, '')
+		FROM feed_snapshots
+		WHERE snapshot_id > $1 AND fetched_at >= $2
+		ORDER BY snapshot_id
+		LIMIT $3`, after, since, snapshotPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var page []feedSnapshot
+	for rows.Next() {
+		var snapshot feedSnapshot
+		var header sql.NullString
+		var payload string
+		var durationMS int64
+		if err := rows.Scan(&snapshot.ID, &snapshot.FetchedAt, &snapshot.URL, &snapshot.CountyID, &snapshot.StatusCode,
+			&header, &payload, &durationMS, &snapshot.Error); err != nil {
+			return nil, err
+		}
+		if header.Valid {
+			// Headers are only for reading; a bad value leaves them empty.
+			json.Unmarshal([]byte(header.String), &snapshot.Header)
+		}
+		snapshot.Payload = []byte(payload)
+		snapshot.Duration = time.Duration(durationMS) * time.Millisecond
+		page = append(page, snapshot)
+	}
+	return page, rows.Err()
+}
+
+// PurgeFeedSnapshots deletes snapshots fetched before cutoff.
+func (s *sqlStore) PurgeFeedSnapshots(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, "DELETE FROM feed_snapshots WHERE fetched_at < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// storedPayload undoes payloadJSON for a body that wasn't JSON.
+func storedPayload(payload []byte) []byte {
+	var text string
+	if strings.HasPrefix(string(payload), `"`) && json.Unmarshal(payload, &text) == nil {
+		return []byte(text)
+	}
+	return payload
+}
+
+// replayFeedSnapshots upserts the incidents of every snapshot fetched since
+// since, as backfill does with saved payloads, returning how many were stored
+// and how many failed.
+func replayFeedSnapshots(ctx context.Context, store Store, since time.Time, types IncidentTypeFilter) (stored, failed int, err error) {
+	err = store.FeedSnapshots(ctx, since, func(snapshot feedSnapshot) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		incidents, err := decodeIncidents(storedPayload(snapshot.Payload))
+		if err != nil {
+			slog.Warn("Skipping snapshot", "snapshot_id", snapshot.ID, "url", snapshot.URL, "fetched_at", snapshot.FetchedAt, "err", err)
+			return nil
+		}
+		for i := range incidents {
+			if incidents[i].CountyID == 0 {
+				incidents[i].CountyID = snapshot.CountyID
+			}
+		}
+		incidents = types.apply(incidents)
+		if _, err := store.UpsertIncidents(ctx, incidents); err != nil {
+			slog.Error("Error upserting incidents from snapshot", "count", len(incidents), "snapshot_id", snapshot.ID, "err", err)
+			failed += len(incidents)
+			return nil
+		}
+		stored += len(incidents)
+		return nil
+	})
+	return stored, failed, err
+}
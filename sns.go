@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SNSConfig publishes events to an AWS SNS topic, from which they can fan out to
+// SQS, Lambda, email or SMS subscriptions. Every message carries event, county,
+// county_id, type and severity message attributes for subscription filter
+// policies. SMS subscribers get a one-line summary instead of the JSON. Credentials
+// default to the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables; the region comes from the topic ARN.
+type SNSConfig struct {
+	TopicARN        string `yaml:"topic_arn"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+}
+
+// enabled reports whether SNS publishing is configured.
+func (s SNSConfig) enabled() bool {
+	return s.TopicARN != ""
+}
+
+// region extracts the region from the topic ARN, arn:aws:sns:<region>:<account>:<name>.
+func (s SNSConfig) region() string {
+	parts := strings.Split(s.TopicARN, ":")
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[3]
+}
+
+// credentials returns the configured credentials, falling back to the environment.
+func (s SNSConfig) credentials() awsCredentials {
+	creds := awsCredentials{AccessKeyID: s.AccessKeyID, SecretAccessKey: s.SecretAccessKey, SessionToken: s.SessionToken}
+	if creds.AccessKeyID == "" {
+		creds = awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	return creds
+}
+
+// snsSummary is the short text sent to SMS subscribers.
+func snsSummary(event IncidentEvent) string {
+	i := event.Incident
+	return truncateRunes(fmt.Sprintf("NC DOT %s %s: %s at %s, %s", i.IncidentType, event.Event, orNA(i.Road), orNA(i.Location), orNA(i.City)), 140)
+}
+
+// snsPublishForm builds the Publish API form for one event.
+func (s SNSConfig) snsPublishForm(event IncidentEvent) (url.Values, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	// With MessageStructure=json each protocol can get its own message.
+	message, err := json.Marshal(map[string]string{"default": string(data), "sms": snsSummary(event)})
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"Action":           {"Publish"},
+		"Version":          {"2010-03-31"},
+		"TopicArn":         {s.TopicARN},
+		"Message":          {string(message)},
+		"MessageStructure": {"json"},
+		"Subject":          {truncateRunes(fmt.Sprintf("NC DOT incident %d %s", event.Incident.ID, event.Event), 100)},
+	}
+	attributes := []struct {
+		name, dataType, value string
+	}{
+		{"event", "String", event.Event},
+		{"county", "String", event.Incident.CountyName},
+		{"county_id", "Number", strconv.Itoa(event.Incident.CountyID)},
+		{"type", "String", event.Incident.IncidentType},
+		{"severity", "Number", strconv.Itoa(event.Incident.Severity)},
+	}
+	n := 0
+	for _, a := range attributes {
+		if a.value == "" {
+			continue // SNS rejects empty attribute values.
+		}
+		n++
+		prefix := fmt.Sprintf("MessageAttributes.entry.%d.", n)
+		form.Set(prefix+"Name", a.name)
+		form.Set(prefix+"Value.DataType", a.dataType)
+		form.Set(prefix+"Value.StringValue", a.value)
+	}
+	// FIFO topics need a group, and order each incident's events within it.
+	if strings.HasSuffix(s.TopicARN, ".fifo") {
+		form.Set("MessageGroupId", strconv.Itoa(event.Incident.ID))
+		form.Set("MessageDeduplicationId", fmt.Sprintf("%d-%s-%d", event.Incident.ID, event.Event, event.Time.UnixNano()))
+	}
+	return form, nil
+}
+
+// publishSNSEvent publishes one event.
+func (s SNSConfig) publishSNSEvent(ctx context.Context, event IncidentEvent) error {
+	form, err := s.snsPublishForm(event)
+	if err != nil {
+		return err
+	}
+	body := []byte(form.Encode())
+	region := s.region()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://sns.%s.amazonaws.com/", region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSRequest(req, body, s.credentials(), region, "sns", time.Now())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("SNS returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// publishToSNS publishes a cycle's events.
+func publishToSNS(ctx context.Context, cfg SNSConfig, events []IncidentEvent) error {
+	var errs []error
+	for _, event := range events {
+		if err := cfg.publishSNSEvent(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("incident %d: %w", event.Incident.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// The SQLite driver is only linked into builds that ask for it, so Postgres-only
+// deployments don't carry it: go build -tags sqlite.
+import _ "modernc.org/sqlite"
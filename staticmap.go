@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/mtickle/crash-reporting/geo"
+)
+
+// Static map providers.
+const (
+	mapProviderGoogle = "google"
+	mapProviderMapbox = "mapbox"
+	mapProviderOSM    = "osm"
+)
+
+// defaultOSMStaticMapURL is a staticmap.php service drawing OpenStreetMap tiles.
+const defaultOSMStaticMapURL = "https://staticmap.openstreetmap.de/staticmap.php"
+
+// StaticMapConfig sets the map image, with the incident pinned, shown in
+// Discord, Slack and email alerts. Provider is "google", using
+// notifications.google_maps_api_key, "mapbox", using MapboxToken, or "osm", a
+// staticmap.php-compatible service at OSMURL that needs no key. Without the
+// provider's key alerts have no image.
+type StaticMapConfig struct {
+	Provider    string `yaml:"provider"`
+	MapboxToken string `yaml:"mapbox_token"`
+	// MapboxStyle is the Mapbox style drawn, as "owner/style".
+	MapboxStyle string `yaml:"mapbox_style"`
+	OSMURL      string `yaml:"osm_url"`
+	Zoom        int    `yaml:"zoom"`
+	Width       int    `yaml:"width"`
+	Height      int    `yaml:"height"`
+
+	// googleKey is notifications.google_maps_api_key, filled in by staticMap.
+	googleKey string
+}
+
+// staticMap returns the static map settings with the Google key filled in.
+func (n NotificationConfig) staticMap() StaticMapConfig {
+	m := n.StaticMap
+	m.googleKey = n.GoogleMapsAPIKey
+	return m
+}
+
+// url returns the image of the incident's location, or "" when the provider
+// has no key or the incident no coordinates.
+func (m StaticMapConfig) url(incident Incident) string {
+	if !(geo.Point{Lat: incident.Latitude, Lon: incident.Longitude}).Valid() {
+		return ""
+	}
+	lat := strconv.FormatFloat(incident.Latitude, 'f', 6, 64)
+	lon := strconv.FormatFloat(incident.Longitude, 'f', 6, 64)
+	size := fmt.Sprintf("%dx%d", m.Width, m.Height)
+	switch m.Provider {
+	case mapProviderGoogle:
+		if m.googleKey == "" {
+			return ""
+		}
+		q := url.Values{
+			"center":  {lat + "," + lon},
+			"zoom":    {strconv.Itoa(m.Zoom)},
+			"size":    {size},
+			"markers": {"color:red|" + lat + "," + lon},
+			"key":     {m.googleKey},
+		}
+		return "https://maps.googleapis.com/maps/api/staticmap?" + q.Encode()
+	case mapProviderMapbox:
+		if m.MapboxToken == "" {
+			return ""
+		}
+		// Mapbox takes longitude first.
+		return fmt.Sprintf("https://api.mapbox.com/styles/v1/%s/static/pin-l+d32f2f(%s,%s)/%s,%s,%d/%s?access_token=%s",
+			m.MapboxStyle, lon, lat, lon, lat, m.Zoom, size, url.QueryEscape(m.MapboxToken))
+	case mapProviderOSM:
+		q := url.Values{
+			"center":  {lat + "," + lon},
+			"zoom":    {strconv.Itoa(m.Zoom)},
+			"size":    {size},
+			"markers": {lat + "," + lon + ",red-pushpin"},
+		}
+		return m.OSMURL + "?" + q.Encode()
+	}
+	return ""
+}
+
+// validate checks the provider and image size.
+func (m StaticMapConfig) validate() error {
+	switch m.Provider {
+	case mapProviderGoogle, mapProviderOSM:
+	case mapProviderMapbox:
+		if m.MapboxStyle == "" {
+			return errors.New("notifications.static_map.mapbox_style cannot be empty")
+		}
+	default:
+		return fmt.Errorf("notifications.static_map.provider must be google, mapbox or osm, got %q", m.Provider)
+	}
+	if m.Provider == mapProviderOSM && m.OSMURL == "" {
+		return errors.New("notifications.static_map.osm_url cannot be empty")
+	}
+	if m.Zoom < 0 || m.Zoom > 22 {
+		return fmt.Errorf("notifications.static_map.zoom must be between 0 and 22, got %d", m.Zoom)
+	}
+	// 1280 is the largest the Mapbox Static Images API draws.
+	if m.Width <= 0 || m.Height <= 0 || m.Width > 1280 || m.Height > 1280 {
+		return fmt.Errorf("notifications.static_map needs a width and height between 1 and 1280, got %dx%d", m.Width, m.Height)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// statGroup is the incidents of one type, road or county.
+type statGroup struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	// AvgClearanceSeconds is 0 when none of them cleared.
+	AvgClearanceSeconds int `json:"avgClearanceSeconds"`
+}
+
+// incidentStats aggregates the incidents that started in [Since, Until).
+type incidentStats struct {
+	Since               time.Time   `json:"since"`
+	Until               time.Time   `json:"until"`
+	Total               int         `json:"total"`
+	Cleared             int         `json:"cleared"`
+	AvgClearanceSeconds int         `json:"avgClearanceSeconds"`
+	ByType              []statGroup `json:"byType"`
+	ByRoad              []statGroup `json:"byRoad"`
+	ByCounty            []statGroup `json:"byCounty"`
+	// ByHour counts by local hour of day from midnight, and ByWeekday by day
+	// of the week from Sunday.
+	ByHour    [24]int `json:"byHour"`
+	ByWeekday [7]int  `json:"byWeekday"`
+}
+
+// addHours tallies UTC hourly counts by hour of day and day of week in loc.
+func (s *incidentStats) addHours(counts []hourCount, loc *time.Location) {
+	for _, hc := range counts {
+		hour := hc.Hour.In(loc)
+		s.ByHour[hour.Hour()] += hc.Count
+		s.ByWeekday[hour.Weekday()] += hc.Count
+	}
+}
+
+// clearanceText formats an average clearance in seconds, or N/A for none.
+func clearanceText(seconds int) string {
+	if seconds <= 0 {
+		return "N/A"
+	}
+	return formatDuration(time.Duration(seconds) * time.Second)
+}
+
+// writeTable writes the statistics as terminal tables.
+func (s incidentStats) writeTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Incidents from %s to %s\n\n", s.Since.Format("Jan 2 2006 15:04"), s.Until.Format("Jan 2 2006 15:04"))
+	fmt.Fprintf(w, "TOTAL\tCLEARED\tAVG CLEARANCE\n%d\t%d\t%s\n", s.Total, s.Cleared, clearanceText(s.AvgClearanceSeconds))
+	for _, g := range []struct {
+		heading string
+		groups  []statGroup
+	}{{"TYPE", s.ByType}, {"ROAD", s.ByRoad}, {"COUNTY", s.ByCounty}} {
+		fmt.Fprintf(w, "\n%s\tCOUNT\tAVG CLEARANCE\n", g.heading)
+		for _, sg := range g.groups {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", sg.Name, sg.Count, clearanceText(sg.AvgClearanceSeconds))
+		}
+	}
+	fmt.Fprint(w, "\nHOUR\tCOUNT\t\n")
+	for hour, count := range s.ByHour {
+		fmt.Fprintf(w, "%02d:00\t%d\t%s\n", hour, count, bar(count, s.ByHour[:]))
+	}
+	fmt.Fprint(w, "\nDAY\tCOUNT\t\n")
+	for day, count := range s.ByWeekday {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", time.Weekday(day), count, bar(count, s.ByWeekday[:]))
+	}
+	return w.Flush()
+}
+
+// bar draws count as a bar up to 30 characters long, scaled to the largest
+// of counts.
+func bar(count int, counts []int) string {
+	most := 0
+	for _, c := range counts {
+		most = max(most, c)
+	}
+	if most == 0 {
+		return ""
+	}
+	return strings.Repeat("#", count*30/most)
+}
+
+// parseTimeFlag parses a time flag such as --since: a number of days ago such
+// as "30d", a duration ago such as "12h", or a date as YYYY-MM-DD in loc.
+func parseTimeFlag(name, s string, now time.Time, loc *time.Location) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, loc); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("--%s must be a number of days such as 30d, a duration such as 12h, or a date as YYYY-MM-DD, got %q", name, s)
+}
+
+// statsCommand implements the "stats" subcommand, printing incident statistics
+// for a period as tables or JSON.
+func statsCommand(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	configPath := addConfigFlag(fs)
+	since := fs.String("since", "30d", "count incidents starting since this many days (30d), this long ago (12h) or this date (YYYY-MM-DD)")
+	top := fs.Int("top", 10, "list this many types, roads and counties")
+	asJSON := fs.Bool("json", false, "write the statistics to stdout as JSON")
+	fs.Parse(args)
+
+	if *top <= 0 {
+		return errors.New("--top must be positive")
+	}
+	cfg, err := loadCommandConfig(fs, *configPath, false)
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(cfg.Reports.loc)
+	from, err := parseTimeFlag("since", *since, now, cfg.Reports.loc)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	stats, err := store.IncidentStats(context.Background(), from, now, *top)
+	if err != nil {
+		return fmt.Errorf("could not query statistics: %w", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+	return stats.writeTable(os.Stdout)
+}
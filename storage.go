@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Database backends selected with database.driver.
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+	driverMySQL    = "mysql"
+	driverMemory   = "memory"
+)
+
+// DB is an open database and the SQL dialect of its backend. Queries are written
+// for Postgres, with $N placeholders; QueryContext, QueryRowContext and
+// ExecContext rewrite them, and their time arguments, for the backend.
+type DB struct {
+	*sql.DB
+	dialect *dialect
+	// queryTimeout bounds each operation; see timeout.
+	queryTimeout time.Duration
+}
+
+// Tx is a transaction on a DB, with queries rewritten the same way.
+type Tx struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+// dialect is the SQL that differs between database backends.
+type dialect struct {
+	// driver is the database/sql driver name.
+	driver string
+	// placeholder replaces the "$" of numbered placeholders, e.g. "?" for ?1.
+	placeholder string
+	// positional backends only take bare ? placeholders, bound in order, so
+	// $N becomes ? and its argument is repeated wherever it is used.
+	positional bool
+	// timeLayout, when set, formats time arguments as UTC text, for backends that
+	// store timestamps as text and compare them as strings.
+	timeLayout string
+	// name is the database.driver value, which also names the backend's
+	// directory under migrations.
+	name string
+	// upsertConflict is the upsert's clause for incidents already stored.
+	upsertConflict string
+	// greatest is the name of the two-argument maximum function, and integer
+	// the type to cast to for a whole number.
+	greatest string
+	integer  string
+	// seconds is an expression for the seconds from one timestamp to another.
+	seconds func(from, to string) string
+	// hour is an expression for the UTC hour of a timestamp as text, e.g.
+	// "2024-05-01 13".
+	hour func(col string) string
+}
+
+// dialects are the supported backends by database.driver.
+var dialects = map[string]*dialect{
+	driverPostgres: {
+		name:           driverPostgres,
+		driver:         "postgres",
+		placeholder:    "$",
+		upsertConflict: upsertConflictClause,
+		greatest:       "GREATEST",
+		integer:        "INTEGER",
+		seconds: func(from, to string) string {
+			return `EXTRACT(EPOCH FROM ` + to + ` - ` + from + `)`
+		},
+		hour: func(col string) string {
+			return `to_char(` + col + ` AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24')`
+		},
+	},
+	driverSQLite: {
+		name:           driverSQLite,
+		driver:         "sqlite",
+		placeholder:    "?",
+		timeLayout:     "2006-01-02 15:04:05",
+		upsertConflict: upsertConflictClause,
+		greatest:       "MAX",
+		integer:        "INTEGER",
+		seconds: func(from, to string) string {
+			return `(julianday(` + to + `) - julianday(` + from + `)) * 86400`
+		},
+		hour: func(col string) string {
+			return `strftime('%Y-%m-%d %H', ` + col + `)`
+		},
+	},
+	driverMySQL: {
+		name:           driverMySQL,
+		driver:         "mysql",
+		placeholder:    "?",
+		positional:     true,
+		timeLayout:     "2006-01-02 15:04:05",
+		upsertConflict: mysqlUpsertConflictClause,
+		greatest:       "GREATEST",
+		integer:        "SIGNED",
+		seconds: func(from, to string) string {
+			return `TIMESTAMPDIFF(SECOND, ` + from + `, ` + to + `)`
+		},
+		hour: func(col string) string {
+			return `DATE_FORMAT(` + col + `, '%Y-%m-%d %H')`
+		},
+	},
+}
+
+// mysqlUpsertConflictClause is upsertConflictClause for MySQL, whose connections
+// use ANSI_QUOTES so "condition" is quoted as it is for Postgres. Assignments
+// run in order, so the reopen columns come first, while status is still the
+// stored one.
+const mysqlUpsertConflictClause = `
+	ON DUPLICATE KEY UPDATE
+		reopen_count = reopen_count + CASE WHEN status = 'cleared' THEN 1 ELSE 0 END,
+		reopened_at = CASE WHEN status = 'cleared' THEN CURRENT_TIMESTAMP ELSE reopened_at END,
+		latitude = VALUES(latitude),
+		longitude = VALUES(longitude),
+		reason = VALUES(reason),
+		"condition" = VALUES("condition"),
+		incident_type = VALUES(incident_type),
+		severity = VALUES(severity),
+		end_time = VALUES(end_time),
+		last_update = VALUES(last_update),
+		lanes_closed = VALUES(lanes_closed),
+		lanes_total = VALUES(lanes_total),
+		detour = VALUES(detour),
+		status = 'active',
+		cleared_time = NULL,
+		duration_seconds = NULL`
+
+// openDB opens the configured backend. The SQLite and MySQL drivers are only
+// linked into binaries built with -tags sqlite or -tags mysql.
+func openDB(cfg DatabaseConfig) (*DB, error) {
+	d := dialects[cfg.Driver]
+	if d == nil {
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+	if !slices.Contains(sql.Drivers(), d.driver) {
+		return nil, fmt.Errorf("this binary was built without %s support; rebuild with -tags %s", cfg.Driver, cfg.Driver)
+	}
+	db, err := sql.Open(d.driver, cfg.dsn())
+	if err != nil {
+		return nil, err
+	}
+	return &DB{DB: db, dialect: d, queryTimeout: cfg.QueryTimeout}, nil
+}
+
+// placeholderPattern matches numbered placeholders such as $1.
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// rebind rewrites query's placeholders and args' times for the backend.
+func (d *dialect) rebind(query string, args []any) (string, []any) {
+	switch {
+	case d.positional:
+		var ordered []any
+		query = placeholderPattern.ReplaceAllStringFunc(query, func(p string) string {
+			n, _ := strconv.Atoi(p[1:])
+			if n < 1 || n > len(args) {
+				return p // Left for the driver to reject.
+			}
+			ordered = append(ordered, args[n-1])
+			return d.placeholder
+		})
+		args = ordered
+	case d.placeholder != "$":
+		query = placeholderPattern.ReplaceAllString(query, d.placeholder+"${1}")
+	}
+	if d.timeLayout != "" {
+		args = slices.Clone(args)
+		for i, arg := range args {
+			if t, ok := arg.(FeedTime); ok {
+				arg, _ = t.Value()
+				args[i] = arg
+			}
+			if t, ok := arg.(time.Time); ok {
+				args[i] = t.UTC().Format(d.timeLayout)
+			}
+		}
+	}
+	return query, args
+}
+
+// timeout returns a context derived from ctx and bounded by
+// database.query_timeout, for one operation.
+func (db *DB) timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+// QueryContext runs a query that returns rows.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = db.dialect.rebind(query, args)
+	start := time.Now()
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	logQuery(query, start, err)
+	return rows, err
+}
+
+// QueryRowContext runs a query that returns at most one row.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = db.dialect.rebind(query, args)
+	start := time.Now()
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	logQuery(query, start, row.Err())
+	return row
+}
+
+// ExecContext runs a statement that returns no rows.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = db.dialect.rebind(query, args)
+	start := time.Now()
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	logQuery(query, start, err)
+	return result, err
+}
+
+// BeginTx starts a transaction.
+func (db *DB) BeginTx(ctx context.Context) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.dialect}, nil
+}
+
+// QueryContext runs a query that returns rows.
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = tx.dialect.rebind(query, args)
+	start := time.Now()
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	logQuery(query, start, err)
+	return rows, err
+}
+
+// QueryRowContext runs a query that returns at most one row.
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = tx.dialect.rebind(query, args)
+	start := time.Now()
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	logQuery(query, start, row.Err())
+	return row
+}
+
+// ExecContext runs a statement that returns no rows.
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = tx.dialect.rebind(query, args)
+	start := time.Now()
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	logQuery(query, start, err)
+	return result, err
+}
+
+// logQuery logs a statement's timing to the sql module. For queries returning
+// rows it times the wait for the first row.
+func logQuery(query string, start time.Time, err error) {
+	log := moduleLogger(logModuleSQL)
+	if !log.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	attrs := []any{"query", strings.Join(strings.Fields(query), " "), "duration", time.Since(start)}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	log.Debug("SQL", attrs...)
+}
@@ -0,0 +1,861 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store persists incidents and answers the queries the commands and reports
+// need. sqlStore keeps them in the configured database; memStore keeps them in
+// memory, for tests and the "memory" driver.
+type Store interface {
+	// UpsertIncidents inserts or updates incidents from the feed, returning
+	// what was stored before for each.
+	UpsertIncidents(ctx context.Context, incidents []Incident) ([]storedIncident, error)
+	// ActiveIncidents returns the incidents that haven't cleared.
+	ActiveIncidents(ctx context.Context) ([]ClearedIncident, error)
+	// MarkCleared clears an incident, returning how many seconds it was active,
+	// or 0 if unknown.
+	MarkCleared(ctx context.Context, id int) (int, error)
+	// Incidents returns the stored incidents with status "active" or "cleared",
+	// or all of them for "all", by ID.
+	Incidents(ctx context.Context, status string) ([]StoredIncident, error)
+	// FindIncidents returns the page of incidents matching q, by ID, and how
+	// many match in all.
+	FindIncidents(ctx context.Context, q incidentQuery) ([]StoredIncident, int, error)
+	// IncidentCounts counts incidents by type and status.
+	IncidentCounts(ctx context.Context) ([]incidentCount, error)
+	// IncidentChanges returns the changes recorded for an incident, oldest first.
+	IncidentChanges(ctx context.Context, id int) ([]IncidentChange, error)
+	// IncidentChangesByID returns the recorded changes of those of the
+	// incidents that have any, oldest first, by ID.
+	IncidentChangesByID(ctx context.Context, ids []int) (map[int][]IncidentChange, error)
+	// PurgeCleared deletes incidents cleared before cutoff, returning how many.
+	PurgeCleared(ctx context.Context, cutoff time.Time) (int64, error)
+	// ArchiveCleared moves incidents cleared before cutoff to
+	// ncdot_incidents_archive, returning how many.
+	ArchiveCleared(ctx context.Context, cutoff time.Time) (int64, error)
+	// SaveFeedSnapshots stores feed responses, skipping any whose payload is
+	// the same as the newest stored for its URL, and returns how many it stored.
+	SaveFeedSnapshots(ctx context.Context, snapshots []feedSnapshot) (int, error)
+	// FeedSnapshots calls fn with each stored feed response fetched since
+	// since, oldest first, stopping at fn's first error.
+	FeedSnapshots(ctx context.Context, since time.Time, fn func(feedSnapshot) error) error
+	// PurgeFeedSnapshots deletes feed responses fetched before cutoff,
+	// returning how many.
+	PurgeFeedSnapshots(ctx context.Context, cutoff time.Time) (int64, error)
+	// RoadCounts returns the roads with the most incidents starting in
+	// [from, to), busiest first.
+	RoadCounts(ctx context.Context, from, to time.Time, limit int) ([]roadCount, error)
+	// HourlyCounts counts the incidents starting in [from, to) by UTC hour,
+	// leaving out hours without any.
+	HourlyCounts(ctx context.Context, from, to time.Time) ([]hourCount, error)
+	// DailyReport aggregates the incidents that started during day (midnight
+	// to midnight in the day's location).
+	DailyReport(ctx context.Context, day time.Time) (dailyReport, error)
+	// WeeklyReport compares the week starting at start with the week before,
+	// overall and for the top roads and counties by this week's count.
+	WeeklyReport(ctx context.Context, start time.Time, top int) (weeklyReport, error)
+	// IncidentStats aggregates the incidents starting in [from, to): totals,
+	// the top types, roads and counties, and counts by hour of day and day of
+	// week in from's location.
+	IncidentStats(ctx context.Context, from, to time.Time, top int) (incidentStats, error)
+	// QueueNotifications adds failed notifications to the retry queue.
+	QueueNotifications(ctx context.Context, queued []queuedNotification) error
+	// DueNotifications returns the queued notifications whose next attempt is
+	// due by now, oldest first.
+	DueNotifications(ctx context.Context, now time.Time) ([]queuedNotification, error)
+	// RescheduleNotification updates a queued notification's attempts, next
+	// attempt and last error.
+	RescheduleNotification(ctx context.Context, q queuedNotification) error
+	// DeleteQueuedNotification removes a notification from the retry queue.
+	DeleteQueuedNotification(ctx context.Context, id int64) error
+	// IncidentWeather returns the stored weather of those of the incidents
+	// that have it, by ID.
+	IncidentWeather(ctx context.Context, ids []int) (map[int]incidentWeather, error)
+	// SaveIncidentWeather stores the weather at an incident when it started.
+	SaveIncidentWeather(ctx context.Context, id int, w incidentWeather) error
+	// Ping checks that the database is reachable.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// incidentQuery selects stored incidents. Zero fields match everything, as does
+// status "all".
+type incidentQuery struct {
+	ID           int
+	Status       string
+	CountyID     int
+	IncidentType string
+	Road         string
+	MinSeverity  int
+	// StartedSince and StartedBefore keep the incidents that started in
+	// [StartedSince, StartedBefore). A zero bound is open; with either bound,
+	// incidents with an unknown start time are left out.
+	StartedSince  time.Time
+	StartedBefore time.Time
+	// Limit and Offset page through the matches in ID order, or from the
+	// highest ID down with Newest. A zero Limit returns no incidents, only the
+	// total.
+	Limit  int
+	Offset int
+	Newest bool
+}
+
+// where returns the query's WHERE clause, or "", and its arguments.
+func (q incidentQuery) where() (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if q.ID != 0 {
+		add("id = $%d", q.ID)
+	}
+	if q.Status != "" && q.Status != "all" {
+		add("status = $%d", q.Status)
+	}
+	if q.CountyID != 0 {
+		add("county_id = $%d", q.CountyID)
+	}
+	if q.IncidentType != "" {
+		add("incident_type = $%d", q.IncidentType)
+	}
+	if q.Road != "" {
+		add("road = $%d", q.Road)
+	}
+	if q.MinSeverity != 0 {
+		add("severity >= $%d", q.MinSeverity)
+	}
+	if !q.StartedSince.IsZero() {
+		add("start_time >= $%d", q.StartedSince)
+	}
+	if !q.StartedBefore.IsZero() {
+		add("start_time < $%d", q.StartedBefore)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// matches reports whether an incident passes the query's filters.
+func (q incidentQuery) matches(si StoredIncident) bool {
+	return (q.ID == 0 || si.ID == q.ID) &&
+		(q.Status == "" || q.Status == "all" || si.Status == q.Status) &&
+		(q.CountyID == 0 || si.CountyID == q.CountyID) &&
+		(q.IncidentType == "" || si.IncidentType == q.IncidentType) &&
+		(q.Road == "" || si.Road == q.Road) &&
+		si.Severity >= q.MinSeverity &&
+		(q.StartedSince.IsZero() || (!si.StartTime.IsZero() && !si.StartTime.Before(q.StartedSince))) &&
+		(q.StartedBefore.IsZero() || (!si.StartTime.IsZero() && si.StartTime.Before(q.StartedBefore)))
+}
+
+// incidentCount is how many incidents have a type and status.
+type incidentCount struct {
+	IncidentType string
+	Status       string
+	Count        int
+}
+
+// sqlStore is the Store for every database backend.
+type sqlStore struct {
+	db *DB
+	// timescale is set when incident history is kept in a TimescaleDB
+	// hypertable, whose aggregates the reports count from.
+	timescale bool
+}
+
+// openStore connects to the configured database.
+func openStore(cfg DatabaseConfig) (Store, error) {
+	if cfg.Driver == driverMemory {
+		slog.Info("Using the in-memory store. Nothing is kept after this run.")
+		return newMemStore(), nil
+	}
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store := &sqlStore{db: db, timescale: cfg.TimescaleDB}
+	if db.dialect.name != driverPostgres {
+		return store, nil
+	}
+	if store.timescale {
+		if err := enableTimescale(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	postgis, err := enablePostGIS(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if postgis {
+		return &postgisStore{store}, nil
+	}
+	return store, nil
+}
+
+// Ping checks the database connection.
+func (s *sqlStore) Ping(ctx context.Context) error {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database.
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// previousIncidentsQuery reads the stored fields UpsertIncidents reports, for
+// the IDs in the list the caller appends.
+const previousIncidentsQuery = `
+	SELECT id, status = 'cleared', COALESCE(severity, 0), COALESCE(lanes_closed, 0),
+		COALESCE(lanes_total, 0), reopen_count, last_update,
+		COALESCE("condition", ''), COALESCE(reason, '')
+	FROM ncdot_incidents WHERE id IN `
+
+// insertIncidentColumns are the columns an upsert writes: incidentValues,
+// then status and cleared_time.
+const insertIncidentColumns = `
+		id, latitude, longitude, common_name, reason, "condition", incident_type,
+		severity, direction, location, county_id, county_name, city, start_time,
+		end_time, last_update, road, route_id, lanes_closed, lanes_total, detour,
+		cross_street_prefix, cross_street_number, cross_street_suffix,
+		cross_street_common_name, event, created_from_concurrent, movable_construction,
+		work_zone_speed_limit, status, cleared_time`
+
+// incidentValues returns the feed's values for insertIncidentColumns.
+func incidentValues(incident Incident) []any {
+	return []any{
+		incident.ID, incident.Latitude, incident.Longitude, incident.CommonName, incident.Reason,
+		incident.Condition, incident.IncidentType, incident.Severity, incident.Direction,
+		incident.Location, incident.CountyID, incident.CountyName, incident.City, incident.StartTime,
+		incident.EndTime, incident.LastUpdate, incident.Road, incident.RouteID, incident.LanesClosed,
+		incident.LanesTotal, incident.Detour, incident.CrossStreetPrefix, incident.CrossStreetNumber,
+		incident.CrossStreetSuffix, incident.CrossStreetCommonName, incident.Event,
+		incident.CreatedFromConcurrent, incident.MovableConstruction, incident.WorkZoneSpeedLimit,
+	}
+}
+
+// incidentValueCount is the length of incidentValues.
+const incidentValueCount = 29
+
+// upsertBatchSize caps the rows in one upsert statement, keeping its
+// parameters well under every backend's limit.
+const upsertBatchSize = 500
+
+// upsertConflictClause updates the feed's fields of an existing incident,
+// counting a reopening if it had cleared.
+const upsertConflictClause = `
+	ON CONFLICT (id) DO UPDATE SET
+		latitude = EXCLUDED.latitude,
+		longitude = EXCLUDED.longitude,
+		reason = EXCLUDED.reason,
+		"condition" = EXCLUDED.condition,
+		incident_type = EXCLUDED.incident_type,
+		severity = EXCLUDED.severity,
+		end_time = EXCLUDED.end_time,
+		last_update = EXCLUDED.last_update,
+		lanes_closed = EXCLUDED.lanes_closed,
+		lanes_total = EXCLUDED.lanes_total,
+		detour = EXCLUDED.detour,
+		status = 'active',
+		cleared_time = NULL,
+		duration_seconds = NULL,
+		reopen_count = ncdot_incidents.reopen_count + CASE WHEN ncdot_incidents.status = 'cleared' THEN 1 ELSE 0 END,
+		reopened_at = CASE WHEN ncdot_incidents.status = 'cleared' THEN CURRENT_TIMESTAMP ELSE ncdot_incidents.reopened_at END`
+
+// upsertIncidentsQuery inserts n incidents as active, or updates them with the
+// backend's conflict clause.
+func (d *dialect) upsertIncidentsQuery(n int) string {
+	var b strings.Builder
+	b.WriteString("INSERT INTO ncdot_incidents (" + insertIncidentColumns + ") VALUES ")
+	for row := 0; row < n; row++ {
+		if row > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for col := 1; col <= incidentValueCount; col++ {
+			fmt.Fprintf(&b, "$%d, ", row*incidentValueCount+col)
+		}
+		b.WriteString("'active', NULL)")
+	}
+	b.WriteString(d.upsertConflict)
+	return b.String()
+}
+
+// placeholderList returns "($1, $2, ...)" for n arguments.
+func placeholderList(n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = "$" + strconv.Itoa(i+1)
+	}
+	return "(" + strings.Join(list, ", ") + ")"
+}
+
+// UpsertIncidents writes the incidents in one transaction, in multi-row
+// statements of up to upsertBatchSize, reading the rows they replace first.
+// An ID that appears more than once is written once, with its last copy, since
+// a statement can't update the same row twice. Active incidents whose feed
+// lastUpdate matches the stored one haven't changed and aren't written at all.
+// Changes to the tracked fields go to incident_updates in the same transaction.
+func (s *sqlStore) UpsertIncidents(ctx context.Context, incidents []Incident) ([]storedIncident, error) {
+	latest := make(map[int]Incident, len(incidents))
+	var ids []int
+	for _, incident := range incidents {
+		if _, ok := latest[incident.ID]; !ok {
+			ids = append(ids, incident.ID)
+		}
+		latest[incident.ID] = incident
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	var previous map[int]storedIncident
+	var changed []int
+	err := withReconnect(s.db, func() error {
+		previous = make(map[int]storedIncident, len(ids))
+		tx, err := s.db.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() // No-op once committed.
+
+		for start := 0; start < len(ids); start += upsertBatchSize {
+			batch := ids[start:min(start+upsertBatchSize, len(ids))]
+			if err := readPreviousIncidents(ctx, tx, batch, previous); err != nil {
+				return err
+			}
+		}
+		changed = slices.DeleteFunc(slices.Clone(ids), func(id int) bool {
+			return previous[id].unchanged(latest[id])
+		})
+		for start := 0; start < len(changed); start += upsertBatchSize {
+			batch := changed[start:min(start+upsertBatchSize, len(changed))]
+			args := make([]any, 0, len(batch)*incidentValueCount)
+			for _, id := range batch {
+				args = append(args, incidentValues(latest[id])...)
+			}
+			if _, err := tx.ExecContext(ctx, tx.dialect.upsertIncidentsQuery(len(batch)), args...); err != nil {
+				return err
+			}
+		}
+		var changes []IncidentChange
+		for _, id := range changed {
+			changes = append(changes, previous[id].changes(latest[id])...)
+		}
+		if err := insertIncidentChanges(ctx, tx, changes); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if skipped := len(ids) - len(changed); skipped > 0 {
+		slog.Info("Skipped writing unchanged incidents", "count", skipped)
+	}
+
+	stored := make([]storedIncident, len(incidents))
+	for i, incident := range incidents {
+		stored[i] = previous[incident.ID]
+	}
+	return stored, nil
+}
+
+// readPreviousIncidents adds the stored rows of ids to previous.
+func readPreviousIncidents(ctx context.Context, tx *Tx, ids []int, previous map[int]storedIncident) error {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := tx.QueryContext(ctx, previousIncidentsQuery+placeholderList(len(ids)), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		p := storedIncident{Exists: true}
+		if err := rows.Scan(&id, &p.Cleared, &p.Severity, &p.LanesClosed, &p.LanesTotal, &p.ReopenCount, &p.LastUpdate, &p.Condition, &p.Reason); err != nil {
+			return err
+		}
+		if p.Cleared {
+			p.ReopenCount++
+		}
+		previous[id] = p
+	}
+	return rows.Err()
+}
+
+// ActiveIncidents returns the incidents that haven't cleared.
+func (s *sqlStore) ActiveIncidents(ctx context.Context) ([]ClearedIncident, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	var active []ClearedIncident
+	err := withReconnect(s.db, func() error {
+		active = nil
+		rows, err := s.db.QueryContext(ctx, "SELECT id, incident_type, COALESCE(county_id, 0), COALESCE(severity, 0), road, location, city FROM ncdot_incidents WHERE status = 'active'")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var i ClearedIncident
+			if err := rows.Scan(&i.ID, &i.IncidentType, &i.CountyID, &i.Severity, &i.Road, &i.Location, &i.City); err != nil {
+				slog.Error("Error scanning active incident from DB", "err", err)
+				continue
+			}
+			active = append(active, i)
+		}
+		return rows.Err()
+	})
+	return active, err
+}
+
+// clearIncidentQuery marks an incident cleared and stores how long it was active,
+// when its start time is known.
+func (d *dialect) clearIncidentQuery() string {
+	return `
+	UPDATE ncdot_incidents SET
+		status = 'cleared',
+		cleared_time = CURRENT_TIMESTAMP,
+		duration_seconds = CASE WHEN start_time IS NOT NULL
+			THEN CAST(` + d.greatest + `(` + d.seconds("start_time", "CURRENT_TIMESTAMP") + `, 0) AS ` + d.integer + `) END
+	WHERE id = $1`
+}
+
+// MarkCleared clears the incident and reads back the duration the update stored.
+func (s *sqlStore) MarkCleared(ctx context.Context, id int) (int, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	var duration sql.NullInt64
+	err := withReconnect(s.db, func() error {
+		if _, err := s.db.ExecContext(ctx, s.db.dialect.clearIncidentQuery(), id); err != nil {
+			return err
+		}
+		return s.db.QueryRowContext(ctx, "SELECT duration_seconds FROM ncdot_incidents WHERE id = $1", id).Scan(&duration)
+	})
+	return int(duration.Int64), err
+}
+
+// Incidents returns the stored incidents with a status, or all of them, by ID.
+func (s *sqlStore) Incidents(ctx context.Context, status string) ([]StoredIncident, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	query := "SELECT " + incidentColumns + " FROM ncdot_incidents WHERE $1 = 'all' OR status = $1 ORDER BY id"
+	return s.queryIncidents(ctx, query, status)
+}
+
+// FindIncidents filters and pages the incidents in SQL.
+func (s *sqlStore) FindIncidents(ctx context.Context, q incidentQuery) ([]StoredIncident, int, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	where, args := q.where()
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ncdot_incidents"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	order := "id"
+	if q.Newest {
+		order = "id DESC"
+	}
+	query := fmt.Sprintf("SELECT %s FROM ncdot_incidents%s ORDER BY %s LIMIT $%d OFFSET $%d", incidentColumns, where, order, len(args)+1, len(args)+2)
+	incidents, err := s.queryIncidents(ctx, query, append(args, q.Limit, q.Offset)...)
+	return incidents, total, err
+}
+
+// queryIncidents runs a query selecting incidentColumns and scans the rows.
+func (s *sqlStore) queryIncidents(ctx context.Context, query string, args ...any) ([]StoredIncident, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	incidents := []StoredIncident{}
+	for rows.Next() {
+		incident, err := scanStoredIncident(rows)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, incident)
+	}
+	return incidents, rows.Err()
+}
+
+// IncidentCounts counts incidents by type and status.
+func (s *sqlStore) IncidentCounts(ctx context.Context) ([]incidentCount, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT incident_type, status, COUNT(*)
+		FROM ncdot_incidents
+		GROUP BY incident_type, status
+		ORDER BY incident_type, status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []incidentCount
+	for rows.Next() {
+		var c incidentCount
+		if err := rows.Scan(&c.IncidentType, &c.Status, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// PurgeCleared deletes incidents cleared before cutoff.
+func (s *sqlStore) PurgeCleared(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, "DELETE FROM ncdot_incidents WHERE status = 'cleared' AND cleared_time < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ArchiveCleared copies incidents cleared before cutoff to the archive table and
+// deletes them, in one transaction.
+func (s *sqlStore) ArchiveCleared(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	const where = " FROM ncdot_incidents WHERE status = 'cleared' AND cleared_time < $1"
+	var n int64
+	err := withReconnect(s.db, func() error {
+		tx, err := s.db.BeginTx(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() // No-op once committed.
+
+		query := "INSERT INTO ncdot_incidents_archive (" + incidentColumns + ") SELECT " + incidentColumns + where
+		if _, err := tx.ExecContext(ctx, query, cutoff); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, "DELETE"+where, cutoff)
+		if err != nil {
+			return err
+		}
+		if n, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	return n, err
+}
+
+// reportWindowQuery selects the incidents that started in [$1, $2), as started.
+// Incidents with an unknown start time are left out.
+func (d *dialect) reportWindowQuery() string {
+	return `
+	WITH window_incidents AS (
+		SELECT *, start_time AS started
+		FROM ncdot_incidents
+		WHERE start_time >= $1 AND start_time < $2
+	)`
+}
+
+// avgClearance is the average seconds from start to clearance of the incidents
+// matching cond, or 0 when none cleared.
+func (d *dialect) avgClearance(cond string) string {
+	return `COALESCE(AVG(CASE WHEN ` + cond + `cleared_time > started THEN ` + d.seconds("started", "cleared_time") + ` END), 0)`
+}
+
+// DailyReport aggregates the incidents with SQL.
+func (s *sqlStore) DailyReport(ctx context.Context, day time.Time) (dailyReport, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	report := dailyReport{Day: day, ByType: make(map[string]int)}
+	from, to := day, day.AddDate(0, 0, 1)
+
+	rows, err := s.db.QueryContext(ctx, s.db.dialect.reportWindowQuery()+`
+		SELECT incident_type, COUNT(*), COUNT(cleared_time), `+s.db.dialect.avgClearance("")+`
+		FROM window_incidents
+		GROUP BY incident_type`, from, to)
+	if err != nil {
+		return report, fmt.Errorf("could not query daily totals: %w", err)
+	}
+	var clearanceSum float64
+	var clearanceCount int
+	for rows.Next() {
+		var incidentType string
+		var count, cleared int
+		var avgSeconds float64
+		if err := rows.Scan(&incidentType, &count, &cleared, &avgSeconds); err != nil {
+			rows.Close()
+			return report, fmt.Errorf("could not read daily totals: %w", err)
+		}
+		report.ByType[incidentType] = count
+		report.Total += count
+		report.Cleared += cleared
+		if avgSeconds > 0 {
+			clearanceSum += avgSeconds * float64(cleared)
+			clearanceCount += cleared
+		}
+		if incidentType == "Vehicle Crash" {
+			report.Crashes = count
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	if clearanceCount > 0 {
+		report.AvgClearance = time.Duration(clearanceSum / float64(clearanceCount) * float64(time.Second))
+	}
+	if s.timescale {
+		if err := s.historyCounts(ctx, &report, from, to); err != nil {
+			return report, err
+		}
+	}
+
+	if report.BusiestRoads, err = s.busiestRoads(ctx, from, to, 5); err != nil {
+		return report, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, s.db.dialect.reportWindowQuery()+`
+		SELECT latitude, longitude, severity FROM window_incidents
+		WHERE latitude <> 0 AND longitude <> 0
+		ORDER BY severity DESC, started
+		LIMIT $3`, from, to, maxReportPoints)
+	if err != nil {
+		return report, fmt.Errorf("could not query incident locations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p reportPoint
+		if err := rows.Scan(&p.Latitude, &p.Longitude, &p.Severity); err != nil {
+			return report, fmt.Errorf("could not read incident locations: %w", err)
+		}
+		report.Points = append(report.Points, p)
+	}
+	return report, rows.Err()
+}
+
+// RoadCounts returns the busiest roads in SQL.
+func (s *sqlStore) RoadCounts(ctx context.Context, from, to time.Time, limit int) ([]roadCount, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	return s.busiestRoads(ctx, from, to, limit)
+}
+
+// HourlyCounts groups the window by the dialect's hour text.
+func (s *sqlStore) HourlyCounts(ctx context.Context, from, to time.Time) ([]hourCount, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, s.db.dialect.reportWindowQuery()+`
+		SELECT `+s.db.dialect.hour("started")+` AS hour, COUNT(*) FROM window_incidents
+		GROUP BY 1
+		ORDER BY 1`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("could not query hourly counts: %w", err)
+	}
+	defer rows.Close()
+	var counts []hourCount
+	for rows.Next() {
+		var hour string
+		var hc hourCount
+		if err := rows.Scan(&hour, &hc.Count); err != nil {
+			return nil, fmt.Errorf("could not read hourly counts: %w", err)
+		}
+		if hc.Hour, err = time.Parse("2006-01-02 15", hour); err != nil {
+			return nil, fmt.Errorf("could not read hourly counts: %w", err)
+		}
+		counts = append(counts, hc)
+	}
+	return counts, rows.Err()
+}
+
+// busiestRoads returns the roads with the most incidents starting in [from, to).
+func (s *sqlStore) busiestRoads(ctx context.Context, from, to time.Time, limit int) ([]roadCount, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.dialect.reportWindowQuery()+`
+		SELECT road, COUNT(*) FROM window_incidents
+		WHERE road <> ''
+		GROUP BY road
+		ORDER BY COUNT(*) DESC, road
+		LIMIT $3`, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not query busiest roads: %w", err)
+	}
+	defer rows.Close()
+	var roads []roadCount
+	for rows.Next() {
+		var rc roadCount
+		if err := rows.Scan(&rc.Road, &rc.Count); err != nil {
+			return nil, fmt.Errorf("could not read busiest roads: %w", err)
+		}
+		roads = append(roads, rc)
+	}
+	return roads, rows.Err()
+}
+
+// trendColumns compares the week starting at $3 with the week before it: counts
+// and average clearance seconds for each.
+func (d *dialect) trendColumns() string {
+	return `
+	COUNT(CASE WHEN started >= $3 THEN 1 END),
+	COUNT(CASE WHEN started < $3 THEN 1 END),
+	` + d.avgClearance("started >= $3 AND ") + `,
+	` + d.avgClearance("started < $3 AND ")
+}
+
+// scan reads trendColumns, after name if it is non-nil.
+func (t *trend) scan(rows *sql.Rows, name *string) error {
+	var cur, prev float64
+	dest := []any{&t.Count, &t.PrevCount, &cur, &prev}
+	if name != nil {
+		dest = append([]any{name}, dest...)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	t.Clearance = time.Duration(cur * float64(time.Second))
+	t.PrevClearance = time.Duration(prev * float64(time.Second))
+	return nil
+}
+
+// WeeklyReport compares the weeks with SQL.
+func (s *sqlStore) WeeklyReport(ctx context.Context, start time.Time, top int) (weeklyReport, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	if top <= 0 {
+		top = defaultWeeklyTop
+	}
+	report := weeklyReport{Start: start}
+	from, to := start.AddDate(0, 0, -7), start.AddDate(0, 0, 7)
+
+	rows, err := s.db.QueryContext(ctx, s.db.dialect.reportWindowQuery()+`SELECT`+s.db.dialect.trendColumns()+` FROM window_incidents`, from, to, start)
+	if err != nil {
+		return report, fmt.Errorf("could not query weekly totals: %w", err)
+	}
+	for rows.Next() {
+		if err := report.Total.scan(rows, nil); err != nil {
+			rows.Close()
+			return report, fmt.Errorf("could not read weekly totals: %w", err)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	if s.timescale {
+		if report.Total.Count, err = s.historyCount(ctx, start, to); err != nil {
+			return report, err
+		}
+		if report.Total.PrevCount, err = s.historyCount(ctx, from, start); err != nil {
+			return report, err
+		}
+	}
+
+	if report.Roads, err = s.trends(ctx, "road", from, to, start, top); err != nil {
+		return report, err
+	}
+	report.Counties, err = s.trends(ctx, "county_name", from, to, start, top)
+	return report, err
+}
+
+// IncidentStats aggregates the window with SQL. Hours are grouped in UTC and
+// moved into from's location afterwards.
+func (s *sqlStore) IncidentStats(ctx context.Context, from, to time.Time, top int) (incidentStats, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	stats := incidentStats{Since: from, Until: to}
+
+	var avgSeconds float64
+	row := s.db.QueryRowContext(ctx, s.db.dialect.reportWindowQuery()+`
+		SELECT COUNT(*), COUNT(cleared_time), `+s.db.dialect.avgClearance("")+`
+		FROM window_incidents`, from, to)
+	if err := row.Scan(&stats.Total, &stats.Cleared, &avgSeconds); err != nil {
+		return stats, fmt.Errorf("could not query incident totals: %w", err)
+	}
+	stats.AvgClearanceSeconds = int(avgSeconds)
+
+	var err error
+	if stats.ByType, err = s.statGroups(ctx, "incident_type", from, to, top); err != nil {
+		return stats, err
+	}
+	if stats.ByRoad, err = s.statGroups(ctx, "road", from, to, top); err != nil {
+		return stats, err
+	}
+	if stats.ByCounty, err = s.statGroups(ctx, "county_name", from, to, top); err != nil {
+		return stats, err
+	}
+	hours, err := s.HourlyCounts(ctx, from, to)
+	if err != nil {
+		return stats, err
+	}
+	stats.addHours(hours, from.Location())
+	return stats, nil
+}
+
+// statGroups returns the incident counts and average clearance grouped by
+// column, busiest first. column is one of ours, never user input.
+func (s *sqlStore) statGroups(ctx context.Context, column string, from, to time.Time, limit int) ([]statGroup, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.dialect.reportWindowQuery()+`
+		SELECT `+column+`, COUNT(*), `+s.db.dialect.avgClearance("")+`
+		FROM window_incidents
+		WHERE `+column+` <> ''
+		GROUP BY `+column+`
+		ORDER BY 2 DESC, 1
+		LIMIT $3`, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not query %s counts: %w", column, err)
+	}
+	defer rows.Close()
+	var groups []statGroup
+	for rows.Next() {
+		var g statGroup
+		var avgSeconds float64
+		if err := rows.Scan(&g.Name, &g.Count, &avgSeconds); err != nil {
+			return nil, fmt.Errorf("could not read %s counts: %w", column, err)
+		}
+		g.AvgClearanceSeconds = int(avgSeconds)
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// trends returns trends grouped by column, busiest this week first. column is
+// one of ours, never user input.
+func (s *sqlStore) trends(ctx context.Context, column string, from, to, split time.Time, limit int) ([]trend, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.dialect.reportWindowQuery()+`
+		SELECT `+column+`,`+s.db.dialect.trendColumns()+`
+		FROM window_incidents
+		WHERE `+column+` <> ''
+		GROUP BY `+column+`
+		ORDER BY 2 DESC, 3 DESC, 1
+		LIMIT $4`, from, to, split, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not query %s trends: %w", column, err)
+	}
+	defer rows.Close()
+	var trends []trend
+	for rows.Next() {
+		var t trend
+		if err := t.scan(rows, &t.Name); err != nil {
+			return nil, fmt.Errorf("could not read %s trends: %w", column, err)
+		}
+		trends = append(trends, t)
+	}
+	return trends, rows.Err()
+}
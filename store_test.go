@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIncidentQueryStartWindow(t *testing.T) {
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	before := since.AddDate(0, 0, 1)
+	tests := []struct {
+		name      string
+		q         incidentQuery
+		wantWhere string
+		wantArgs  []any
+		// wantMatches is whether incidents starting at each of starts match.
+		wantMatches []bool
+	}{
+		{"open", incidentQuery{}, "", nil, []bool{true, true, true, true, true}},
+		{"since", incidentQuery{StartedSince: since}, " WHERE start_time >= $1", []any{since},
+			[]bool{false, false, true, true, true}},
+		{"before", incidentQuery{StartedBefore: before}, " WHERE start_time < $1", []any{before},
+			[]bool{false, true, true, true, false}},
+		{"between", incidentQuery{Status: "cleared", StartedSince: since, StartedBefore: before},
+			" WHERE status = $1 AND start_time >= $2 AND start_time < $3", []any{"cleared", since, before},
+			[]bool{false, false, true, true, false}},
+	}
+	// An unknown start, the hour before since, since itself, the hour
+	// before before, and before itself.
+	starts := []time.Time{{}, since.Add(-time.Hour), since, before.Add(-time.Hour), before}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := tt.q.where()
+			if where != tt.wantWhere || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("where() = %q %v, want %q %v", where, args, tt.wantWhere, tt.wantArgs)
+			}
+			for i, start := range starts {
+				si := StoredIncident{Incident: Incident{StartTime: FeedTime{start}}, Status: "cleared"}
+				if got := tt.q.matches(si); got != tt.wantMatches[i] {
+					t.Errorf("matches(started %v) = %v, want %v", start, got, tt.wantMatches[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TeamsConfig posts alerts to a Microsoft Teams channel through an incoming
+// webhook (or a Workflows "post to a channel when a webhook request is received"
+// URL, which accepts the same payload).
+type TeamsConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether Teams alerts are configured.
+func (t TeamsConfig) enabled() bool {
+	return t.WebhookURL != ""
+}
+
+// teamsMessage wraps an Adaptive Card the way Teams webhooks expect.
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     adaptiveCard `json:"content"`
+}
+
+type adaptiveCard struct {
+	Schema  string           `json:"$schema"`
+	Type    string           `json:"type"`
+	Version string           `json:"version"`
+	Body    []map[string]any `json:"body"`
+	Actions []map[string]any `json:"actions,omitempty"`
+}
+
+// teamsFact is one row of an Adaptive Card FactSet.
+func teamsFact(title, value string) map[string]any {
+	return map[string]any{"title": title, "value": orNA(value)}
+}
+
+// teamsSeverityStyle maps severity to an Adaptive Card text color.
+func teamsSeverityStyle(severity int) string {
+	switch severity {
+	case 1:
+		return "good"
+	case 2:
+		return "warning"
+	case 3:
+		return "attention"
+	default:
+		return "default"
+	}
+}
+
+// newAdaptiveCard wraps card content in the message envelope.
+func newAdaptiveCard(body []map[string]any, actions []map[string]any) teamsMessage {
+	return teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: adaptiveCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body:    body,
+				Actions: actions,
+			},
+		}},
+	}
+}
+
+// buildTeamsIncidentCard lays out a new incident like the Discord embed.
+func buildTeamsIncidentCard(incident Incident, parsedTime time.Time) teamsMessage {
+	body := []map[string]any{
+		{
+			"type": "TextBlock", "size": "Large", "weight": "Bolder", "wrap": true,
+			"color": teamsSeverityStyle(incident.Severity),
+			"text":  fmt.Sprintf("New %s Alert", incident.IncidentType),
+		},
+		{
+			"type": "FactSet",
+			"facts": []map[string]any{
+				teamsFact("Reason", incident.Reason),
+				teamsFact("Road", incident.Road),
+				teamsFact("Location", incident.Location),
+				teamsFact("City", incident.City),
+				teamsFact("Lanes", lanesText(incident.LanesClosed, incident.LanesTotal)),
+				teamsFact("Severity", strconv.Itoa(incident.Severity)),
+				teamsFact("Started", formatLocalTime(parsedTime)),
+			},
+		},
+		{
+			"type": "TextBlock", "size": "Small", "isSubtle": true, "wrap": true,
+			"text": fmt.Sprintf("Incident #%d · Fetched from NC DOT API", incident.ID),
+		},
+	}
+	actions := []map[string]any{
+		{"type": "Action.OpenUrl", "title": "View on map", "url": mapLink(incident.Latitude, incident.Longitude)},
+	}
+	return newAdaptiveCard(body, actions)
+}
+
+// buildTeamsClearedCard lays out a cleared notification.
+func buildTeamsClearedCard(incident ClearedIncident) teamsMessage {
+	body := []map[string]any{
+		{"type": "TextBlock", "size": "Large", "weight": "Bolder", "color": "good", "text": clearedTitle(incident)},
+		{
+			"type": "FactSet",
+			"facts": []map[string]any{
+				teamsFact("Road", incident.Road),
+				teamsFact("Location", incident.Location),
+				teamsFact("City", incident.City),
+			},
+		},
+		{
+			"type": "TextBlock", "size": "Small", "isSubtle": true,
+			"text": fmt.Sprintf("Incident #%d · No longer in NC DOT feed", incident.ID),
+		},
+	}
+	return newAdaptiveCard(body, nil)
+}
+
+// applyTeamsTemplate replaces the fact set of a built-in card with templated text.
+func applyTeamsTemplate(card teamsMessage, text string) {
+	card.Attachments[0].Content.Body[1] = map[string]any{"type": "TextBlock", "wrap": true, "text": text}
+}
+
+// sendToTeams posts a new-incident alert to the Teams webhook.
+func sendToTeams(ctx context.Context, cfg TeamsConfig, incident Incident, parsedTime time.Time) error {
+	card := buildTeamsIncidentCard(incident, parsedTime)
+	if text, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		applyTeamsTemplate(card, text)
+	}
+	return postJSON(ctx, cfg.WebhookURL, card, nil, nil)
+}
+
+// sendClearedNotificationToTeams posts a cleared notification to the Teams webhook.
+func sendClearedNotificationToTeams(ctx context.Context, cfg TeamsConfig, incident ClearedIncident) error {
+	card := buildTeamsClearedCard(incident)
+	if text, ok := cfg.templates.render(clearedTemplateData(incident)); ok {
+		applyTeamsTemplate(card, text)
+	}
+	return postJSON(ctx, cfg.WebhookURL, card, nil, nil)
+}
+
+// sendUpdateToTeams posts an escalation, reopening or update to the Teams webhook.
+func sendUpdateToTeams(ctx context.Context, cfg TeamsConfig, n Notification) error {
+	body := []map[string]any{
+		{
+			"type": "TextBlock", "size": "Large", "weight": "Bolder", "wrap": true,
+			"color": teamsSeverityStyle(n.Incident.Severity),
+			"text":  updateTitle(n),
+		},
+		{"type": "TextBlock", "wrap": true, "text": updateText(cfg.templates, n)},
+	}
+	return postJSON(ctx, cfg.WebhookURL, newAdaptiveCard(body, nil), nil, nil)
+}
+
+// teamsNotifier delivers notifications to a Microsoft Teams channel.
+type teamsNotifier struct {
+	cfg TeamsConfig
+}
+
+func init() {
+	registerNotifier("teams", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Teams.enabled() {
+			return nil
+		}
+		c := cfg.Teams
+		c.templates = cfg.templatesFor("teams")
+		return teamsNotifier{cfg: c}
+	})
+}
+
+func (t teamsNotifier) Name() string { return "Teams" }
+
+// NotifyBatch posts one digest card.
+func (t teamsNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	body := []map[string]any{
+		{
+			"type": "TextBlock", "size": "Large", "weight": "Bolder", "wrap": true,
+			"color": teamsSeverityStyle(maxSeverity(batch)),
+			"text":  digestTitle(batch),
+		},
+		{"type": "TextBlock", "wrap": true, "text": digestText(batch, 0, teamsDigestLine)},
+	}
+	return postJSON(ctx, t.cfg.WebhookURL, newAdaptiveCard(body, nil), nil, nil)
+}
+
+// teamsDigestLine is digestLine as a Markdown list item with the road linked to the map.
+func teamsDigestLine(incident Incident) string {
+	return fmt.Sprintf("- **%s**: [%s](%s) at %s, %s (lanes %s, severity %d)",
+		incident.IncidentType, orNA(incident.Road), mapLink(incident.Latitude, incident.Longitude),
+		orNA(incident.Location), orNA(incident.City),
+		lanesText(incident.LanesClosed, incident.LanesTotal), incident.Severity)
+}
+
+func (t teamsNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedNotificationToTeams(ctx, t.cfg, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return sendUpdateToTeams(ctx, t.cfg, n)
+	}
+	return sendToTeams(ctx, t.cfg, n.Incident, n.StartTime)
+}
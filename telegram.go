@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramAPIBase is the Bot API root; the bot token is appended to it.
+const telegramAPIBase = "https://api.telegram.org/bot"
+
+// TelegramConfig sends alerts through a Telegram bot to one or more chats. Chat IDs
+// are numeric (negative for groups) or @channelusername for public channels.
+type TelegramConfig struct {
+	BotToken string   `yaml:"bot_token"`
+	ChatIDs  []string `yaml:"chat_ids"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether Telegram alerts are configured.
+func (t TelegramConfig) enabled() bool {
+	return t.BotToken != "" && len(t.ChatIDs) > 0
+}
+
+// telegramMessage is the sendMessage request body.
+type telegramMessage struct {
+	ChatID                string          `json:"chat_id"`
+	Text                  string          `json:"text"`
+	ParseMode             string          `json:"parse_mode,omitempty"`
+	DisableWebPagePreview bool            `json:"disable_web_page_preview"`
+	ReplyMarkup           *telegramMarkup `json:"reply_markup,omitempty"`
+}
+
+type telegramMarkup struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+type telegramButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// telegramEscaper escapes the characters MarkdownV2 treats as markup.
+var telegramEscaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`",
+	">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// telegramLine formats a bold label and escaped value.
+func telegramLine(label, value string) string {
+	return fmt.Sprintf("*%s:* %s", label, telegramEscaper.Replace(orNA(value)))
+}
+
+// buildTelegramIncidentText formats a new incident as MarkdownV2.
+func buildTelegramIncidentText(incident Incident, parsedTime time.Time) string {
+	lines := []string{
+		"🚨 *" + telegramEscaper.Replace(fmt.Sprintf("New %s Alert", incident.IncidentType)) + "*",
+		"",
+		telegramLine("Reason", incident.Reason),
+		telegramLine("Road", incident.Road),
+		telegramLine("Location", incident.Location),
+		telegramLine("City", incident.City),
+		telegramLine("Lanes", lanesText(incident.LanesClosed, incident.LanesTotal)),
+		telegramLine("Severity", strconv.Itoa(incident.Severity)),
+		telegramLine("Started", formatLocalTime(parsedTime)),
+		"",
+		"_" + telegramEscaper.Replace(fmt.Sprintf("Incident #%d", incident.ID)) + "_",
+	}
+	return strings.Join(lines, "\n")
+}
+
+// buildTelegramClearedText formats a cleared notification as MarkdownV2.
+func buildTelegramClearedText(incident ClearedIncident) string {
+	lines := []string{
+		"✅ *" + telegramEscaper.Replace(clearedTitle(incident)) + "*",
+		"",
+		telegramLine("Road", incident.Road),
+		telegramLine("Location", incident.Location),
+		telegramLine("City", incident.City),
+		"",
+		"_" + telegramEscaper.Replace(fmt.Sprintf("Incident #%d", incident.ID)) + "_",
+	}
+	return strings.Join(lines, "\n")
+}
+
+// postToTelegram sends a message to one chat.
+func postToTelegram(ctx context.Context, botToken string, msg telegramMessage) error {
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := postJSON(ctx, telegramAPIBase+botToken+"/sendMessage", msg, nil, &result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("Telegram API error: %s", result.Description)
+	}
+	return nil
+}
+
+// sendToTelegram posts a new-incident alert, with a "View on map" button, to every chat.
+func sendToTelegram(ctx context.Context, cfg TelegramConfig, incident Incident, parsedTime time.Time) error {
+	text, parseMode := buildTelegramIncidentText(incident, parsedTime), "MarkdownV2"
+	if custom, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		text, parseMode = custom, "" // Templates are sent as plain text.
+	}
+	markup := &telegramMarkup{InlineKeyboard: [][]telegramButton{{
+		{Text: "View on map", URL: mapLink(incident.Latitude, incident.Longitude)},
+	}}}
+	var errs []error
+	for _, chatID := range cfg.ChatIDs {
+		msg := telegramMessage{ChatID: chatID, Text: text, ParseMode: parseMode, DisableWebPagePreview: true, ReplyMarkup: markup}
+		if err := postToTelegram(ctx, cfg.BotToken, msg); err != nil {
+			errs = append(errs, fmt.Errorf("chat %s: %w", chatID, err))
+		}
+	}
+	return targetErrors(len(cfg.ChatIDs)-len(errs), errs)
+}
+
+// sendClearedNotificationToTelegram posts a cleared notification to every chat.
+func sendClearedNotificationToTelegram(ctx context.Context, cfg TelegramConfig, incident ClearedIncident) error {
+	text, parseMode := buildTelegramClearedText(incident), "MarkdownV2"
+	if custom, ok := cfg.templates.render(clearedTemplateData(incident)); ok {
+		text, parseMode = custom, ""
+	}
+	var errs []error
+	for _, chatID := range cfg.ChatIDs {
+		msg := telegramMessage{ChatID: chatID, Text: text, ParseMode: parseMode, DisableWebPagePreview: true}
+		if err := postToTelegram(ctx, cfg.BotToken, msg); err != nil {
+			errs = append(errs, fmt.Errorf("chat %s: %w", chatID, err))
+		}
+	}
+	return targetErrors(len(cfg.ChatIDs)-len(errs), errs)
+}
+
+// sendUpdateToTelegram posts an escalation, reopening or update, as plain text, to every chat.
+func sendUpdateToTelegram(ctx context.Context, cfg TelegramConfig, n Notification) error {
+	text := updateTitle(n) + "\n" + updateText(cfg.templates, n)
+	var errs []error
+	for _, chatID := range cfg.ChatIDs {
+		msg := telegramMessage{ChatID: chatID, Text: text, DisableWebPagePreview: true}
+		if err := postToTelegram(ctx, cfg.BotToken, msg); err != nil {
+			errs = append(errs, fmt.Errorf("chat %s: %w", chatID, err))
+		}
+	}
+	return targetErrors(len(cfg.ChatIDs)-len(errs), errs)
+}
+
+// telegramNotifier delivers notifications to Telegram chats.
+type telegramNotifier struct {
+	cfg TelegramConfig
+}
+
+func init() {
+	registerNotifier("telegram", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Telegram.enabled() {
+			return nil
+		}
+		c := cfg.Telegram
+		c.templates = cfg.templatesFor("telegram")
+		return telegramNotifier{cfg: c}
+	})
+}
+
+func (t telegramNotifier) Name() string { return "Telegram" }
+
+// NotifyBatch sends one digest message to every chat.
+func (t telegramNotifier) NotifyBatch(ctx context.Context, batch []Notification) error {
+	text := digestTitle(batch) + "\n\n" + digestText(batch, 4000, nil)
+	var errs []error
+	for _, chatID := range t.cfg.ChatIDs {
+		msg := telegramMessage{ChatID: chatID, Text: text, DisableWebPagePreview: true}
+		if err := postToTelegram(ctx, t.cfg.BotToken, msg); err != nil {
+			errs = append(errs, fmt.Errorf("chat %s: %w", chatID, err))
+		}
+	}
+	return targetErrors(len(t.cfg.ChatIDs)-len(errs), errs)
+}
+
+func (t telegramNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedNotificationToTelegram(ctx, t.cfg, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return sendUpdateToTelegram(ctx, t.cfg, n)
+	}
+	return sendToTelegram(ctx, t.cfg, n.Incident, n.StartTime)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Template event names. Updates are escalations and other changes to alerted
+// incidents, including those posted to Discord threads.
+const (
+	templateNew     = "new"
+	templateUpdated = "updated"
+	templateCleared = "cleared"
+)
+
+// defaultTemplatesKey is the notifications.templates entry used by every notifier
+// that has no template of its own for an event.
+const defaultTemplatesKey = "default"
+
+// MessageTemplates replaces a notifier's built-in message text with Go templates,
+// one per event. An empty template keeps the built-in text. Templates are executed
+// with templateData, so {{.Road}} is the incident's road and {{.MapURL}} its map link.
+type MessageTemplates struct {
+	New     string `yaml:"new"`
+	Updated string `yaml:"updated"`
+	Cleared string `yaml:"cleared"`
+
+	// parsed holds the templates by event, filled in by load.
+	parsed map[string]*template.Template
+}
+
+// templateFuncs are available in every message template.
+var templateFuncs = template.FuncMap{
+	"orNA":     orNA,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"join":     strings.Join,
+	"truncate": func(n int, s string) string { return truncateRunes(s, n) },
+	"json":     toJSON,
+}
+
+// load parses the templates. name is only used in error messages.
+func (t *MessageTemplates) load(name string) error {
+	t.parsed = make(map[string]*template.Template)
+	for event, text := range map[string]string{templateNew: t.New, templateUpdated: t.Updated, templateCleared: t.Cleared} {
+		if text == "" {
+			continue
+		}
+		tmpl, err := template.New(name + "." + event).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return fmt.Errorf("could not parse notifications.templates.%s.%s: %w", name, event, err)
+		}
+		t.parsed[event] = tmpl
+	}
+	return nil
+}
+
+// loadTemplates parses every configured message template and checks that each
+// entry names a notifier that supports them.
+func (n *NotificationConfig) loadTemplates() error {
+	names := make([]string, 0, len(n.Templates))
+	for name := range n.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch {
+		case name == "email" || name == "webhook":
+			return fmt.Errorf("notifications.templates.%s: %s messages are templated with their own template_file setting", name, name)
+		case name != defaultTemplatesKey && !slices.ContainsFunc(notifierRegistry, func(r notifierRegistration) bool { return r.name == name }):
+			return fmt.Errorf("notifications.templates.%s: unknown notifier", name)
+		}
+		t := n.Templates[name]
+		if err := t.load(name); err != nil {
+			return err
+		}
+		n.Templates[name] = t
+	}
+	return nil
+}
+
+// templatesFor returns the templates a notifier uses: its own, falling back event
+// by event to the defaults.
+func (n NotificationConfig) templatesFor(name string) MessageTemplates {
+	merged := MessageTemplates{parsed: make(map[string]*template.Template)}
+	for event, tmpl := range n.Templates[defaultTemplatesKey].parsed {
+		merged.parsed[event] = tmpl
+	}
+	for event, tmpl := range n.Templates[name].parsed {
+		merged.parsed[event] = tmpl
+	}
+	return merged
+}
+
+// templateData is what message templates are executed with. The incident's fields
+// are promoted, so {{.Road}} and {{.Severity}} work directly. Cleared incidents are
+// only known from the database, so only their ID, county, type, severity, road,
+// location, city and Duration are set, and MapURL and Lanes are empty.
+type templateData struct {
+	Incident
+	// Event is "new", "updated" or "cleared".
+	Event string
+	// Start is the parsed start time and LocalStart the same time formatted for display.
+	Start      time.Time
+	LocalStart string
+	// Now is when the message is sent.
+	Now time.Time
+	// MapURL links to the incident on Google Maps.
+	MapURL string
+	// Lanes describes the closure, e.g. "2 of 3 closed".
+	Lanes       string
+	FullClosure bool
+	// Changes lists what changed, for updates.
+	Changes []string
+	// Duration is how long a cleared incident was active, e.g. "2h 14m", or empty
+	// if unknown.
+	Duration string
+}
+
+// newTemplateData builds the data for a new or updated incident.
+func newTemplateData(event string, incident Incident, start time.Time) templateData {
+	return templateData{
+		Incident:    incident,
+		Event:       event,
+		Start:       start,
+		LocalStart:  formatLocalTime(start),
+		Now:         time.Now(),
+		MapURL:      mapLink(incident.Latitude, incident.Longitude),
+		Lanes:       lanesText(incident.LanesClosed, incident.LanesTotal),
+		FullClosure: isFullClosure(incident),
+	}
+}
+
+// clearedTemplateData builds the data for a cleared incident.
+func clearedTemplateData(incident ClearedIncident) templateData {
+	var duration string
+	if incident.DurationSeconds > 0 {
+		duration = formatDuration(incident.duration())
+	}
+	return templateData{
+		Incident: Incident{
+			ID:           incident.ID,
+			CountyID:     incident.CountyID,
+			IncidentType: incident.IncidentType,
+			Severity:     incident.Severity,
+			Road:         incident.Road,
+			Location:     incident.Location,
+			City:         incident.City,
+		},
+		Event:    templateCleared,
+		Now:      time.Now(),
+		Duration: duration,
+	}
+}
+
+// render executes the template for the data's event. It reports false when there
+// is no template, or when it fails, in which case the error is logged and the
+// caller falls back to its built-in text so the alert still goes out.
+func (t MessageTemplates) render(data templateData) (string, bool) {
+	tmpl := t.parsed[data.Event]
+	if tmpl == nil {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		slog.Error("Error rendering template. Using the default message.", "template", tmpl.Name(), "incident_id", data.ID, "err", err)
+		return "", false
+	}
+	return strings.TrimSpace(buf.String()), true
+}
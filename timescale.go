@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// timescaleSetup keeps a row in ncdot_incident_history for every incident when
+// it is first stored, at the time it started (or when it was stored, if the
+// feed's start time is unknown). Unlike ncdot_incidents, the history is
+// partitioned by time and keeps incidents the retention policy has removed, so
+// the hourly and daily count aggregates stay cheap to read and complete.
+//
+// Aggregates use real-time aggregation, so counts include rows newer than the
+// last refresh, and the policies refresh the recent buckets.
+var timescaleSetup = []string{
+	`CREATE TABLE IF NOT EXISTS ncdot_incident_history (
+		started TIMESTAMPTZ NOT NULL,
+		id INTEGER NOT NULL,
+		incident_type TEXT,
+		severity INTEGER,
+		road TEXT,
+		county_name TEXT
+	)`,
+	`SELECT create_hypertable('ncdot_incident_history', 'started', if_not_exists => TRUE)`,
+	`CREATE INDEX IF NOT EXISTS ncdot_incident_history_id ON ncdot_incident_history (id, started DESC)`,
+	`CREATE OR REPLACE FUNCTION ncdot_record_incident_history() RETURNS trigger AS $$
+	BEGIN
+		INSERT INTO ncdot_incident_history (started, id, incident_type, severity, road, county_name)
+		VALUES (COALESCE(NEW.start_time, now()),
+			NEW.id, NEW.incident_type, NEW.severity, NEW.road, NEW.county_name);
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS ncdot_incidents_history ON ncdot_incidents`,
+	`CREATE TRIGGER ncdot_incidents_history AFTER INSERT ON ncdot_incidents
+		FOR EACH ROW EXECUTE FUNCTION ncdot_record_incident_history()`,
+	// Incidents stored before the history existed are copied in once.
+	`INSERT INTO ncdot_incident_history (started, id, incident_type, severity, road, county_name)
+		SELECT COALESCE(start_time, now()),
+			id, incident_type, severity, road, county_name
+		FROM ncdot_incidents
+		WHERE NOT EXISTS (SELECT 1 FROM ncdot_incident_history)`,
+	`CREATE MATERIALIZED VIEW IF NOT EXISTS ncdot_incident_counts_hourly
+		WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+		SELECT time_bucket(INTERVAL '1 hour', started) AS bucket, incident_type, COUNT(*) AS incidents
+		FROM ncdot_incident_history
+		GROUP BY bucket, incident_type
+		WITH NO DATA`,
+	`CREATE MATERIALIZED VIEW IF NOT EXISTS ncdot_incident_counts_daily
+		WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+		SELECT time_bucket(INTERVAL '1 day', started) AS bucket, incident_type, COUNT(*) AS incidents
+		FROM ncdot_incident_history
+		GROUP BY bucket, incident_type
+		WITH NO DATA`,
+	`SELECT add_continuous_aggregate_policy('ncdot_incident_counts_hourly',
+		start_offset => INTERVAL '3 days', end_offset => NULL,
+		schedule_interval => INTERVAL '30 minutes', if_not_exists => TRUE)`,
+	`SELECT add_continuous_aggregate_policy('ncdot_incident_counts_daily',
+		start_offset => INTERVAL '30 days', end_offset => NULL,
+		schedule_interval => INTERVAL '1 hour', if_not_exists => TRUE)`,
+}
+
+// timescaleRefresh materializes whatever the policies haven't, such as the
+// copied-in history and incidents whose start time is older than a policy's
+// window. Only changed buckets are recomputed, so it is cheap after the first
+// start. It can't run in a transaction, so it is not part of a migration.
+var timescaleRefresh = []string{
+	`CALL refresh_continuous_aggregate('ncdot_incident_counts_hourly', NULL, NULL)`,
+	`CALL refresh_continuous_aggregate('ncdot_incident_counts_daily', NULL, NULL)`,
+}
+
+// enableTimescale sets up the incident history hypertable and its aggregates.
+// The timescaledb extension must already be installed in the database.
+func enableTimescale(db *DB) error {
+	ctx := context.Background()
+	var installed bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(&installed); err != nil {
+		return fmt.Errorf("could not check for TimescaleDB: %w", err)
+	}
+	if !installed {
+		return fmt.Errorf("database.timescaledb is set but the timescaledb extension is not installed; run CREATE EXTENSION timescaledb in the database")
+	}
+	for _, stmt := range slices.Concat(timescaleSetup, timescaleRefresh) {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("could not set up the TimescaleDB incident history: %w", err)
+		}
+	}
+	slog.Info("Keeping incident history in TimescaleDB.")
+	return nil
+}
+
+// historyCounts sets the daily report's incident counts from the hourly
+// aggregate, which also counts incidents removed since by the retention policy.
+// Hourly buckets line up with report days in any zone with a whole-hour offset.
+func (s *sqlStore) historyCounts(ctx context.Context, report *dailyReport, from, to time.Time) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(incident_type, ''), SUM(incidents)
+		FROM ncdot_incident_counts_hourly
+		WHERE bucket >= $1 AND bucket < $2
+		GROUP BY 1`, from, to)
+	if err != nil {
+		return fmt.Errorf("could not query incident history: %w", err)
+	}
+	defer rows.Close()
+	report.Total, report.Crashes = 0, 0
+	clear(report.ByType)
+	for rows.Next() {
+		var incidentType string
+		var count int
+		if err := rows.Scan(&incidentType, &count); err != nil {
+			return fmt.Errorf("could not read incident history: %w", err)
+		}
+		report.ByType[incidentType] = count
+		report.Total += count
+		if incidentType == "Vehicle Crash" {
+			report.Crashes = count
+		}
+	}
+	return rows.Err()
+}
+
+// historyCount counts the incidents in the history that started in [from, to).
+func (s *sqlStore) historyCount(ctx context.Context, from, to time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(incidents), 0)
+		FROM ncdot_incident_counts_hourly
+		WHERE bucket >= $1 AND bucket < $2`, from, to).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("could not query incident history: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracingConfig exports OpenTelemetry traces of each cycle (the feed fetches
+// and decoding, the database upsert and every notification) over OTLP/HTTP
+// with JSON encoding, which Jaeger, Tempo and the OpenTelemetry Collector
+// accept on port 4318.
+type TracingConfig struct {
+	// Endpoint is the collector's base URL, e.g. http://localhost:4318; spans
+	// are posted to its /v1/traces. Empty disables tracing.
+	Endpoint    string `yaml:"endpoint"`
+	ServiceName string `yaml:"service_name"`
+	// Headers are "Name=value" pairs sent with every export, e.g. for an API key.
+	Headers []string `yaml:"headers"`
+}
+
+// enabled reports whether traces are exported.
+func (t TracingConfig) enabled() bool {
+	return t.Endpoint != ""
+}
+
+// maxPendingSpans bounds the spans held for export, so a collector outage
+// can't grow memory without limit.
+const maxPendingSpans = 4096
+
+// Span kinds and status codes from the OTLP protocol.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	spanStatusError  = 2
+)
+
+// otlpTracer collects finished spans and exports them when a trace's root
+// span ends.
+type otlpTracer struct {
+	endpoint string
+	service  string
+	headers  map[string]string
+
+	mu      sync.Mutex
+	pending []*span
+}
+
+// tracer is the process's tracer, nil when tracing is off.
+var tracer *otlpTracer
+
+// startTracing sets up the tracer from the configuration.
+func startTracing(cfg TracingConfig) {
+	if !cfg.enabled() {
+		return
+	}
+	headers := make(map[string]string)
+	for _, h := range cfg.Headers {
+		if name, value, ok := strings.Cut(h, "="); ok {
+			headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	tracer = &otlpTracer{
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		service:  cfg.ServiceName,
+		headers:  headers,
+	}
+	slog.Info("Exporting traces", "endpoint", tracer.endpoint)
+}
+
+// span is one timed operation in a trace.
+type span struct {
+	tracer   *otlpTracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+
+	mu    sync.Mutex
+	attrs []spanAttribute
+	err   error
+}
+
+// spanAttribute is a key and a string, int, float64 or bool value.
+type spanAttribute struct {
+	key   string
+	value any
+}
+
+type spanKey struct{}
+
+// startSpan starts a span as a child of the one in ctx, or a new trace's root,
+// and returns a context holding it. With tracing off it returns ctx and a nil
+// span, whose methods do nothing.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: tracer, name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// set records an attribute.
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, spanAttribute{key, value})
+	s.mu.Unlock()
+}
+
+// finish ends the span, marking it failed when err isn't nil. Ending a root
+// span exports everything collected so far.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end, s.err = time.Now(), err
+	s.mu.Unlock()
+
+	t := s.tracer
+	t.mu.Lock()
+	if len(t.pending) < maxPendingSpans {
+		t.pending = append(t.pending, s)
+	}
+	var batch []*span
+	if s.parentID == [8]byte{} {
+		batch, t.pending = t.pending, nil
+	}
+	t.mu.Unlock()
+	if len(batch) > 0 {
+		if err := t.export(batch); err != nil {
+			slog.Error("Error exporting spans", "count", len(batch), "err", err)
+		}
+	}
+}
+
+// traceparent is the W3C Trace Context header naming the span, so services
+// that trace incoming requests join the trace.
+func (s *span) traceparent() string {
+	return fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID)
+}
+
+// export posts spans to the collector as an OTLP ExportTraceServiceRequest.
+func (t *otlpTracer) export(spans []*span) error {
+	var out []map[string]any
+	for _, s := range spans {
+		s.mu.Lock()
+		o := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			o["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			o["status"] = map[string]any{"code": spanStatusError, "message": s.err.Error()}
+		}
+		s.mu.Unlock()
+		out = append(out, o)
+	}
+	payload := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]spanAttribute{{"service.name", t.service}}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "crash-reporting"},
+				"spans": out,
+			}},
+		}},
+	}
+	// Exports outlive the cycle that made the spans, so they don't share its
+	// context; one that ends on shutdown is still exported.
+	return postJSON(context.Background(), t.endpoint, payload, t.headers, nil)
+}
+
+// otlpAttributes encodes attributes as OTLP KeyValues.
+func otlpAttributes(attrs []spanAttribute) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.value.(type) {
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": a.key, "value": value})
+	}
+	return out
+}
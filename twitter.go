@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Posting limits for X.
+const (
+	tweetsURL     = "https://api.twitter.com/2/tweets"
+	tweetMaxChars = 280
+	// tweetLinkChars is what every link counts as, whatever its real length.
+	tweetLinkChars = 23
+)
+
+// TwitterConfig posts qualifying incidents to an X account using OAuth 1.0a user
+// credentials from the developer portal. Posted incident IDs are kept in
+// StateFile until the incident clears, so an incident is only posted once,
+// even across restarts.
+type TwitterConfig struct {
+	APIKey            string   `yaml:"api_key"`
+	APISecret         string   `yaml:"api_secret"`
+	AccessToken       string   `yaml:"access_token"`
+	AccessTokenSecret string   `yaml:"access_token_secret"`
+	MinSeverity       int      `yaml:"min_severity"`
+	FullClosure       bool     `yaml:"full_closure"`
+	Hashtags          []string `yaml:"hashtags"`
+	StateFile         string   `yaml:"state_file"`
+
+	// templates is set from notifications.templates when the notifier is built.
+	templates MessageTemplates
+}
+
+// enabled reports whether posting to X is configured.
+func (t TwitterConfig) enabled() bool {
+	return t.APIKey != "" && t.APISecret != "" && t.AccessToken != "" && t.AccessTokenSecret != ""
+}
+
+// qualifies reports whether an incident is major enough to post.
+func (t TwitterConfig) qualifies(incident Incident) bool {
+	if incident.Severity >= t.MinSeverity {
+		return true
+	}
+	return t.FullClosure && isFullClosure(incident)
+}
+
+// buildTweet composes the post within the character budget. The map link and
+// hashtags are always kept; the description is shortened to make room.
+func buildTweet(incident Incident, hashtags []string) string {
+	text := fmt.Sprintf("%s: %s at %s, %s. Lanes %s.",
+		incident.IncidentType, orNA(incident.Road), orNA(incident.Location), orNA(incident.City),
+		lanesText(incident.LanesClosed, incident.LanesTotal))
+	tail := "\n" + mapLink(incident.Latitude, incident.Longitude)
+	budget := tweetMaxChars - 1 - tweetLinkChars
+	if tags := hashtagText(hashtags); tags != "" {
+		tail += "\n" + tags
+		budget -= 1 + len([]rune(tags))
+	}
+	return truncateRunes(text, budget) + tail
+}
+
+// percentEncode escapes per RFC 3986, as OAuth 1.0a requires.
+func percentEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// oauth1Header signs a request with HMAC-SHA1. JSON bodies aren't part of the
+// signature, so only the OAuth parameters are signed.
+func (t TwitterConfig) oauth1Header(method, endpoint string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate OAuth nonce: %w", err)
+	}
+	params := map[string]string{
+		"oauth_consumer_key":     t.APIKey,
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_token":            t.AccessToken,
+		"oauth_version":          "1.0",
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = percentEncode(k) + "=" + percentEncode(params[k])
+	}
+	base := method + "&" + percentEncode(endpoint) + "&" + percentEncode(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(percentEncode(t.APISecret)+"&"+percentEncode(t.AccessTokenSecret)))
+	mac.Write([]byte(base))
+	params["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	keys = append(keys, "oauth_signature")
+	sort.Strings(keys)
+
+	header := make([]string, len(keys))
+	for i, k := range keys {
+		header[i] = fmt.Sprintf(`%s="%s"`, percentEncode(k), percentEncode(params[k]))
+	}
+	return "OAuth " + strings.Join(header, ", "), nil
+}
+
+// sendToTwitter posts a qualifying incident unless it has been posted before.
+func sendToTwitter(ctx context.Context, cfg TwitterConfig, incident Incident, parsedTime time.Time) error {
+	if !cfg.qualifies(incident) {
+		return nil
+	}
+	posted, err := loadSentIncidents(cfg.StateFile)
+	if err != nil {
+		return fmt.Errorf("could not load posted tweets file: %w", err)
+	}
+	if posted[incident.ID] {
+		return nil
+	}
+
+	text := buildTweet(incident, cfg.Hashtags)
+	if custom, ok := cfg.templates.render(newTemplateData(templateNew, incident, parsedTime)); ok {
+		text = truncateRunes(custom, tweetMaxChars)
+	}
+	auth, err := cfg.oauth1Header("POST", tweetsURL)
+	if err != nil {
+		return err
+	}
+	payload := map[string]string{"text": text}
+	headers := map[string]string{"Authorization": auth}
+	if err := postJSON(ctx, tweetsURL, payload, headers, nil); err != nil {
+		return err
+	}
+	posted[incident.ID] = true
+	if err := saveSentIncidents(cfg.StateFile, posted); err != nil {
+		return fmt.Errorf("could not save posted tweets file: %w", err)
+	}
+	return nil
+}
+
+// forgetTweeted drops a cleared incident from the posted tweets file, which
+// would otherwise keep every incident ever posted.
+func forgetTweeted(cfg TwitterConfig, incidentID int) error {
+	posted, err := loadSentIncidents(cfg.StateFile)
+	if err != nil {
+		return fmt.Errorf("could not load posted tweets file: %w", err)
+	}
+	if !posted[incidentID] {
+		return nil
+	}
+	delete(posted, incidentID)
+	if err := saveSentIncidents(cfg.StateFile, posted); err != nil {
+		return fmt.Errorf("could not save posted tweets file: %w", err)
+	}
+	return nil
+}
+
+// twitterNotifier delivers notifications to an X account. Only new incidents
+// are posted; clearing one removes it from the posted tweets file.
+type twitterNotifier struct {
+	cfg TwitterConfig
+}
+
+func init() {
+	registerNotifier("twitter", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if !cfg.Twitter.enabled() {
+			return nil
+		}
+		c := cfg.Twitter
+		c.templates = cfg.templatesFor("twitter")
+		return twitterNotifier{cfg: c}
+	})
+}
+
+func (t twitterNotifier) Name() string { return "X" }
+
+func (t twitterNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return forgetTweeted(t.cfg, n.Cleared.ID)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return nil // Only new incidents are posted.
+	}
+	return sendToTwitter(ctx, t.cfg, n.Incident, n.StartTime)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+func TestTwitterNotifierForgetsCleared(t *testing.T) {
+	tests := []struct {
+		name string
+		n    Notification
+		want map[int]bool
+	}{
+		{"cleared", Notification{Kind: notifyCleared, Cleared: ClearedIncident{ID: 7}}, map[int]bool{8: true}},
+		{"not posted", Notification{Kind: notifyCleared, Cleared: ClearedIncident{ID: 9}}, map[int]bool{7: true, 8: true}},
+		{"updated", Notification{Kind: notifyUpdated, Incident: Incident{ID: 7}}, map[int]bool{7: true, 8: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := TwitterConfig{StateFile: filepath.Join(t.TempDir(), "tweeted.json")}
+			if err := saveSentIncidents(cfg.StateFile, map[int]bool{7: true, 8: true}); err != nil {
+				t.Fatal(err)
+			}
+			if err := (twitterNotifier{cfg: cfg}).Notify(context.Background(), tt.n); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+			posted, err := loadSentIncidents(cfg.StateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(posted, tt.want) {
+				t.Errorf("posted = %v, want %v", posted, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mtickle/crash-reporting/geo"
+)
+
+// defaultWeatherURL is the Open-Meteo forecast API, which also serves the
+// past three months of hourly weather.
+const defaultWeatherURL = "https://api.open-meteo.com/v1/forecast"
+
+// WeatherConfig adds the weather at an incident's location, in the hour it
+// started, to its alerts: temperature, conditions, precipitation and
+// visibility. URL is an Open-Meteo-compatible forecast endpoint. The weather
+// is looked up once per incident and stored in incident_weather, for
+// comparing crashes with the weather later.
+type WeatherConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url"`
+}
+
+// incidentWeather is the weather at an incident when it started.
+type incidentWeather struct {
+	// ObservedAt is the start of the hour the weather is for.
+	ObservedAt      time.Time
+	TemperatureF    float64
+	PrecipitationIn float64
+	VisibilityMiles float64
+	// Code is the WMO weather interpretation code.
+	Code int
+}
+
+// wmoConditions describes the WMO weather interpretation codes Open-Meteo uses.
+var wmoConditions = map[int]string{
+	0: "clear", 1: "mainly clear", 2: "partly cloudy", 3: "overcast",
+	45: "fog", 48: "freezing fog",
+	51: "light drizzle", 53: "drizzle", 55: "heavy drizzle",
+	56: "light freezing drizzle", 57: "freezing drizzle",
+	61: "light rain", 63: "rain", 65: "heavy rain",
+	66: "light freezing rain", 67: "freezing rain",
+	71: "light snow", 73: "snow", 75: "heavy snow", 77: "snow grains",
+	80: "light rain showers", 81: "rain showers", 82: "heavy rain showers",
+	85: "snow showers", 86: "heavy snow showers",
+	95: "thunderstorm", 96: "thunderstorm with hail", 99: "thunderstorm with heavy hail",
+}
+
+// conditions describes the weather code, e.g. "light rain".
+func (w incidentWeather) conditions() string {
+	if c, ok := wmoConditions[w.Code]; ok {
+		return c
+	}
+	return "weather code " + strconv.Itoa(w.Code)
+}
+
+// String describes the weather for alerts, e.g. "48°F, light rain, 0.05 in
+// precipitation, 2.5 mi visibility". Dry hours leave out the precipitation.
+func (w incidentWeather) String() string {
+	parts := []string{fmt.Sprintf("%.0f°F", w.TemperatureF), w.conditions()}
+	if w.PrecipitationIn > 0 {
+		parts = append(parts, fmt.Sprintf("%.2f in precipitation", w.PrecipitationIn))
+	}
+	parts = append(parts, fmt.Sprintf("%.1f mi visibility", w.VisibilityMiles))
+	return strings.Join(parts, ", ")
+}
+
+// weatherLookup finds the weather of a cycle's incidents, from what is stored
+// or from the provider, storing what it looks up.
+type weatherLookup struct {
+	cfg   WeatherConfig
+	store Store
+	known map[int]incidentWeather
+}
+
+// loadWeatherLookup reads the stored weather of the incidents. A nil lookup,
+// returned when weather is off, adds nothing.
+func loadWeatherLookup(ctx context.Context, cfg WeatherConfig, store Store, incidents []Incident) *weatherLookup {
+	if !cfg.Enabled {
+		return nil
+	}
+	ids := make([]int, len(incidents))
+	for i, incident := range incidents {
+		ids[i] = incident.ID
+	}
+	known, err := store.IncidentWeather(ctx, ids)
+	if err != nil {
+		slog.Error("Error loading the stored incident weather", "err", err)
+		known = make(map[int]incidentWeather)
+	}
+	return &weatherLookup{cfg: cfg, store: store, known: known}
+}
+
+// enrich sets the incident's Weather. A failed lookup is logged and leaves it
+// empty, so it is tried again next cycle.
+func (l *weatherLookup) enrich(ctx context.Context, incident Incident) Incident {
+	if l == nil || !(geo.Point{Lat: incident.Latitude, Lon: incident.Longitude}).Valid() {
+		return incident
+	}
+	w, ok := l.known[incident.ID]
+	if !ok {
+		at := incident.StartTime.Time
+		if at.IsZero() {
+			at = time.Now()
+		}
+		var err error
+		if w, err = fetchWeather(ctx, l.cfg, incident.Latitude, incident.Longitude, at); err != nil {
+			slog.Warn("Could not look up the weather for incident", "incident_id", incident.ID, "err", err)
+			return incident
+		}
+		l.known[incident.ID] = w
+		if err := l.store.SaveIncidentWeather(ctx, incident.ID, w); err != nil {
+			slog.Error("Error storing the incident weather", "incident_id", incident.ID, "err", err)
+		}
+	}
+	incident.Weather = w.String()
+	return incident
+}
+
+// openMeteoHourly is the part of an Open-Meteo hourly response used.
+type openMeteoHourly struct {
+	Reason      string `json:"reason"`
+	HourlyUnits struct {
+		Visibility string `json:"visibility"`
+	} `json:"hourly_units"`
+	Hourly struct {
+		Time          []string   `json:"time"`
+		Temperature   []*float64 `json:"temperature_2m"`
+		Precipitation []*float64 `json:"precipitation"`
+		Visibility    []*float64 `json:"visibility"`
+		WeatherCode   []*int     `json:"weather_code"`
+	} `json:"hourly"`
+}
+
+// fetchWeather asks the provider for the weather at a point in the hour of at.
+func fetchWeather(ctx context.Context, cfg WeatherConfig, lat, lon float64, at time.Time) (incidentWeather, error) {
+	hour := at.UTC().Truncate(time.Hour)
+	params := url.Values{
+		"latitude":           {strconv.FormatFloat(lat, 'f', 4, 64)},
+		"longitude":          {strconv.FormatFloat(lon, 'f', 4, 64)},
+		"hourly":             {"temperature_2m,precipitation,visibility,weather_code"},
+		"temperature_unit":   {"fahrenheit"},
+		"precipitation_unit": {"inch"},
+		"timezone":           {"GMT"},
+		"start_hour":         {hour.Format("2006-01-02T15:04")},
+		"end_hour":           {hour.Format("2006-01-02T15:04")},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.URL+"?"+params.Encode(), nil)
+	if err != nil {
+		return incidentWeather{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return incidentWeather{}, err
+	}
+	defer resp.Body.Close()
+	var body openMeteoHourly
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return incidentWeather{}, fmt.Errorf("could not decode weather response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Such as an hour outside the range the API keeps.
+		return incidentWeather{}, fmt.Errorf("weather API returned %s: %s", resp.Status, body.Reason)
+	}
+
+	h := body.Hourly
+	if len(h.Time) == 0 || len(h.Temperature) == 0 || len(h.Precipitation) == 0 || len(h.Visibility) == 0 || len(h.WeatherCode) == 0 ||
+		h.Temperature[0] == nil || h.Precipitation[0] == nil || h.Visibility[0] == nil || h.WeatherCode[0] == nil {
+		return incidentWeather{}, fmt.Errorf("no weather for %s", hour.Format(time.RFC3339))
+	}
+	visibility := *h.Visibility[0] / 1609.344
+	if body.HourlyUnits.Visibility == "ft" {
+		visibility = *h.Visibility[0] / 5280
+	}
+	return incidentWeather{
+		ObservedAt:      hour,
+		TemperatureF:    *h.Temperature[0],
+		PrecipitationIn: *h.Precipitation[0],
+		VisibilityMiles: visibility,
+		Code:            *h.WeatherCode[0],
+	}, nil
+}
+
+// IncidentWeather returns the stored weather of the incidents that have it,
+// by ID, reading upsertBatchSize IDs at a time.
+func (s *sqlStore) IncidentWeather(ctx context.Context, ids []int) (map[int]incidentWeather, error) {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	weather := make(map[int]incidentWeather)
+	for start := 0; start < len(ids); start += upsertBatchSize {
+		batch := ids[start:min(start+upsertBatchSize, len(ids))]
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		err := withReconnect(s.db, func() error {
+			rows, err := s.db.QueryContext(ctx, `
+				SELECT incident_id, observed_at, temperature_f, precipitation_in, visibility_mi, weather_code
+				FROM incident_weather WHERE incident_id IN `+placeholderList(len(args)), args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var id int
+				var w incidentWeather
+				if err := rows.Scan(&id, &w.ObservedAt, &w.TemperatureF, &w.PrecipitationIn, &w.VisibilityMiles, &w.Code); err != nil {
+					return err
+				}
+				weather[id] = w
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return weather, nil
+}
+
+// SaveIncidentWeather stores an incident's weather, with its conditions as
+// text for querying.
+func (s *sqlStore) SaveIncidentWeather(ctx context.Context, id int, w incidentWeather) error {
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	return withReconnect(s.db, func() error {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO incident_weather (incident_id, observed_at, temperature_f, precipitation_in, visibility_mi, weather_code, conditions)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			id, w.ObservedAt, w.TemperatureF, w.PrecipitationIn, w.VisibilityMiles, w.Code, w.conditions())
+		return err
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// Event names sent to outbound webhooks.
+const (
+	webhookEventNew       = "incident.new"
+	webhookEventCleared   = "incident.cleared"
+	webhookEventEscalated = "incident.escalated"
+	webhookEventUpdated   = "incident.updated"
+	webhookEventReopened  = "incident.reopened"
+)
+
+// Headers sent with every delivery: the signature, as "sha256=<hex>", and the
+// Unix time it was signed at.
+const (
+	webhookSignatureHeader = "X-NCDOT-Signature-256"
+	webhookTimestampHeader = "X-NCDOT-Timestamp"
+)
+
+// OutboundWebhook POSTs every event to a user-supplied URL. The body is the event
+// as JSON unless Template (inline) or TemplateFile sets a text/template for it.
+// With a Secret, each request is signed with HMAC-SHA256 over the
+// X-NCDOT-Timestamp header, a ".", and the body, so receivers can verify it and
+// reject stale timestamps to stop a captured request being replayed.
+// Network errors, 429s and 5xx responses are retried up to Retries times with
+// exponential backoff.
+type OutboundWebhook struct {
+	URL          string            `yaml:"url"`
+	Secret       string            `yaml:"secret"`
+	Headers      map[string]string `yaml:"headers"`
+	Template     string            `yaml:"template"`
+	TemplateFile string            `yaml:"template_file"`
+	ContentType  string            `yaml:"content_type"`
+	Retries      int               `yaml:"retries"`
+
+	// template is parsed from Template or TemplateFile by load.
+	template *template.Template
+}
+
+// load parses the body template, if any.
+func (w *OutboundWebhook) load() error {
+	text := w.Template
+	if w.TemplateFile != "" {
+		data, err := os.ReadFile(w.TemplateFile)
+		if err != nil {
+			return fmt.Errorf("could not read webhook template for %s: %w", w.URL, err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		return nil
+	}
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(text)
+	if err != nil {
+		return fmt.Errorf("could not parse webhook template for %s: %w", w.URL, err)
+	}
+	w.template = tmpl
+	return nil
+}
+
+// toJSON lets templates embed values as JSON, e.g. {{json .Incident.Road}}.
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// webhookEvent is the default body and the data templates are executed with.
+// Incident is the full feed record for new incidents; Cleared is set instead for
+// cleared ones, which are only known from the database.
+type webhookEvent struct {
+	Event    string           `json:"event"`
+	SentAt   time.Time        `json:"sent_at"`
+	Start    *time.Time       `json:"start,omitempty"`
+	MapURL   string           `json:"map_url,omitempty"`
+	Incident *Incident        `json:"incident,omitempty"`
+	Cleared  *ClearedIncident `json:"cleared,omitempty"`
+	// Changes lists what changed, for escalations and updates.
+	Changes []string `json:"changes,omitempty"`
+}
+
+// body renders the request body for an event.
+func (w OutboundWebhook) body(event webhookEvent) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(event)
+	}
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("could not render webhook template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// signWebhookBody returns the signature header value for a body sent at timestamp.
+func signWebhookBody(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver POSTs the event, retrying transient failures until ctx is done.
+func (w OutboundWebhook) deliver(ctx context.Context, event webhookEvent) error {
+	body, err := w.body(event)
+	if err != nil {
+		return err
+	}
+	contentType := w.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, body, contentType, event.Event)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.Retries {
+			return err
+		}
+		slog.Warn("Webhook failed. Retrying.", "url", w.URL, "err", err, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth retrying.
+func (w OutboundWebhook) post(ctx context.Context, body []byte, contentType, event string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-NCDOT-Event", event)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	if w.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhookBody(w.Secret, timestamp, body))
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("non-2xx status: %s", resp.Status)
+}
+
+// sendToWebhooks delivers a new-incident event to every outbound webhook.
+func sendToWebhooks(ctx context.Context, webhooks []OutboundWebhook, incident Incident, parsedTime time.Time) error {
+	return deliverToWebhooks(ctx, webhooks, webhookEventNew, incident, parsedTime, nil)
+}
+
+// sendUpdateToWebhooks delivers an escalation, reopening or update event to every outbound webhook.
+func sendUpdateToWebhooks(ctx context.Context, webhooks []OutboundWebhook, n Notification) error {
+	name := webhookEventUpdated
+	switch n.Kind {
+	case notifyEscalated:
+		name = webhookEventEscalated
+	case notifyReopened:
+		name = webhookEventReopened
+	}
+	return deliverToWebhooks(ctx, webhooks, name, n.Incident, n.StartTime, n.Changes)
+}
+
+// deliverToWebhooks sends an event about an active incident to every outbound webhook.
+func deliverToWebhooks(ctx context.Context, webhooks []OutboundWebhook, name string, incident Incident, parsedTime time.Time, changes []string) error {
+	event := webhookEvent{
+		Event:    name,
+		SentAt:   time.Now().UTC(),
+		Start:    &parsedTime,
+		MapURL:   mapLink(incident.Latitude, incident.Longitude),
+		Incident: &incident,
+		Changes:  changes,
+	}
+	var errs []error
+	for _, w := range webhooks {
+		if err := w.deliver(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", w.URL, err))
+		}
+	}
+	return targetErrors(len(webhooks)-len(errs), errs)
+}
+
+// sendClearedToWebhooks delivers a cleared event to every outbound webhook.
+func sendClearedToWebhooks(ctx context.Context, webhooks []OutboundWebhook, incident ClearedIncident) error {
+	event := webhookEvent{Event: webhookEventCleared, SentAt: time.Now().UTC(), Cleared: &incident}
+	var errs []error
+	for _, w := range webhooks {
+		if err := w.deliver(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", w.URL, err))
+		}
+	}
+	return targetErrors(len(webhooks)-len(errs), errs)
+}
+
+// webhookNotifier delivers notifications to the outbound webhooks.
+type webhookNotifier struct {
+	webhooks []OutboundWebhook
+}
+
+func init() {
+	registerNotifier("webhook", false, func(cfg NotificationConfig, _ notifierState) Notifier {
+		if len(cfg.Webhooks) == 0 {
+			return nil
+		}
+		return webhookNotifier{webhooks: cfg.Webhooks}
+	})
+}
+
+func (w webhookNotifier) Name() string { return "webhook" }
+
+func (w webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	switch n.Kind {
+	case notifyCleared:
+		return sendClearedToWebhooks(ctx, w.webhooks, n.Cleared)
+	case notifyEscalated, notifyUpdated, notifyReopened:
+		return sendUpdateToWebhooks(ctx, w.webhooks, n)
+	}
+	return sendToWebhooks(ctx, w.webhooks, n.Incident, n.StartTime)
+}
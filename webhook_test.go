@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOutboundWebhookDeliverCancelled(t *testing.T) {
+	requests := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requests
+		cancel()
+	}()
+	// Five retries back off for 31 seconds in all.
+	w := OutboundWebhook{URL: server.URL, Retries: 5}
+	start := time.Now()
+	if err := w.deliver(ctx, webhookEvent{Event: webhookEventNew}); err == nil {
+		t.Error("deliver() succeeded, want the server's error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("deliver() took %s, want it to return promptly once cancelled", elapsed)
+	}
+	if n := len(requests); n != 0 {
+		t.Errorf("deliver() retried %d more times after the cancellation", n)
+	}
+}
+
+func TestSignWebhookBody(t *testing.T) {
+	got := signWebhookBody("change-me", "1714564800", []byte(`{"event":"incident.new"}`))
+	want := "sha256=9986294783d5336a3200992b3885280883eb9c9122597385143f00fd9447908c"
+	if got != want {
+		t.Errorf("signWebhookBody() = %s, want %s", got, want)
+	}
+}
+
+func TestOutboundWebhookSignsTimestamp(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		headers <- r.Header
+		bodies <- body
+	}))
+	defer server.Close()
+
+	w := OutboundWebhook{URL: server.URL, Secret: "change-me"}
+	if err := w.deliver(context.Background(), webhookEvent{Event: webhookEventNew}); err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+	h, body := <-headers, <-bodies
+	timestamp := h.Get(webhookTimestampHeader)
+	if timestamp == "" {
+		t.Fatalf("no %s header", webhookTimestampHeader)
+	}
+	if got, want := h.Get(webhookSignatureHeader), signWebhookBody("change-me", timestamp, body); got != want {
+		t.Errorf("%s = %s, want %s", webhookSignatureHeader, got, want)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket opcodes (RFC 6455).
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// wsGUID is appended to the client's key to compute the handshake's accept value.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessage bounds a client message; clients only send small subscriptions.
+const wsMaxMessage = 64 << 10
+
+// wsWriteTimeout bounds writing one frame to a client.
+const wsWriteTimeout = 10 * time.Second
+
+// errWSClosed is returned by readMessage once the client has closed.
+var errWSClosed = errors.New("websocket closed")
+
+// wsConn is a minimal server side of a WebSocket: text messages, pings and
+// close. Writes may come from several goroutines; reads from one.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	mu   sync.Mutex // serializes writes
+	// readTimeout, when set, is how long to wait for each frame.
+	readTimeout time.Duration
+}
+
+// headerHasToken reports whether a comma-separated header contains token.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection. On failure it has already written an error response.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		writeAPIError(w, http.StatusBadRequest, "expected a WebSocket upgrade request")
+		return nil, errors.New("not a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeAPIError(w, http.StatusUpgradeRequired, "unsupported WebSocket version")
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "WebSockets are not supported")
+		return nil, errors.New("response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// writeFrame sends one unfragmented, unmasked frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeText sends a text message.
+func (c *wsConn) writeText(data []byte) error {
+	return c.writeFrame(wsText, data)
+}
+
+// close sends a close frame with code and closes the connection.
+func (c *wsConn) close(code uint16, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	c.writeFrame(wsClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// readFrame reads one frame, unmasking its payload.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0F
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("client frame is not masked")
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage {
+		return false, 0, nil, fmt.Errorf("client frame of %d bytes is too large", n)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// readMessage returns the next text or binary message, answering pings and
+// joining fragments along the way. It returns errWSClosed once the client
+// sends a close frame, after answering it.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeFrame(wsClose, payload[:min(len(payload), 2)])
+			return nil, errWSClosed
+		case wsText, wsBinary, wsContinuation:
+		default:
+			return nil, fmt.Errorf("unknown WebSocket opcode %d", opcode)
+		}
+		message = append(message, payload...)
+		if len(message) > wsMaxMessage {
+			return nil, fmt.Errorf("client message of %d bytes is too large", len(message))
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}